@@ -1,20 +1,38 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	commontConfig "github.com/quadev-ltd/qd-common/pkg/config"
 	commonLogger "github.com/quadev-ltd/qd-common/pkg/log"
 
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+	apiErrors "github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/metrics"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/proxy"
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/services"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/tracing"
 )
 
 // APIPath is the path of the API
 const APIPath = "/api/v1"
 
+// defaultDrainTimeout is used when no drain timeout is configured
+const defaultDrainTimeout = 30 * time.Second
+
+// traceFlushTimeout bounds the export of the last spans, apart from the drain which may use its whole timeout
+const traceFlushTimeout = 5 * time.Second
+
 func main() {
 	configuration := config.Config{}
 	err := configuration.Load("internal/config")
@@ -29,10 +47,21 @@ func main() {
 		configuration.AWS.Secret,
 	)
 
+	shutdownTracing, err := tracing.InitTracerProvider(&configuration)
+	if err != nil {
+		log.Fatalln("Failed to initialize tracing:", err)
+	}
+
 	router := gin.Default()
+	if err := proxy.ConfigureEngine(router, &configuration); err != nil {
+		log.Fatalln("Failed to configure trusted proxies:", err)
+	}
+	router.Use(metrics.GinMiddleware())
 	router.Use(commonLogger.AddNewCorrelationIDToContext)
+	router.Use(tracing.GinMiddleware())
 	logger := commonLogger.NewLogFactory(configuration.Environment)
 	router.Use(commonLogger.CreateGinLoggerMiddleware(logger))
+	router.Use(apiErrors.Middleware())
 
 	api := router.Group(APIPath)
 
@@ -41,6 +70,66 @@ func main() {
 		log.Fatalln("Failed to initialize services:", err)
 	}
 
-	fmt.Println("Listening API requests on URL: ", fmt.Sprintf("%s:%s%s", centralConfig.GatewayService.Host, centralConfig.GatewayService.Port, APIPath))
-	router.Run(fmt.Sprintf("%s:%s", centralConfig.GatewayService.Host, centralConfig.GatewayService.Port))
+	address := fmt.Sprintf("%s:%s", centralConfig.GatewayService.Host, centralConfig.GatewayService.Port)
+	listener, err := proxy.Listen(address, &configuration)
+	if err != nil {
+		log.Fatalln("Failed to listen on", address, err)
+	}
+	server := &http.Server{
+		Addr:    address,
+		Handler: router,
+	}
+	// The listener is bound, the connections wait in its backlog until the server accepts them
+	serviceInitializer.SetReady(true)
+
+	go func() {
+		fmt.Println("Listening API requests on URL: ", fmt.Sprintf("%s:%s%s", centralConfig.GatewayService.Host, centralConfig.GatewayService.Port, APIPath))
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalln("Failed to serve API requests:", err)
+		}
+	}()
+
+	var metricsServer *http.Server
+	if configuration.Server.MetricsAddress != "" {
+		metricsListener, err := net.Listen("tcp", configuration.Server.MetricsAddress)
+		if err != nil {
+			log.Fatalln("Failed to listen on", configuration.Server.MetricsAddress, err)
+		}
+		metricsServer = metrics.NewServer(configuration.Server.MetricsAddress)
+		go func() {
+			fmt.Println("Exposing metrics on URL: ", fmt.Sprintf("%s%s", configuration.Server.MetricsAddress, metrics.Path))
+			if err := metricsServer.Serve(metricsListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalln("Failed to serve metrics:", err)
+			}
+		}()
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	receivedSignal := <-quit
+	fmt.Println("Received signal", receivedSignal, "shutting down")
+
+	drainTimeout := configuration.Server.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = defaultDrainTimeout
+	}
+	if err := serviceInitializer.Drain(server, configuration.Server.ShutdownDelay, drainTimeout); err != nil {
+		fmt.Println("Failed to drain in-flight requests:", err)
+	}
+
+	if err := serviceInitializer.Close(); err != nil {
+		fmt.Println("Failed to close service clients:", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		fmt.Println("Failed to flush traces:", err)
+	}
+	// The metrics are served until the end, the drain being observable
+	if metricsServer != nil {
+		if err := metricsServer.Close(); err != nil {
+			fmt.Println("Failed to stop the metrics server:", err)
+		}
+	}
+	fmt.Println("Gateway stopped")
 }
@@ -7,9 +7,14 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/quadev-ltd/qd-common/pb/gen/go/pb_authentication"
 	commonConfig "github.com/quadev-ltd/qd-common/pkg/config"
-	commonTLS "github.com/quadev-ltd/qd-common/pkg/tls"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
 
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/authentication/pb_authentication_sessions"
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/authentication/routes"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/connection"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/health"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/revocation"
 )
 
 // ServiceClienter is an interface for the authentication service client
@@ -26,28 +31,43 @@ type ServiceClienter interface {
 	ResetPassword(ctx *gin.Context)
 	GetUserProfile(ctx *gin.Context)
 	UpdateUserProfile(ctx *gin.Context)
+	PatchUserProfile(ctx *gin.Context)
 	DeleteAccount(ctx *gin.Context)
+	Logout(ctx *gin.Context)
+	LogoutEverywhere(ctx *gin.Context)
+	CheckHealth(ctx context.Context) error
+	Close() error
 }
 
 // ServiceClient is a struct for the authentication service client
 type ServiceClient struct {
-	client pb_authentication.AuthenticationServiceClient
+	client       pb_authentication_sessions.AuthenticationServiceClient
+	healthClient grpc_health_v1.HealthClient
+	connection   *grpc.ClientConn
+	revocations  revocation.Storer
 }
 
 var _ ServiceClienter = &ServiceClient{}
 
-// InitServiceClient initializes the authentication service client
-func InitServiceClient(config *commonConfig.Config) (*ServiceClient, error) {
+// InitServiceClient initializes the authentication service client, revoking the ended sessions in the given store
+func InitServiceClient(
+	config *commonConfig.Config,
+	revocations revocation.Storer,
+	options ...grpc.DialOption,
+) (*ServiceClient, error) {
 	grpcServiceAddress := fmt.Sprintf("%s:%s", config.AuthenticationService.Host, config.AuthenticationService.Port)
 
 	fmt.Println("Connecting to authentication service at", grpcServiceAddress, config.TLSEnabled)
-	clientConnection, err := commonTLS.CreateGRPCConnection(grpcServiceAddress, config.TLSEnabled)
+	clientConnection, err := connection.CreateGRPCConnection(grpcServiceAddress, config.TLSEnabled, options...)
 	if err != nil {
 		return nil, fmt.Errorf("Could not connect to grpc authentication service: %v", err)
 	}
 
 	service := &ServiceClient{
-		client: pb_authentication.NewAuthenticationServiceClient(clientConnection),
+		client:       pb_authentication_sessions.NewAuthenticationServiceClient(clientConnection),
+		healthClient: grpc_health_v1.NewHealthClient(clientConnection),
+		connection:   clientConnection,
+		revocations:  revocations,
 	}
 	return service, nil
 }
@@ -119,7 +139,35 @@ func (service *ServiceClient) UpdateUserProfile(ctx *gin.Context) {
 	routes.UpdateUserProfile(ctx, service.client)
 }
 
+// PatchUserProfile redirects request to the patch user profile route
+func (service *ServiceClient) PatchUserProfile(ctx *gin.Context) {
+	routes.PatchUserProfile(ctx, service.client)
+}
+
 // DeleteAccount redirects request to the update user profile route
 func (service *ServiceClient) DeleteAccount(ctx *gin.Context) {
 	routes.DeleteAccount(ctx, service.client)
 }
+
+// Logout redirects request to the logout route
+func (service *ServiceClient) Logout(ctx *gin.Context) {
+	routes.Logout(ctx, service.client, service.revocations)
+}
+
+// LogoutEverywhere redirects request to the logout everywhere route
+func (service *ServiceClient) LogoutEverywhere(ctx *gin.Context) {
+	routes.LogoutEverywhere(ctx, service.client, service.revocations)
+}
+
+// CheckHealth checks the authentication service with the gRPC health protocol
+func (service *ServiceClient) CheckHealth(ctx context.Context) error {
+	return health.CheckGRPCHealth(ctx, service.healthClient, "")
+}
+
+// Close closes the connection to the authentication service
+func (service *ServiceClient) Close() error {
+	if service.connection == nil {
+		return nil
+	}
+	return service.connection.Close()
+}
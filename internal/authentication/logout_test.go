@@ -0,0 +1,221 @@
+package authentication
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/quadev-ltd/qd-common/pb/gen/go/pb_authentication"
+	commonJWT "github.com/quadev-ltd/qd-common/pkg/jwt"
+	commonToken "github.com/quadev-ltd/qd-common/pkg/token"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/authentication/pb_authentication_sessions"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/authentication/routes"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/revocation"
+)
+
+const (
+	testAccessToken = "access-token"
+	testUserID      = "user-id"
+)
+
+// sessionServer records the session RPCs the authentication service does not define in the shared protos yet
+type sessionServer struct {
+	pb_authentication_sessions.UnimplementedAuthenticationServiceServer
+	method        string
+	authorization []string
+}
+
+func (server *sessionServer) record(ctx context.Context) (*pb_authentication.BaseResponse, error) {
+	server.method, _ = grpc.Method(ctx)
+	incomingMetadata, _ := metadata.FromIncomingContext(ctx)
+	server.authorization = incomingMetadata.Get("authorization")
+	return &pb_authentication.BaseResponse{Success: true}, nil
+}
+
+func (server *sessionServer) Logout(ctx context.Context, _ *emptypb.Empty) (*pb_authentication.BaseResponse, error) {
+	return server.record(ctx)
+}
+
+func (server *sessionServer) LogoutEverywhere(ctx context.Context, _ *emptypb.Empty) (*pb_authentication.BaseResponse, error) {
+	return server.record(ctx)
+}
+
+// startSessionServer serves the session RPCs of the server
+func startSessionServer(t *testing.T, server *sessionServer) *grpc.ClientConn {
+	grpcServer := grpc.NewServer()
+	pb_authentication_sessions.RegisterAuthenticationServiceServer(grpcServer, server)
+	return dialBufferedServer(t, bufconn.Listen(bufSize), grpcServer)
+}
+
+// dialBufferedServer connects to the listener, served by the given server when not nil
+func dialBufferedServer(t *testing.T, listener *bufconn.Listener, server *grpc.Server) *grpc.ClientConn {
+	if server != nil {
+		go server.Serve(listener)
+		t.Cleanup(server.Stop)
+	}
+	connection, err := grpc.DialContext(
+		context.Background(),
+		"bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { connection.Close() })
+	return connection
+}
+
+func newSessionRouter(connection *grpc.ClientConn, revocations revocation.Storer) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	service := &ServiceClient{
+		client:      pb_authentication_sessions.NewAuthenticationServiceClient(connection),
+		connection:  connection,
+		revocations: revocations,
+	}
+	authenticate := func(ctx *gin.Context) {
+		ctx.Set(string(commonJWT.ClaimsContextKey), &commonJWT.TokenClaims{
+			Type:   commonToken.AuthTokenType,
+			UserID: testUserID,
+			Expiry: time.Now().Add(time.Minute),
+		})
+		ctx.Set(string(commonJWT.JWTTokenKey), &jwt.Token{Raw: testAccessToken})
+	}
+	router := gin.New()
+	router.DELETE("/user/sessions", authenticate, service.Logout)
+	router.DELETE("/user/sessions/all", authenticate, service.LogoutEverywhere)
+	return router
+}
+
+func signRefreshToken(t *testing.T, userID string) string {
+	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		commonJWT.TypeClaim:   string(commonToken.RefreshTokenType),
+		commonJWT.UserIDClaim: userID,
+		commonJWT.ExpiryClaim: time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("secret"))
+	assert.NoError(t, err)
+	return refreshToken
+}
+
+func deleteSession(router *gin.Engine, path string, body interface{}) *httptest.ResponseRecorder {
+	var requestBody bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&requestBody).Encode(body)
+	}
+	request := httptest.NewRequest(http.MethodDelete, path, &requestBody)
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func isTokenRevoked(t *testing.T, revocations revocation.Storer, rawToken string) bool {
+	revoked, err := revocations.IsTokenRevoked(context.Background(), revocation.TokenID(nil, rawToken))
+	assert.NoError(t, err)
+	return revoked
+}
+
+func TestLogout(t *testing.T) {
+	t.Run("Logout_Revokes_Access_And_Refresh_Tokens", func(t *testing.T) {
+		server := &sessionServer{}
+		connection := startSessionServer(t, server)
+		revocations := revocation.NewMemoryRevocationStore(24 * time.Hour)
+		router := newSessionRouter(connection, revocations)
+		refreshToken := signRefreshToken(t, testUserID)
+
+		recorder := deleteSession(router, "/user/sessions", routes.LogoutRequestBody{RefreshToken: refreshToken})
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, pb_authentication_sessions.AuthenticationService_Logout_FullMethodName, server.method)
+		assert.Equal(t, []string{"Bearer " + refreshToken}, server.authorization)
+		assert.True(t, isTokenRevoked(t, revocations, testAccessToken))
+		assert.True(t, isTokenRevoked(t, revocations, refreshToken))
+	})
+
+	t.Run("Logout_Without_Logout_RPC_Revoked_At_Gateway", func(t *testing.T) {
+		// The shared buffered server implements none of the session RPCs
+		connection := dialBufferedServer(t, lis, nil)
+		revocations := revocation.NewMemoryRevocationStore(24 * time.Hour)
+		router := newSessionRouter(connection, revocations)
+		refreshToken := signRefreshToken(t, testUserID)
+
+		recorder := deleteSession(router, "/user/sessions", routes.LogoutRequestBody{RefreshToken: refreshToken})
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.True(t, isTokenRevoked(t, revocations, testAccessToken))
+		assert.True(t, isTokenRevoked(t, revocations, refreshToken))
+	})
+
+	t.Run("Logout_Without_Refresh_Token_Revokes_Access_Token", func(t *testing.T) {
+		server := &sessionServer{}
+		connection := startSessionServer(t, server)
+		revocations := revocation.NewMemoryRevocationStore(24 * time.Hour)
+		router := newSessionRouter(connection, revocations)
+
+		recorder := deleteSession(router, "/user/sessions", nil)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Empty(t, server.method)
+		assert.True(t, isTokenRevoked(t, revocations, testAccessToken))
+	})
+
+	t.Run("Logout_Refresh_Token_Of_Another_User_Error", func(t *testing.T) {
+		connection := dialBufferedServer(t, lis, nil)
+		revocations := revocation.NewMemoryRevocationStore(24 * time.Hour)
+		router := newSessionRouter(connection, revocations)
+		refreshToken := signRefreshToken(t, "another-user-id")
+
+		recorder := deleteSession(router, "/user/sessions", routes.LogoutRequestBody{RefreshToken: refreshToken})
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "The refresh token does not belong to the user")
+		assert.False(t, isTokenRevoked(t, revocations, testAccessToken))
+	})
+
+	t.Run("LogoutEverywhere_Without_RPC_Revoked_At_Gateway", func(t *testing.T) {
+		connection := dialBufferedServer(t, lis, nil)
+		revocations := revocation.NewMemoryRevocationStore(24 * time.Hour)
+		router := newSessionRouter(connection, revocations)
+
+		recorder := deleteSession(router, "/user/sessions/all", nil)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		revokedBefore, err := revocations.UserTokensRevokedBefore(context.Background(), testUserID)
+		assert.NoError(t, err)
+		assert.False(t, revokedBefore.IsZero())
+	})
+
+	t.Run("LogoutEverywhere_Revokes_User_Tokens", func(t *testing.T) {
+		server := &sessionServer{}
+		connection := startSessionServer(t, server)
+		revocations := revocation.NewMemoryRevocationStore(24 * time.Hour)
+		router := newSessionRouter(connection, revocations)
+
+		recorder := deleteSession(router, "/user/sessions/all", nil)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, pb_authentication_sessions.AuthenticationService_LogoutEverywhere_FullMethodName, server.method)
+		assert.Equal(t, []string{"Bearer " + testAccessToken}, server.authorization)
+		revoked, err := revocation.IsRevoked(context.Background(), revocations, revocation.Token{
+			ID:       "another-session",
+			UserID:   testUserID,
+			IssuedAt: time.Now().Add(-time.Minute),
+		})
+		assert.NoError(t, err)
+		assert.True(t, revoked)
+	})
+}
@@ -59,6 +59,34 @@ func (mr *MockServiceClienterMockRecorder) AuthenticateWithFirebase(ctx interfac
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuthenticateWithFirebase", reflect.TypeOf((*MockServiceClienter)(nil).AuthenticateWithFirebase), ctx)
 }
 
+// CheckHealth mocks base method.
+func (m *MockServiceClienter) CheckHealth(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckHealth", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CheckHealth indicates an expected call of CheckHealth.
+func (mr *MockServiceClienterMockRecorder) CheckHealth(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckHealth", reflect.TypeOf((*MockServiceClienter)(nil).CheckHealth), ctx)
+}
+
+// Close mocks base method.
+func (m *MockServiceClienter) Close() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockServiceClienterMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockServiceClienter)(nil).Close))
+}
+
 // DeleteAccount mocks base method.
 func (m *MockServiceClienter) DeleteAccount(ctx *gin.Context) {
 	m.ctrl.T.Helper()
@@ -110,6 +138,42 @@ func (mr *MockServiceClienterMockRecorder) GetUserProfile(ctx interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserProfile", reflect.TypeOf((*MockServiceClienter)(nil).GetUserProfile), ctx)
 }
 
+// Logout mocks base method.
+func (m *MockServiceClienter) Logout(ctx *gin.Context) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Logout", ctx)
+}
+
+// Logout indicates an expected call of Logout.
+func (mr *MockServiceClienterMockRecorder) Logout(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Logout", reflect.TypeOf((*MockServiceClienter)(nil).Logout), ctx)
+}
+
+// LogoutEverywhere mocks base method.
+func (m *MockServiceClienter) LogoutEverywhere(ctx *gin.Context) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "LogoutEverywhere", ctx)
+}
+
+// LogoutEverywhere indicates an expected call of LogoutEverywhere.
+func (mr *MockServiceClienterMockRecorder) LogoutEverywhere(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogoutEverywhere", reflect.TypeOf((*MockServiceClienter)(nil).LogoutEverywhere), ctx)
+}
+
+// PatchUserProfile mocks base method.
+func (m *MockServiceClienter) PatchUserProfile(ctx *gin.Context) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "PatchUserProfile", ctx)
+}
+
+// PatchUserProfile indicates an expected call of PatchUserProfile.
+func (mr *MockServiceClienterMockRecorder) PatchUserProfile(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PatchUserProfile", reflect.TypeOf((*MockServiceClienter)(nil).PatchUserProfile), ctx)
+}
+
 // RefreshToken mocks base method.
 func (m *MockServiceClienter) RefreshToken(ctx *gin.Context) {
 	m.ctrl.T.Helper()
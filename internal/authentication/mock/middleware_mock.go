@@ -34,6 +34,20 @@ func (m *MockAutheticationMiddlewarer) EXPECT() *MockAutheticationMiddlewarerMoc
 	return m.recorder
 }
 
+// HasPublicKeys mocks base method.
+func (m *MockAutheticationMiddlewarer) HasPublicKeys() bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HasPublicKeys")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// HasPublicKeys indicates an expected call of HasPublicKeys.
+func (mr *MockAutheticationMiddlewarerMockRecorder) HasPublicKeys() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasPublicKeys", reflect.TypeOf((*MockAutheticationMiddlewarer)(nil).HasPublicKeys))
+}
+
 // RefreshAuthentication mocks base method.
 func (m *MockAutheticationMiddlewarer) RefreshAuthentication(arg0 *gin.Context) {
 	m.ctrl.T.Helper()
@@ -57,3 +71,15 @@ func (mr *MockAutheticationMiddlewarerMockRecorder) RequireAuthentication(arg0 i
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequireAuthentication", reflect.TypeOf((*MockAutheticationMiddlewarer)(nil).RequireAuthentication), arg0)
 }
+
+// Stop mocks base method.
+func (m *MockAutheticationMiddlewarer) Stop() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Stop")
+}
+
+// Stop indicates an expected call of Stop.
+func (mr *MockAutheticationMiddlewarerMockRecorder) Stop() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stop", reflect.TypeOf((*MockAutheticationMiddlewarer)(nil).Stop))
+}
@@ -0,0 +1,136 @@
+// Package pb_authentication_sessions holds the service code of authentication.proto, laid out as
+// protoc-gen-go-grpc generates it. The messages are those generated in the shared pb_authentication
+// package and emptypb, so the package goes away once the session RPCs are merged into the shared definitions.
+package pb_authentication_sessions
+
+import (
+	"context"
+
+	"github.com/quadev-ltd/qd-common/pb/gen/go/pb_authentication"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Full method names of the session RPCs of the authentication service
+const (
+	AuthenticationService_Logout_FullMethodName           = "/pb_authentication.AuthenticationService/Logout"
+	AuthenticationService_LogoutEverywhere_FullMethodName = "/pb_authentication.AuthenticationService/LogoutEverywhere"
+)
+
+// AuthenticationServiceClient is the client API for the AuthenticationService service
+type AuthenticationServiceClient interface {
+	pb_authentication.AuthenticationServiceClient
+	Logout(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*pb_authentication.BaseResponse, error)
+	LogoutEverywhere(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*pb_authentication.BaseResponse, error)
+}
+
+type authenticationServiceClient struct {
+	pb_authentication.AuthenticationServiceClient
+	cc grpc.ClientConnInterface
+}
+
+// NewAuthenticationServiceClient creates a client of the authentication service on the connection
+func NewAuthenticationServiceClient(cc grpc.ClientConnInterface) AuthenticationServiceClient {
+	return &authenticationServiceClient{pb_authentication.NewAuthenticationServiceClient(cc), cc}
+}
+
+func (c *authenticationServiceClient) Logout(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*pb_authentication.BaseResponse, error) {
+	out := new(pb_authentication.BaseResponse)
+	err := c.cc.Invoke(ctx, AuthenticationService_Logout_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authenticationServiceClient) LogoutEverywhere(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*pb_authentication.BaseResponse, error) {
+	out := new(pb_authentication.BaseResponse)
+	err := c.cc.Invoke(ctx, AuthenticationService_LogoutEverywhere_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AuthenticationServiceServer is the server API for the AuthenticationService service.
+// All implementations must embed UnimplementedAuthenticationServiceServer for forward compatibility.
+type AuthenticationServiceServer interface {
+	pb_authentication.AuthenticationServiceServer
+	Logout(context.Context, *emptypb.Empty) (*pb_authentication.BaseResponse, error)
+	LogoutEverywhere(context.Context, *emptypb.Empty) (*pb_authentication.BaseResponse, error)
+}
+
+// UnimplementedAuthenticationServiceServer must be embedded to have forward compatible implementations
+type UnimplementedAuthenticationServiceServer struct {
+	pb_authentication.UnimplementedAuthenticationServiceServer
+}
+
+func (UnimplementedAuthenticationServiceServer) Logout(context.Context, *emptypb.Empty) (*pb_authentication.BaseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Logout not implemented")
+}
+
+func (UnimplementedAuthenticationServiceServer) LogoutEverywhere(context.Context, *emptypb.Empty) (*pb_authentication.BaseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LogoutEverywhere not implemented")
+}
+
+// RegisterAuthenticationServiceServer registers the implementation of the authentication service with the server
+func RegisterAuthenticationServiceServer(s grpc.ServiceRegistrar, srv AuthenticationServiceServer) {
+	s.RegisterService(&AuthenticationService_ServiceDesc, srv)
+}
+
+func _AuthenticationService_Logout_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthenticationServiceServer).Logout(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AuthenticationService_Logout_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthenticationServiceServer).Logout(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthenticationService_LogoutEverywhere_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthenticationServiceServer).LogoutEverywhere(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AuthenticationService_LogoutEverywhere_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthenticationServiceServer).LogoutEverywhere(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// AuthenticationService_ServiceDesc is the grpc.ServiceDesc for the AuthenticationService service,
+// only intended for direct use with grpc.RegisterService
+var AuthenticationService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "pb_authentication.AuthenticationService",
+	HandlerType: (*AuthenticationServiceServer)(nil),
+	Methods: append([]grpc.MethodDesc{
+		{
+			MethodName: "Logout",
+			Handler:    _AuthenticationService_Logout_Handler,
+		},
+		{
+			MethodName: "LogoutEverywhere",
+			Handler:    _AuthenticationService_LogoutEverywhere_Handler,
+		},
+	}, pb_authentication.AuthenticationService_ServiceDesc.Methods...),
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "authentication.proto",
+}
@@ -3,39 +3,53 @@ package authentication
 import (
 	"github.com/gin-gonic/gin"
 	commonConfig "github.com/quadev-ltd/qd-common/pkg/config"
-	"golang.org/x/time/rate"
 
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/middleware"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/revocation"
 )
 
-// RegisterRoutes registers the authentication routes
+// RegisterRoutes registers the authentication routes, applying the configured authorization and rate limit policies and
+// revoking the user tokens after a password reset or an account deletion
 func RegisterRoutes(
 	service ServiceClienter,
 	api *gin.RouterGroup,
 	centralConfig *commonConfig.Config,
 	configurations *config.Config,
 	authenticationMiddleware middleware.AutheticationMiddlewarer,
+	routePolicies []middleware.RoutePolicier,
+	revocations revocation.Storer,
 ) error {
+	router := middleware.NewPolicyRouter(api, routePolicies...)
 
-	rl := middleware.NewRateLimiter(rate.Limit(0.08), 5)
-
-	userRoutes := api.Group("/user")
-	userRoutes.POST("/", middleware.RateLimitMiddleware(rl), service.Register)
+	userRoutes := router.Group("/user")
+	userRoutes.POST("/", service.Register)
 	userRoutes.POST("/:userID/email/:verificationToken", service.VerifyEmail)
-	userRoutes.POST("/sessions", middleware.RateLimitMiddleware(rl), service.Authenticate)
-	userRoutes.POST("/firebase/sessions", middleware.RateLimitMiddleware(rl), service.AuthenticateWithFirebase)
-	userRoutes.POST("/:userID/email/verification", middleware.RateLimitMiddleware(rl), service.ResendEmailVerification)
-	userRoutes.POST("/password/reset", middleware.RateLimitMiddleware(rl), service.ForgotPassword)
-	userRoutes.GET("/:userID/password/reset-verification/:verificationToken", middleware.RateLimitMiddleware(rl), service.VerifyResetPasswordToken)
-	userRoutes.POST("/:userID/password/reset/:verificationToken", middleware.RateLimitMiddleware(rl), service.ResetPassword)
+	userRoutes.POST("/sessions", service.Authenticate)
+	userRoutes.POST("/firebase/sessions", service.AuthenticateWithFirebase)
+	userRoutes.DELETE("/sessions", authenticationMiddleware.RequireAuthentication, service.Logout)
+	userRoutes.DELETE("/sessions/all", authenticationMiddleware.RequireAuthentication, service.LogoutEverywhere)
+	userRoutes.POST("/:userID/email/verification", service.ResendEmailVerification)
+	userRoutes.POST("/password/reset", service.ForgotPassword)
+	userRoutes.GET("/:userID/password/reset-verification/:verificationToken", service.VerifyResetPasswordToken)
+	userRoutes.POST(
+		"/:userID/password/reset/:verificationToken",
+		revocation.RevokeUserTokensOnSuccess(revocations, revocation.UserIDFromParam),
+		service.ResetPassword,
+	)
 	userRoutes.GET("/profile", authenticationMiddleware.RequireAuthentication, service.GetUserProfile)
 	userRoutes.PUT("/profile", authenticationMiddleware.RequireAuthentication, service.UpdateUserProfile)
-	userRoutes.DELETE("", authenticationMiddleware.RequireAuthentication, service.DeleteAccount)
+	userRoutes.PATCH("/profile", authenticationMiddleware.RequireAuthentication, service.PatchUserProfile)
+	userRoutes.DELETE(
+		"",
+		authenticationMiddleware.RequireAuthentication,
+		revocation.RevokeUserTokensOnSuccess(revocations, revocation.UserIDFromClaims),
+		service.DeleteAccount,
+	)
 
-	authenticationRoutes := api.Group("/authentication")
+	authenticationRoutes := router.Group("/authentication")
 	authenticationRoutes.Use(authenticationMiddleware.RefreshAuthentication)
 	authenticationRoutes.POST("/refresh", service.RefreshToken)
 
-	return nil
+	return router.Err()
 }
@@ -7,20 +7,20 @@ import (
 	"github.com/quadev-ltd/qd-common/pb/gen/go/pb_authentication"
 
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/validation"
 )
 
 // AuthenticateRequestBody is the request body for the Authenticate route
 type AuthenticateRequestBody struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
 }
 
 // Authenticate authenticates a user
 func Authenticate(ctx *gin.Context, client pb_authentication.AuthenticationServiceClient) {
 	body := AuthenticateRequestBody{}
 
-	if err := ctx.BindJSON(&body); err != nil {
-		ctx.AbortWithError(http.StatusBadRequest, err)
+	if !validation.BindJSON(ctx, &body) {
 		return
 	}
 
@@ -7,22 +7,22 @@ import (
 	"github.com/quadev-ltd/qd-common/pb/gen/go/pb_authentication"
 
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/validation"
 )
 
 // AuthenticateWithFirebaseRequestBody is the request body for the Authenticate route
 type AuthenticateWithFirebaseRequestBody struct {
-	Email     string `json:"email"`
-	FirstName string `json:"firstName"`
-	LastName  string `json:"lastName"`
-	IDToken   string `json:"idToken"`
+	Email     string `json:"email" binding:"omitempty,email"`
+	FirstName string `json:"firstName" binding:"max=100"`
+	LastName  string `json:"lastName" binding:"max=100"`
+	IDToken   string `json:"idToken" binding:"required"`
 }
 
 // AuthenticateWithFirebase authenticates a user using firebase
 func AuthenticateWithFirebase(ctx *gin.Context, client pb_authentication.AuthenticationServiceClient) {
 	body := AuthenticateWithFirebaseRequestBody{}
 
-	if err := ctx.BindJSON(&body); err != nil {
-		ctx.AbortWithError(http.StatusBadRequest, err)
+	if !validation.BindJSON(ctx, &body) {
 		return
 	}
 
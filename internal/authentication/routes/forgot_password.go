@@ -7,19 +7,19 @@ import (
 	"github.com/quadev-ltd/qd-common/pb/gen/go/pb_authentication"
 
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/validation"
 )
 
 // ForgotPasswordRequestBody is the request body for the ForgotPassword route
 type ForgotPasswordRequestBody struct {
-	Email string `json:"email" required:"true"`
+	Email string `json:"email" binding:"required,email"`
 }
 
 // ForgotPassword requests a password reset email
 func ForgotPassword(ctx *gin.Context, client pb_authentication.AuthenticationServiceClient) {
 	body := ForgotPasswordRequestBody{}
 
-	if err := ctx.BindJSON(&body); err != nil {
-		ctx.AbortWithError(http.StatusBadRequest, err)
+	if !validation.BindJSON(ctx, &body) {
 		return
 	}
 	res, err := client.ForgotPassword(
@@ -1,15 +1,55 @@
 package routes
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/quadev-ltd/qd-common/pb/gen/go/pb_authentication"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
 )
 
-// GetUserProfile requests a user's profile
+// ProfileETag returns the strong entity tag of a user profile, derived from its deterministic encoding
+func ProfileETag(user *pb_authentication.User) (string, error) {
+	encoded, err := proto.MarshalOptions{Deterministic: true}.Marshal(user)
+	if err != nil {
+		return "", fmt.Errorf("Could not encode the user profile: %v", err)
+	}
+	hash := sha256.Sum256(encoded)
+	return fmt.Sprintf(`"%s"`, hex.EncodeToString(hash[:16])), nil
+}
+
+// matchesETag reports whether an If-Match or If-None-Match header lists the entity tag, or any tag with "*".
+// The weak comparison matches the weak tags by their opaque value, the strong comparison never matches them.
+func matchesETag(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// setProfileETag sets the ETag header of the user profile returned
+func setProfileETag(ctx *gin.Context, user *pb_authentication.User) error {
+	etag, err := ProfileETag(user)
+	if err != nil {
+		return err
+	}
+	ctx.Header("ETag", etag)
+	return nil
+}
+
+// GetUserProfile requests a user's profile, tagged with its ETag and not sent again while it matches If-None-Match
 func GetUserProfile(ctx *gin.Context, client pb_authentication.AuthenticationServiceClient) {
 	res, err := client.GetUserProfile(
 		ctx.Request.Context(),
@@ -20,6 +60,16 @@ func GetUserProfile(ctx *gin.Context, client pb_authentication.AuthenticationSer
 		errors.HandleError(ctx, err)
 		return
 	}
+	etag, err := ProfileETag(res.User)
+	if err != nil {
+		errors.HandleError(ctx, err)
+		return
+	}
+	ctx.Header("ETag", etag)
+	if ifNoneMatch := ctx.GetHeader("If-None-Match"); ifNoneMatch != "" && matchesETag(ifNoneMatch, etag, true) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
 
 	ctx.JSON(http.StatusOK, &res)
 }
@@ -0,0 +1,167 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/quadev-ltd/qd-common/pb/gen/go/pb_authentication"
+	commonJWT "github.com/quadev-ltd/qd-common/pkg/jwt"
+	commonLogger "github.com/quadev-ltd/qd-common/pkg/log"
+	commonToken "github.com/quadev-ltd/qd-common/pkg/token"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/authentication/pb_authentication_sessions"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/revocation"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/validation"
+)
+
+// LogoutRequestBody is the request body for the Logout route
+type LogoutRequestBody struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// authenticatedToken returns the verified access token and its claims set by the authentication middleware
+func authenticatedToken(ctx *gin.Context) (*jwt.Token, *commonJWT.TokenClaims, error) {
+	claimsValue, claimsExist := ctx.Get(string(commonJWT.ClaimsContextKey))
+	tokenValue, tokenExists := ctx.Get(string(commonJWT.JWTTokenKey))
+	if !claimsExist || !tokenExists {
+		return nil, nil, fmt.Errorf("No authenticated token in the request context")
+	}
+	claims, claimsOK := claimsValue.(*commonJWT.TokenClaims)
+	token, tokenOK := tokenValue.(*jwt.Token)
+	if !claimsOK || !tokenOK {
+		return nil, nil, fmt.Errorf("Invalid authenticated token in the request context")
+	}
+	return token, claims, nil
+}
+
+// refreshTokenExpiry checks that the refresh token belongs to the user and returns its expiry.
+// The signature is not verified: revoking a forged token only denies a token nobody holds.
+func refreshTokenExpiry(refreshToken, userID string) (time.Time, error) {
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(refreshToken, claims); err != nil {
+		return time.Time{}, fmt.Errorf("The refresh token was invalid")
+	}
+	if tokenType, _ := claims[commonJWT.TypeClaim].(string); commonToken.Type(tokenType) != commonToken.RefreshTokenType {
+		return time.Time{}, fmt.Errorf("The refresh token was not a %s", commonToken.RefreshTokenType)
+	}
+	if tokenUserID, _ := claims[commonJWT.UserIDClaim].(string); tokenUserID != userID {
+		return time.Time{}, fmt.Errorf("The refresh token does not belong to the user")
+	}
+	expiry, ok := claims[commonJWT.ExpiryClaim].(float64)
+	if !ok {
+		return time.Time{}, fmt.Errorf("The refresh token has no expiry")
+	}
+	return time.Unix(int64(expiry), 0), nil
+}
+
+// endSession calls a session RPC of the authentication service authorized with the given token, once the
+// gateway revocation is stored, aborting the request and returning false if it fails. An authentication
+// service without the RPC only logs it: the gateway revocation ends the session, although its refresh tokens
+// are accepted again by the replicas with a memory revocation store once they restart.
+func endSession(
+	ginCtx *gin.Context,
+	call func(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*pb_authentication.BaseResponse, error),
+	method, token string,
+) bool {
+	ctx := ginCtx.Request.Context()
+	outgoingMetadata, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		outgoingMetadata = outgoingMetadata.Copy()
+	} else {
+		outgoingMetadata = metadata.MD{}
+	}
+	outgoingMetadata.Set("authorization", "Bearer "+token)
+
+	_, err := call(metadata.NewOutgoingContext(ctx, outgoingMetadata), &emptypb.Empty{})
+	if status.Code(err) == codes.Unimplemented {
+		if logger, loggerErr := commonLogger.GetLoggerFromContext(ctx); loggerErr == nil {
+			logger.Error(err, fmt.Sprintf("The authentication service does not implement %s, the session is only revoked at the gateway", method))
+		}
+		return true
+	}
+	if err != nil {
+		errors.HandleError(ginCtx, err)
+		return false
+	}
+	return true
+}
+
+// Logout revokes the bearer access token at the gateway and the refresh token of the body, if any,
+// at the gateway and in the authentication service
+func Logout(ctx *gin.Context, client pb_authentication_sessions.AuthenticationServiceClient, revocations revocation.Storer) {
+	accessToken, claims, err := authenticatedToken(ctx)
+	if err != nil {
+		errors.Abort(ctx, errors.New(http.StatusUnauthorized, errors.Unauthorized, err.Error()))
+		return
+	}
+	body := LogoutRequestBody{}
+	if ctx.Request.ContentLength != 0 {
+		if !validation.BindJSON(ctx, &body) {
+			return
+		}
+	}
+
+	var refreshExpiry time.Time
+	if body.RefreshToken != "" {
+		refreshExpiry, err = refreshTokenExpiry(body.RefreshToken, claims.UserID)
+		if err != nil {
+			errors.Abort(ctx, errors.New(http.StatusBadRequest, errors.BadRequest, err.Error()))
+			return
+		}
+	}
+
+	requestContext := ctx.Request.Context()
+	err = revocations.RevokeToken(requestContext, revocation.TokenID(accessToken, accessToken.Raw), claims.Expiry)
+	if err == nil && body.RefreshToken != "" {
+		err = revocations.RevokeToken(requestContext, revocation.TokenID(nil, body.RefreshToken), refreshExpiry)
+	}
+	if err != nil {
+		errors.Abort(ctx, errors.New(http.StatusServiceUnavailable, errors.Unavailable, "Could not revoke the session").WithCause(err))
+		return
+	}
+
+	if body.RefreshToken != "" {
+		if !endSession(ctx, client.Logout, pb_authentication_sessions.AuthenticationService_Logout_FullMethodName, body.RefreshToken) {
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, &pb_authentication.BaseResponse{
+		Success: true,
+		Message: "Logged out",
+	})
+}
+
+// LogoutEverywhere revokes every token issued to the user so far, ending all their sessions
+func LogoutEverywhere(ctx *gin.Context, client pb_authentication_sessions.AuthenticationServiceClient, revocations revocation.Storer) {
+	accessToken, claims, err := authenticatedToken(ctx)
+	if err != nil {
+		errors.Abort(ctx, errors.New(http.StatusUnauthorized, errors.Unauthorized, err.Error()))
+		return
+	}
+
+	requestContext := ctx.Request.Context()
+	if err := revocations.RevokeUserTokens(requestContext, claims.UserID, time.Now()); err != nil {
+		errors.Abort(ctx, errors.New(http.StatusServiceUnavailable, errors.Unavailable, "Could not revoke the sessions").WithCause(err))
+		return
+	}
+
+	if !endSession(ctx, client.LogoutEverywhere, pb_authentication_sessions.AuthenticationService_LogoutEverywhere_FullMethodName, accessToken.Raw) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &pb_authentication.BaseResponse{
+		Success: true,
+		Message: "Logged out of every session",
+	})
+}
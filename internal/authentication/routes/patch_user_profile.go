@@ -0,0 +1,132 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/quadev-ltd/qd-common/pb/gen/go/pb_authentication"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/validation"
+)
+
+// MergePatchContentType is the media type of the JSON Merge Patch documents (RFC 7396)
+const MergePatchContentType = "application/merge-patch+json"
+
+// clearField empties a field of the body, so that a patch removing it fails its validation
+func (body *UpdateUserProfileRequestBody) clearField(field string) {
+	switch field {
+	case "firstName":
+		body.FirstName = ""
+	case "lastName":
+		body.LastName = ""
+	case "dateOfBirth":
+		body.DateOfBirth = nil
+	}
+}
+
+// profileBody returns the body replacing the profile with its current values
+func profileBody(user *pb_authentication.User) *UpdateUserProfileRequestBody {
+	body := &UpdateUserProfileRequestBody{
+		FirstName: user.GetFirstName(),
+		LastName:  user.GetLastName(),
+	}
+	if user.GetDateOfBirth() != nil {
+		dateOfBirth := user.GetDateOfBirth().AsTime().Unix()
+		body.DateOfBirth = &dateOfBirth
+	}
+	return body
+}
+
+// readPatch reads the JSON object of a partial JSON or JSON Merge Patch body, aborting the request and returning
+// false if it is not one or it changes a field that cannot be patched
+func readPatch(ctx *gin.Context) (map[string]json.RawMessage, []byte, bool) {
+	contentType := ctx.ContentType()
+	if contentType != binding.MIMEJSON && contentType != MergePatchContentType {
+		errors.Abort(ctx, errors.New(
+			http.StatusUnsupportedMediaType,
+			errors.UnsupportedMediaType,
+			fmt.Sprintf("The body must be %s or %s", binding.MIMEJSON, MergePatchContentType),
+		))
+		return nil, nil, false
+	}
+	rawPatch, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		errors.Abort(ctx, errors.InvalidBody(err))
+		return nil, nil, false
+	}
+	patch := map[string]json.RawMessage{}
+	if err := json.Unmarshal(rawPatch, &patch); err != nil {
+		errors.Abort(ctx, errors.InvalidBody(err))
+		return nil, nil, false
+	}
+
+	fieldErrors := []errors.FieldError{}
+	for field := range patch {
+		if _, err := fieldmaskpb.New(&pb_authentication.UpdateUserProfileRequest{}, field); err != nil {
+			fieldErrors = append(fieldErrors, errors.FieldError{Field: field, Error: fmt.Sprintf("%s cannot be updated", field)})
+		}
+	}
+	if len(fieldErrors) > 0 {
+		sort.Slice(fieldErrors, func(i, j int) bool { return fieldErrors[i].Field < fieldErrors[j].Field })
+		errors.Abort(ctx, errors.New(http.StatusBadRequest, errors.ValidationFailed, "The request body is invalid").
+			WithFieldErrors(fieldErrors))
+		return nil, nil, false
+	}
+	return patch, rawPatch, true
+}
+
+// PatchUserProfile updates the fields of a user's profile sent in a partial JSON or JSON Merge Patch body, where null
+// removes a field. The patch is merged into the current profile, which must match If-Match if the header is sent.
+// The UpdateUserProfileRequest carries neither a version nor a field mask, so the whole merged profile replaces the
+// stored one and the If-Match check is best-effort: an update landing between the read and the write is overwritten.
+func PatchUserProfile(ctx *gin.Context, client pb_authentication.AuthenticationServiceClient) {
+	patch, rawPatch, ok := readPatch(ctx)
+	if !ok {
+		return
+	}
+	user, ok := getProfile(ctx, client)
+	if !ok || !checkIfMatch(ctx, user) {
+		return
+	}
+
+	body := profileBody(user)
+	for field := range patch {
+		body.clearField(field)
+	}
+	if err := json.Unmarshal(rawPatch, body); err != nil {
+		validation.Abort(ctx, err)
+		return
+	}
+	// Only the patched fields are validated, a profile stored without a date of birth can still be patched
+	fields := make([]string, 0, len(patch))
+	for field := range patch {
+		fields = append(fields, field)
+	}
+	if !validation.ValidateFields(ctx, body, fields...) {
+		return
+	}
+	if len(patch) == 0 {
+		if err := setProfileETag(ctx, user); err != nil {
+			errors.HandleError(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, &pb_authentication.UpdateUserProfileResponse{User: user})
+		return
+	}
+
+	res, err := client.UpdateUserProfile(ctx.Request.Context(), body.request())
+
+	if err != nil {
+		errors.HandleError(ctx, err)
+		return
+	}
+
+	sendUpdatedProfile(ctx, res)
+}
@@ -1,7 +1,6 @@
 package routes
 
 import (
-	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -9,27 +8,23 @@ import (
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/validation"
 )
 
 // RegisterRequestBody is the request body for the Register route
 type RegisterRequestBody struct {
-	Email       string                 `json:"email"`
-	Password    string                 `json:"password"`
-	FirstName   string                 `json:"firstName"`
-	LastName    string                 `json:"lastName"`
-	DateOfBirth *timestamppb.Timestamp `json:"dateOfBirth,omitempty"`
+	Email       string                 `json:"email" binding:"required,email,max=254"`
+	Password    string                 `json:"password" binding:"required,min=8,max=128"`
+	FirstName   string                 `json:"firstName" binding:"required,max=100"`
+	LastName    string                 `json:"lastName" binding:"required,max=100"`
+	DateOfBirth *timestamppb.Timestamp `json:"dateOfBirth,omitempty" binding:"required,past"`
 }
 
 // Register registers a new user
 func Register(ctx *gin.Context, client pb_authentication.AuthenticationServiceClient) {
 	body := RegisterRequestBody{}
 
-	if err := ctx.BindJSON(&body); err != nil {
-		ctx.AbortWithError(http.StatusBadRequest, err)
-		return
-	}
-	if body.DateOfBirth == nil {
-		ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("dateOfBirth is required"))
+	if !validation.BindJSON(ctx, &body) {
 		return
 	}
 	res, err := client.Register(ctx.Request.Context(), &pb_authentication.RegisterRequest{
@@ -7,18 +7,18 @@ import (
 	"github.com/quadev-ltd/qd-common/pb/gen/go/pb_authentication"
 
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/validation"
 )
 
 // ResetPasswordRequestBody is the request body for the ResetPassword route
 type ResetPasswordRequestBody struct {
-	Password string `json:"password" required:"true"`
+	Password string `json:"password" binding:"required,min=8,max=128"`
 }
 
 // ResetPassword resets a user's password
 func ResetPassword(ctx *gin.Context, client pb_authentication.AuthenticationServiceClient) {
 	body := ResetPasswordRequestBody{}
-	if err := ctx.BindJSON(&body); err != nil {
-		ctx.AbortWithError(http.StatusBadRequest, err)
+	if !validation.BindJSON(ctx, &body) {
 		return
 	}
 	res, err := client.ResetPassword(
@@ -9,39 +9,91 @@ import (
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/validation"
 )
 
 // UpdateUserProfileRequestBody is the request body for the UpdateUserProfile route
 type UpdateUserProfileRequestBody struct {
-	FirstName   string `json:"firstName"`
-	LastName    string `json:"lastName"`
-	DateOfBirth *int64 `json:"dateOfBirth,omitempty"`
+	FirstName   string `json:"firstName" binding:"required,max=100"`
+	LastName    string `json:"lastName" binding:"required,max=100"`
+	DateOfBirth *int64 `json:"dateOfBirth,omitempty" binding:"required,past"`
 }
 
-// UpdateUserProfile updates a user's profile
+// request returns the gRPC request updating the profile with the body, without date of birth if it has none
+func (body *UpdateUserProfileRequestBody) request() *pb_authentication.UpdateUserProfileRequest {
+	request := &pb_authentication.UpdateUserProfileRequest{
+		FirstName: body.FirstName,
+		LastName:  body.LastName,
+	}
+	if body.DateOfBirth != nil {
+		request.DateOfBirth = timestamppb.New(time.Unix(*body.DateOfBirth, 0))
+	}
+	return request
+}
+
+// getProfile requests the current profile of the user, aborting the request and returning false on failure
+func getProfile(ctx *gin.Context, client pb_authentication.AuthenticationServiceClient) (*pb_authentication.User, bool) {
+	res, err := client.GetUserProfile(ctx.Request.Context(), &pb_authentication.GetUserProfileRequest{})
+	if err != nil {
+		errors.HandleError(ctx, err)
+		return nil, false
+	}
+	return res.User, true
+}
+
+// checkIfMatch checks that the If-Match header of the request, if any, lists the ETag of the current profile,
+// aborting the request with a precondition failure and returning false otherwise. If-Match compares strongly.
+func checkIfMatch(ctx *gin.Context, user *pb_authentication.User) bool {
+	ifMatch := ctx.GetHeader("If-Match")
+	if ifMatch == "" {
+		return true
+	}
+	etag, err := ProfileETag(user)
+	if err != nil {
+		errors.HandleError(ctx, err)
+		return false
+	}
+	if !matchesETag(ifMatch, etag, false) {
+		errors.Abort(ctx, errors.New(
+			http.StatusPreconditionFailed,
+			errors.PreconditionFailed,
+			"The user profile was modified since it was read",
+		).WithDetail("etag", etag))
+		return false
+	}
+	return true
+}
+
+// sendUpdatedProfile renders the profile returned by the update with its new ETag
+func sendUpdatedProfile(ctx *gin.Context, res *pb_authentication.UpdateUserProfileResponse) {
+	if err := setProfileETag(ctx, res.User); err != nil {
+		errors.HandleError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, &res)
+}
+
+// UpdateUserProfile replaces a user's profile, only while the profile matches If-Match if the header is sent.
+// The check is best-effort, the authentication service cannot make the update conditional on the version read.
 func UpdateUserProfile(ctx *gin.Context, client pb_authentication.AuthenticationServiceClient) {
 	body := UpdateUserProfileRequestBody{}
 
-	if err := ctx.BindJSON(&body); err != nil {
-		ctx.AbortWithError(http.StatusBadRequest, err)
+	if !validation.BindJSON(ctx, &body) {
 		return
 	}
+	if ctx.GetHeader("If-Match") != "" {
+		user, ok := getProfile(ctx, client)
+		if !ok || !checkIfMatch(ctx, user) {
+			return
+		}
+	}
 
-	dateOfBirth := time.Unix(*body.DateOfBirth, 0)
-	dateOfBirthProto := timestamppb.New(dateOfBirth)
-	res, err := client.UpdateUserProfile(
-		ctx.Request.Context(),
-		&pb_authentication.UpdateUserProfileRequest{
-			FirstName:   body.FirstName,
-			LastName:    body.LastName,
-			DateOfBirth: dateOfBirthProto,
-		},
-	)
+	res, err := client.UpdateUserProfile(ctx.Request.Context(), body.request())
 
 	if err != nil {
 		errors.HandleError(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, &res)
+	sendUpdatedProfile(ctx, res)
 }
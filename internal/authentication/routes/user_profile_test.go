@@ -0,0 +1,232 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/quadev-ltd/qd-common/pb/gen/go/pb_authentication"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+)
+
+// profileClientStub keeps a user profile, updating it with the requests received
+type profileClientStub struct {
+	pb_authentication.AuthenticationServiceClient
+	user       *pb_authentication.User
+	lastUpdate *pb_authentication.UpdateUserProfileRequest
+}
+
+func (client *profileClientStub) GetUserProfile(ctx context.Context, in *pb_authentication.GetUserProfileRequest, opts ...grpc.CallOption) (*pb_authentication.GetUserProfileResponse, error) {
+	return &pb_authentication.GetUserProfileResponse{User: client.user}, nil
+}
+
+func (client *profileClientStub) UpdateUserProfile(ctx context.Context, in *pb_authentication.UpdateUserProfileRequest, opts ...grpc.CallOption) (*pb_authentication.UpdateUserProfileResponse, error) {
+	client.lastUpdate = in
+	client.user = &pb_authentication.User{
+		UserID:      client.user.UserID,
+		Email:       client.user.Email,
+		FirstName:   in.FirstName,
+		LastName:    in.LastName,
+		DateOfBirth: in.DateOfBirth,
+	}
+	return &pb_authentication.UpdateUserProfileResponse{User: client.user}, nil
+}
+
+var testDateOfBirth = time.Date(1990, time.May, 17, 0, 0, 0, 0, time.UTC)
+
+func newProfileClientStub() *profileClientStub {
+	return &profileClientStub{user: &pb_authentication.User{
+		UserID:      "user-id",
+		Email:       "test@example.com",
+		FirstName:   "Test",
+		LastName:    "User",
+		DateOfBirth: timestamppb.New(testDateOfBirth),
+	}}
+}
+
+func newProfileRouter(client pb_authentication.AuthenticationServiceClient) *gin.Engine {
+	router := gin.New()
+	router.GET("/profile", func(ctx *gin.Context) { GetUserProfile(ctx, client) })
+	router.PUT("/profile", func(ctx *gin.Context) { UpdateUserProfile(ctx, client) })
+	router.PATCH("/profile", func(ctx *gin.Context) { PatchUserProfile(ctx, client) })
+	return router
+}
+
+func serveProfile(router *gin.Engine, method, body string, headers map[string]string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, "/profile", bytes.NewBufferString(body))
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func decodeEnvelope(t *testing.T, recorder *httptest.ResponseRecorder) errors.Envelope {
+	envelope := errors.Envelope{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &envelope))
+	return envelope
+}
+
+func TestUserProfile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("GetUserProfile_ETag_And_Not_Modified", func(t *testing.T) {
+		client := newProfileClientStub()
+		router := newProfileRouter(client)
+		etag, err := ProfileETag(client.user)
+		assert.NoError(t, err)
+
+		recorder := serveProfile(router, http.MethodGet, "", nil)
+		notModifiedRecorder := serveProfile(router, http.MethodGet, "", map[string]string{"If-None-Match": "W/" + etag})
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, etag, recorder.Header().Get("ETag"))
+		assert.Equal(t, http.StatusNotModified, notModifiedRecorder.Code)
+		assert.Empty(t, notModifiedRecorder.Body.String())
+	})
+
+	t.Run("PatchUserProfile_Merges_Changed_Fields", func(t *testing.T) {
+		client := newProfileClientStub()
+		router := newProfileRouter(client)
+
+		recorder := serveProfile(router, http.MethodPatch, `{"firstName":"Changed"}`, nil)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "Changed", client.lastUpdate.FirstName)
+		assert.Equal(t, "User", client.lastUpdate.LastName)
+		assert.Equal(t, testDateOfBirth, client.lastUpdate.DateOfBirth.AsTime())
+		etag, _ := ProfileETag(client.user)
+		assert.Equal(t, etag, recorder.Header().Get("ETag"))
+	})
+
+	t.Run("PatchUserProfile_Without_Stored_Date_Of_Birth", func(t *testing.T) {
+		client := newProfileClientStub()
+		client.user.DateOfBirth = nil
+		router := newProfileRouter(client)
+
+		recorder := serveProfile(router, http.MethodPatch, `{"firstName":"Changed"}`, nil)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "Changed", client.lastUpdate.FirstName)
+		assert.Equal(t, "User", client.lastUpdate.LastName)
+		assert.Nil(t, client.lastUpdate.DateOfBirth)
+	})
+
+	t.Run("PatchUserProfile_Merge_Patch_Content_Type", func(t *testing.T) {
+		client := newProfileClientStub()
+		router := newProfileRouter(client)
+
+		recorder := serveProfile(router, http.MethodPatch, `{"lastName":"Changed","dateOfBirth":0}`, map[string]string{
+			"Content-Type": MergePatchContentType,
+		})
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "Test", client.lastUpdate.FirstName)
+		assert.Equal(t, "Changed", client.lastUpdate.LastName)
+		assert.Equal(t, int64(0), client.lastUpdate.DateOfBirth.Seconds)
+	})
+
+	t.Run("PatchUserProfile_Matching_If_Match", func(t *testing.T) {
+		client := newProfileClientStub()
+		router := newProfileRouter(client)
+		etag, _ := ProfileETag(client.user)
+
+		recorder := serveProfile(router, http.MethodPatch, `{"firstName":"Changed"}`, map[string]string{"If-Match": etag})
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("PatchUserProfile_Stale_If_Match_Precondition_Failed", func(t *testing.T) {
+		client := newProfileClientStub()
+		router := newProfileRouter(client)
+		etag, _ := ProfileETag(client.user)
+
+		recorder := serveProfile(router, http.MethodPatch, `{"firstName":"Changed"}`, map[string]string{"If-Match": `"stale"`})
+
+		assert.Equal(t, http.StatusPreconditionFailed, recorder.Code)
+		envelope := decodeEnvelope(t, recorder)
+		assert.Equal(t, errors.PreconditionFailed, envelope.Code)
+		assert.Equal(t, etag, envelope.Details["etag"])
+		assert.Nil(t, client.lastUpdate)
+	})
+
+	t.Run("PatchUserProfile_Invalid_Patches", func(t *testing.T) {
+		testCases := []struct {
+			name        string
+			body        string
+			contentType string
+			status      int
+			fieldErrors []errors.FieldError
+		}{
+			{
+				name:        "Unknown_Field",
+				body:        `{"email":"other@example.com","firstName":"Changed"}`,
+				status:      http.StatusBadRequest,
+				fieldErrors: []errors.FieldError{{Field: "email", Error: "email cannot be updated"}},
+			},
+			{
+				name:        "Null_Removes_Required_Field",
+				body:        `{"lastName":null}`,
+				status:      http.StatusBadRequest,
+				fieldErrors: []errors.FieldError{{Field: "lastName", Error: "lastName is required"}},
+			},
+			{
+				name:        "Null_Removes_Date_Of_Birth",
+				body:        `{"dateOfBirth":null}`,
+				status:      http.StatusBadRequest,
+				fieldErrors: []errors.FieldError{{Field: "dateOfBirth", Error: "dateOfBirth is required"}},
+			},
+			{
+				name:        "Field_Of_Wrong_Type",
+				body:        `{"dateOfBirth":"1990-05-17"}`,
+				status:      http.StatusBadRequest,
+				fieldErrors: []errors.FieldError{{Field: "dateOfBirth", Error: "dateOfBirth must be a number"}},
+			},
+			{
+				name:        "Unsupported_Content_Type",
+				body:        `firstName=Changed`,
+				contentType: "application/x-www-form-urlencoded",
+				status:      http.StatusUnsupportedMediaType,
+			},
+		}
+		for _, testCase := range testCases {
+			t.Run(testCase.name, func(t *testing.T) {
+				client := newProfileClientStub()
+				router := newProfileRouter(client)
+				headers := map[string]string{}
+				if testCase.contentType != "" {
+					headers["Content-Type"] = testCase.contentType
+				}
+
+				recorder := serveProfile(router, http.MethodPatch, testCase.body, headers)
+
+				assert.Equal(t, testCase.status, recorder.Code)
+				assert.Equal(t, testCase.fieldErrors, decodeEnvelope(t, recorder).FieldErrors)
+				assert.Nil(t, client.lastUpdate)
+			})
+		}
+	})
+
+	t.Run("UpdateUserProfile_Stale_If_Match_Precondition_Failed", func(t *testing.T) {
+		client := newProfileClientStub()
+		router := newProfileRouter(client)
+
+		recorder := serveProfile(router, http.MethodPut, `{"firstName":"Changed","lastName":"User","dateOfBirth":0}`, map[string]string{
+			"If-Match": `"stale"`,
+		})
+
+		assert.Equal(t, http.StatusPreconditionFailed, recorder.Code)
+		assert.Nil(t, client.lastUpdate)
+	})
+}
@@ -0,0 +1,267 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/quadev-ltd/qd-common/pb/gen/go/pb_authentication"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+)
+
+// authenticationClientStub answers the routes under test and counts the calls reaching the service
+type authenticationClientStub struct {
+	pb_authentication.AuthenticationServiceClient
+	calls int
+}
+
+func (client *authenticationClientStub) Register(ctx context.Context, in *pb_authentication.RegisterRequest, opts ...grpc.CallOption) (*pb_authentication.RegisterResponse, error) {
+	client.calls++
+	return &pb_authentication.RegisterResponse{Success: true}, nil
+}
+
+func (client *authenticationClientStub) Authenticate(ctx context.Context, in *pb_authentication.AuthenticateRequest, opts ...grpc.CallOption) (*pb_authentication.AuthenticateResponse, error) {
+	client.calls++
+	return &pb_authentication.AuthenticateResponse{}, nil
+}
+
+func (client *authenticationClientStub) AuthenticateWithFirebase(ctx context.Context, in *pb_authentication.AuthenticateWithFirebaseRequest, opts ...grpc.CallOption) (*pb_authentication.AuthenticateResponse, error) {
+	client.calls++
+	return &pb_authentication.AuthenticateResponse{}, nil
+}
+
+func (client *authenticationClientStub) ForgotPassword(ctx context.Context, in *pb_authentication.ForgotPasswordRequest, opts ...grpc.CallOption) (*pb_authentication.BaseResponse, error) {
+	client.calls++
+	return &pb_authentication.BaseResponse{Success: true}, nil
+}
+
+func (client *authenticationClientStub) ResetPassword(ctx context.Context, in *pb_authentication.ResetPasswordRequest, opts ...grpc.CallOption) (*pb_authentication.BaseResponse, error) {
+	client.calls++
+	return &pb_authentication.BaseResponse{Success: true}, nil
+}
+
+func (client *authenticationClientStub) UpdateUserProfile(ctx context.Context, in *pb_authentication.UpdateUserProfileRequest, opts ...grpc.CallOption) (*pb_authentication.UpdateUserProfileResponse, error) {
+	client.calls++
+	return &pb_authentication.UpdateUserProfileResponse{}, nil
+}
+
+type validationTestCase struct {
+	name        string
+	body        string
+	status      int
+	code        string
+	fieldErrors []errors.FieldError
+}
+
+func runValidationTests(
+	t *testing.T,
+	route func(*gin.Context, pb_authentication.AuthenticationServiceClient),
+	testCases []validationTestCase,
+) {
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			client := &authenticationClientStub{}
+			router := gin.New()
+			router.POST("/test", func(ctx *gin.Context) {
+				route(ctx, client)
+			})
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/test", bytes.NewBufferString(testCase.body))
+			request.Header.Set("Content-Type", "application/json")
+
+			router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, testCase.status, recorder.Code)
+			if testCase.status == http.StatusOK {
+				assert.Equal(t, 1, client.calls)
+				return
+			}
+			assert.Equal(t, 0, client.calls)
+			envelope := errors.Envelope{}
+			assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &envelope))
+			assert.Equal(t, testCase.code, envelope.Code)
+			assert.Equal(t, testCase.fieldErrors, envelope.FieldErrors)
+		})
+	}
+}
+
+func TestRequestBodyValidation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	pastDate := time.Now().AddDate(-20, 0, 0)
+	futureDate := time.Now().AddDate(1, 0, 0)
+	longName := strings.Repeat("a", 101)
+
+	t.Run("Register", func(t *testing.T) {
+		runValidationTests(t, Register, []validationTestCase{
+			{
+				name:   "Valid_Body",
+				body:   `{"email":"test@example.com","password":"password1","firstName":"Test","lastName":"User","dateOfBirth":{"seconds":` + strconv.FormatInt(pastDate.Unix(), 10) + `}}`,
+				status: http.StatusOK,
+			},
+			{
+				name:   "Missing_Fields",
+				body:   `{}`,
+				status: http.StatusBadRequest,
+				code:   errors.ValidationFailed,
+				fieldErrors: []errors.FieldError{
+					{Field: "email", Error: "email is required"},
+					{Field: "password", Error: "password is required"},
+					{Field: "firstName", Error: "firstName is required"},
+					{Field: "lastName", Error: "lastName is required"},
+					{Field: "dateOfBirth", Error: "dateOfBirth is required"},
+				},
+			},
+			{
+				name:   "Invalid_Fields",
+				body:   `{"email":"not-an-email","password":"short","firstName":"` + longName + `","lastName":"User","dateOfBirth":{"seconds":` + strconv.FormatInt(futureDate.Unix(), 10) + `}}`,
+				status: http.StatusBadRequest,
+				code:   errors.ValidationFailed,
+				fieldErrors: []errors.FieldError{
+					{Field: "email", Error: "email must be a valid email address"},
+					{Field: "password", Error: "password must be at least 8 characters long"},
+					{Field: "firstName", Error: "firstName must be at most 100 characters long"},
+					{Field: "dateOfBirth", Error: "dateOfBirth must be in the past"},
+				},
+			},
+			{
+				name:   "Malformed_JSON",
+				body:   `{"email":`,
+				status: http.StatusBadRequest,
+				code:   errors.BadRequest,
+			},
+		})
+	})
+
+	t.Run("Authenticate", func(t *testing.T) {
+		runValidationTests(t, Authenticate, []validationTestCase{
+			{
+				name:   "Valid_Body",
+				body:   `{"email":"test@example.com","password":"password"}`,
+				status: http.StatusOK,
+			},
+			{
+				name:   "Invalid_Email_And_Missing_Password",
+				body:   `{"email":"test"}`,
+				status: http.StatusBadRequest,
+				code:   errors.ValidationFailed,
+				fieldErrors: []errors.FieldError{
+					{Field: "email", Error: "email must be a valid email address"},
+					{Field: "password", Error: "password is required"},
+				},
+			},
+		})
+	})
+
+	t.Run("AuthenticateWithFirebase", func(t *testing.T) {
+		runValidationTests(t, AuthenticateWithFirebase, []validationTestCase{
+			{
+				name:   "Valid_Body_Without_Email",
+				body:   `{"idToken":"token"}`,
+				status: http.StatusOK,
+			},
+			{
+				name:   "Missing_ID_Token",
+				body:   `{"email":"test@example.com"}`,
+				status: http.StatusBadRequest,
+				code:   errors.ValidationFailed,
+				fieldErrors: []errors.FieldError{
+					{Field: "idToken", Error: "idToken is required"},
+				},
+			},
+		})
+	})
+
+	t.Run("ForgotPassword", func(t *testing.T) {
+		runValidationTests(t, ForgotPassword, []validationTestCase{
+			{
+				name:   "Valid_Body",
+				body:   `{"email":"test@example.com"}`,
+				status: http.StatusOK,
+			},
+			{
+				name:   "Missing_Email",
+				body:   `{}`,
+				status: http.StatusBadRequest,
+				code:   errors.ValidationFailed,
+				fieldErrors: []errors.FieldError{
+					{Field: "email", Error: "email is required"},
+				},
+			},
+			{
+				name:   "Email_Of_Wrong_Type",
+				body:   `{"email":42}`,
+				status: http.StatusBadRequest,
+				code:   errors.ValidationFailed,
+				fieldErrors: []errors.FieldError{
+					{Field: "email", Error: "email must be a string"},
+				},
+			},
+		})
+	})
+
+	t.Run("ResetPassword", func(t *testing.T) {
+		runValidationTests(t, ResetPassword, []validationTestCase{
+			{
+				name:   "Valid_Body",
+				body:   `{"password":"password1"}`,
+				status: http.StatusOK,
+			},
+			{
+				name:   "Short_Password",
+				body:   `{"password":"short"}`,
+				status: http.StatusBadRequest,
+				code:   errors.ValidationFailed,
+				fieldErrors: []errors.FieldError{
+					{Field: "password", Error: "password must be at least 8 characters long"},
+				},
+			},
+		})
+	})
+
+	t.Run("UpdateUserProfile", func(t *testing.T) {
+		runValidationTests(t, UpdateUserProfile, []validationTestCase{
+			{
+				name:   "Valid_Body",
+				body:   `{"firstName":"Test","lastName":"User","dateOfBirth":` + strconv.FormatInt(pastDate.Unix(), 10) + `}`,
+				status: http.StatusOK,
+			},
+			{
+				name:   "Missing_Date_Of_Birth",
+				body:   `{"firstName":"Test","lastName":"User"}`,
+				status: http.StatusBadRequest,
+				code:   errors.ValidationFailed,
+				fieldErrors: []errors.FieldError{
+					{Field: "dateOfBirth", Error: "dateOfBirth is required"},
+				},
+			},
+			{
+				name:   "Future_Date_Of_Birth",
+				body:   `{"firstName":"Test","lastName":"User","dateOfBirth":` + strconv.FormatInt(futureDate.Unix(), 10) + `}`,
+				status: http.StatusBadRequest,
+				code:   errors.ValidationFailed,
+				fieldErrors: []errors.FieldError{
+					{Field: "dateOfBirth", Error: "dateOfBirth must be in the past"},
+				},
+			},
+			{
+				name:   "Date_Of_Birth_Of_Wrong_Type",
+				body:   `{"firstName":"Test","lastName":"User","dateOfBirth":"2000-01-01"}`,
+				status: http.StatusBadRequest,
+				code:   errors.ValidationFailed,
+				fieldErrors: []errors.FieldError{
+					{Field: "dateOfBirth", Error: "dateOfBirth must be a number"},
+				},
+			},
+		})
+	})
+}
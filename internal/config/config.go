@@ -2,18 +2,261 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	commonAWS "github.com/quadev-ltd/qd-common/pkg/aws"
 	commonConfig "github.com/quadev-ltd/qd-common/pkg/config"
 	"github.com/rs/zerolog/log"
 )
 
+// AuthenticationConfig is the configuration of the authentication middleware
+type AuthenticationConfig struct {
+	KeyRefreshInterval time.Duration `mapstructure:"key_refresh_interval"`
+	KeyGracePeriod     time.Duration `mapstructure:"key_grace_period"`
+	KeyMissCooldown    time.Duration `mapstructure:"key_miss_cooldown"`
+}
+
+// ServerConfig is the configuration of the HTTP server lifecycle
+type ServerConfig struct {
+	DrainTimeout  time.Duration `mapstructure:"drain_timeout"`
+	ShutdownDelay time.Duration `mapstructure:"shutdown_delay"`
+	// MetricsAddress is the address of the metrics listener, kept off the public one, empty to disable it
+	MetricsAddress string `mapstructure:"metrics_address"`
+}
+
+// RedisConfig is the configuration of a Redis connection
+type RedisConfig struct {
+	// Address is a comma separated list of host:port addresses
+	Address   string `mapstructure:"address"`
+	Password  string `mapstructure:"password"`
+	DB        int    `mapstructure:"db"`
+	KeyPrefix string `mapstructure:"key_prefix"`
+}
+
+// RateLimitPlanConfig is the rate and burst granted to the users of a plan
+type RateLimitPlanConfig struct {
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
+}
+
+// RateLimitPolicyConfig maps a route pattern to its rate limit
+type RateLimitPolicyConfig struct {
+	// Route is the full route template, a trailing * matches any route with that prefix
+	Route string `mapstructure:"route"`
+	// Method restricts the policy to an HTTP method, empty matches every method
+	Method string  `mapstructure:"method"`
+	Rate   float64 `mapstructure:"rate"`
+	Burst  int     `mapstructure:"burst"`
+	// Key is the key strategy, ip or user
+	Key string `mapstructure:"key"`
+	// Bucket is shared by the routes with the same bucket name, by default each route has its own
+	Bucket string `mapstructure:"bucket"`
+	// Group selects the store through the groups configuration
+	Group string `mapstructure:"group"`
+	// Plans overrides the rate and burst per plan for the user key strategy
+	Plans map[string]RateLimitPlanConfig `mapstructure:"plans"`
+}
+
+// RateLimitConfig is the configuration of the rate limiters
+type RateLimitConfig struct {
+	// Store is the default store, memory or redis
+	Store string `mapstructure:"store"`
+	// Groups overrides the store per route group
+	Groups map[string]string `mapstructure:"groups"`
+	Redis  RedisConfig       `mapstructure:"redis"`
+	// Policies are matched in order against every rate limited route
+	Policies        []RateLimitPolicyConfig `mapstructure:"policies"`
+	IdleTimeout     time.Duration           `mapstructure:"idle_timeout"`
+	CleanupInterval time.Duration           `mapstructure:"cleanup_interval"`
+	MaxKeys         int                     `mapstructure:"max_keys"`
+}
+
+// TracingConfig is the configuration of the OpenTelemetry tracing exporter
+type TracingConfig struct {
+	// Exporter is one of none, stdout or otlp
+	Exporter    string  `mapstructure:"exporter"`
+	Endpoint    string  `mapstructure:"endpoint"`
+	Insecure    bool    `mapstructure:"insecure"`
+	ServiceName string  `mapstructure:"service_name"`
+	SampleRatio float64 `mapstructure:"sample_ratio"`
+}
+
+// AuthorizationPolicyConfig maps a route pattern to the roles and scopes it requires
+type AuthorizationPolicyConfig struct {
+	// Route is the full route template, a trailing * matches any route with that prefix
+	Route string `mapstructure:"route"`
+	// Method restricts the policy to an HTTP method, empty matches every method
+	Method string `mapstructure:"method"`
+	// Roles grants access to the tokens with any of the roles
+	Roles []string `mapstructure:"roles"`
+	// Scopes grants access to the tokens with every one of the scopes
+	Scopes []string `mapstructure:"scopes"`
+}
+
+// AuthorizationConfig is the configuration of the route authorization
+type AuthorizationConfig struct {
+	// Policies are matched in order against every route, the first match applies
+	Policies []AuthorizationPolicyConfig `mapstructure:"policies"`
+}
+
+// RevocationFeedConfig is the configuration of the revocation feed of the authentication service
+type RevocationFeedConfig struct {
+	// Enabled subscribes the gateway to the revocations published by the authentication service
+	Enabled bool `mapstructure:"enabled"`
+	// Channel is the Redis channel of the revocation events, on the Redis of the revocation configuration
+	Channel string `mapstructure:"channel"`
+}
+
+// RevocationConfig is the configuration of the revoked tokens store
+type RevocationConfig struct {
+	// Store is memory or redis, redis shares the revocations across replicas and with the authentication service
+	Store string      `mapstructure:"store"`
+	Redis RedisConfig `mapstructure:"redis"`
+	// UserRevocationTTL is how long a per user revocation is kept, at least the lifetime of the longest lived token
+	UserRevocationTTL time.Duration        `mapstructure:"user_revocation_ttl"`
+	Feed              RevocationFeedConfig `mapstructure:"feed"`
+}
+
+// FeatureConfig is the configuration of who is entitled to a feature
+type FeatureConfig struct {
+	// Plans grants the feature to the users of any of the plans
+	Plans []string `mapstructure:"plans"`
+	// Users grants the feature to the users with any of the IDs, whatever their plan
+	Users []string `mapstructure:"users"`
+	// Cohorts grants the feature to the users of any of the cohorts, whatever their plan
+	Cohorts []string `mapstructure:"cohorts"`
+}
+
+// EntitlementsConfig is the configuration of the feature entitlements
+type EntitlementsConfig struct {
+	// Features maps each feature name to who is entitled to it
+	Features map[string]FeatureConfig `mapstructure:"features"`
+	// Cohorts maps each cohort name to the IDs of its users
+	Cohorts map[string][]string `mapstructure:"cohorts"`
+	// CacheTTL is how long the entitlements looked up for a user are cached
+	CacheTTL time.Duration `mapstructure:"cache_ttl"`
+	// Lookup is none or redis, where the entitlements taking over the claims are looked up
+	Lookup string      `mapstructure:"lookup"`
+	Redis  RedisConfig `mapstructure:"redis"`
+}
+
+// QuotaConfig is the monthly usage allowed to the users of a plan, 0 is unlimited
+type QuotaConfig struct {
+	Requests int64 `mapstructure:"requests"`
+	Bytes    int64 `mapstructure:"bytes"`
+}
+
+// MeteringConfig is the configuration of the usage metering
+type MeteringConfig struct {
+	// Store is memory or redis, redis shares the counters across replicas
+	Store string      `mapstructure:"store"`
+	Redis RedisConfig `mapstructure:"redis"`
+	// Quotas maps each plan to its monthly quota, the plans without one get the free plan quota
+	Quotas map[string]QuotaConfig `mapstructure:"quotas"`
+}
+
+// ImagePreprocessingConfig is the configuration of the preprocessing of the images before their analysis
+type ImagePreprocessingConfig struct {
+	// Enabled strips the image metadata, orients the images and downscales them. The images are then read
+	// whole before they are sent, rather than streamed as they are received.
+	Enabled bool `mapstructure:"enabled"`
+	// MaxEdge is the longest image edge in pixels, the larger images are downscaled, 0 keeps their size
+	MaxEdge int `mapstructure:"max_edge"`
+	// JPEGQuality is the quality of the re-encoded JPEG images, from 1 to 100
+	JPEGQuality int `mapstructure:"jpeg_quality"`
+	// MaxPixels is the pixel budget of the images decoded to be oriented or downscaled
+	MaxPixels int `mapstructure:"max_pixels"`
+	// MaxConcurrentDecodes is the number of images decoded at once, the number of CPUs when 0
+	MaxConcurrentDecodes int `mapstructure:"max_concurrent_decodes"`
+}
+
+// EventStreamingConfig is the configuration of the image analysis responses streamed as server-sent events
+type EventStreamingConfig struct {
+	// Enabled relays the analysis in parts to the clients accepting text/event-stream
+	Enabled bool `mapstructure:"enabled"`
+	// HeartbeatInterval is the interval of the comments keeping the idle event streams open through the proxies
+	HeartbeatInterval time.Duration `mapstructure:"heartbeat_interval"`
+}
+
+// ImageAnalysisConfig is the configuration of the image uploads to the image analysis service
+type ImageAnalysisConfig struct {
+	// Streaming streams the images in chunks as they are received when the service supports it
+	Streaming bool `mapstructure:"streaming"`
+	// ChunkSize is the size in bytes of the streamed chunks
+	ChunkSize int `mapstructure:"chunk_size"`
+	// MaxUploadSize is the largest request body accepted, in bytes, 0 is unlimited
+	MaxUploadSize int64 `mapstructure:"max_upload_size"`
+	// MaxWidth and MaxHeight are the largest image dimensions accepted, in pixels, 0 is unlimited
+	MaxWidth  int `mapstructure:"max_width"`
+	MaxHeight int `mapstructure:"max_height"`
+	// Preprocessing prepares the images before they are sent to the service
+	Preprocessing ImagePreprocessingConfig `mapstructure:"preprocessing"`
+	// EventStreaming streams the analysis to the clients as it is generated
+	EventStreaming EventStreamingConfig `mapstructure:"event_streaming"`
+}
+
+// WebhookConfig is the configuration of the deliveries of the job results to the callback URLs
+type WebhookConfig struct {
+	// Secret signs the deliveries with HMAC-SHA256, for the receivers to verify them
+	Secret string `mapstructure:"secret"`
+	// Timeout bounds each delivery attempt
+	Timeout time.Duration `mapstructure:"timeout"`
+	// MaxAttempts is the number of attempts of a delivery failing with a network error or a 5xx or 429 status
+	MaxAttempts int `mapstructure:"max_attempts"`
+	// AllowInsecure accepts http callback URLs and callback hosts on private networks, for development only
+	AllowInsecure bool `mapstructure:"allow_insecure"`
+	// Workers is the number of deliveries made at once, apart from the job workers
+	Workers int `mapstructure:"workers"`
+	// QueueSize is the number of deliveries waiting for a worker, the deliveries beyond it are dropped
+	QueueSize int `mapstructure:"queue_size"`
+}
+
+// JobsConfig is the configuration of the asynchronous jobs
+type JobsConfig struct {
+	// Store is memory or redis, redis lets any replica return the jobs run by the others
+	Store string      `mapstructure:"store"`
+	Redis RedisConfig `mapstructure:"redis"`
+	// Workers is the number of jobs run at once by a replica
+	Workers int `mapstructure:"workers"`
+	// QueueSize is the number of jobs waiting for a worker, the jobs submitted beyond it are rejected
+	QueueSize int `mapstructure:"queue_size"`
+	// MaxQueuedBytes bounds the bytes of the images held by the queued and running jobs, the jobs submitted
+	// beyond it are rejected
+	MaxQueuedBytes int64 `mapstructure:"max_queued_bytes"`
+	// Timeout bounds the run of each job
+	Timeout time.Duration `mapstructure:"timeout"`
+	// TTL is how long the jobs and their results are kept after their last update
+	TTL     time.Duration `mapstructure:"ttl"`
+	Webhook WebhookConfig `mapstructure:"webhook"`
+}
+
+// ProxyConfig is the configuration of the proxies in front of the gateway
+type ProxyConfig struct {
+	// TrustedProxies are the IPs or CIDRs allowed to set the client IP, none by default
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+	// Headers are read in order to resolve the client IP: X-Forwarded-For, X-Real-IP or Forwarded
+	Headers []string `mapstructure:"headers"`
+	// ProxyProtocol accepts PROXY protocol headers from the trusted proxies
+	ProxyProtocol bool `mapstructure:"proxy_protocol"`
+}
+
 // Config is the configuration of the application
 type Config struct {
-	Verbose     bool
-	Environment string
-	AWS         commonAWS.Config
-	TLSEnabled  bool
+	Verbose        bool
+	Environment    string
+	AWS            commonAWS.Config
+	TLSEnabled     bool
+	Server         ServerConfig         `mapstructure:"server"`
+	Authentication AuthenticationConfig `mapstructure:"authentication"`
+	Tracing        TracingConfig        `mapstructure:"tracing"`
+	RateLimit      RateLimitConfig      `mapstructure:"rate_limit"`
+	Proxy          ProxyConfig          `mapstructure:"proxy"`
+	Revocation     RevocationConfig     `mapstructure:"revocation"`
+	Authorization  AuthorizationConfig  `mapstructure:"authorization"`
+	Entitlements   EntitlementsConfig   `mapstructure:"entitlements"`
+	Metering       MeteringConfig       `mapstructure:"metering"`
+	ImageAnalysis  ImageAnalysisConfig  `mapstructure:"image_analysis"`
+	Jobs           JobsConfig           `mapstructure:"jobs"`
 }
 
 // Load loads the configuration from the given path yml file
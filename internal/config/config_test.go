@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/quadev-ltd/qd-common/pkg/config"
 	pkgConfig "github.com/quadev-ltd/qd-common/pkg/config"
@@ -30,6 +31,23 @@ func TestLoad(t *testing.T) {
 
 		assert.False(t, cfg.Verbose)
 		assert.Equal(t, "test", cfg.Environment)
+		assert.Equal(t, 10*time.Minute, cfg.Authentication.KeyRefreshInterval)
+		assert.Equal(t, time.Hour, cfg.Authentication.KeyGracePeriod)
+		assert.Equal(t, "sessions", cfg.RateLimit.Policies[1].Bucket)
+		assert.Equal(t, 10, cfg.RateLimit.Policies[6].Plans["paid"].Burst)
+		assert.Equal(t, []string{"paid", "trial"}, cfg.Entitlements.Features["image-analysis"].Plans)
+		assert.Equal(t, int64(50), cfg.Metering.Quotas["free"].Requests)
+		assert.True(t, cfg.ImageAnalysis.Streaming)
+		assert.Equal(t, 65536, cfg.ImageAnalysis.ChunkSize)
+		assert.Equal(t, int64(20971520), cfg.ImageAnalysis.MaxUploadSize)
+		assert.Equal(t, 8192, cfg.ImageAnalysis.MaxWidth)
+		assert.Equal(t, 2048, cfg.ImageAnalysis.Preprocessing.MaxEdge)
+		assert.Equal(t, 15*time.Second, cfg.ImageAnalysis.EventStreaming.HeartbeatInterval)
+		assert.Equal(t, 8, cfg.Jobs.Workers)
+		assert.Equal(t, 24*time.Hour, cfg.Jobs.TTL)
+		assert.Equal(t, int64(268435456), cfg.Jobs.MaxQueuedBytes)
+		assert.Equal(t, 3, cfg.Jobs.Webhook.MaxAttempts)
+		assert.Equal(t, 4, cfg.Jobs.Webhook.Workers)
 	})
 
 	t.Run("Load_Should_Show_Env_Vars_Values", func(t *testing.T) {
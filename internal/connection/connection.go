@@ -0,0 +1,42 @@
+package connection
+
+import (
+	"fmt"
+	"strings"
+
+	commonTLS "github.com/quadev-ltd/qd-common/pkg/tls"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// SplitMethodName splits a full gRPC method name such as /package.Service/Method
+func SplitMethodName(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if index := strings.LastIndex(fullMethod, "/"); index >= 0 {
+		return fullMethod[:index], fullMethod[index+1:]
+	}
+	return "unknown", fullMethod
+}
+
+// CreateGRPCConnection creates a gRPC client connection like commonTLS.CreateGRPCConnection
+// while allowing additional dial options such as client interceptors
+func CreateGRPCConnection(grpcServerAddress string, tlsEnabled bool, options ...grpc.DialOption) (*grpc.ClientConn, error) {
+	dialOptions := []grpc.DialOption{}
+	if tlsEnabled {
+		tlsConfig, err := commonTLS.CreateTLSConfig()
+		if err != nil {
+			return nil, fmt.Errorf("Could not create CA certificate pool: %v", err)
+		}
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	dialOptions = append(dialOptions, options...)
+
+	connection, err := grpc.Dial(grpcServerAddress, dialOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("Could not connect to server: %v", err)
+	}
+	return connection, nil
+}
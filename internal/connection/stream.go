@@ -0,0 +1,60 @@
+package connection
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+// finishingClientStream calls finish once, with the status of the stream, when the stream ends
+type finishingClientStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	once          sync.Once
+	finish        func(err error)
+}
+
+// WrapClientStream wraps an outgoing stream to call finish once when it ends: when a message received
+// ends it or returns its status, when a message fails to be sent or when the context of the call is done.
+// The status of the streams ending normally is nil.
+func WrapClientStream(ctx context.Context, stream grpc.ClientStream, desc *grpc.StreamDesc, finish func(err error)) grpc.ClientStream {
+	wrapped := &finishingClientStream{
+		ClientStream:  stream,
+		serverStreams: desc.ServerStreams,
+		finish:        finish,
+	}
+	go func() {
+		<-ctx.Done()
+		wrapped.end(ctx.Err())
+	}()
+	return wrapped
+}
+
+func (stream *finishingClientStream) end(err error) {
+	stream.once.Do(func() { stream.finish(err) })
+}
+
+func (stream *finishingClientStream) SendMsg(message interface{}) error {
+	err := stream.ClientStream.SendMsg(message)
+	// io.EOF tells that the stream ended, its status being returned by RecvMsg
+	if err != nil && err != io.EOF {
+		stream.end(err)
+	}
+	return err
+}
+
+func (stream *finishingClientStream) RecvMsg(message interface{}) error {
+	err := stream.ClientStream.RecvMsg(message)
+	switch {
+	case err == io.EOF:
+		stream.end(nil)
+	case err != nil:
+		stream.end(err)
+	case !stream.serverStreams:
+		// The single response of the stream ends it
+		stream.end(nil)
+	}
+	return err
+}
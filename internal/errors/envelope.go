@@ -0,0 +1,199 @@
+package errors
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	commonLogger "github.com/quadev-ltd/qd-common/pkg/log"
+)
+
+// FieldError describes why the value of a request field was rejected
+type FieldError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+// Envelope is the body of every error response
+type Envelope struct {
+	// Code is the stable error code clients can switch on
+	Code    string `json:"error"`
+	Message string `json:"message"`
+	// Reason refines the code when clients need to tell apart errors of the same code
+	Reason        string                 `json:"reason,omitempty"`
+	CorrelationID string                 `json:"correlation_id,omitempty"`
+	FieldErrors   []FieldError           `json:"field_errors,omitempty"`
+	Details       map[string]interface{} `json:"details,omitempty"`
+}
+
+// APIError is an error rendered with an HTTP status and the error envelope
+type APIError struct {
+	Status      int
+	Code        string
+	Message     string
+	Reason      string
+	FieldErrors []FieldError
+	Details     map[string]interface{}
+	// RetryAfter is sent in the Retry-After header when set
+	RetryAfter time.Duration
+	// Cause is logged but never rendered
+	Cause error
+}
+
+// New creates an API error with the status, code and message
+func New(status int, code, message string) *APIError {
+	return &APIError{
+		Status:  status,
+		Code:    code,
+		Message: message,
+	}
+}
+
+// Error returns the message of the error and its cause
+func (apiError *APIError) Error() string {
+	if apiError.Cause != nil {
+		return fmt.Sprintf("%s: %v", apiError.Message, apiError.Cause)
+	}
+	return apiError.Message
+}
+
+// Unwrap returns the cause of the error
+func (apiError *APIError) Unwrap() error {
+	return apiError.Cause
+}
+
+// WithReason sets the reason refining the code
+func (apiError *APIError) WithReason(reason string) *APIError {
+	apiError.Reason = reason
+	return apiError
+}
+
+// WithDetail adds a detail to the envelope
+func (apiError *APIError) WithDetail(key string, value interface{}) *APIError {
+	if apiError.Details == nil {
+		apiError.Details = make(map[string]interface{})
+	}
+	apiError.Details[key] = value
+	return apiError
+}
+
+// WithFieldErrors sets the errors of the rejected request fields
+func (apiError *APIError) WithFieldErrors(fieldErrors []FieldError) *APIError {
+	apiError.FieldErrors = fieldErrors
+	return apiError
+}
+
+// WithCause sets the error that caused the API error
+func (apiError *APIError) WithCause(err error) *APIError {
+	apiError.Cause = err
+	return apiError
+}
+
+// InvalidBody returns the API error of a request body that could not be bound
+func InvalidBody(err error) *APIError {
+	return New(http.StatusBadRequest, BadRequest, "The request body is invalid").WithCause(err)
+}
+
+// CodeFromStatus returns the error code of an HTTP status
+func CodeFromStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return BadRequest
+	case http.StatusUnauthorized:
+		return Unauthorized
+	case http.StatusPaymentRequired:
+		return PaymentRequired
+	case http.StatusForbidden:
+		return Forbidden
+	case http.StatusNotFound:
+		return NotFound
+	case http.StatusMethodNotAllowed:
+		return MethodNotAllowed
+	case http.StatusRequestTimeout:
+		return RequestTimeout
+	case http.StatusConflict:
+		return Conflict
+	case http.StatusPreconditionFailed:
+		return PreconditionFailed
+	case http.StatusRequestEntityTooLarge:
+		return PayloadTooLarge
+	case http.StatusUnsupportedMediaType:
+		return UnsupportedMediaType
+	case http.StatusTooManyRequests:
+		return TooManyRequests
+	case http.StatusNotImplemented:
+		return NotImplemented
+	case http.StatusServiceUnavailable:
+		return Unavailable
+	case http.StatusGatewayTimeout:
+		return Timeout
+	default:
+		if status >= http.StatusBadRequest && status < http.StatusInternalServerError {
+			return BadRequest
+		}
+		return Internal
+	}
+}
+
+// FromError returns the API error of any error, the ones that are not API errors are rendered with the status
+// and a generic message so that their text does not leak
+func FromError(err error, status int) *APIError {
+	if apiError, ok := err.(*APIError); ok {
+		return apiError
+	}
+	if status < http.StatusBadRequest {
+		status = http.StatusInternalServerError
+	}
+	return New(status, CodeFromStatus(status), http.StatusText(status)).WithCause(err)
+}
+
+// NewEnvelope returns the envelope rendering the API error for the request
+func NewEnvelope(ctx *gin.Context, apiError *APIError) *Envelope {
+	envelope := &Envelope{
+		Code:        apiError.Code,
+		Message:     apiError.Message,
+		Reason:      apiError.Reason,
+		FieldErrors: apiError.FieldErrors,
+		Details:     apiError.Details,
+	}
+	if ctx.Request != nil {
+		if correlationID, err := commonLogger.GetCorrelationIDFromContext(ctx.Request.Context()); err == nil {
+			envelope.CorrelationID = *correlationID
+		}
+	}
+	return envelope
+}
+
+// Abort aborts the request rendering the API error, which is also attached to the context for the logs
+func Abort(ctx *gin.Context, apiError *APIError) {
+	ctx.Error(apiError)
+	setHeaders(ctx, apiError)
+	ctx.AbortWithStatusJSON(apiError.Status, NewEnvelope(ctx, apiError))
+}
+
+// Middleware renders the error envelope of the requests that failed without a response body, such as the ones
+// aborted with AbortWithError or AbortWithStatus. It must be the first middleware using the correlation ID.
+func Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		if ctx.Writer.Size() > 0 || (len(ctx.Errors) == 0 && status < http.StatusBadRequest) {
+			return
+		}
+		var apiError *APIError
+		if lastError := ctx.Errors.Last(); lastError != nil {
+			apiError = FromError(lastError.Err, status)
+		} else {
+			apiError = New(status, CodeFromStatus(status), http.StatusText(status))
+		}
+		renderStatus := apiError.Status
+		if ctx.Writer.Written() {
+			// The status is already sent, the envelope has to follow it
+			renderStatus = status
+		}
+		setHeaders(ctx, apiError)
+		ctx.JSON(renderStatus, NewEnvelope(ctx, apiError))
+	}
+}
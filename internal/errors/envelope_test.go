@@ -0,0 +1,112 @@
+package errors
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	commonLogger "github.com/quadev-ltd/qd-common/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func serveEnvelope(t *testing.T, router *gin.Engine, target string) (int, *Envelope) {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+	envelope := &Envelope{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), envelope))
+	return recorder.Code, envelope
+}
+
+func newTestRouter() *gin.Engine {
+	router := gin.New()
+	router.Use(commonLogger.AddNewCorrelationIDToContext)
+	router.Use(Middleware())
+	return router
+}
+
+func TestEnvelope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("Abort_Renders_Envelope_With_Correlation_ID", func(t *testing.T) {
+		router := newTestRouter()
+		router.GET("/test", func(ctx *gin.Context) {
+			Abort(ctx, New(http.StatusPaymentRequired, PaymentRequired, "Upgrade the plan").
+				WithReason(QuotaExceeded).
+				WithDetail("feature", "image-analysis").
+				WithCause(fmt.Errorf("hidden cause")))
+		})
+
+		code, envelope := serveEnvelope(t, router, "/test")
+
+		assert.Equal(t, http.StatusPaymentRequired, code)
+		assert.Equal(t, PaymentRequired, envelope.Code)
+		assert.Equal(t, "Upgrade the plan", envelope.Message)
+		assert.Equal(t, QuotaExceeded, envelope.Reason)
+		assert.Equal(t, "image-analysis", envelope.Details["feature"])
+		assert.NotEmpty(t, envelope.CorrelationID)
+	})
+
+	t.Run("HandleError_GRPC_Status", func(t *testing.T) {
+		router := newTestRouter()
+		router.GET("/test", func(ctx *gin.Context) {
+			HandleError(ctx, status.Error(codes.NotFound, "User not found"))
+		})
+
+		code, envelope := serveEnvelope(t, router, "/test")
+
+		assert.Equal(t, http.StatusNotFound, code)
+		assert.Equal(t, NotFound, envelope.Code)
+		assert.Equal(t, "User not found", envelope.Message)
+	})
+
+	t.Run("Middleware_Renders_AbortWithError", func(t *testing.T) {
+		router := newTestRouter()
+		router.GET("/test", func(ctx *gin.Context) {
+			ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("internal detail"))
+		})
+
+		code, envelope := serveEnvelope(t, router, "/test")
+
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, BadRequest, envelope.Code)
+		assert.Equal(t, http.StatusText(http.StatusBadRequest), envelope.Message)
+		assert.NotEmpty(t, envelope.CorrelationID)
+	})
+
+	t.Run("Middleware_Renders_AbortWithStatus", func(t *testing.T) {
+		router := newTestRouter()
+		router.GET("/test", func(ctx *gin.Context) {
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+		})
+
+		code, envelope := serveEnvelope(t, router, "/test")
+
+		assert.Equal(t, http.StatusUnauthorized, code)
+		assert.Equal(t, Unauthorized, envelope.Code)
+	})
+
+	t.Run("Middleware_Renders_Unknown_Route", func(t *testing.T) {
+		code, envelope := serveEnvelope(t, newTestRouter(), "/unknown")
+
+		assert.Equal(t, http.StatusNotFound, code)
+		assert.Equal(t, NotFound, envelope.Code)
+	})
+
+	t.Run("Middleware_Keeps_Successful_Responses", func(t *testing.T) {
+		router := newTestRouter()
+		router.GET("/test", func(ctx *gin.Context) {
+			ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+		})
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.JSONEq(t, `{"status": "ok"}`, recorder.Body.String())
+	})
+}
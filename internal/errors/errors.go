@@ -4,14 +4,37 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	commonPB "github.com/quadev-ltd/qd-common/pkg/pb"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
-// Error name constants
+// Error codes of the error envelope
 const (
-	TooManyRequests = "too_many_requests"
+	BadRequest           = "bad_request"
+	ValidationFailed     = "validation_failed"
+	Unauthorized         = "unauthorized"
+	PaymentRequired      = "payment_required"
+	Forbidden            = "forbidden"
+	InsufficientScope    = "insufficient_scope"
+	NotFound             = "not_found"
+	MethodNotAllowed     = "method_not_allowed"
+	RequestTimeout       = "request_timeout"
+	Conflict             = "conflict"
+	PreconditionFailed   = "precondition_failed"
+	PayloadTooLarge      = "payload_too_large"
+	UnsupportedMediaType = "unsupported_media_type"
+	TooManyRequests      = "too_many_requests"
+	Internal             = "internal"
+	NotImplemented       = "not_implemented"
+	Unavailable          = "unavailable"
+	Timeout              = "timeout"
+)
+
+// Reasons refining the error codes
+const (
+	QuotaExceeded = "quota_exceeded"
+	TokenExpired  = "token_expired"
+	TokenRevoked  = "token_revoked"
 )
 
 // GRPCErrorToHTTPStatus converts a gRPC error to an HTTP status code
@@ -60,22 +83,10 @@ func GRPCErrorToHTTPStatus(err error) int {
 	}
 }
 
-// HandleError handles an error by returning an HTTP response with the appropriate status code
+// HandleError handles an error returned by a downstream gRPC service by returning an HTTP response with
+// the appropriate status code and the error details. It returns an error when some details could not be decoded.
 func HandleError(ctx *gin.Context, err error) error {
-	errorHTTPStatusCode := GRPCErrorToHTTPStatus(err)
-	errorsMap := gin.H{"error": err.Error()}
-
-	fieldValidationErrors, parsingError := commonPB.GetFieldValidationErrors(err)
-	if parsingError != nil {
-		ctx.JSON(errorHTTPStatusCode, errorsMap)
-		ctx.AbortWithError(errorHTTPStatusCode, err)
-		return parsingError
-	}
-
-	if len(fieldValidationErrors) > 0 {
-		errorsMap["field_errors"] = fieldValidationErrors
-	}
-	ctx.JSON(errorHTTPStatusCode, errorsMap)
-	ctx.AbortWithError(errorHTTPStatusCode, err)
-	return nil
+	apiError, decodingError := FromGRPCError(ctx, err)
+	Abort(ctx, apiError)
+	return decodingError
 }
@@ -0,0 +1,128 @@
+package errors
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/quadev-ltd/qd-common/pb/gen/go/pb_errors"
+	commonConfig "github.com/quadev-ltd/qd-common/pkg/config"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// internalErrorMessage replaces the text of the internal errors where it must not leak
+const internalErrorMessage = "An internal error occurred"
+
+// exposesInternalErrors reports whether the text of the internal errors is rendered, never in production
+var exposesInternalErrors = func() bool {
+	return commonConfig.GetEnvironment() != commonConfig.ProductionEnvironment
+}
+
+// isInternalCode reports whether the messages of the code describe the service internals rather than the request
+func isInternalCode(code codes.Code) bool {
+	return code == codes.Internal || code == codes.Unknown || code == codes.DataLoss
+}
+
+// stableCode turns an ErrorInfo reason such as EMAIL_ALREADY_TAKEN into an error code such as email_already_taken
+func stableCode(reason string) string {
+	return strings.ToLower(reason)
+}
+
+// localizedMessage returns the message of the locale accepted first by the request, the first message otherwise
+func localizedMessage(ctx *gin.Context, messages []*errdetails.LocalizedMessage) *errdetails.LocalizedMessage {
+	if ctx.Request != nil {
+		for _, acceptedLanguage := range strings.Split(ctx.GetHeader("Accept-Language"), ",") {
+			language := strings.TrimSpace(strings.Split(acceptedLanguage, ";")[0])
+			for _, message := range messages {
+				if language != "" && strings.HasPrefix(strings.ToLower(message.Locale), strings.ToLower(language)) {
+					return message
+				}
+			}
+		}
+	}
+	return messages[0]
+}
+
+// FromGRPCError returns the API error of an error returned by a downstream gRPC service, decoding the
+// field errors of the services and the standard error details. The text of the internal errors is
+// only rendered outside production. The error returned reports details that could not be decoded.
+func FromGRPCError(ctx *gin.Context, err error) (*APIError, error) {
+	httpStatus := GRPCErrorToHTTPStatus(err)
+	apiError := New(httpStatus, CodeFromStatus(httpStatus), internalErrorMessage).WithCause(err)
+	grpcStatus, isStatus := status.FromError(err)
+	if !isStatus {
+		if exposesInternalErrors() {
+			apiError.Message = err.Error()
+		}
+		return apiError, nil
+	}
+	if !isInternalCode(grpcStatus.Code()) || exposesInternalErrors() {
+		apiError.Message = grpcStatus.Message()
+	}
+
+	var decodingErrors []string
+	var localizedMessages []*errdetails.LocalizedMessage
+	for _, detail := range grpcStatus.Details() {
+		switch detail := detail.(type) {
+		case *pb_errors.FieldError:
+			apiError.Code = ValidationFailed
+			apiError.FieldErrors = append(apiError.FieldErrors, FieldError{Field: detail.Field, Error: detail.Error})
+		case *errdetails.BadRequest:
+			apiError.Code = ValidationFailed
+			for _, violation := range detail.FieldViolations {
+				apiError.FieldErrors = append(apiError.FieldErrors, FieldError{Field: violation.Field, Error: violation.Description})
+			}
+		case *errdetails.ErrorInfo:
+			apiError.Reason = stableCode(detail.Reason)
+			if detail.Domain != "" {
+				apiError.WithDetail("domain", detail.Domain)
+			}
+			if len(detail.Metadata) > 0 {
+				apiError.WithDetail("metadata", detail.Metadata)
+			}
+		case *errdetails.RetryInfo:
+			apiError.RetryAfter = detail.RetryDelay.AsDuration()
+		case *errdetails.QuotaFailure:
+			violations := make([]gin.H, 0, len(detail.Violations))
+			for _, violation := range detail.Violations {
+				violations = append(violations, gin.H{"subject": violation.Subject, "description": violation.Description})
+			}
+			apiError.WithDetail("quota_violations", violations)
+		case *errdetails.PreconditionFailure:
+			violations := make([]gin.H, 0, len(detail.Violations))
+			for _, violation := range detail.Violations {
+				violations = append(violations, gin.H{
+					"type":        violation.Type,
+					"subject":     violation.Subject,
+					"description": violation.Description,
+				})
+			}
+			apiError.WithDetail("precondition_violations", violations)
+		case *errdetails.LocalizedMessage:
+			localizedMessages = append(localizedMessages, detail)
+		case error:
+			decodingErrors = append(decodingErrors, detail.Error())
+		}
+	}
+	if len(localizedMessages) > 0 {
+		// The localized messages are written for the end users, so they are safe to render
+		message := localizedMessage(ctx, localizedMessages)
+		apiError.Message = message.Message
+		apiError.WithDetail("locale", message.Locale)
+	}
+	if len(decodingErrors) > 0 {
+		return apiError, fmt.Errorf("Could not decode the error details: %s", strings.Join(decodingErrors, ", "))
+	}
+	return apiError, nil
+}
+
+// setHeaders sets the response headers carried by the API error, the Retry-After header in whole seconds rounded up
+func setHeaders(ctx *gin.Context, apiError *APIError) {
+	if apiError.RetryAfter > 0 {
+		ctx.Header("Retry-After", strconv.FormatInt(int64(math.Ceil(apiError.RetryAfter.Seconds())), 10))
+	}
+}
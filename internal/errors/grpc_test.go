@@ -0,0 +1,144 @@
+package errors
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/quadev-ltd/qd-common/pb/gen/go/pb_errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func grpcError(t *testing.T, code codes.Code, message string, details ...protoadapt.MessageV1) error {
+	grpcStatus, err := status.New(code, message).WithDetails(details...)
+	assert.NoError(t, err)
+	return grpcStatus.Err()
+}
+
+func newTestContext(acceptLanguage string) (*gin.Context, *httptest.ResponseRecorder) {
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/test", nil)
+	if acceptLanguage != "" {
+		ctx.Request.Header.Set("Accept-Language", acceptLanguage)
+	}
+	return ctx, recorder
+}
+
+func exposingInternalErrors(t *testing.T, exposes bool) {
+	previous := exposesInternalErrors
+	exposesInternalErrors = func() bool { return exposes }
+	t.Cleanup(func() { exposesInternalErrors = previous })
+}
+
+func TestFromGRPCError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("Field_Errors", func(t *testing.T) {
+		ctx, _ := newTestContext("")
+		err := grpcError(t, codes.InvalidArgument, "Invalid user",
+			&pb_errors.FieldError{Field: "email", Error: "email is invalid"},
+			&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "password", Description: "password is too short"},
+			}},
+		)
+
+		apiError, decodingErr := FromGRPCError(ctx, err)
+
+		assert.NoError(t, decodingErr)
+		assert.Equal(t, http.StatusBadRequest, apiError.Status)
+		assert.Equal(t, ValidationFailed, apiError.Code)
+		assert.Equal(t, []FieldError{
+			{Field: "email", Error: "email is invalid"},
+			{Field: "password", Error: "password is too short"},
+		}, apiError.FieldErrors)
+	})
+
+	t.Run("Error_Info_Reason_And_Domain", func(t *testing.T) {
+		ctx, _ := newTestContext("")
+		err := grpcError(t, codes.AlreadyExists, "Email taken", &errdetails.ErrorInfo{
+			Reason:   "EMAIL_ALREADY_TAKEN",
+			Domain:   "authentication.quadev.com",
+			Metadata: map[string]string{"field": "email"},
+		})
+
+		apiError, _ := FromGRPCError(ctx, err)
+
+		assert.Equal(t, Conflict, apiError.Code)
+		assert.Equal(t, "email_already_taken", apiError.Reason)
+		assert.Equal(t, "authentication.quadev.com", apiError.Details["domain"])
+		assert.Equal(t, map[string]string{"field": "email"}, apiError.Details["metadata"])
+	})
+
+	t.Run("Retry_Info_Sets_Retry_After", func(t *testing.T) {
+		ctx, recorder := newTestContext("")
+		err := grpcError(t, codes.ResourceExhausted, "Slow down", &errdetails.RetryInfo{
+			RetryDelay: durationpb.New(1500 * time.Millisecond),
+		})
+
+		HandleError(ctx, err)
+
+		assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		assert.Equal(t, "2", recorder.Header().Get("Retry-After"))
+	})
+
+	t.Run("Quota_And_Precondition_Failures", func(t *testing.T) {
+		ctx, _ := newTestContext("")
+		err := grpcError(t, codes.FailedPrecondition, "Cannot proceed",
+			&errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{
+				{Subject: "user:1", Description: "Daily analyses used up"},
+			}},
+			&errdetails.PreconditionFailure{Violations: []*errdetails.PreconditionFailure_Violation{
+				{Type: "TOS", Subject: "user:1", Description: "Terms not accepted"},
+			}},
+		)
+
+		apiError, _ := FromGRPCError(ctx, err)
+
+		assert.Equal(t, []gin.H{{"subject": "user:1", "description": "Daily analyses used up"}}, apiError.Details["quota_violations"])
+		assert.Equal(t, []gin.H{{"type": "TOS", "subject": "user:1", "description": "Terms not accepted"}}, apiError.Details["precondition_violations"])
+	})
+
+	t.Run("Localized_Message_Of_Accepted_Language", func(t *testing.T) {
+		ctx, _ := newTestContext("es-ES,es;q=0.9,en;q=0.8")
+		err := grpcError(t, codes.NotFound, "User not found",
+			&errdetails.LocalizedMessage{Locale: "en-US", Message: "The user was not found"},
+			&errdetails.LocalizedMessage{Locale: "es-ES", Message: "No se encontró el usuario"},
+		)
+
+		apiError, _ := FromGRPCError(ctx, err)
+
+		assert.Equal(t, "No se encontró el usuario", apiError.Message)
+		assert.Equal(t, "es-ES", apiError.Details["locale"])
+	})
+
+	t.Run("Internal_Message_Hidden_In_Production", func(t *testing.T) {
+		exposingInternalErrors(t, false)
+		ctx, _ := newTestContext("")
+
+		internalError, _ := FromGRPCError(ctx, status.Error(codes.Internal, "pq: connection refused"))
+		unknownError, _ := FromGRPCError(ctx, fmt.Errorf("dial tcp 10.0.0.1:9090"))
+		notFoundError, _ := FromGRPCError(ctx, status.Error(codes.NotFound, "User not found"))
+
+		assert.Equal(t, internalErrorMessage, internalError.Message)
+		assert.Equal(t, internalErrorMessage, unknownError.Message)
+		assert.Equal(t, "User not found", notFoundError.Message)
+	})
+
+	t.Run("Internal_Message_Exposed_Outside_Production", func(t *testing.T) {
+		exposingInternalErrors(t, true)
+		ctx, _ := newTestContext("")
+
+		apiError, _ := FromGRPCError(ctx, status.Error(codes.Internal, "pq: connection refused"))
+
+		assert.Equal(t, "pq: connection refused", apiError.Message)
+	})
+}
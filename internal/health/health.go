@@ -0,0 +1,129 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Route paths
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+)
+
+// Status values reported by the health routes
+const (
+	StatusOK       = "ok"
+	StatusReady    = "ready"
+	StatusNotReady = "not_ready"
+)
+
+// defaultCheckTimeout bounds how long all readiness checks may take together
+const defaultCheckTimeout = 2 * time.Second
+
+// Check is a named readiness check
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Handlerer defines the interface for the health route handlers
+type Handlerer interface {
+	// Liveness reports whether the process is alive
+	Liveness(ctx *gin.Context)
+	// Readiness reports whether the gateway and its dependencies can serve traffic
+	Readiness(ctx *gin.Context)
+}
+
+// Handler implements the Handlerer interface
+type Handler struct {
+	isReady      func() bool
+	checks       []Check
+	checkTimeout time.Duration
+}
+
+var _ Handlerer = &Handler{}
+
+// NewHandler creates a new health handler, isReady reports the lifecycle state of the gateway
+func NewHandler(isReady func() bool, checks ...Check) *Handler {
+	return &Handler{
+		isReady:      isReady,
+		checks:       checks,
+		checkTimeout: defaultCheckTimeout,
+	}
+}
+
+// RegisterRoutes registers the liveness and readiness routes
+func RegisterRoutes(router gin.IRoutes, handler Handlerer) {
+	router.GET(LivenessPath, handler.Liveness)
+	router.GET(ReadinessPath, handler.Readiness)
+}
+
+// Liveness always reports ok while the process is able to serve requests
+func (handler *Handler) Liveness(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"status": StatusOK})
+}
+
+// Readiness runs every readiness check and reports 503 if any of them fails. The errors of the checks are
+// logged and not returned, the route being public and the errors holding downstream addresses and TLS details.
+func (handler *Handler) Readiness(ctx *gin.Context) {
+	if !handler.isReady() {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"status": StatusNotReady})
+		return
+	}
+
+	checkContext, cancel := context.WithTimeout(ctx.Request.Context(), handler.checkTimeout)
+	defer cancel()
+
+	results := make(map[string]string, len(handler.checks))
+	var mtx sync.Mutex
+	var waitGroup sync.WaitGroup
+	for _, check := range handler.checks {
+		waitGroup.Add(1)
+		go func(check Check) {
+			defer waitGroup.Done()
+			result := StatusOK
+			if err := check.Check(checkContext); err != nil {
+				log.Error().Err(err).Str("check", check.Name).Msg("Readiness check failed")
+				result = StatusNotReady
+			}
+			mtx.Lock()
+			results[check.Name] = result
+			mtx.Unlock()
+		}(check)
+	}
+	waitGroup.Wait()
+
+	status := StatusReady
+	httpStatus := http.StatusOK
+	for _, result := range results {
+		if result != StatusOK {
+			status = StatusNotReady
+			httpStatus = http.StatusServiceUnavailable
+			break
+		}
+	}
+	ctx.JSON(httpStatus, gin.H{
+		"status": status,
+		"checks": results,
+	})
+}
+
+// CheckGRPCHealth queries a downstream service with the standard gRPC health protocol
+func CheckGRPCHealth(ctx context.Context, client grpc_health_v1.HealthClient, service string) error {
+	response, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+	if err != nil {
+		return err
+	}
+	if response.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		return fmt.Errorf("service status is %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcHealth "google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+func performRequest(t *testing.T, handler Handlerer, path string) (int, healthResponse) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterRoutes(router, handler)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	router.ServeHTTP(recorder, request)
+
+	var response healthResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return recorder.Code, response
+}
+
+func TestHealth(t *testing.T) {
+	passingCheck := Check{Name: "passing", Check: func(ctx context.Context) error { return nil }}
+	failingCheck := Check{Name: "failing", Check: func(ctx context.Context) error { return errors.New("example error") }}
+
+	t.Run("Liveness_Success", func(t *testing.T) {
+		handler := NewHandler(func() bool { return false }, failingCheck)
+
+		code, response := performRequest(t, handler, LivenessPath)
+
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, StatusOK, response.Status)
+	})
+
+	t.Run("Readiness_Success", func(t *testing.T) {
+		handler := NewHandler(func() bool { return true }, passingCheck)
+
+		code, response := performRequest(t, handler, ReadinessPath)
+
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, StatusReady, response.Status)
+		assert.Equal(t, StatusOK, response.Checks["passing"])
+	})
+
+	t.Run("Readiness_Draining_Error", func(t *testing.T) {
+		handler := NewHandler(func() bool { return false }, passingCheck)
+
+		code, response := performRequest(t, handler, ReadinessPath)
+
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, StatusNotReady, response.Status)
+	})
+
+	t.Run("Readiness_Failing_Check_Error", func(t *testing.T) {
+		handler := NewHandler(func() bool { return true }, passingCheck, failingCheck)
+
+		code, response := performRequest(t, handler, ReadinessPath)
+
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, StatusNotReady, response.Status)
+		assert.Equal(t, StatusOK, response.Checks["passing"])
+		assert.Equal(t, StatusNotReady, response.Checks["failing"])
+	})
+
+	t.Run("CheckGRPCHealth", func(t *testing.T) {
+		listener := bufconn.Listen(1024 * 1024)
+		server := grpc.NewServer()
+		healthServer := grpcHealth.NewServer()
+		grpc_health_v1.RegisterHealthServer(server, healthServer)
+		go server.Serve(listener)
+		defer server.Stop()
+
+		connection, err := grpc.Dial(
+			"bufnet",
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+		assert.NoError(t, err)
+		defer connection.Close()
+		client := grpc_health_v1.NewHealthClient(connection)
+
+		assert.NoError(t, CheckGRPCHealth(context.Background(), client, ""))
+
+		healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+		err = CheckGRPCHealth(context.Background(), client, "")
+		assert.Error(t, err)
+		assert.Equal(t, "service status is NOT_SERVING", err.Error())
+	})
+}
@@ -1,51 +1,133 @@
 package imageanalysis
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"runtime"
 
 	"github.com/gin-gonic/gin"
-	"github.com/quadev-ltd/qd-common/pb/gen/go/pb_image_analysis"
 	commonConfig "github.com/quadev-ltd/qd-common/pkg/config"
-	commonTLS "github.com/quadev-ltd/qd-common/pkg/tls"
 	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
 
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/connection"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/health"
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/imageanalysis/routes"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/images"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/jobs"
 )
 
 // ServiceClienter defines the interface for the image analysis service client
 type ServiceClienter interface {
 	// ProcessImageAndPrompt processes an image with a given prompt
 	ProcessImageAndPrompt(ctx *gin.Context)
+	// GetImageAnalysisJob returns an asynchronous image analysis job
+	GetImageAnalysisJob(ctx *gin.Context)
+	// CheckHealth checks the image analysis service with the gRPC health protocol
+	CheckHealth(ctx context.Context) error
+	// Close closes the connection to the image analysis service
+	Close() error
 }
 
 // ServiceClient implements the ServiceClienter interface and handles communication with the image analysis service
 type ServiceClient struct {
-	client pb_image_analysis.ImageAnalysisServiceClient
+	uploader *routes.ImageUploader
+	limits   routes.UploadLimits
+	// preprocessing is nil when the images are sent as they are uploaded
+	preprocessing *images.PreprocessOptions
+	jobManager    *jobs.Manager
+	// streamer is nil when the analysis is not sent as server-sent events
+	streamer     *routes.EventStreamer
+	healthClient grpc_health_v1.HealthClient
+	connection   *grpc.ClientConn
 }
 
 var _ ServiceClienter = &ServiceClient{}
 
-// InitServiceClient initializes a new image analysis service client with the provided configuration
-func InitServiceClient(configurations *commonConfig.Config) (ServiceClienter, error) {
+// InitServiceClient initializes a new image analysis service client with the provided configuration,
+// streaming, limiting and preprocessing the images as configured. The asynchronous requests run on the job
+// manager, which the client closes with itself.
+func InitServiceClient(
+	configurations *commonConfig.Config,
+	imageAnalysisConfig config.ImageAnalysisConfig,
+	jobManager *jobs.Manager,
+	options ...grpc.DialOption,
+) (ServiceClienter, error) {
 	log.Info().Msg("Initializing image analysis service client")
 
 	addr := fmt.Sprintf("%s:%s",
 		configurations.ImageAnalysisService.Host,
 		configurations.ImageAnalysisService.Port)
 
-	conn, err := commonTLS.CreateGRPCConnection(addr, configurations.TLSEnabled)
+	conn, err := connection.CreateGRPCConnection(addr, configurations.TLSEnabled, options...)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create gRPC connection: %v", err)
 	}
 
-	client := pb_image_analysis.NewImageAnalysisServiceClient(conn)
+	var preprocessing *images.PreprocessOptions
+	if imageAnalysisConfig.Preprocessing.Enabled {
+		decodes := imageAnalysisConfig.Preprocessing.MaxConcurrentDecodes
+		if decodes <= 0 {
+			decodes = runtime.NumCPU()
+		}
+		preprocessing = &images.PreprocessOptions{
+			MaxEdge:     imageAnalysisConfig.Preprocessing.MaxEdge,
+			JPEGQuality: imageAnalysisConfig.Preprocessing.JPEGQuality,
+			MaxPixels:   imageAnalysisConfig.Preprocessing.MaxPixels,
+			Decodes:     make(chan struct{}, decodes),
+		}
+	}
+
+	var streamer *routes.EventStreamer
+	if imageAnalysisConfig.EventStreaming.Enabled {
+		streamer = routes.NewEventStreamer(conn, imageAnalysisConfig.EventStreaming.HeartbeatInterval)
+	}
 
 	return &ServiceClient{
-		client: client,
+		uploader: routes.NewImageUploader(conn, imageAnalysisConfig.Streaming, imageAnalysisConfig.ChunkSize),
+		limits: routes.UploadLimits{
+			MaxUploadSize: imageAnalysisConfig.MaxUploadSize,
+			MaxWidth:      imageAnalysisConfig.MaxWidth,
+			MaxHeight:     imageAnalysisConfig.MaxHeight,
+		},
+		preprocessing: preprocessing,
+		jobManager:    jobManager,
+		streamer:      streamer,
+		healthClient:  grpc_health_v1.NewHealthClient(conn),
+		connection:    conn,
 	}, nil
 }
 
 // ProcessImageAndPrompt handles the HTTP request to process an image with a prompt
 func (service *ServiceClient) ProcessImageAndPrompt(ctx *gin.Context) {
-	routes.ProcessImageAndPrompt(ctx, service.client)
+	routes.ProcessImageAndPrompt(ctx, service.uploader, service.limits, service.preprocessing, service.jobManager, service.streamer)
+}
+
+// GetImageAnalysisJob handles the HTTP request returning an asynchronous image analysis job
+func (service *ServiceClient) GetImageAnalysisJob(ctx *gin.Context) {
+	routes.GetImageAnalysisJob(ctx, service.jobManager)
+}
+
+// CheckHealth checks the image analysis service with the gRPC health protocol
+func (service *ServiceClient) CheckHealth(ctx context.Context) error {
+	return health.CheckGRPCHealth(ctx, service.healthClient, "")
+}
+
+// Close interrupts the jobs in flight and closes the connection to the image analysis service
+func (service *ServiceClient) Close() error {
+	var closeErrors []error
+	if service.jobManager != nil {
+		if err := service.jobManager.Close(); err != nil {
+			closeErrors = append(closeErrors, fmt.Errorf("Could not close the job manager: %v", err))
+		}
+	}
+	if service.connection != nil {
+		if err := service.connection.Close(); err != nil {
+			closeErrors = append(closeErrors, err)
+		}
+	}
+	return errors.Join(closeErrors...)
 }
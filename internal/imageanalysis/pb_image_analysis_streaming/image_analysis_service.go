@@ -0,0 +1,200 @@
+// Package pb_image_analysis_streaming holds the service code of image-analysis.proto, laid out as
+// protoc-gen-go-grpc generates it. The messages are those generated in the shared pb_image_analysis
+// package, so the package goes away once the streaming RPCs are merged into the shared definitions.
+package pb_image_analysis_streaming
+
+import (
+	"context"
+
+	"github.com/quadev-ltd/qd-common/pb/gen/go/pb_image_analysis"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Full method names of the RPCs of the image analysis service
+const (
+	ImageAnalysisService_ProcessImageAndPrompt_FullMethodName       = pb_image_analysis.ImageAnalysisService_ProcessImageAndPrompt_FullMethodName
+	ImageAnalysisService_ProcessImageAndPromptStream_FullMethodName = "/src.pb.ImageAnalysisService/ProcessImageAndPromptStream"
+	ImageAnalysisService_ProcessImageAndPromptEvents_FullMethodName = "/src.pb.ImageAnalysisService/ProcessImageAndPromptEvents"
+)
+
+// ImageAnalysisServiceClient is the client API for the ImageAnalysisService service
+type ImageAnalysisServiceClient interface {
+	ProcessImageAndPrompt(ctx context.Context, in *pb_image_analysis.ImagePromptRequest, opts ...grpc.CallOption) (*pb_image_analysis.ImagePromptResponse, error)
+	ProcessImageAndPromptStream(ctx context.Context, opts ...grpc.CallOption) (ImageAnalysisService_ProcessImageAndPromptStreamClient, error)
+	ProcessImageAndPromptEvents(ctx context.Context, in *pb_image_analysis.ImagePromptRequest, opts ...grpc.CallOption) (ImageAnalysisService_ProcessImageAndPromptEventsClient, error)
+}
+
+type imageAnalysisServiceClient struct {
+	pb_image_analysis.ImageAnalysisServiceClient
+	cc grpc.ClientConnInterface
+}
+
+// NewImageAnalysisServiceClient creates a client of the image analysis service on the connection
+func NewImageAnalysisServiceClient(cc grpc.ClientConnInterface) ImageAnalysisServiceClient {
+	return &imageAnalysisServiceClient{pb_image_analysis.NewImageAnalysisServiceClient(cc), cc}
+}
+
+func (c *imageAnalysisServiceClient) ProcessImageAndPromptStream(ctx context.Context, opts ...grpc.CallOption) (ImageAnalysisService_ProcessImageAndPromptStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ImageAnalysisService_ServiceDesc.Streams[0], ImageAnalysisService_ProcessImageAndPromptStream_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &imageAnalysisServiceProcessImageAndPromptStreamClient{stream}, nil
+}
+
+// ImageAnalysisService_ProcessImageAndPromptStreamClient is the client side of the ProcessImageAndPromptStream stream
+type ImageAnalysisService_ProcessImageAndPromptStreamClient interface {
+	Send(*pb_image_analysis.ImagePromptRequest) error
+	CloseAndRecv() (*pb_image_analysis.ImagePromptResponse, error)
+	grpc.ClientStream
+}
+
+type imageAnalysisServiceProcessImageAndPromptStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *imageAnalysisServiceProcessImageAndPromptStreamClient) Send(m *pb_image_analysis.ImagePromptRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *imageAnalysisServiceProcessImageAndPromptStreamClient) CloseAndRecv() (*pb_image_analysis.ImagePromptResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(pb_image_analysis.ImagePromptResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *imageAnalysisServiceClient) ProcessImageAndPromptEvents(ctx context.Context, in *pb_image_analysis.ImagePromptRequest, opts ...grpc.CallOption) (ImageAnalysisService_ProcessImageAndPromptEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ImageAnalysisService_ServiceDesc.Streams[1], ImageAnalysisService_ProcessImageAndPromptEvents_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &imageAnalysisServiceProcessImageAndPromptEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// ImageAnalysisService_ProcessImageAndPromptEventsClient is the client side of the ProcessImageAndPromptEvents stream
+type ImageAnalysisService_ProcessImageAndPromptEventsClient interface {
+	Recv() (*pb_image_analysis.ImagePromptResponse, error)
+	grpc.ClientStream
+}
+
+type imageAnalysisServiceProcessImageAndPromptEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *imageAnalysisServiceProcessImageAndPromptEventsClient) Recv() (*pb_image_analysis.ImagePromptResponse, error) {
+	m := new(pb_image_analysis.ImagePromptResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ImageAnalysisServiceServer is the server API for the ImageAnalysisService service.
+// All implementations must embed UnimplementedImageAnalysisServiceServer for forward compatibility.
+type ImageAnalysisServiceServer interface {
+	pb_image_analysis.ImageAnalysisServiceServer
+	ProcessImageAndPromptStream(ImageAnalysisService_ProcessImageAndPromptStreamServer) error
+	ProcessImageAndPromptEvents(*pb_image_analysis.ImagePromptRequest, ImageAnalysisService_ProcessImageAndPromptEventsServer) error
+}
+
+// UnimplementedImageAnalysisServiceServer must be embedded to have forward compatible implementations
+type UnimplementedImageAnalysisServiceServer struct {
+	pb_image_analysis.UnimplementedImageAnalysisServiceServer
+}
+
+func (UnimplementedImageAnalysisServiceServer) ProcessImageAndPromptStream(ImageAnalysisService_ProcessImageAndPromptStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method ProcessImageAndPromptStream not implemented")
+}
+
+func (UnimplementedImageAnalysisServiceServer) ProcessImageAndPromptEvents(*pb_image_analysis.ImagePromptRequest, ImageAnalysisService_ProcessImageAndPromptEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ProcessImageAndPromptEvents not implemented")
+}
+
+// RegisterImageAnalysisServiceServer registers the implementation of the image analysis service with the server
+func RegisterImageAnalysisServiceServer(s grpc.ServiceRegistrar, srv ImageAnalysisServiceServer) {
+	s.RegisterService(&ImageAnalysisService_ServiceDesc, srv)
+}
+
+func _ImageAnalysisService_ProcessImageAndPromptStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ImageAnalysisServiceServer).ProcessImageAndPromptStream(&imageAnalysisServiceProcessImageAndPromptStreamServer{stream})
+}
+
+// ImageAnalysisService_ProcessImageAndPromptStreamServer is the server side of the ProcessImageAndPromptStream stream
+type ImageAnalysisService_ProcessImageAndPromptStreamServer interface {
+	SendAndClose(*pb_image_analysis.ImagePromptResponse) error
+	Recv() (*pb_image_analysis.ImagePromptRequest, error)
+	grpc.ServerStream
+}
+
+type imageAnalysisServiceProcessImageAndPromptStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *imageAnalysisServiceProcessImageAndPromptStreamServer) SendAndClose(m *pb_image_analysis.ImagePromptResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *imageAnalysisServiceProcessImageAndPromptStreamServer) Recv() (*pb_image_analysis.ImagePromptRequest, error) {
+	m := new(pb_image_analysis.ImagePromptRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _ImageAnalysisService_ProcessImageAndPromptEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(pb_image_analysis.ImagePromptRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ImageAnalysisServiceServer).ProcessImageAndPromptEvents(m, &imageAnalysisServiceProcessImageAndPromptEventsServer{stream})
+}
+
+// ImageAnalysisService_ProcessImageAndPromptEventsServer is the server side of the ProcessImageAndPromptEvents stream
+type ImageAnalysisService_ProcessImageAndPromptEventsServer interface {
+	Send(*pb_image_analysis.ImagePromptResponse) error
+	grpc.ServerStream
+}
+
+type imageAnalysisServiceProcessImageAndPromptEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *imageAnalysisServiceProcessImageAndPromptEventsServer) Send(m *pb_image_analysis.ImagePromptResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// ImageAnalysisService_ServiceDesc is the grpc.ServiceDesc for the ImageAnalysisService service,
+// only intended for direct use with grpc.RegisterService
+var ImageAnalysisService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "src.pb.ImageAnalysisService",
+	HandlerType: (*ImageAnalysisServiceServer)(nil),
+	Methods:     pb_image_analysis.ImageAnalysisService_ServiceDesc.Methods,
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ProcessImageAndPromptStream",
+			Handler:       _ImageAnalysisService_ProcessImageAndPromptStream_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "ProcessImageAndPromptEvents",
+			Handler:       _ImageAnalysisService_ProcessImageAndPromptEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "image-analysis.proto",
+}
@@ -3,17 +3,43 @@ package imageanalysis
 import (
 	"github.com/gin-gonic/gin"
 	commonConfig "github.com/quadev-ltd/qd-common/pkg/config"
-	"golang.org/x/time/rate"
 
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/imageanalysis/routes"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/metering"
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/middleware"
 )
 
-// RegisterRoutes registers all image analysis related routes with the provided router group
-func RegisterRoutes(service ServiceClienter, api *gin.RouterGroup, configurations *commonConfig.Config, authMiddleware middleware.AutheticationMiddlewarer) error {
-	rl := middleware.NewRateLimiter(rate.Limit(0.05), 3) // 3 requests per minute
+// Feature is the entitlement required to use the image analysis
+const Feature = "image-analysis"
 
-	imageAnalysisRoutes := api.Group("/image-analysis")
-	imageAnalysisRoutes.POST("", authMiddleware.RequirePaidFeatures, middleware.RateLimitMiddleware(rl), service.ProcessImageAndPrompt)
+// RegisterRoutes registers all image analysis related routes with the provided router group,
+// applying the configured authorization and rate limit policies
+func RegisterRoutes(
+	service ServiceClienter,
+	api *gin.RouterGroup,
+	centralConfig *commonConfig.Config,
+	configurations *config.Config,
+	authMiddleware middleware.AutheticationMiddlewarer,
+	entitlementMiddleware middleware.EntitlementMiddlewarer,
+	meter *metering.Meter,
+	routePolicies []middleware.RoutePolicier,
+) error {
+	router := middleware.NewPolicyRouter(api, routePolicies...)
 
-	return nil
+	imageAnalysisRoutes := router.Group("/image-analysis")
+	imageAnalysisRoutes.POST(
+		"",
+		authMiddleware.RequireAuthentication,
+		entitlementMiddleware.RequireEntitlement(Feature),
+		meter.Middleware(),
+		service.ProcessImageAndPrompt,
+	)
+	imageAnalysisRoutes.GET(
+		"/jobs/:"+routes.JobIDParam,
+		authMiddleware.RequireAuthentication,
+		service.GetImageAnalysisJob,
+	)
+
+	return router.Err()
 }
@@ -0,0 +1,219 @@
+package routes
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/quadev-ltd/qd-common/pb/gen/go/pb_image_analysis"
+	commonLogger "github.com/quadev-ltd/qd-common/pkg/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/imageanalysis/pb_image_analysis_streaming"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/metering"
+)
+
+// EventStreamContentType is the media type of the server-sent events
+const EventStreamContentType = "text/event-stream"
+
+// Server-sent events of the image analysis: the progress of the request, the parts of the analysis as they
+// are generated and either the final result, rendered as the response of the other requests, or the error
+const (
+	ProgressEvent = "progress"
+	ChunkEvent    = "chunk"
+	ResultEvent   = "result"
+	ErrorEvent    = "error"
+)
+
+// Stages of the progress events
+const (
+	AnalysingStage  = "analysing"
+	GeneratingStage = "generating"
+)
+
+// DefaultHeartbeatInterval is used when no heartbeat interval is configured
+const DefaultHeartbeatInterval = 15 * time.Second
+
+// heartbeatComment keeps the idle event streams open, the clients ignore the comments
+const heartbeatComment = ": heartbeat\n\n"
+
+// EventStreamer relays the analysis of the images as server-sent events, in parts from the server streaming
+// RPC of the image analysis service when it supports it and at once otherwise
+type EventStreamer struct {
+	client            pb_image_analysis_streaming.ImageAnalysisServiceClient
+	heartbeatInterval time.Duration
+	supported         int32
+}
+
+// NewEventStreamer creates an event streamer on the connection to the image analysis service, sending heartbeat
+// comments on the idle streams at the given interval
+func NewEventStreamer(connection grpc.ClientConnInterface, heartbeatInterval time.Duration) *EventStreamer {
+	if heartbeatInterval <= 0 {
+		heartbeatInterval = DefaultHeartbeatInterval
+	}
+	return &EventStreamer{
+		client:            pb_image_analysis_streaming.NewImageAnalysisServiceClient(connection),
+		heartbeatInterval: heartbeatInterval,
+		supported:         streamingUnknown,
+	}
+}
+
+// acceptsEventStream reports whether the Accept header accepts the server-sent events
+func acceptsEventStream(accept string) bool {
+	for _, acceptedType := range strings.Split(accept, ",") {
+		mediaType, parameters, err := mime.ParseMediaType(strings.TrimSpace(acceptedType))
+		if err == nil && mediaType == EventStreamContentType && parameters["q"] != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// receive calls the server streaming RPC and sends the parts of the analysis received on the channel,
+// returning the error ending the stream or nil once it completes
+func (streamer *EventStreamer) receive(ctx context.Context, request imageAnalysisRequest, parts chan<- string) error {
+	stream, err := streamer.client.ProcessImageAndPromptEvents(ctx, &pb_image_analysis.ImagePromptRequest{
+		ImageData: request.image,
+		Prompt:    request.prompt,
+		MimeType:  request.mimeType,
+	})
+	if err != nil {
+		return err
+	}
+	for {
+		message, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		select {
+		case parts <- message.ResponseToPrompt:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// receiveAtOnce sends the image with the uploader, for the services without the server streaming RPC, and
+// sends the whole analysis on the channel as its only part
+func receiveAtOnce(uploader *ImageUploader) func(ctx context.Context, request imageAnalysisRequest, parts chan<- string) error {
+	return func(ctx context.Context, request imageAnalysisRequest, parts chan<- string) error {
+		response, err := request.analyse(ctx, uploader)
+		if err != nil {
+			return err
+		}
+		select {
+		case parts <- response.ResponseToPrompt:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// relay sends the parts of the analysis received as chunk events, and heartbeat comments while the service
+// is silent, until the receiver returns. It returns the analysis relayed and the error of the receiver.
+func (streamer *EventStreamer) relay(
+	ctx *gin.Context,
+	streamCtx context.Context,
+	request imageAnalysisRequest,
+	receive func(ctx context.Context, request imageAnalysisRequest, parts chan<- string) error,
+) (string, error) {
+	parts := make(chan string)
+	done := make(chan error, 1)
+	go func() {
+		done <- receive(streamCtx, request, parts)
+	}()
+	heartbeat := time.NewTicker(streamer.heartbeatInterval)
+	defer heartbeat.Stop()
+
+	var analysis strings.Builder
+	for {
+		select {
+		case part := <-parts:
+			if analysis.Len() == 0 {
+				sendEvent(ctx, ProgressEvent, gin.H{"stage": GeneratingStage})
+			}
+			analysis.WriteString(part)
+			sendEvent(ctx, ChunkEvent, gin.H{"text": part})
+		case err := <-done:
+			return analysis.String(), err
+		case <-heartbeat.C:
+			ctx.Writer.WriteString(heartbeatComment)
+			ctx.Writer.Flush()
+		case <-streamCtx.Done():
+			return analysis.String(), streamCtx.Err()
+		}
+	}
+}
+
+// sendEvent writes a server-sent event and flushes it to the client
+func sendEvent(ctx *gin.Context, event string, data interface{}) {
+	ctx.SSEvent(event, data)
+	ctx.Writer.Flush()
+}
+
+// sendError renders the error of the image analysis service as an error event, the status being sent already
+func sendError(ctx *gin.Context, err error) {
+	apiError, decodingError := errors.FromGRPCError(ctx, err)
+	if decodingError != nil {
+		if logger, loggerErr := commonLogger.GetLoggerFromContext(ctx.Request.Context()); loggerErr == nil {
+			logger.Error(decodingError, "Error decoding the image analysis error")
+		}
+	}
+	ctx.Error(apiError)
+	sendEvent(ctx, ErrorEvent, errors.NewEnvelope(ctx, apiError))
+}
+
+// streamImageAnalysis relays the analysis of the image as server-sent events. When the client disconnects the
+// call to the service is cancelled. A service without the server streaming RPC gets the image from the uploader
+// and its analysis is sent in one chunk. The status being sent before the outcome is known, the usage of the
+// request is metered from the outcome, only the streams ending with the result being billed.
+func streamImageAnalysis(ctx *gin.Context, streamer *EventStreamer, uploader *ImageUploader, request imageAnalysisRequest) {
+	streamCtx, cancel := context.WithCancel(ctx.Request.Context())
+	defer cancel()
+	settle := metering.DeferSettlement(ctx)
+
+	ctx.Header("Cache-Control", "no-cache")
+	// Stops the proxies such as nginx from buffering the events
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Status(http.StatusOK)
+	sendEvent(ctx, ProgressEvent, gin.H{"stage": AnalysingStage})
+
+	streams := atomic.LoadInt32(&streamer.supported) != streamingUnsupported
+	var analysis string
+	var err error
+	if streams {
+		analysis, err = streamer.relay(ctx, streamCtx, request, streamer.receive)
+		if status.Code(err) == codes.Unimplemented && analysis == "" {
+			atomic.StoreInt32(&streamer.supported, streamingUnsupported)
+			streams = false
+		} else if err == nil {
+			atomic.StoreInt32(&streamer.supported, streamingSupported)
+		}
+	}
+	if !streams {
+		analysis, err = streamer.relay(ctx, streamCtx, request, receiveAtOnce(uploader))
+	}
+
+	switch {
+	case streamCtx.Err() != nil:
+		// The client disconnected, nothing more can be sent
+		settle(ctx.Request.Context(), false)
+	case err != nil:
+		sendError(ctx, err)
+		settle(ctx.Request.Context(), false)
+	default:
+		sendEvent(ctx, ResultEvent, &pb_image_analysis.ImagePromptResponse{ResponseToPrompt: analysis})
+		settle(ctx.Request.Context(), true)
+	}
+}
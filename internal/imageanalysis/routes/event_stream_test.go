@@ -0,0 +1,239 @@
+package routes
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/metering"
+)
+
+type serverEvent struct {
+	event string
+	data  string
+}
+
+func newEventStreamRouter(uploader *ImageUploader, streamer *EventStreamer) *gin.Engine {
+	router := gin.New()
+	router.Use(withTestUser)
+	router.POST("/image-analysis", func(ctx *gin.Context) {
+		ProcessImageAndPrompt(ctx, uploader, UploadLimits{}, nil, nil, streamer)
+	})
+	return router
+}
+
+func newEventStreamRequest(t *testing.T, image []byte) *http.Request {
+	request := newImageAnalysisRequest(t,
+		formField{name: PromptField, value: []byte("Describe the image")},
+		formField{name: ImageField, value: image},
+	)
+	request.Header.Set("Accept", EventStreamContentType)
+	return request
+}
+
+// parseEvents reads the server-sent events of the body, skipping the comments
+func parseEvents(body string) []serverEvent {
+	var events []serverEvent
+	var event serverEvent
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event.event != "" {
+				events = append(events, event)
+			}
+			event = serverEvent{}
+		case strings.HasPrefix(line, "event:"):
+			event.event = strings.TrimPrefix(line, "event:")
+		case strings.HasPrefix(line, "data:"):
+			event.data = strings.TrimPrefix(line, "data:")
+		}
+	}
+	return events
+}
+
+func TestStreamImageAnalysis(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	image := testPNG(t, 64, 64)
+
+	t.Run("Chunks_And_Result_Relayed", func(t *testing.T) {
+		server := &imageAnalysisServer{parts: []string{"A cat ", "on a mat"}}
+		connection := startImageAnalysisServer(t, server, true)
+		router := newEventStreamRouter(NewImageUploader(connection, true, 0), NewEventStreamer(connection, time.Minute))
+		recorder := httptest.NewRecorder()
+
+		router.ServeHTTP(recorder, newEventStreamRequest(t, image))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, EventStreamContentType, recorder.Header().Get("Content-Type"))
+		assert.Equal(t, "no-cache", recorder.Header().Get("Cache-Control"))
+		assert.Equal(t, []serverEvent{
+			{event: ProgressEvent, data: `{"stage":"analysing"}`},
+			{event: ProgressEvent, data: `{"stage":"generating"}`},
+			{event: ChunkEvent, data: `{"text":"A cat "}`},
+			{event: ChunkEvent, data: `{"text":"on a mat"}`},
+			{event: ResultEvent, data: `{"responseToPrompt":"A cat on a mat"}`},
+		}, parseEvents(recorder.Body.String()))
+		assert.Equal(t, image, server.eventRequests[0].ImageData)
+		assert.Equal(t, "Describe the image", server.eventRequests[0].Prompt)
+		assert.Equal(t, "image/png", server.eventRequests[0].MimeType)
+	})
+
+	t.Run("Service_Error_Event", func(t *testing.T) {
+		server := &imageAnalysisServer{parts: []string{"A cat "}, err: status.Error(codes.InvalidArgument, "Unsupported image")}
+		connection := startImageAnalysisServer(t, server, true)
+		router := newEventStreamRouter(NewImageUploader(connection, true, 0), NewEventStreamer(connection, time.Minute))
+		recorder := httptest.NewRecorder()
+
+		router.ServeHTTP(recorder, newEventStreamRequest(t, image))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		events := parseEvents(recorder.Body.String())
+		assert.Equal(t, serverEvent{event: ChunkEvent, data: `{"text":"A cat "}`}, events[2])
+		assert.Equal(t, ErrorEvent, events[3].event)
+		assert.Contains(t, events[3].data, `"error":"`+errors.BadRequest+`"`)
+		assert.Contains(t, events[3].data, `"message":"Unsupported image"`)
+		assert.Len(t, events, 4)
+	})
+
+	t.Run("Usage_Metered_From_Stream_Outcome", func(t *testing.T) {
+		for _, testCase := range []struct {
+			err      error
+			isBilled bool
+		}{
+			{isBilled: true},
+			{err: status.Error(codes.Internal, "Model failure")},
+		} {
+			server := &imageAnalysisServer{parts: []string{"A cat"}, err: testCase.err}
+			connection := startImageAnalysisServer(t, server, true)
+			uploader := NewImageUploader(connection, true, 0)
+			streamer := NewEventStreamer(connection, time.Minute)
+			store := metering.NewMemoryMeteringStore()
+			router := gin.New()
+			router.Use(withTestUser)
+			router.POST("/image-analysis", metering.NewMeter(store, &config.Config{}).Middleware(), func(ctx *gin.Context) {
+				ProcessImageAndPrompt(ctx, uploader, UploadLimits{}, nil, nil, streamer)
+			})
+			request := newEventStreamRequest(t, image)
+			recorder := httptest.NewRecorder()
+
+			router.ServeHTTP(recorder, request)
+
+			usage, err := store.Usage(context.Background(), testUserID, metering.Period(time.Now()))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, recorder.Code)
+			if testCase.isBilled {
+				assert.Equal(t, metering.Usage{Requests: 1, Bytes: request.ContentLength}, usage)
+			} else {
+				assert.Equal(t, metering.Usage{}, usage)
+			}
+		}
+	})
+
+	t.Run("Without_Streaming_RPC_Result_At_Once", func(t *testing.T) {
+		server := &imageAnalysisServer{}
+		connection := startImageAnalysisServer(t, server, false)
+		streamer := NewEventStreamer(connection, time.Minute)
+		router := newEventStreamRouter(NewImageUploader(connection, false, 0), streamer)
+
+		for range []int{1, 2} {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, newEventStreamRequest(t, image))
+
+			assert.Equal(t, []serverEvent{
+				{event: ProgressEvent, data: `{"stage":"analysing"}`},
+				{event: ProgressEvent, data: `{"stage":"generating"}`},
+				{event: ChunkEvent, data: `{"text":"unary"}`},
+				{event: ResultEvent, data: `{"responseToPrompt":"unary"}`},
+			}, parseEvents(recorder.Body.String()))
+		}
+		assert.Equal(t, streamingUnsupported, streamer.supported)
+		assert.Len(t, server.unaryRequests, 2)
+	})
+
+	t.Run("Heartbeats_While_Silent", func(t *testing.T) {
+		server := &imageAnalysisServer{parts: []string{"A cat"}, delay: 50 * time.Millisecond}
+		connection := startImageAnalysisServer(t, server, true)
+		router := newEventStreamRouter(NewImageUploader(connection, true, 0), NewEventStreamer(connection, 5*time.Millisecond))
+		recorder := httptest.NewRecorder()
+
+		router.ServeHTTP(recorder, newEventStreamRequest(t, image))
+
+		assert.Contains(t, recorder.Body.String(), heartbeatComment)
+		events := parseEvents(recorder.Body.String())
+		assert.Equal(t, serverEvent{event: ResultEvent, data: `{"responseToPrompt":"A cat"}`}, events[len(events)-1])
+	})
+
+	t.Run("Client_Disconnect_Cancels_Analysis", func(t *testing.T) {
+		server := &imageAnalysisServer{block: true, cancelled: make(chan struct{})}
+		connection := startImageAnalysisServer(t, server, true)
+		gateway := httptest.NewServer(newEventStreamRouter(NewImageUploader(connection, true, 0), NewEventStreamer(connection, time.Minute)))
+		defer gateway.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		request := newEventStreamRequest(t, image)
+		request.RequestURI = ""
+		request.URL.Scheme = "http"
+		request.URL.Host = strings.TrimPrefix(gateway.URL, "http://")
+
+		response, err := http.DefaultClient.Do(request.WithContext(ctx))
+		assert.NoError(t, err)
+		defer response.Body.Close()
+		line, err := bufio.NewReader(response.Body).ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "event:"+ProgressEvent+"\n", line)
+		cancel()
+
+		select {
+		case <-server.cancelled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("The image analysis was not cancelled")
+		}
+	})
+
+	t.Run("Without_Accept_JSON_Response", func(t *testing.T) {
+		server := &imageAnalysisServer{parts: []string{"A cat"}}
+		connection := startImageAnalysisServer(t, server, true)
+		router := newEventStreamRouter(NewImageUploader(connection, true, 0), NewEventStreamer(connection, time.Minute))
+		recorder := httptest.NewRecorder()
+		request := newEventStreamRequest(t, image)
+		request.Header.Del("Accept")
+
+		router.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.JSONEq(t, `{"responseToPrompt":"streamed"}`, recorder.Body.String())
+		assert.Empty(t, server.eventRequests)
+	})
+}
+
+func TestAcceptsEventStream(t *testing.T) {
+	testCases := []struct {
+		accept   string
+		accepted bool
+	}{
+		{accept: "text/event-stream", accepted: true},
+		{accept: "application/json, text/event-stream;q=0.5", accepted: true},
+		{accept: "text/event-stream;q=0", accepted: false},
+		{accept: "application/json", accepted: false},
+		{accept: "*/*", accepted: false},
+		{accept: "", accepted: false},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.accept, func(t *testing.T) {
+			assert.Equal(t, testCase.accepted, acceptsEventStream(testCase.accept))
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package routes
+
+import (
+	"context"
+	goErrors "errors"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	commonJWT "github.com/quadev-ltd/qd-common/pkg/jwt"
+	commonLogger "github.com/quadev-ltd/qd-common/pkg/log"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/jobs"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/metering"
+)
+
+// PreferHeader carries the preferences of the client, RespondAsyncPreference asking for a job (RFC 7240)
+const (
+	PreferHeader            = "Prefer"
+	PreferenceAppliedHeader = "Preference-Applied"
+	RespondAsyncPreference  = "respond-async"
+)
+
+// JobIDParam is the path parameter of the job ID
+const JobIDParam = "jobID"
+
+// queueFullRetryAfter is the wait suggested to the clients when the job queue is full
+const queueFullRetryAfter = 5 * time.Second
+
+// prefersAsync reports whether the Prefer header asks for an asynchronous response
+func prefersAsync(prefer string) bool {
+	for _, preference := range strings.Split(prefer, ",") {
+		token := strings.TrimSpace(strings.Split(preference, ";")[0])
+		if strings.EqualFold(token, RespondAsyncPreference) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticatedUserID returns the user ID of the verified token
+func authenticatedUserID(ctx *gin.Context) (string, bool) {
+	claims, ok := ctx.Value(string(commonJWT.ClaimsContextKey)).(*commonJWT.TokenClaims)
+	if !ok || claims.UserID == "" {
+		return "", false
+	}
+	return claims.UserID, true
+}
+
+// runJob sends the image to the image analysis service, rendering its errors as they would be for the request
+func runJob(ctx context.Context, requestCtx *gin.Context, uploader *ImageUploader, request imageAnalysisRequest) (interface{}, *errors.Envelope) {
+	response, err := request.analyse(ctx, uploader)
+	if err == nil {
+		return response, nil
+	}
+	apiError, decodingError := errors.FromGRPCError(requestCtx, err)
+	if decodingError != nil {
+		if logger, loggerErr := commonLogger.GetLoggerFromContext(ctx); loggerErr == nil {
+			logger.Error(decodingError, "Error decoding the image analysis error")
+		}
+	}
+	return nil, errors.NewEnvelope(requestCtx, apiError)
+}
+
+// submitImageAnalysisJob queues the analysis of the image on the job manager and responds 202 with the job,
+// its status URL in the Location header. The job result is returned by GetImageAnalysisJob and, with a
+// callback URL, delivered to it. The usage of the request is metered from the outcome of the job.
+func submitImageAnalysisJob(
+	ctx *gin.Context,
+	uploader *ImageUploader,
+	jobManager *jobs.Manager,
+	request imageAnalysisRequest,
+	callbackURL string,
+) {
+	userID, isAuthenticated := authenticatedUserID(ctx)
+	if !isAuthenticated {
+		errors.Abort(ctx, errors.New(http.StatusUnauthorized, errors.Unauthorized, "The request has no verified bearer token"))
+		return
+	}
+	if callbackURL != "" {
+		if err := jobManager.ValidateCallbackURL(callbackURL); err != nil {
+			errors.Abort(ctx, invalidForm(CallbackURLField, CallbackURLField+" "+err.Error(), err))
+			return
+		}
+	}
+
+	// The copy of the request context renders the job errors once the request has ended
+	requestCtx := ctx.Copy()
+	settle := metering.DeferSettlement(ctx)
+	submitted, err := jobManager.Submit(ctx.Request.Context(), userID, callbackURL, int64(len(request.image)), func(jobCtx context.Context) (interface{}, *errors.Envelope) {
+		result, envelope := runJob(jobCtx, requestCtx, uploader, request)
+		settle(jobCtx, envelope == nil)
+		return result, envelope
+	})
+	if goErrors.Is(err, jobs.ErrQueueFull) {
+		apiError := errors.New(http.StatusServiceUnavailable, errors.Unavailable, "Too many image analysis jobs are queued, retry later")
+		apiError.RetryAfter = queueFullRetryAfter
+		errors.Abort(ctx, apiError)
+		return
+	}
+	if err != nil {
+		errors.Abort(ctx, errors.New(http.StatusServiceUnavailable, errors.Unavailable, "Could not create the image analysis job").WithCause(err))
+		return
+	}
+
+	ctx.Header(PreferenceAppliedHeader, RespondAsyncPreference)
+	ctx.Header("Location", path.Join(ctx.Request.URL.Path, "jobs", submitted.ID))
+	ctx.JSON(http.StatusAccepted, submitted.Response())
+}
+
+// GetImageAnalysisJob handles the HTTP request returning an image analysis job of the authenticated user
+func GetImageAnalysisJob(ctx *gin.Context, jobManager *jobs.Manager) {
+	userID, isAuthenticated := authenticatedUserID(ctx)
+	if !isAuthenticated {
+		errors.Abort(ctx, errors.New(http.StatusUnauthorized, errors.Unauthorized, "The request has no verified bearer token"))
+		return
+	}
+	job, err := jobManager.Get(ctx.Request.Context(), userID, ctx.Param(JobIDParam))
+	if err != nil {
+		errors.Abort(ctx, errors.New(http.StatusServiceUnavailable, errors.Unavailable, "Could not get the image analysis job").WithCause(err))
+		return
+	}
+	if job == nil {
+		errors.Abort(ctx, errors.New(http.StatusNotFound, errors.NotFound, "The image analysis job does not exist or expired"))
+		return
+	}
+	ctx.JSON(http.StatusOK, job.Response())
+}
@@ -0,0 +1,191 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/jobs"
+)
+
+func newTestJobManager(t *testing.T, webhook config.WebhookConfig) *jobs.Manager {
+	manager := jobs.NewManager(jobs.NewMemoryJobStore(time.Hour), &config.Config{Jobs: config.JobsConfig{Webhook: webhook}})
+	t.Cleanup(func() { manager.Close() })
+	return manager
+}
+
+func newAsyncRequest(t *testing.T, fields ...formField) *http.Request {
+	request := newImageAnalysisRequest(t, fields...)
+	request.Header.Set(PreferHeader, RespondAsyncPreference+", wait=0")
+	return request
+}
+
+// getJob serves the job status request of the test user, waiting for the job to be done
+func getJob(t *testing.T, manager *jobs.Manager, jobID string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	router := gin.New()
+	router.Use(withTestUser)
+	router.GET("/image-analysis/jobs/:"+JobIDParam, func(ctx *gin.Context) {
+		GetImageAnalysisJob(ctx, manager)
+	})
+	assert.Eventually(t, func() bool {
+		job, err := manager.Get(context.Background(), testUserID, jobID)
+		return err == nil && job != nil && job.Status.Done()
+	}, 5*time.Second, time.Millisecond)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/image-analysis/jobs/"+jobID, nil))
+	response := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	return recorder, response
+}
+
+func TestImageAnalysisJobs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	image := testPNG(t, 256, 256)
+
+	t.Run("Accepted_Job_Result_Returned", func(t *testing.T) {
+		server := &imageAnalysisServer{}
+		uploader := NewImageUploader(startImageAnalysisServer(t, server, true), true, 64*1024)
+		manager := newTestJobManager(t, config.WebhookConfig{})
+		request := newAsyncRequest(t,
+			formField{name: PromptField, value: []byte("Describe the image")},
+			formField{name: ImageField, value: image, contentType: "image/png"},
+		)
+
+		recorder, response := serveRequest(t, uploader, UploadLimits{}, nil, manager, request)
+
+		assert.Equal(t, http.StatusAccepted, recorder.Code)
+		assert.Equal(t, string(jobs.Queued), response["status"])
+		jobID := response["id"].(string)
+		assert.Equal(t, "/image-analysis/jobs/"+jobID, recorder.Header().Get("Location"))
+		assert.Equal(t, RespondAsyncPreference, recorder.Header().Get(PreferenceAppliedHeader))
+
+		jobRecorder, job := getJob(t, manager, jobID)
+		assert.Equal(t, http.StatusOK, jobRecorder.Code)
+		assert.Equal(t, string(jobs.Succeeded), job["status"])
+		assert.Equal(t, map[string]interface{}{"responseToPrompt": "streamed"}, job["result"])
+		assert.Equal(t, image, server.streamRequests[0].ImageData)
+		assert.Equal(t, "Describe the image", server.streamRequests[0].Prompt)
+	})
+
+	t.Run("Failed_Job_Error_Returned", func(t *testing.T) {
+		server := &imageAnalysisServer{err: status.Error(codes.InvalidArgument, "Unsupported image")}
+		uploader := NewImageUploader(startImageAnalysisServer(t, server, false), true, 64*1024)
+		manager := newTestJobManager(t, config.WebhookConfig{})
+
+		recorder, response := serveRequest(t, uploader, UploadLimits{}, nil, manager, newAsyncRequest(t,
+			formField{name: PromptField, value: []byte("Describe the image")},
+			formField{name: ImageField, value: image},
+		))
+
+		assert.Equal(t, http.StatusAccepted, recorder.Code)
+		_, job := getJob(t, manager, response["id"].(string))
+		assert.Equal(t, string(jobs.Failed), job["status"])
+		assert.Equal(t, map[string]interface{}{"error": errors.BadRequest, "message": "Unsupported image"}, job["error"])
+	})
+
+	t.Run("Without_Preference_Synchronous", func(t *testing.T) {
+		server := &imageAnalysisServer{}
+		uploader := NewImageUploader(startImageAnalysisServer(t, server, true), true, 0)
+		manager := newTestJobManager(t, config.WebhookConfig{})
+
+		recorder, response := serveRequest(t, uploader, UploadLimits{}, nil, manager, newImageAnalysisRequest(t,
+			formField{name: PromptField, value: []byte("Describe the image")},
+			formField{name: ImageField, value: image},
+		))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "streamed", response["responseToPrompt"])
+	})
+
+	t.Run("Callback_Delivered", func(t *testing.T) {
+		deliveries := make(chan []byte, 1)
+		receiver := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			body, _ := io.ReadAll(request.Body)
+			deliveries <- body
+		}))
+		defer receiver.Close()
+		uploader := NewImageUploader(startImageAnalysisServer(t, &imageAnalysisServer{}, true), true, 0)
+		manager := newTestJobManager(t, config.WebhookConfig{Secret: "secret", AllowInsecure: true})
+
+		recorder, response := serveRequest(t, uploader, UploadLimits{}, nil, manager, newAsyncRequest(t,
+			formField{name: PromptField, value: []byte("Describe the image")},
+			formField{name: CallbackURLField, value: []byte(receiver.URL)},
+			formField{name: ImageField, value: image},
+		))
+
+		assert.Equal(t, http.StatusAccepted, recorder.Code)
+		delivered := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(<-deliveries, &delivered))
+		assert.Equal(t, response["id"], delivered["id"])
+		assert.Equal(t, string(jobs.Succeeded), delivered["status"])
+	})
+
+	t.Run("Invalid_Callbacks", func(t *testing.T) {
+		testCases := []struct {
+			name    string
+			request func(t *testing.T, fields ...formField) *http.Request
+			webhook config.WebhookConfig
+			error   string
+		}{
+			{
+				name:    "Synchronous_Request",
+				request: newImageAnalysisRequest,
+				webhook: config.WebhookConfig{Secret: "secret"},
+				error:   "callbackUrl requires the Prefer: respond-async header",
+			},
+			{
+				name:    "Callbacks_Disabled",
+				request: newAsyncRequest,
+				error:   "callbackUrl is not supported, the callbacks are not enabled",
+			},
+			{
+				name:    "Private_Network",
+				request: newAsyncRequest,
+				webhook: config.WebhookConfig{Secret: "secret"},
+				error:   "callbackUrl must not point to a private network",
+			},
+		}
+		for _, testCase := range testCases {
+			t.Run(testCase.name, func(t *testing.T) {
+				server := &imageAnalysisServer{}
+				uploader := NewImageUploader(startImageAnalysisServer(t, server, true), true, 0)
+				manager := newTestJobManager(t, testCase.webhook)
+
+				recorder, response := serveRequest(t, uploader, UploadLimits{}, nil, manager, testCase.request(t,
+					formField{name: CallbackURLField, value: []byte("https://127.0.0.1/callback")},
+					formField{name: ImageField, value: image},
+				))
+
+				assert.Equal(t, http.StatusBadRequest, recorder.Code)
+				assert.Equal(t, []interface{}{map[string]interface{}{"field": CallbackURLField, "error": testCase.error}}, response["field_errors"])
+				assert.Empty(t, server.streamRequests)
+			})
+		}
+	})
+
+	t.Run("Unknown_Job_Not_Found", func(t *testing.T) {
+		manager := newTestJobManager(t, config.WebhookConfig{})
+		router := gin.New()
+		router.Use(withTestUser)
+		router.GET("/image-analysis/jobs/:"+JobIDParam, func(ctx *gin.Context) {
+			GetImageAnalysisJob(ctx, manager)
+		})
+		recorder := httptest.NewRecorder()
+
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/image-analysis/jobs/unknown", nil))
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+}
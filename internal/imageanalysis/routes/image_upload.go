@@ -0,0 +1,257 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/quadev-ltd/qd-common/pb/gen/go/pb_image_analysis"
+	commonLogger "github.com/quadev-ltd/qd-common/pkg/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/imageanalysis/pb_image_analysis_streaming"
+)
+
+// DefaultChunkSize is the size in bytes of the streamed chunks when none is configured
+const DefaultChunkSize = 64 * 1024
+
+const (
+	// streamingProbeInterval is how long the streaming RPC is not called again once the service rejected it,
+	// so that the uploads stream again after the service is upgraded
+	streamingProbeInterval = 5 * time.Minute
+	// maxRetainedSize is the size in bytes of the image streamed that is retained while the streaming support
+	// is unknown. The service rejects the RPC it does not implement on the first messages, so the larger
+	// images are not kept twice in memory to be sent again.
+	maxRetainedSize = 1024 * 1024
+)
+
+// Support of the streaming RPC by the image analysis service, unknown until a call answers
+const (
+	streamingUnknown int32 = iota
+	streamingSupported
+	streamingUnsupported
+)
+
+// ImageUploader sends the images to the image analysis service, streaming them in chunks as they are received
+// when the service supports it and in one unary request otherwise
+type ImageUploader struct {
+	client    pb_image_analysis_streaming.ImageAnalysisServiceClient
+	chunkSize int
+	enabled   bool
+	streaming int32
+	// probeAt is the Unix time in nanoseconds when the streaming RPC is called again after being rejected
+	probeAt int64
+	now     func() time.Time
+}
+
+// NewImageUploader creates an image uploader on the connection to the image analysis service, which only
+// sends unary requests if streaming is disabled
+func NewImageUploader(connection grpc.ClientConnInterface, streaming bool, chunkSize int) *ImageUploader {
+	uploader := &ImageUploader{
+		client:    pb_image_analysis_streaming.NewImageAnalysisServiceClient(connection),
+		chunkSize: chunkSize,
+		enabled:   streaming,
+		streaming: streamingUnknown,
+		now:       time.Now,
+	}
+	if uploader.chunkSize <= 0 {
+		uploader.chunkSize = DefaultChunkSize
+	}
+	if !streaming {
+		uploader.streaming = streamingUnsupported
+	}
+	return uploader
+}
+
+// setStreaming records whether the image analysis service supports the streaming RPC
+func (uploader *ImageUploader) setStreaming(ctx context.Context, supported bool) {
+	if !supported {
+		atomic.StoreInt64(&uploader.probeAt, uploader.now().Add(streamingProbeInterval).UnixNano())
+		if atomic.SwapInt32(&uploader.streaming, streamingUnsupported) != streamingUnsupported {
+			if logger, err := commonLogger.GetLoggerFromContext(ctx); err == nil {
+				logger.Warn(fmt.Sprintf(
+					"The image analysis service does not implement %s, the images are sent in one request",
+					pb_image_analysis_streaming.ImageAnalysisService_ProcessImageAndPromptStream_FullMethodName,
+				))
+			}
+		}
+		return
+	}
+	atomic.StoreInt32(&uploader.streaming, streamingSupported)
+}
+
+// streamingSupport returns the streaming support of the service for a new upload. Once the probe interval
+// has passed since the service rejected the streaming RPC, a single upload probes it again as if unknown.
+func (uploader *ImageUploader) streamingSupport() int32 {
+	streaming := atomic.LoadInt32(&uploader.streaming)
+	if streaming != streamingUnsupported || !uploader.enabled {
+		return streaming
+	}
+	probeAt := atomic.LoadInt64(&uploader.probeAt)
+	now := uploader.now()
+	if now.UnixNano() < probeAt {
+		return streaming
+	}
+	if !atomic.CompareAndSwapInt64(&uploader.probeAt, probeAt, now.Add(streamingProbeInterval).UnixNano()) {
+		// Another upload is probing
+		return streaming
+	}
+	return streamingUnknown
+}
+
+// imageUpload is the upload of the image of one request. While the streaming support is unknown the start of
+// the image streamed is also retained, to be sent again in one request if the service turns out not to stream.
+type imageUpload struct {
+	uploader     *ImageUploader
+	ctx          context.Context
+	cancel       context.CancelFunc
+	stream       pb_image_analysis_streaming.ImageAnalysisService_ProcessImageAndPromptStreamClient
+	retained     *bytes.Buffer
+	sentPrompt   string
+	sentMimeType string
+	size         int64
+}
+
+// newUpload starts the upload of an image with the prompt and MIME type known so far
+func (uploader *ImageUploader) newUpload(ctx context.Context, prompt, mimeType string) (*imageUpload, error) {
+	upload := &imageUpload{uploader: uploader}
+	upload.ctx, upload.cancel = context.WithCancel(ctx)
+
+	streaming := uploader.streamingSupport()
+	if streaming != streamingSupported {
+		upload.retained = &bytes.Buffer{}
+	}
+	if streaming == streamingUnsupported {
+		return upload, nil
+	}
+	stream, err := uploader.client.ProcessImageAndPromptStream(upload.ctx)
+	if err != nil {
+		if fallbackErr := upload.fallBack(err); fallbackErr != nil {
+			upload.cancel()
+			return nil, fallbackErr
+		}
+		return upload, nil
+	}
+	upload.stream = stream
+	upload.sentPrompt = prompt
+	upload.sentMimeType = mimeType
+	if err := upload.send(&pb_image_analysis.ImagePromptRequest{Prompt: prompt, MimeType: mimeType}); err != nil {
+		upload.cancel()
+		return nil, err
+	}
+	return upload, nil
+}
+
+// fallBack switches the upload to the unary request if the streaming error tells that the service does not
+// stream and the image was retained, returning the error otherwise
+func (upload *imageUpload) fallBack(err error) error {
+	if status.Code(err) != codes.Unimplemented {
+		return err
+	}
+	upload.uploader.setStreaming(upload.ctx, false)
+	if upload.retained == nil {
+		return err
+	}
+	upload.stream = nil
+	return nil
+}
+
+// send sends a message on the stream, if still streaming
+func (upload *imageUpload) send(message *pb_image_analysis.ImagePromptRequest) error {
+	if upload.stream == nil {
+		return nil
+	}
+	err := upload.stream.Send(message)
+	if err == io.EOF {
+		// The service ended the stream, its status is the error
+		err = upload.stream.RecvMsg(&pb_image_analysis.ImagePromptResponse{})
+		if err == nil {
+			err = fmt.Errorf("The image analysis service answered before receiving the image")
+		}
+	}
+	if err != nil {
+		return upload.fallBack(err)
+	}
+	return nil
+}
+
+// imageReadError is an error reading the image of the request, rather than uploading it
+type imageReadError struct {
+	err error
+}
+
+func (readError *imageReadError) Error() string {
+	return fmt.Sprintf("Could not read the image: %v", readError.err)
+}
+
+func (readError *imageReadError) Unwrap() error {
+	return readError.err
+}
+
+// copy uploads the image read from the reader chunk by chunk
+func (upload *imageUpload) copy(image io.Reader) error {
+	for {
+		// The messages sent must not be modified, so every chunk has its own buffer
+		chunk := make([]byte, upload.uploader.chunkSize)
+		read, err := io.ReadFull(image, chunk)
+		if read > 0 {
+			chunk = chunk[:read]
+			upload.size += int64(read)
+			if upload.retained != nil {
+				upload.retained.Write(chunk)
+				if upload.stream != nil && upload.retained.Len() > maxRetainedSize {
+					upload.retained = nil
+				}
+			}
+			if sendErr := upload.send(&pb_image_analysis.ImagePromptRequest{ImageData: chunk}); sendErr != nil {
+				return sendErr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return &imageReadError{err: err}
+		}
+	}
+}
+
+// finish ends the upload with the final prompt and MIME type and returns the analysis of the image
+func (upload *imageUpload) finish(prompt, mimeType string) (*pb_image_analysis.ImagePromptResponse, error) {
+	defer upload.cancel()
+	if upload.stream != nil && (prompt != upload.sentPrompt || mimeType != upload.sentMimeType) {
+		if err := upload.send(&pb_image_analysis.ImagePromptRequest{Prompt: prompt, MimeType: mimeType}); err != nil {
+			return nil, err
+		}
+	}
+	if upload.stream != nil {
+		response, err := upload.stream.CloseAndRecv()
+		if err == nil {
+			upload.uploader.setStreaming(upload.ctx, true)
+			return response, nil
+		}
+		if fallbackErr := upload.fallBack(err); fallbackErr != nil {
+			return nil, fallbackErr
+		}
+	}
+	return upload.uploader.client.ProcessImageAndPrompt(upload.ctx, &pb_image_analysis.ImagePromptRequest{
+		ImageData: upload.retained.Bytes(),
+		Prompt:    prompt,
+		MimeType:  mimeType,
+	})
+}
+
+// close releases the upload if it is abandoned before finishing
+func (upload *imageUpload) close() {
+	upload.cancel()
+}
+
+// streamed reports whether the image was streamed
+func (upload *imageUpload) streamed() bool {
+	return upload.stream != nil
+}
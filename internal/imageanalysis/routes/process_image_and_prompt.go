@@ -1,8 +1,13 @@
 package routes
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	goErrors "errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -10,59 +15,347 @@ import (
 	commonLogger "github.com/quadev-ltd/qd-common/pkg/log"
 
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/images"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/jobs"
 )
 
+// Fields of the multipart form of the image analysis requests
+const (
+	ImageField       = "image"
+	PromptField      = "prompt"
+	MimeTypeField    = "mimeType"
+	CallbackURLField = "callbackUrl"
+)
+
+// Reasons of the rejected images
+const (
+	UnsupportedImageTypeReason    = "unsupported_image_type"
+	ImageTypeMismatchReason       = "image_type_mismatch"
+	ImageDimensionsExceededReason = "image_dimensions_exceeded"
+)
+
+// maxTextFieldSize is the largest text field of the multipart form read, in bytes
+const maxTextFieldSize = 64 * 1024
+
 // ProcessImagePromptRequestBody represents the expected request body for image processing
 type ProcessImagePromptRequestBody struct {
 	Image  []byte `json:"image" binding:"required"`  // Base64 encoded image data
 	Prompt string `json:"prompt" binding:"required"` // Text prompt for image analysis
 }
 
-// ProcessImageAndPrompt handles the image processing request by forwarding it to the image analysis service
-func ProcessImageAndPrompt(ctx *gin.Context, client pb_image_analysis.ImageAnalysisServiceClient) {
-	logger, err := commonLogger.GetLoggerFromContext(ctx.Request.Context())
+// UploadLimits are the limits of the image analysis requests, 0 is unlimited
+type UploadLimits struct {
+	// MaxUploadSize is the largest request body, in bytes
+	MaxUploadSize int64
+	// MaxWidth and MaxHeight are the largest image dimensions, in pixels
+	MaxWidth  int
+	MaxHeight int
+}
+
+// imageAnalysisRequest is an image analysis request read whole, to send once the request form is read
+type imageAnalysisRequest struct {
+	prompt   string
+	mimeType string
+	image    []byte
+}
+
+// analyse sends the image to the image analysis service with the uploader and returns its analysis
+func (request imageAnalysisRequest) analyse(ctx context.Context, uploader *ImageUploader) (*pb_image_analysis.ImagePromptResponse, error) {
+	upload, err := uploader.newUpload(ctx, request.prompt, request.mimeType)
 	if err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, err)
-		return
+		return nil, err
+	}
+	defer upload.close()
+	if err := upload.copy(bytes.NewReader(request.image)); err != nil {
+		return nil, err
+	}
+	return upload.finish(request.prompt, request.mimeType)
+}
+
+// invalidForm returns the API error of a multipart form without a valid field
+func invalidForm(field, message string, err error) *errors.APIError {
+	return errors.New(http.StatusBadRequest, errors.ValidationFailed, "The request body is invalid").
+		WithFieldErrors([]errors.FieldError{{Field: field, Error: message}}).
+		WithCause(err)
+}
+
+// payloadTooLarge returns the API error of a request body over the size limit
+func payloadTooLarge(limit int64, err error) *errors.APIError {
+	return errors.New(
+		http.StatusRequestEntityTooLarge,
+		errors.PayloadTooLarge,
+		fmt.Sprintf("The request body must be at most %d bytes", limit),
+	).WithDetail("max_upload_size", limit).WithCause(err)
+}
+
+// bodyReadError returns the API error of an error reading the request body, which is too large if the
+// error comes from the size limit
+func bodyReadError(err error, message string) *errors.APIError {
+	var maxBytesError *http.MaxBytesError
+	if goErrors.As(err, &maxBytesError) {
+		return payloadTooLarge(maxBytesError.Limit, err)
 	}
+	return errors.New(http.StatusBadRequest, errors.BadRequest, message).WithCause(err)
+}
 
-	// Get the image file from the multipart form
-	file, header, err := ctx.Request.FormFile("image")
+// readTextField reads a text field of the multipart form
+func readTextField(part *multipart.Part) (string, *errors.APIError) {
+	value, err := io.ReadAll(io.LimitReader(part, maxTextFieldSize+1))
 	if err != nil {
-		logger.Error(err, "Error getting image file from multipart form")
-		ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("File error"))
+		return "", bodyReadError(err, "The multipart form could not be read")
+	}
+	if len(value) > maxTextFieldSize {
+		return "", invalidForm(
+			part.FormName(),
+			fmt.Sprintf("%s must be at most %d bytes long", part.FormName(), maxTextFieldSize),
+			nil,
+		)
+	}
+	return string(value), nil
+}
+
+// checkDeclaredType checks that the MIME type declared by the client, if any, names the format of the image
+func checkDeclaredType(format images.Format, declaredMimeType string) *errors.APIError {
+	if images.MatchesMimeType(format, declaredMimeType) {
+		return nil
+	}
+	return errors.New(
+		http.StatusUnsupportedMediaType,
+		errors.UnsupportedMediaType,
+		fmt.Sprintf("The image is %s, not the declared %s", format.MimeType(), declaredMimeType),
+	).WithReason(ImageTypeMismatchReason).
+		WithDetail("declared_type", declaredMimeType).
+		WithDetail("detected_type", format.MimeType())
+}
+
+// inspectImage sniffs the format of the image and reads its dimensions from its header, checking them
+// against the MIME type declared by the client and the limits
+func inspectImage(image *bufio.Reader, declaredMimeType string, limits UploadLimits) (images.Format, *errors.APIError) {
+	header, err := image.Peek(images.HeaderSize)
+	if err != nil && err != io.EOF {
+		return "", bodyReadError(err, "The image could not be read")
+	}
+	format, ok := images.Sniff(header)
+	if !ok {
+		return "", errors.New(
+			http.StatusUnsupportedMediaType,
+			errors.UnsupportedMediaType,
+			"The image must be a JPEG, PNG, WebP or HEIC image",
+		).WithReason(UnsupportedImageTypeReason)
+	}
+	if apiError := checkDeclaredType(format, declaredMimeType); apiError != nil {
+		return "", apiError
+	}
+	width, height, err := images.Dimensions(format, header)
+	if err != nil {
+		return "", invalidForm(ImageField, "image dimensions could not be read", err)
+	}
+	if (limits.MaxWidth > 0 && width > limits.MaxWidth) || (limits.MaxHeight > 0 && height > limits.MaxHeight) {
+		return "", invalidForm(
+			ImageField,
+			fmt.Sprintf("image must be at most %dx%d pixels", limits.MaxWidth, limits.MaxHeight),
+			nil,
+		).WithReason(ImageDimensionsExceededReason).
+			WithDetail("width", width).
+			WithDetail("height", height)
+	}
+	return format, nil
+}
+
+// preprocessImage reads the whole image and preprocesses it, the upload size limit bounding its size
+func preprocessImage(
+	ctx context.Context,
+	image io.Reader,
+	format images.Format,
+	options images.PreprocessOptions,
+) ([]byte, *errors.APIError) {
+	data, err := io.ReadAll(image)
+	if err != nil {
+		return nil, bodyReadError(err, "The image could not be read")
+	}
+	processed, err := images.Preprocess(ctx, format, data, options)
+	var budgetError *images.PixelBudgetError
+	switch {
+	case goErrors.As(err, &budgetError):
+		return nil, invalidForm(
+			ImageField,
+			fmt.Sprintf("image must be at most %d pixels", budgetError.MaxPixels),
+			err,
+		).WithReason(ImageDimensionsExceededReason).
+			WithDetail("width", budgetError.Width).
+			WithDetail("height", budgetError.Height)
+	case goErrors.Is(err, context.Canceled), goErrors.Is(err, context.DeadlineExceeded):
+		return nil, errors.New(http.StatusServiceUnavailable, errors.Unavailable, "The image could not be preprocessed in time").WithCause(err)
+	case err != nil:
+		return nil, invalidForm(ImageField, "image could not be decoded", err)
+	}
+	return processed, nil
+}
+
+// ProcessImageAndPrompt handles the image processing request by forwarding it to the image analysis service.
+// The multipart form is read as it arrives and the image is streamed to the service by the uploader, so only
+// the chunk in flight is held in memory when the service supports streaming. The image type is sniffed from
+// its magic bytes and its dimensions read from its header before it is sent. With preprocessing options the
+// image is read whole and preprocessed before it is sent. With a job manager the clients preferring an
+// asynchronous response with the Prefer header get a job, returned by GetImageAnalysisJob. With an event
+// streamer the clients accepting text/event-stream get the analysis as server-sent events.
+func ProcessImageAndPrompt(
+	ctx *gin.Context,
+	uploader *ImageUploader,
+	limits UploadLimits,
+	preprocessing *images.PreprocessOptions,
+	jobManager *jobs.Manager,
+	streamer *EventStreamer,
+) {
+	logger, err := commonLogger.GetLoggerFromContext(ctx.Request.Context())
+	if err != nil {
+		errors.Abort(ctx, errors.New(http.StatusInternalServerError, errors.Internal, "Could not get the logger of the request").WithCause(err))
 		return
 	}
-	defer file.Close()
-	imageData, err := io.ReadAll(file)
+
+	if limits.MaxUploadSize > 0 {
+		if ctx.Request.ContentLength > limits.MaxUploadSize {
+			errors.Abort(ctx, payloadTooLarge(limits.MaxUploadSize, nil))
+			return
+		}
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, limits.MaxUploadSize)
+	}
+	form, err := ctx.Request.MultipartReader()
 	if err != nil {
-		logger.Error(err, "Error reading image file")
-		ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("File error"))
+		logger.Error(err, "Error reading multipart form")
+		errors.Abort(ctx, invalidForm(ImageField, "image is required", err))
 		return
 	}
-	prompt := ctx.PostForm("prompt")
 
-	mimeType := header.Header.Get("Content-Type")
-	if mimeType == "" {
-		mimeType = ctx.PostForm("mimeType")
+	async := jobManager != nil && prefersAsync(ctx.GetHeader(PreferHeader))
+	events := !async && streamer != nil && acceptsEventStream(ctx.GetHeader("Accept"))
+	var upload *imageUpload
+	defer func() {
+		if upload != nil {
+			upload.close()
+		}
+	}()
+	var prompt, formMimeType, imageMimeType, callbackURL string
+	var format images.Format
+	// The image of the asynchronous requests is read whole, the job runs after the request ends, and so is the
+	// image of the event streams, sent to the server streaming RPC in one message
+	var imageData []byte
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Error(err, "Error reading multipart form")
+			errors.Abort(ctx, bodyReadError(err, "The multipart form could not be read"))
+			return
+		}
+
+		var apiError *errors.APIError
+		switch part.FormName() {
+		case PromptField:
+			prompt, apiError = readTextField(part)
+		case MimeTypeField:
+			formMimeType, apiError = readTextField(part)
+		case CallbackURLField:
+			callbackURL, apiError = readTextField(part)
+		case ImageField:
+			if upload != nil || imageData != nil {
+				apiError = invalidForm(ImageField, "only one image can be sent", nil)
+				break
+			}
+			imageMimeType = part.Header.Get("Content-Type")
+			declaredMimeType := imageMimeType
+			if declaredMimeType == "" {
+				declaredMimeType = formMimeType
+			}
+			image := bufio.NewReaderSize(part, images.HeaderSize)
+			format, apiError = inspectImage(image, declaredMimeType, limits)
+			if apiError != nil {
+				break
+			}
+			var imageReader io.Reader = image
+			if preprocessing != nil {
+				var processed []byte
+				processed, apiError = preprocessImage(ctx.Request.Context(), image, format, *preprocessing)
+				if apiError != nil {
+					break
+				}
+				imageReader = bytes.NewReader(processed)
+			}
+			if async || events {
+				imageData, err = io.ReadAll(imageReader)
+				if err != nil {
+					logger.Error(err, "Error reading image file")
+					apiError = bodyReadError(err, "The image could not be read")
+				}
+				break
+			}
+			upload, err = uploader.newUpload(ctx.Request.Context(), prompt, format.MimeType())
+			if err == nil {
+				err = upload.copy(imageReader)
+			}
+			var readError *imageReadError
+			if goErrors.As(err, &readError) {
+				logger.Error(err, "Error reading image file")
+				apiError = bodyReadError(readError.err, "The image could not be read")
+			} else if err != nil {
+				errors.HandleError(ctx, err)
+				return
+			}
+		}
+		part.Close()
+		if apiError != nil {
+			errors.Abort(ctx, apiError)
+			return
+		}
+	}
+	if upload == nil && imageData == nil {
+		errors.Abort(ctx, invalidForm(ImageField, "image is required", nil))
+		return
+	}
+	if imageMimeType == "" {
+		// The MIME type field can follow the image
+		if apiError := checkDeclaredType(format, formMimeType); apiError != nil {
+			errors.Abort(ctx, apiError)
+			return
+		}
+	}
+	if callbackURL != "" && !async {
+		errors.Abort(ctx, invalidForm(
+			CallbackURLField,
+			fmt.Sprintf("callbackUrl requires the %s: %s header", PreferHeader, RespondAsyncPreference),
+			nil,
+		))
+		return
+	}
+	if async {
+		submitImageAnalysisJob(ctx, uploader, jobManager, imageAnalysisRequest{
+			prompt:   prompt,
+			mimeType: format.MimeType(),
+			image:    imageData,
+		}, callbackURL)
+		return
+	}
+	if events {
+		streamImageAnalysis(ctx, streamer, uploader, imageAnalysisRequest{
+			prompt:   prompt,
+			mimeType: format.MimeType(),
+			image:    imageData,
+		})
+		return
 	}
 
+	res, err := upload.finish(prompt, format.MimeType())
 	logger.Info(
 		fmt.Sprintf(
-			"Processing image size %d bytes, mimeType %s and prompt length %d characters",
-			len(imageData),
-			mimeType,
+			"Processed image size %d bytes, mimeType %s and prompt length %d characters, streamed %t",
+			upload.size,
+			format.MimeType(),
 			len(prompt),
+			upload.streamed(),
 		),
 	)
-	res, err := client.ProcessImageAndPrompt(
-		ctx.Request.Context(),
-		&pb_image_analysis.ImagePromptRequest{
-			ImageData: imageData,
-			Prompt:    prompt,
-			MimeType:  mimeType,
-		},
-	)
 
 	if err != nil {
 		errors.HandleError(ctx, err)
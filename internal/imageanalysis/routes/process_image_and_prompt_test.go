@@ -0,0 +1,466 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"math/rand"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/quadev-ltd/qd-common/pb/gen/go/pb_image_analysis"
+	commonJWT "github.com/quadev-ltd/qd-common/pkg/jwt"
+	commonLogger "github.com/quadev-ltd/qd-common/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/imageanalysis/pb_image_analysis_streaming"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/images"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/jobs"
+)
+
+// imageAnalysisServer records the images received through the unary and the streaming RPCs. The server
+// streaming RPC sends the parts after the delay, or blocks until the call is cancelled.
+type imageAnalysisServer struct {
+	pb_image_analysis_streaming.UnimplementedImageAnalysisServiceServer
+	mutex          sync.Mutex
+	unaryRequests  []*pb_image_analysis.ImagePromptRequest
+	streamRequests []*pb_image_analysis.ImagePromptRequest
+	streamMessages int
+	eventRequests  []*pb_image_analysis.ImagePromptRequest
+	parts          []string
+	delay          time.Duration
+	block          bool
+	cancelled      chan struct{}
+	err            error
+}
+
+func (server *imageAnalysisServer) ProcessImageAndPrompt(ctx context.Context, request *pb_image_analysis.ImagePromptRequest) (*pb_image_analysis.ImagePromptResponse, error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.unaryRequests = append(server.unaryRequests, request)
+	return &pb_image_analysis.ImagePromptResponse{ResponseToPrompt: "unary"}, server.err
+}
+
+func (server *imageAnalysisServer) ProcessImageAndPromptStream(stream pb_image_analysis_streaming.ImageAnalysisService_ProcessImageAndPromptStreamServer) error {
+	request := &pb_image_analysis.ImagePromptRequest{}
+	for {
+		message, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		server.mutex.Lock()
+		server.streamMessages++
+		server.mutex.Unlock()
+		request.ImageData = append(request.ImageData, message.ImageData...)
+		if message.Prompt != "" {
+			request.Prompt = message.Prompt
+		}
+		if message.MimeType != "" {
+			request.MimeType = message.MimeType
+		}
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.streamRequests = append(server.streamRequests, request)
+	if server.err != nil {
+		return server.err
+	}
+	return stream.SendAndClose(&pb_image_analysis.ImagePromptResponse{ResponseToPrompt: "streamed"})
+}
+
+func (server *imageAnalysisServer) ProcessImageAndPromptEvents(
+	request *pb_image_analysis.ImagePromptRequest,
+	stream pb_image_analysis_streaming.ImageAnalysisService_ProcessImageAndPromptEventsServer,
+) error {
+	server.mutex.Lock()
+	server.eventRequests = append(server.eventRequests, request)
+	server.mutex.Unlock()
+	if server.block {
+		<-stream.Context().Done()
+		close(server.cancelled)
+		return stream.Context().Err()
+	}
+	time.Sleep(server.delay)
+	for _, part := range server.parts {
+		if err := stream.Send(&pb_image_analysis.ImagePromptResponse{ResponseToPrompt: part}); err != nil {
+			return err
+		}
+	}
+	return server.err
+}
+
+// startImageAnalysisServer serves the image analysis service, with the streaming RPCs if streams is set
+func startImageAnalysisServer(t *testing.T, server *imageAnalysisServer, streams bool) *grpc.ClientConn {
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	if streams {
+		pb_image_analysis_streaming.RegisterImageAnalysisServiceServer(grpcServer, server)
+	} else {
+		pb_image_analysis.RegisterImageAnalysisServiceServer(grpcServer, server)
+	}
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	connection, err := grpc.DialContext(
+		context.Background(),
+		"bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { connection.Close() })
+	return connection
+}
+
+// testUserID is the ID of the authenticated user of the requests served in the tests
+const testUserID = "user-id"
+
+type formField struct {
+	name        string
+	value       []byte
+	contentType string
+}
+
+// testPNG encodes a PNG image of noise, which does not compress
+func testPNG(t *testing.T, width, height int) []byte {
+	random := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	random.Read(img.Pix)
+	encoded := &bytes.Buffer{}
+	assert.NoError(t, png.Encode(encoded, img))
+	return encoded.Bytes()
+}
+
+func newImageAnalysisRequest(t *testing.T, fields ...formField) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, field := range fields {
+		header := textproto.MIMEHeader{}
+		if field.name == ImageField {
+			header.Set("Content-Disposition", `form-data; name="image"; filename="image.png"`)
+		} else {
+			header.Set("Content-Disposition", `form-data; name="`+field.name+`"`)
+		}
+		if field.contentType != "" {
+			header.Set("Content-Type", field.contentType)
+		}
+		part, err := writer.CreatePart(header)
+		assert.NoError(t, err)
+		_, err = part.Write(field.value)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, writer.Close())
+
+	request := httptest.NewRequest(http.MethodPost, "/image-analysis", body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	return request
+}
+
+// withTestUser adds the logger and the claims of an authenticated test user to the requests
+func withTestUser(ctx *gin.Context) {
+	logger := commonLogger.NewLogFactory("test").NewLogger()
+	ctx.Request = ctx.Request.WithContext(context.WithValue(ctx.Request.Context(), commonLogger.LoggerKey, logger))
+	ctx.Set(string(commonJWT.ClaimsContextKey), &commonJWT.TokenClaims{UserID: testUserID})
+}
+
+func serveRequest(
+	t *testing.T,
+	uploader *ImageUploader,
+	limits UploadLimits,
+	preprocessing *images.PreprocessOptions,
+	jobManager *jobs.Manager,
+	request *http.Request,
+) (*httptest.ResponseRecorder, map[string]interface{}) {
+	router := gin.New()
+	router.Use(withTestUser)
+	router.POST("/image-analysis", func(ctx *gin.Context) {
+		ProcessImageAndPrompt(ctx, uploader, limits, preprocessing, jobManager, nil)
+	})
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	response := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	return recorder, response
+}
+
+func serveImageAnalysis(t *testing.T, uploader *ImageUploader, fields ...formField) (*httptest.ResponseRecorder, map[string]interface{}) {
+	return serveRequest(t, uploader, UploadLimits{}, nil, nil, newImageAnalysisRequest(t, fields...))
+}
+
+func TestProcessImageAndPrompt(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	image := testPNG(t, 256, 256)
+	chunks := (len(image) + 64*1024 - 1) / (64 * 1024)
+
+	t.Run("Streams_Image_In_Chunks", func(t *testing.T) {
+		server := &imageAnalysisServer{}
+		uploader := NewImageUploader(startImageAnalysisServer(t, server, true), true, 64*1024)
+
+		recorder, response := serveImageAnalysis(t, uploader,
+			formField{name: PromptField, value: []byte("Describe the image")},
+			formField{name: ImageField, value: image, contentType: "image/png"},
+		)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "streamed", response["responseToPrompt"])
+		assert.Empty(t, server.unaryRequests)
+		assert.Len(t, server.streamRequests, 1)
+		assert.Equal(t, image, server.streamRequests[0].ImageData)
+		assert.Equal(t, "Describe the image", server.streamRequests[0].Prompt)
+		assert.Equal(t, "image/png", server.streamRequests[0].MimeType)
+		// The prompt and MIME type first, then the image in 64 KiB chunks
+		assert.Equal(t, 1+chunks, server.streamMessages)
+		assert.Equal(t, streamingSupported, uploader.streaming)
+	})
+
+	t.Run("Streams_Fields_Sent_After_Image", func(t *testing.T) {
+		server := &imageAnalysisServer{}
+		uploader := NewImageUploader(startImageAnalysisServer(t, server, true), true, 64*1024)
+
+		recorder, _ := serveImageAnalysis(t, uploader,
+			formField{name: ImageField, value: image},
+			formField{name: PromptField, value: []byte("Describe the image")},
+			formField{name: MimeTypeField, value: []byte("image/png")},
+		)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "Describe the image", server.streamRequests[0].Prompt)
+		assert.Equal(t, "image/png", server.streamRequests[0].MimeType)
+	})
+
+	t.Run("Falls_Back_To_Unary_Without_Streaming_RPC", func(t *testing.T) {
+		server := &imageAnalysisServer{}
+		uploader := NewImageUploader(startImageAnalysisServer(t, server, false), true, 64*1024)
+
+		firstRecorder, response := serveImageAnalysis(t, uploader,
+			formField{name: PromptField, value: []byte("Describe the image")},
+			formField{name: ImageField, value: image, contentType: "image/png"},
+		)
+		secondRecorder, _ := serveImageAnalysis(t, uploader,
+			formField{name: PromptField, value: []byte("Describe the image")},
+			formField{name: ImageField, value: image, contentType: "image/png"},
+		)
+
+		assert.Equal(t, http.StatusOK, firstRecorder.Code)
+		assert.Equal(t, http.StatusOK, secondRecorder.Code)
+		assert.Equal(t, "unary", response["responseToPrompt"])
+		assert.Len(t, server.unaryRequests, 2)
+		assert.Equal(t, image, server.unaryRequests[0].ImageData)
+		assert.Equal(t, "Describe the image", server.unaryRequests[0].Prompt)
+		assert.Equal(t, "image/png", server.unaryRequests[0].MimeType)
+		assert.Equal(t, streamingUnsupported, uploader.streaming)
+	})
+
+	t.Run("Probes_Streaming_Again_After_Interval", func(t *testing.T) {
+		now := time.Now()
+		uploader := NewImageUploader(startImageAnalysisServer(t, &imageAnalysisServer{}, false), true, 0)
+		uploader.now = func() time.Time { return now }
+		uploader.setStreaming(context.Background(), false)
+
+		beforeInterval := uploader.streamingSupport()
+		now = now.Add(streamingProbeInterval)
+		probe := uploader.streamingSupport()
+		duringProbe := uploader.streamingSupport()
+
+		assert.Equal(t, streamingUnsupported, beforeInterval)
+		assert.Equal(t, streamingUnknown, probe)
+		assert.Equal(t, streamingUnsupported, duringProbe)
+	})
+
+	t.Run("Streaming_Disabled_Sends_Unary", func(t *testing.T) {
+		server := &imageAnalysisServer{}
+		uploader := NewImageUploader(startImageAnalysisServer(t, server, true), false, 0)
+
+		recorder, _ := serveImageAnalysis(t, uploader,
+			formField{name: PromptField, value: []byte("Describe the image")},
+			formField{name: ImageField, value: image},
+		)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Len(t, server.unaryRequests, 1)
+		assert.Empty(t, server.streamRequests)
+	})
+
+	t.Run("Service_Error_Rendered", func(t *testing.T) {
+		server := &imageAnalysisServer{err: status.Error(codes.InvalidArgument, "Unsupported image")}
+		uploader := NewImageUploader(startImageAnalysisServer(t, server, true), true, 64*1024)
+
+		recorder, response := serveImageAnalysis(t, uploader,
+			formField{name: PromptField, value: []byte("Describe the image")},
+			formField{name: ImageField, value: image},
+		)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Equal(t, "Unsupported image", response["message"])
+		assert.Empty(t, server.unaryRequests)
+	})
+
+	t.Run("Missing_Image_Validation_Failed", func(t *testing.T) {
+		uploader := NewImageUploader(startImageAnalysisServer(t, &imageAnalysisServer{}, true), true, 0)
+
+		recorder, response := serveImageAnalysis(t, uploader, formField{name: PromptField, value: []byte("Describe the image")})
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Equal(t, errors.ValidationFailed, response["error"])
+		assert.Equal(t, []interface{}{map[string]interface{}{"field": ImageField, "error": "image is required"}}, response["field_errors"])
+	})
+
+	t.Run("Sends_Sniffed_MIME_Type", func(t *testing.T) {
+		server := &imageAnalysisServer{}
+		uploader := NewImageUploader(startImageAnalysisServer(t, server, true), true, 0)
+
+		recorder, _ := serveImageAnalysis(t, uploader,
+			formField{name: PromptField, value: []byte("Describe the image")},
+			formField{name: ImageField, value: image, contentType: "application/octet-stream"},
+		)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "image/png", server.streamRequests[0].MimeType)
+	})
+
+	t.Run("Rejected_Images", func(t *testing.T) {
+		testCases := []struct {
+			name   string
+			fields []formField
+			limits UploadLimits
+			status int
+			code   string
+			reason string
+		}{
+			{
+				name:   "Unsupported_Type",
+				fields: []formField{{name: ImageField, value: []byte("GIF89a\x01\x00\x01\x00")}},
+				status: http.StatusUnsupportedMediaType,
+				code:   errors.UnsupportedMediaType,
+				reason: UnsupportedImageTypeReason,
+			},
+			{
+				name:   "Declared_Type_Mismatch",
+				fields: []formField{{name: ImageField, value: image, contentType: "image/jpeg"}},
+				status: http.StatusUnsupportedMediaType,
+				code:   errors.UnsupportedMediaType,
+				reason: ImageTypeMismatchReason,
+			},
+			{
+				name: "MIME_Type_Field_After_Image_Mismatch",
+				fields: []formField{
+					{name: ImageField, value: image},
+					{name: MimeTypeField, value: []byte("image/webp")},
+				},
+				status: http.StatusUnsupportedMediaType,
+				code:   errors.UnsupportedMediaType,
+				reason: ImageTypeMismatchReason,
+			},
+			{
+				name:   "Dimensions_Exceeded",
+				fields: []formField{{name: ImageField, value: image}},
+				limits: UploadLimits{MaxWidth: 128, MaxHeight: 128},
+				status: http.StatusBadRequest,
+				code:   errors.ValidationFailed,
+				reason: ImageDimensionsExceededReason,
+			},
+			{
+				name:   "Body_Too_Large",
+				fields: []formField{{name: ImageField, value: image}},
+				limits: UploadLimits{MaxUploadSize: 1024},
+				status: http.StatusRequestEntityTooLarge,
+				code:   errors.PayloadTooLarge,
+			},
+		}
+		for _, testCase := range testCases {
+			t.Run(testCase.name, func(t *testing.T) {
+				server := &imageAnalysisServer{}
+				uploader := NewImageUploader(startImageAnalysisServer(t, server, true), true, 0)
+
+				recorder, response := serveRequest(t, uploader, testCase.limits, nil, nil, newImageAnalysisRequest(t, testCase.fields...))
+
+				assert.Equal(t, testCase.status, recorder.Code)
+				assert.Equal(t, testCase.code, response["error"])
+				if testCase.reason != "" {
+					assert.Equal(t, testCase.reason, response["reason"])
+				}
+				assert.Empty(t, server.unaryRequests)
+			})
+		}
+	})
+
+	t.Run("Body_Without_Length_Too_Large", func(t *testing.T) {
+		server := &imageAnalysisServer{}
+		uploader := NewImageUploader(startImageAnalysisServer(t, server, true), true, 0)
+		request := newImageAnalysisRequest(t, formField{name: ImageField, value: image})
+		request.ContentLength = -1
+
+		recorder, response := serveRequest(t, uploader, UploadLimits{MaxUploadSize: int64(len(image) / 2)}, nil, nil, request)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+		assert.Equal(t, errors.PayloadTooLarge, response["error"])
+		assert.Empty(t, server.unaryRequests)
+	})
+	t.Run("Preprocesses_Image", func(t *testing.T) {
+		server := &imageAnalysisServer{}
+		uploader := NewImageUploader(startImageAnalysisServer(t, server, true), true, 64*1024)
+		request := newImageAnalysisRequest(t,
+			formField{name: PromptField, value: []byte("Describe the image")},
+			formField{name: ImageField, value: image, contentType: "image/png"},
+		)
+
+		recorder, _ := serveRequest(t, uploader, UploadLimits{}, &images.PreprocessOptions{MaxEdge: 64}, nil, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Len(t, server.streamRequests, 1)
+		assert.Equal(t, "image/png", server.streamRequests[0].MimeType)
+		width, height, err := images.Dimensions(images.PNG, server.streamRequests[0].ImageData)
+		assert.NoError(t, err)
+		assert.Equal(t, 64, width)
+		assert.Equal(t, 64, height)
+	})
+
+	t.Run("Preprocessing_Over_Pixel_Budget_Rejected", func(t *testing.T) {
+		server := &imageAnalysisServer{}
+		uploader := NewImageUploader(startImageAnalysisServer(t, server, true), true, 0)
+		request := newImageAnalysisRequest(t,
+			formField{name: PromptField, value: []byte("Describe the image")},
+			formField{name: ImageField, value: image, contentType: "image/png"},
+		)
+
+		recorder, response := serveRequest(t, uploader, UploadLimits{}, &images.PreprocessOptions{MaxEdge: 64, MaxPixels: 1024}, nil, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Equal(t, ImageDimensionsExceededReason, response["reason"])
+		assert.Empty(t, server.streamRequests)
+	})
+
+	t.Run("Undecodable_Image_Validation_Failed", func(t *testing.T) {
+		server := &imageAnalysisServer{}
+		uploader := NewImageUploader(startImageAnalysisServer(t, server, true), true, 0)
+		// The header is intact but the image data is cut
+		request := newImageAnalysisRequest(t, formField{name: ImageField, value: image[:len(image)/2]})
+
+		recorder, response := serveRequest(t, uploader, UploadLimits{}, &images.PreprocessOptions{MaxEdge: 64}, nil, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Equal(t, errors.ValidationFailed, response["error"])
+		assert.Equal(t, []interface{}{map[string]interface{}{"field": ImageField, "error": "image could not be decoded"}}, response["field_errors"])
+		assert.Empty(t, server.streamRequests)
+	})
+}
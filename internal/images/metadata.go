@@ -0,0 +1,469 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// JPEG markers read or kept by the metadata stripping
+const (
+	jpegStartOfImage = 0xD8
+	jpegEndOfImage   = 0xD9
+	jpegStartOfScan  = 0xDA
+	jpegExifSegment  = 0xE1
+	jpegComment      = 0xFE
+)
+
+// exifOrientationTag is the tag of the EXIF orientation, 1 to 8 as defined by the TIFF specification
+const exifOrientationTag = 0x0112
+
+// isJPEGMetadataMarker reports whether a JPEG segment only holds metadata. The JFIF (APP0), ICC profile (APP2)
+// and Adobe (APP14) segments are kept since they change how the image is decoded.
+func isJPEGMetadataMarker(marker byte) bool {
+	switch {
+	case marker == jpegComment, marker == jpegExifSegment:
+		return true
+	case marker >= 0xE3 && marker <= 0xED, marker == 0xEF:
+		return true
+	}
+	return false
+}
+
+// jpegSegment is a segment of a JPEG image before its scans, its marker and its payload without the length
+type jpegSegment struct {
+	marker  byte
+	start   int
+	end     int
+	payload []byte
+}
+
+// readJPEGSegments calls visit with the segments of a JPEG image up to its first scan, returning the offset of the scan
+func readJPEGSegments(data []byte, visit func(segment jpegSegment)) (int, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != jpegStartOfImage {
+		return 0, fmt.Errorf("Could not read the jpeg segments: no start of image")
+	}
+	offset := 2
+	for offset+1 < len(data) {
+		if data[offset] != 0xFF {
+			return 0, fmt.Errorf("Could not read the jpeg segments: invalid marker at %d", offset)
+		}
+		marker := data[offset+1]
+		switch {
+		case marker == 0xFF:
+			// Fill byte before a marker
+			offset++
+			continue
+		case marker == jpegStartOfScan, marker == jpegEndOfImage:
+			return offset, nil
+		case marker == 0x01, marker >= 0xD0 && marker <= 0xD7:
+			// Markers without a payload
+			visit(jpegSegment{marker: marker, start: offset, end: offset + 2})
+			offset += 2
+			continue
+		}
+		if offset+4 > len(data) {
+			return 0, fmt.Errorf("Could not read the jpeg segments: truncated segment at %d", offset)
+		}
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		end := offset + 2 + length
+		if length < 2 || end > len(data) {
+			return 0, fmt.Errorf("Could not read the jpeg segments: invalid segment length at %d", offset)
+		}
+		visit(jpegSegment{marker: marker, start: offset, end: end, payload: data[offset+4 : end]})
+		offset = end
+	}
+	return 0, fmt.Errorf("Could not read the jpeg segments: no scan")
+}
+
+// stripJPEGMetadata removes the metadata segments of a JPEG image without decoding it
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	stripped := make([]byte, 0, len(data))
+	stripped = append(stripped, data[:2]...)
+	scanOffset, err := readJPEGSegments(data, func(segment jpegSegment) {
+		if !isJPEGMetadataMarker(segment.marker) {
+			stripped = append(stripped, data[segment.start:segment.end]...)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(stripped, data[scanOffset:]...), nil
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG image, 1 when it has none
+func jpegOrientation(data []byte) int {
+	orientation := 1
+	readJPEGSegments(data, func(segment jpegSegment) {
+		if segment.marker == jpegExifSegment && bytes.HasPrefix(segment.payload, []byte("Exif\x00\x00")) {
+			if exifOrientation, ok := tiffOrientation(segment.payload[6:]); ok {
+				orientation = exifOrientation
+			}
+		}
+	})
+	return orientation
+}
+
+// tiffOrientation reads the orientation tag of the first image file directory of a TIFF structure
+func tiffOrientation(tiff []byte) (int, bool) {
+	if len(tiff) < 8 {
+		return 0, false
+	}
+	var byteOrder binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		byteOrder = binary.LittleEndian
+	case "MM":
+		byteOrder = binary.BigEndian
+	default:
+		return 0, false
+	}
+	directory := int(byteOrder.Uint32(tiff[4:]))
+	if directory+2 > len(tiff) {
+		return 0, false
+	}
+	entries := int(byteOrder.Uint16(tiff[directory:]))
+	for entry := 0; entry < entries; entry++ {
+		offset := directory + 2 + entry*12
+		if offset+12 > len(tiff) {
+			return 0, false
+		}
+		if byteOrder.Uint16(tiff[offset:]) == exifOrientationTag {
+			orientation := int(byteOrder.Uint16(tiff[offset+8:]))
+			return orientation, orientation >= 1 && orientation <= 8
+		}
+	}
+	return 0, false
+}
+
+// pngMetadataChunks are the ancillary PNG chunks holding metadata rather than how to render the image
+var pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+// stripPNGMetadata removes the metadata chunks of a PNG image without decoding it
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	const signatureLength = 8
+	if len(data) < signatureLength {
+		return nil, fmt.Errorf("Could not read the png chunks: no signature")
+	}
+	stripped := make([]byte, 0, len(data))
+	stripped = append(stripped, data[:signatureLength]...)
+	for offset := signatureLength; offset < len(data); {
+		if offset+8 > len(data) {
+			return nil, fmt.Errorf("Could not read the png chunks: truncated chunk at %d", offset)
+		}
+		// The length and type, the data and the CRC
+		end := offset + 12 + int(binary.BigEndian.Uint32(data[offset:]))
+		if end > len(data) || end < offset {
+			return nil, fmt.Errorf("Could not read the png chunks: invalid chunk length at %d", offset)
+		}
+		if !pngMetadataChunks[string(data[offset+4:offset+8])] {
+			stripped = append(stripped, data[offset:end]...)
+		}
+		offset = end
+	}
+	return stripped, nil
+}
+
+// VP8X flags of the metadata chunks of a WebP image
+const (
+	webpExifFlag = 0x08
+	webpXMPFlag  = 0x04
+)
+
+// stripWebPMetadata removes the EXIF and XMP chunks of a WebP image without decoding it
+func stripWebPMetadata(data []byte) ([]byte, error) {
+	const headerLength = 12
+	if len(data) < headerLength {
+		return nil, fmt.Errorf("Could not read the webp chunks: no header")
+	}
+	stripped := make([]byte, 0, len(data))
+	stripped = append(stripped, data[:headerLength]...)
+	for offset := headerLength; offset < len(data); {
+		if offset+8 > len(data) {
+			return nil, fmt.Errorf("Could not read the webp chunks: truncated chunk at %d", offset)
+		}
+		size := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		// The chunks are padded to an even size
+		end := offset + 8 + size + size%2
+		if end > len(data) || end < offset {
+			return nil, fmt.Errorf("Could not read the webp chunks: invalid chunk size at %d", offset)
+		}
+		switch string(data[offset : offset+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunkStart := len(stripped)
+			stripped = append(stripped, data[offset:end]...)
+			if size > 0 {
+				stripped[chunkStart+8] &^= webpExifFlag | webpXMPFlag
+			}
+		default:
+			stripped = append(stripped, data[offset:end]...)
+		}
+		offset = end
+	}
+	binary.LittleEndian.PutUint32(stripped[4:], uint32(len(stripped)-8))
+	return stripped, nil
+}
+
+// isobmffBox is a box of an ISO base media file, such as a HEIC image, with the offsets of its payload
+type isobmffBox struct {
+	boxType string
+	start   int
+	end     int
+}
+
+// readBoxes calls visit with the boxes of data[start:end], stopping at the first error
+func readBoxes(data []byte, start, end int, visit func(box isobmffBox) error) error {
+	for offset := start; offset < end; {
+		if offset+8 > end {
+			return fmt.Errorf("Could not read the heic boxes: truncated box at %d", offset)
+		}
+		size := uint64(binary.BigEndian.Uint32(data[offset:]))
+		headerLength := uint64(8)
+		switch size {
+		case 0:
+			// The last box extends to the end
+			size = uint64(end - offset)
+		case 1:
+			if offset+16 > end {
+				return fmt.Errorf("Could not read the heic boxes: truncated box at %d", offset)
+			}
+			size = binary.BigEndian.Uint64(data[offset+8:])
+			headerLength = 16
+		}
+		if size < headerLength || size > uint64(end-offset) {
+			return fmt.Errorf("Could not read the heic boxes: invalid box size at %d", offset)
+		}
+		box := isobmffBox{
+			boxType: string(data[offset+4 : offset+8]),
+			start:   offset + int(headerLength),
+			end:     offset + int(size),
+		}
+		if err := visit(box); err != nil {
+			return err
+		}
+		offset = box.end
+	}
+	return nil
+}
+
+// boxCursor reads the big endian fields of a box payload, keeping the first error
+type boxCursor struct {
+	data   []byte
+	offset int
+	end    int
+	err    error
+}
+
+func newBoxCursor(data []byte, box isobmffBox) *boxCursor {
+	return &boxCursor{data: data, offset: box.start, end: box.end}
+}
+
+// uint reads an unsigned integer of 0 to 8 bytes
+func (cursor *boxCursor) uint(size int) uint64 {
+	if cursor.err != nil {
+		return 0
+	}
+	if cursor.offset+size > cursor.end {
+		cursor.err = fmt.Errorf("Could not read the heic boxes: truncated field at %d", cursor.offset)
+		return 0
+	}
+	value := uint64(0)
+	for _, fieldByte := range cursor.data[cursor.offset : cursor.offset+size] {
+		value = value<<8 | uint64(fieldByte)
+	}
+	cursor.offset += size
+	return value
+}
+
+// fourCC reads a four character code
+func (cursor *boxCursor) fourCC() string {
+	if cursor.err != nil || cursor.offset+4 > cursor.end {
+		cursor.uint(4)
+		return ""
+	}
+	code := string(cursor.data[cursor.offset : cursor.offset+4])
+	cursor.offset += 4
+	return code
+}
+
+// string reads a null terminated string
+func (cursor *boxCursor) string() string {
+	if cursor.err != nil {
+		return ""
+	}
+	length := bytes.IndexByte(cursor.data[cursor.offset:cursor.end], 0)
+	if length < 0 {
+		cursor.err = fmt.Errorf("Could not read the heic boxes: unterminated string at %d", cursor.offset)
+		return ""
+	}
+	value := string(cursor.data[cursor.offset : cursor.offset+length])
+	cursor.offset += length + 1
+	return value
+}
+
+// xmpContentType is the content type of the XMP metadata items of the HEIC images
+const xmpContentType = "application/rdf+xml"
+
+// readHEICMetadataItems adds the IDs of the EXIF and XMP items listed by an item information box to the set
+func readHEICMetadataItems(data []byte, itemInfo isobmffBox, items map[uint64]bool) error {
+	cursor := newBoxCursor(data, itemInfo)
+	version := cursor.uint(1)
+	cursor.uint(3)
+	if version == 0 {
+		cursor.uint(2)
+	} else {
+		cursor.uint(4)
+	}
+	if cursor.err != nil {
+		return cursor.err
+	}
+	return readBoxes(data, cursor.offset, itemInfo.end, func(entry isobmffBox) error {
+		if entry.boxType != "infe" {
+			return nil
+		}
+		cursor := newBoxCursor(data, entry)
+		version := cursor.uint(1)
+		cursor.uint(3)
+		// The entries before version 2 have no item type, the HEIC images do not use them
+		if version < 2 {
+			return cursor.err
+		}
+		idSize := 2
+		if version >= 3 {
+			idSize = 4
+		}
+		id := cursor.uint(idSize)
+		// Item protection index
+		cursor.uint(2)
+		itemType := cursor.fourCC()
+		// Item name
+		cursor.string()
+		if itemType == "Exif" || (itemType == "mime" && cursor.string() == xmpContentType) {
+			items[id] = true
+		}
+		return cursor.err
+	})
+}
+
+// heicItemLocation is where the data of a HEIC item is: its extents, offsets and lengths, are relative to
+// the base offset in the file or in the item data box, depending on the construction method
+type heicItemLocation struct {
+	constructionMethod uint64
+	baseOffset         uint64
+	extents            [][2]uint64
+}
+
+// readHEICItemLocations reads the locations of the items of an item location box by item ID
+func readHEICItemLocations(data []byte, itemLocation isobmffBox) (map[uint64]heicItemLocation, error) {
+	cursor := newBoxCursor(data, itemLocation)
+	version := cursor.uint(1)
+	cursor.uint(3)
+	sizes := cursor.uint(1)
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0x0F)
+	sizes = cursor.uint(1)
+	baseOffsetSize, indexSize := int(sizes>>4), int(sizes&0x0F)
+	if version != 1 && version != 2 {
+		indexSize = 0
+	}
+	// The item count and IDs are 32 bits from version 2
+	idSize := 2
+	if version == 2 {
+		idSize = 4
+	}
+	count := cursor.uint(idSize)
+	locations := make(map[uint64]heicItemLocation)
+	for item := uint64(0); item < count && cursor.err == nil; item++ {
+		id := cursor.uint(idSize)
+		location := heicItemLocation{}
+		if version == 1 || version == 2 {
+			location.constructionMethod = cursor.uint(2) & 0x0F
+		}
+		// Data reference index, the data of the items being in the same file
+		cursor.uint(2)
+		location.baseOffset = cursor.uint(baseOffsetSize)
+		extents := cursor.uint(2)
+		for extent := uint64(0); extent < extents && cursor.err == nil; extent++ {
+			cursor.uint(indexSize)
+			location.extents = append(location.extents, [2]uint64{cursor.uint(offsetSize), cursor.uint(lengthSize)})
+		}
+		locations[id] = location
+	}
+	return locations, cursor.err
+}
+
+// stripHEICMetadata overwrites with zeros the data of the EXIF and XMP items of a HEIC image, without decoding
+// it. The boxes are left as they are, so the offsets of the image items stay valid and the metadata items
+// read as empty. It fails when the data of a metadata item cannot be located, rather than keep it.
+func stripHEICMetadata(data []byte) ([]byte, error) {
+	var meta *isobmffBox
+	err := readBoxes(data, 0, len(data), func(box isobmffBox) error {
+		if box.boxType == "meta" && meta == nil {
+			meta = &box
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, fmt.Errorf("Could not read the heic boxes: no meta box")
+	}
+
+	metadataItems := make(map[uint64]bool)
+	var locations map[uint64]heicItemLocation
+	var itemData *isobmffBox
+	// The meta box is a full box, its version and flags come before its boxes
+	err = readBoxes(data, meta.start+4, meta.end, func(box isobmffBox) error {
+		var err error
+		switch box.boxType {
+		case "iinf":
+			err = readHEICMetadataItems(data, box, metadataItems)
+		case "iloc":
+			locations, err = readHEICItemLocations(data, box)
+		case "idat":
+			itemData = &box
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stripped := bytes.Clone(data)
+	for id := range metadataItems {
+		location, exists := locations[id]
+		if !exists {
+			return nil, fmt.Errorf("Could not strip the heic metadata: no location of item %d", id)
+		}
+		origin, limit := 0, len(data)
+		switch location.constructionMethod {
+		case 0:
+		case 1:
+			if itemData == nil {
+				return nil, fmt.Errorf("Could not strip the heic metadata: no item data of item %d", id)
+			}
+			origin, limit = itemData.start, itemData.end
+		default:
+			return nil, fmt.Errorf("Could not strip the heic metadata: item %d is constructed from other items", id)
+		}
+		available := uint64(limit - origin)
+		for _, extent := range location.extents {
+			offset, length := extent[0], extent[1]
+			if location.baseOffset > available || offset > available-location.baseOffset {
+				return nil, fmt.Errorf("Could not strip the heic metadata: invalid extent of item %d", id)
+			}
+			remaining := available - location.baseOffset - offset
+			if length == 0 {
+				// The extent extends to the end of the data
+				length = remaining
+			}
+			if length > remaining {
+				return nil, fmt.Errorf("Could not strip the heic metadata: invalid extent of item %d", id)
+			}
+			start := origin + int(location.baseOffset+offset)
+			clear(stripped[start : start+int(length)])
+		}
+	}
+	return stripped, nil
+}
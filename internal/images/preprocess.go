@@ -0,0 +1,183 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+)
+
+// DefaultJPEGQuality is the quality of the re-encoded JPEG images when none is configured
+const DefaultJPEGQuality = 85
+
+// DefaultMaxPixels is the largest image decoded when no pixel budget is configured
+const DefaultMaxPixels = 24_000_000
+
+// PreprocessOptions are the options of the image preprocessing
+type PreprocessOptions struct {
+	// MaxEdge is the longest edge of the images in pixels, the larger ones are downscaled, 0 keeps their size
+	MaxEdge int
+	// JPEGQuality is the quality of the re-encoded JPEG images, from 1 to 100
+	JPEGQuality int
+	// MaxPixels is the pixel budget of the images decoded, the larger ones are rejected before being decoded
+	MaxPixels int
+	// Decodes bounds the images decoded at once, each decoding holding a slot of the channel, nil does not bound them
+	Decodes chan struct{}
+}
+
+// PixelBudgetError is the error of an image with more pixels than the images decoded may have
+type PixelBudgetError struct {
+	Width     int
+	Height    int
+	MaxPixels int
+}
+
+func (budgetError *PixelBudgetError) Error() string {
+	return fmt.Sprintf("The %dx%d image exceeds the budget of %d pixels", budgetError.Width, budgetError.Height, budgetError.MaxPixels)
+}
+
+// Preprocess prepares an image for the analysis: it removes its metadata, such as the EXIF location of the
+// photos, and for the JPEG and PNG images applies their EXIF orientation and downscales them to the maximum
+// edge. The images are only decoded and re-encoded in their format when they have to be oriented or
+// downscaled, waiting for a decoding slot until the context is done. The WebP and HEIC images are not
+// downscaled, since there are no pure Go codecs for them: their metadata is stripped without decoding them.
+func Preprocess(ctx context.Context, format Format, data []byte, options PreprocessOptions) ([]byte, error) {
+	switch format {
+	case JPEG, PNG:
+		width, height, err := Dimensions(format, data)
+		if err != nil {
+			return nil, err
+		}
+		orientation := 1
+		if format == JPEG {
+			orientation = jpegOrientation(data)
+		}
+		if orientation == 1 && !exceedsEdge(width, height, options.MaxEdge) {
+			if format == JPEG {
+				return stripJPEGMetadata(data)
+			}
+			return stripPNGMetadata(data)
+		}
+		maxPixels := options.MaxPixels
+		if maxPixels <= 0 {
+			maxPixels = DefaultMaxPixels
+		}
+		if int64(width)*int64(height) > int64(maxPixels) {
+			return nil, &PixelBudgetError{Width: width, Height: height, MaxPixels: maxPixels}
+		}
+		if options.Decodes != nil {
+			select {
+			case options.Decodes <- struct{}{}:
+				defer func() { <-options.Decodes }()
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		return transform(format, data, orientation, options)
+	case WebP:
+		return stripWebPMetadata(data)
+	case HEIC:
+		return stripHEICMetadata(data)
+	}
+	return data, nil
+}
+
+// exceedsEdge reports whether an image is larger than the maximum edge
+func exceedsEdge(width, height, maxEdge int) bool {
+	return maxEdge > 0 && (width > maxEdge || height > maxEdge)
+}
+
+// transform decodes an image, downscales it, orients it and re-encodes it, without its metadata
+func transform(format Format, data []byte, orientation int, options PreprocessOptions) ([]byte, error) {
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Could not decode the %s image: %v", format, err)
+	}
+	transformed := downscale(decoded, options.MaxEdge, orientation)
+
+	encoded := &bytes.Buffer{}
+	if format == JPEG {
+		quality := options.JPEGQuality
+		if quality <= 0 {
+			quality = DefaultJPEGQuality
+		}
+		err = jpeg.Encode(encoded, transformed, &jpeg.Options{Quality: quality})
+	} else {
+		err = png.Encode(encoded, transformed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not encode the %s image: %v", format, err)
+	}
+	return encoded.Bytes(), nil
+}
+
+// orientedPosition returns where a pixel of an image of the width and height goes once the EXIF orientation
+// is applied, so that the image displays upright without its metadata
+func orientedPosition(orientation, x, y, width, height int) (int, int) {
+	switch orientation {
+	case 2:
+		return width - 1 - x, y
+	case 3:
+		return width - 1 - x, height - 1 - y
+	case 4:
+		return x, height - 1 - y
+	case 5:
+		return y, x
+	case 6:
+		return height - 1 - y, x
+	case 7:
+		return height - 1 - y, width - 1 - x
+	case 8:
+		return y, width - 1 - x
+	}
+	return x, y
+}
+
+// downscale shrinks an image so that its longest edge is the maximum edge, averaging the source pixels
+// covered by each pixel, and applies the orientation. The source is converted a row at a time, so that
+// no full size copy of the decoded image is made.
+func downscale(src image.Image, maxEdge int, orientation int) *image.RGBA {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	dstWidth, dstHeight := width, height
+	if exceedsEdge(width, height, maxEdge) {
+		scale := float64(maxEdge) / float64(max(width, height))
+		dstWidth = max(1, int(math.Round(float64(width)*scale)))
+		dstHeight = max(1, int(math.Round(float64(height)*scale)))
+	}
+	orientedWidth, orientedHeight := dstWidth, dstHeight
+	if orientation >= 5 && orientation <= 8 {
+		orientedWidth, orientedHeight = dstHeight, dstWidth
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, orientedWidth, orientedHeight))
+	row := image.NewRGBA(image.Rect(0, 0, width, 1))
+	sums := make([]int, dstWidth*4)
+	for y := 0; y < dstHeight; y++ {
+		srcY0, srcY1 := y*height/dstHeight, (y+1)*height/dstHeight
+		clear(sums)
+		for srcY := srcY0; srcY < srcY1; srcY++ {
+			draw.Draw(row, row.Rect, src, image.Pt(bounds.Min.X, bounds.Min.Y+srcY), draw.Src)
+			for x := 0; x < dstWidth; x++ {
+				srcX0, srcX1 := x*width/dstWidth, (x+1)*width/dstWidth
+				for offset := srcX0 * 4; offset < srcX1*4; offset += 4 {
+					for channel := 0; channel < 4; channel++ {
+						sums[x*4+channel] += int(row.Pix[offset+channel])
+					}
+				}
+			}
+		}
+		for x := 0; x < dstWidth; x++ {
+			count := (srcY1 - srcY0) * ((x+1)*width/dstWidth - x*width/dstWidth)
+			dstX, dstY := orientedPosition(orientation, x, y, dstWidth, dstHeight)
+			offset := dst.PixOffset(dstX, dstY)
+			for channel := 0; channel < 4; channel++ {
+				dst.Pix[offset+channel] = uint8(sums[x*4+channel] / count)
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,242 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// exifSegment builds a big endian EXIF APP1 segment with an orientation and a GPS directory pointer
+func exifSegment(orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2A\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 2)
+	// Orientation, SHORT, 1 value
+	tiff = append(tiff, 0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0x00, 0x00)
+	// GPS directory pointer, LONG, 1 value
+	tiff = append(tiff, 0x88, 0x25, 0x00, 0x04, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00)
+	tiff = append(tiff, 0x00, 0x00, 0x00, 0x00)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, jpegExifSegment}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// withExif inserts an EXIF segment after the start of image of a JPEG image
+func withExif(data []byte, orientation uint16) []byte {
+	withSegment := append([]byte{}, data[:2]...)
+	withSegment = append(withSegment, exifSegment(orientation)...)
+	return append(withSegment, data[2:]...)
+}
+
+// pngChunk builds a PNG chunk, its CRC left empty since the stripping does not check it
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	return append(chunk, 0, 0, 0, 0)
+}
+
+// box builds an ISO base media file box
+func box(boxType string, payloads ...[]byte) []byte {
+	payload := bytes.Join(payloads, nil)
+	built := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+8))
+	built = append(built, boxType...)
+	return append(built, payload...)
+}
+
+// itemInfoEntry builds a version 2 item information entry
+func itemInfoEntry(id uint16, itemType string, contentType string) []byte {
+	entry := binary.BigEndian.AppendUint16([]byte{2, 0, 0, 0}, id)
+	entry = append(entry, 0, 0)
+	entry = append(entry, itemType...)
+	entry = append(entry, 0)
+	if itemType == "mime" {
+		entry = append(entry, contentType+"\x00"...)
+	}
+	return box("infe", entry)
+}
+
+// heicWithMetadata builds a HEIC image with an HEVC item and EXIF and XMP items holding a GPS location,
+// the data of the items being in the media data box
+func heicWithMetadata() ([]byte, []byte, []byte, []byte) {
+	hevc := []byte("hevc coded image")
+	exif := append([]byte{0, 0, 0, 6}, exifSegment(1)[4:]...)
+	exif = append(exif, "GPS 51.5007N 0.1246W"...)
+	xmp := []byte(`<x:xmpmeta><exif:GPSLatitude>51,30.04N</exif:GPSLatitude></x:xmpmeta>`)
+	fileType := box("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+
+	build := func(mediaDataOffset uint32) []byte {
+		itemInfo := box("iinf", []byte{0, 0, 0, 0, 0, 3},
+			itemInfoEntry(1, "hvc1", ""),
+			itemInfoEntry(2, "Exif", ""),
+			itemInfoEntry(3, "mime", "application/rdf+xml"),
+		)
+		// Version 1, 4 byte offsets and lengths, no base offset nor index
+		itemLocation := []byte{1, 0, 0, 0, 0x44, 0x00, 0, 3}
+		offset := mediaDataOffset
+		for id, data := range [][]byte{hevc, exif, xmp} {
+			itemLocation = binary.BigEndian.AppendUint16(itemLocation, uint16(id+1))
+			itemLocation = append(itemLocation, 0, 0, 0, 0, 0, 1)
+			itemLocation = binary.BigEndian.AppendUint32(itemLocation, offset)
+			itemLocation = binary.BigEndian.AppendUint32(itemLocation, uint32(len(data)))
+			offset += uint32(len(data))
+		}
+		meta := box("meta", []byte{0, 0, 0, 0},
+			box("hdlr", []byte("\x00\x00\x00\x00\x00\x00\x00\x00pict\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")),
+			itemInfo,
+			box("iloc", itemLocation),
+		)
+		return bytes.Join([][]byte{fileType, meta, box("mdat", hevc, exif, xmp)}, nil)
+	}
+	unplaced := build(0)
+	mediaDataOffset := uint32(len(unplaced) - len(hevc) - len(exif) - len(xmp))
+	return build(mediaDataOffset), hevc, exif, xmp
+}
+
+func TestPreprocess(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("JPEG_Strips_Exif", func(t *testing.T) {
+		original := encodeTestImage(t, JPEG, 40, 20)
+		data := withExif(original, 1)
+
+		processed, err := Preprocess(ctx, JPEG, data, PreprocessOptions{MaxEdge: 100})
+
+		assert.NoError(t, err)
+		assert.Equal(t, original, processed)
+		assert.NotContains(t, string(processed), "Exif")
+	})
+
+	t.Run("JPEG_Orients_Image", func(t *testing.T) {
+		data := withExif(encodeTestImage(t, JPEG, 40, 20), 6)
+
+		processed, err := Preprocess(ctx, JPEG, data, PreprocessOptions{MaxEdge: 100})
+
+		assert.NoError(t, err)
+		assert.NotContains(t, string(processed), "Exif")
+		width, height, err := Dimensions(JPEG, processed)
+		assert.NoError(t, err)
+		assert.Equal(t, 20, width)
+		assert.Equal(t, 40, height)
+	})
+
+	t.Run("JPEG_Downscales_To_Max_Edge", func(t *testing.T) {
+		data := withExif(encodeTestImage(t, JPEG, 400, 100), 1)
+
+		processed, err := Preprocess(ctx, JPEG, data, PreprocessOptions{MaxEdge: 200, JPEGQuality: 70})
+
+		assert.NoError(t, err)
+		assert.NotContains(t, string(processed), "Exif")
+		width, height, err := Dimensions(JPEG, processed)
+		assert.NoError(t, err)
+		assert.Equal(t, 200, width)
+		assert.Equal(t, 50, height)
+	})
+
+	t.Run("PNG_Strips_Text", func(t *testing.T) {
+		original := encodeTestImage(t, PNG, 8, 8)
+		// The text chunk follows the header chunk, 8 bytes of signature and 25 of IHDR
+		data := append([]byte{}, original[:33]...)
+		data = append(data, pngChunk("tEXt", []byte("GPS\x0051.5N"))...)
+		data = append(data, original[33:]...)
+
+		processed, err := Preprocess(ctx, PNG, data, PreprocessOptions{MaxEdge: 100})
+
+		assert.NoError(t, err)
+		assert.Equal(t, original, processed)
+	})
+
+	t.Run("PNG_Downscale_Averages_Pixels", func(t *testing.T) {
+		img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+		for x := 0; x < 4; x++ {
+			img.Set(x, 0, color.RGBA{R: 200, A: 255})
+			img.Set(x, 1, color.RGBA{R: 100, A: 255})
+		}
+		encoded := &bytes.Buffer{}
+		assert.NoError(t, png.Encode(encoded, img))
+
+		processed, err := Preprocess(ctx, PNG, encoded.Bytes(), PreprocessOptions{MaxEdge: 2})
+
+		assert.NoError(t, err)
+		decoded, err := png.Decode(bytes.NewReader(processed))
+		assert.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, 2, 1), decoded.Bounds())
+		red, _, _, _ := decoded.At(0, 0).RGBA()
+		assert.Equal(t, uint32(150), red>>8)
+	})
+
+	t.Run("WebP_Strips_Exif", func(t *testing.T) {
+		extended := []byte{webpExifFlag | webpXMPFlag, 0, 0, 0, 0x3F, 0, 0, 0x3F, 0, 0}
+		data := webpHeader("VP8X", extended)
+		data = append(data, "EXIF\x03\x00\x00\x00GPS\x00"...)
+		data = append(data, "VP8L\x02\x00\x00\x00\x2F\x00"...)
+		binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+
+		processed, err := Preprocess(ctx, WebP, data, PreprocessOptions{MaxEdge: 100})
+
+		assert.NoError(t, err)
+		assert.NotContains(t, string(processed), "EXIF")
+		assert.Contains(t, string(processed), "VP8L")
+		assert.Equal(t, byte(0), processed[20])
+		assert.Equal(t, uint32(len(processed)-8), binary.LittleEndian.Uint32(processed[4:]))
+	})
+
+	t.Run("HEIC_Strips_Exif_And_XMP", func(t *testing.T) {
+		data, hevc, exif, xmp := heicWithMetadata()
+
+		processed, err := Preprocess(ctx, HEIC, data, PreprocessOptions{MaxEdge: 100})
+
+		assert.NoError(t, err)
+		assert.Len(t, processed, len(data))
+		assert.NotContains(t, string(processed), "GPS")
+		assert.Contains(t, string(processed), string(hevc))
+		metadataStart := bytes.Index(data, exif)
+		assert.Equal(t, make([]byte, len(exif)+len(xmp)), processed[metadataStart:metadataStart+len(exif)+len(xmp)])
+		assert.Contains(t, string(data), "GPS")
+	})
+
+	t.Run("HEIC_Without_Meta_Box_Error", func(t *testing.T) {
+		data := heicHeader("heic", [2]uint32{4032, 3024})
+
+		_, err := Preprocess(ctx, HEIC, data, PreprocessOptions{MaxEdge: 100})
+
+		assert.Equal(t, "Could not read the heic boxes: no meta box", err.Error())
+	})
+
+	t.Run("Over_Pixel_Budget_Error", func(t *testing.T) {
+		data := encodeTestImage(t, PNG, 40, 20)
+
+		_, err := Preprocess(ctx, PNG, data, PreprocessOptions{MaxEdge: 10, MaxPixels: 799})
+
+		assert.Equal(t, &PixelBudgetError{Width: 40, Height: 20, MaxPixels: 799}, err)
+	})
+
+	t.Run("Waits_For_Decoding_Slot", func(t *testing.T) {
+		data := encodeTestImage(t, PNG, 40, 20)
+		decodes := make(chan struct{}, 1)
+		decodes <- struct{}{}
+		cancelledContext, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err := Preprocess(cancelledContext, PNG, data, PreprocessOptions{MaxEdge: 10, Decodes: decodes})
+
+		assert.Equal(t, context.Canceled, err)
+	})
+
+	t.Run("Corrupt_JPEG_Error", func(t *testing.T) {
+		data := withExif(encodeTestImage(t, JPEG, 40, 20), 6)
+
+		_, err := Preprocess(ctx, JPEG, data[:len(data)/2], PreprocessOptions{MaxEdge: 100})
+
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,141 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	// The decoders register the formats whose configuration is read by image.DecodeConfig
+	_ "image/jpeg"
+	_ "image/png"
+	"strings"
+)
+
+// Format is an image format accepted by the image analysis
+type Format string
+
+// Formats recognised by their magic bytes
+const (
+	JPEG Format = "jpeg"
+	PNG  Format = "png"
+	WebP Format = "webp"
+	HEIC Format = "heic"
+)
+
+// MimeType returns the MIME type of the format
+func (format Format) MimeType() string {
+	return "image/" + string(format)
+}
+
+// HeaderSize is the length of the image prefix Sniff and Dimensions look at: the dimensions of the JPEG
+// images come after their metadata segments, which are kept within this size by the cameras and editors
+const HeaderSize = 256 * 1024
+
+// heicBrands are the ISO base media file brands of the HEIC and HEIF still images
+var heicBrands = map[string]bool{
+	"heic": true, "heix": true, "heim": true, "heis": true,
+	"hevc": true, "hevx": true, "mif1": true, "msf1": true,
+}
+
+// Sniff returns the format of an image from its magic bytes, false if it is not an accepted format
+func Sniff(header []byte) (Format, bool) {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return JPEG, true
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return PNG, true
+	case len(header) >= 12 && string(header[0:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		return WebP, true
+	case len(header) >= 12 && string(header[4:8]) == "ftyp" && heicBrands[string(header[8:12])]:
+		return HEIC, true
+	}
+	return "", false
+}
+
+// MatchesMimeType reports whether a MIME type declared by a client names the format. The generic binary
+// type and an empty type name no format, so they match any.
+func MatchesMimeType(format Format, mimeType string) bool {
+	mimeType = strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+	switch mimeType {
+	case "", "application/octet-stream":
+		return true
+	case "image/jpg", "image/pjpeg":
+		return format == JPEG
+	case "image/heif", "image/heic-sequence", "image/heif-sequence":
+		return format == HEIC
+	}
+	return mimeType == format.MimeType()
+}
+
+// Dimensions returns the width and height of an image read from its header, without decoding its pixels
+func Dimensions(format Format, header []byte) (int, int, error) {
+	switch format {
+	case JPEG, PNG:
+		config, _, err := image.DecodeConfig(bytes.NewReader(header))
+		if err != nil {
+			return 0, 0, fmt.Errorf("Could not read the %s header: %v", format, err)
+		}
+		return config.Width, config.Height, nil
+	case WebP:
+		return webpDimensions(header)
+	case HEIC:
+		return heicDimensions(header)
+	}
+	return 0, 0, fmt.Errorf("Unknown image format %s", format)
+}
+
+// webpDimensions reads the dimensions of the first chunk of a WebP image: the canvas of the extended
+// format, or the frame of the lossy and lossless formats
+func webpDimensions(header []byte) (int, int, error) {
+	if len(header) < 30 {
+		return 0, 0, fmt.Errorf("Could not read the webp header: too short")
+	}
+	chunk := header[20:]
+	switch string(header[12:16]) {
+	case "VP8X":
+		width := int(chunk[4]) | int(chunk[5])<<8 | int(chunk[6])<<16
+		height := int(chunk[7]) | int(chunk[8])<<8 | int(chunk[9])<<16
+		return width + 1, height + 1, nil
+	case "VP8 ":
+		if !bytes.Equal(chunk[3:6], []byte{0x9D, 0x01, 0x2A}) {
+			return 0, 0, fmt.Errorf("Could not read the webp header: invalid lossy frame")
+		}
+		width := int(binary.LittleEndian.Uint16(chunk[6:8]) & 0x3FFF)
+		height := int(binary.LittleEndian.Uint16(chunk[8:10]) & 0x3FFF)
+		return width, height, nil
+	case "VP8L":
+		if chunk[0] != 0x2F {
+			return 0, 0, fmt.Errorf("Could not read the webp header: invalid lossless frame")
+		}
+		bits := binary.LittleEndian.Uint32(chunk[1:5])
+		return int(bits&0x3FFF) + 1, int(bits>>14&0x3FFF) + 1, nil
+	}
+	return 0, 0, fmt.Errorf("Could not read the webp header: unknown chunk %q", header[12:16])
+}
+
+// heicDimensions reads the dimensions of a HEIC image from the image spatial extents properties of its
+// metadata. The largest extents are returned, those of the primary image rather than of its thumbnails.
+func heicDimensions(header []byte) (int, int, error) {
+	width, height := 0, 0
+	for offset := 4; offset+16 <= len(header); {
+		index := bytes.Index(header[offset:], []byte("ispe"))
+		if index < 0 {
+			break
+		}
+		boxStart := offset + index - 4
+		offset += index + 4
+		// The ispe box is 20 bytes: its size and type, its version and flags and the width and height
+		if boxStart < 0 || binary.BigEndian.Uint32(header[boxStart:]) != 20 || boxStart+20 > len(header) {
+			continue
+		}
+		boxWidth := int(binary.BigEndian.Uint32(header[boxStart+12:]))
+		boxHeight := int(binary.BigEndian.Uint32(header[boxStart+16:]))
+		if boxWidth*boxHeight > width*height {
+			width, height = boxWidth, boxHeight
+		}
+	}
+	if width == 0 || height == 0 {
+		return 0, 0, fmt.Errorf("Could not read the heic header: no image spatial extents")
+	}
+	return width, height, nil
+}
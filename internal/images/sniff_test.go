@@ -0,0 +1,127 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeTestImage(t *testing.T, format Format, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	encoded := &bytes.Buffer{}
+	switch format {
+	case JPEG:
+		assert.NoError(t, jpeg.Encode(encoded, img, nil))
+	case PNG:
+		assert.NoError(t, png.Encode(encoded, img))
+	}
+	return encoded.Bytes()
+}
+
+// webpHeader builds the RIFF header and the first chunk of a WebP image
+func webpHeader(chunkType string, chunk []byte) []byte {
+	header := []byte("RIFF\x00\x00\x00\x00WEBP" + chunkType)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(chunk)))
+	return append(header, chunk...)
+}
+
+// heicHeader builds the file type box of a HEIC image followed by image spatial extents boxes
+func heicHeader(brand string, extents ...[2]uint32) []byte {
+	header := []byte("\x00\x00\x00\x18ftyp" + brand + "\x00\x00\x00\x00mif1heic")
+	header = append(header, "\x00\x00\x00\x08free"...)
+	for _, extent := range extents {
+		header = binary.BigEndian.AppendUint32(header, 20)
+		header = append(header, "ispe\x00\x00\x00\x00"...)
+		header = binary.BigEndian.AppendUint32(header, extent[0])
+		header = binary.BigEndian.AppendUint32(header, extent[1])
+	}
+	return header
+}
+
+func TestSniff(t *testing.T) {
+	testCases := []struct {
+		name   string
+		header []byte
+		format Format
+		ok     bool
+	}{
+		{name: "JPEG", header: encodeTestImage(t, JPEG, 2, 2), format: JPEG, ok: true},
+		{name: "PNG", header: encodeTestImage(t, PNG, 2, 2), format: PNG, ok: true},
+		{name: "WebP", header: webpHeader("VP8L", make([]byte, 10)), format: WebP, ok: true},
+		{name: "HEIC", header: heicHeader("heic"), format: HEIC, ok: true},
+		{name: "HEIF", header: heicHeader("mif1"), format: HEIC, ok: true},
+		{name: "GIF", header: []byte("GIF89a\x01\x00\x01\x00"), ok: false},
+		{name: "MP4", header: heicHeader("isom"), ok: false},
+		{name: "Empty", header: nil, ok: false},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			format, ok := Sniff(testCase.header)
+
+			assert.Equal(t, testCase.ok, ok)
+			assert.Equal(t, testCase.format, format)
+		})
+	}
+}
+
+func TestMatchesMimeType(t *testing.T) {
+	assert.True(t, MatchesMimeType(JPEG, "image/jpeg"))
+	assert.True(t, MatchesMimeType(JPEG, "image/jpg"))
+	assert.True(t, MatchesMimeType(PNG, "IMAGE/PNG; charset=binary"))
+	assert.True(t, MatchesMimeType(HEIC, "image/heif"))
+	assert.True(t, MatchesMimeType(WebP, ""))
+	assert.True(t, MatchesMimeType(WebP, "application/octet-stream"))
+	assert.False(t, MatchesMimeType(PNG, "image/jpeg"))
+	assert.False(t, MatchesMimeType(JPEG, "text/html"))
+}
+
+func TestDimensions(t *testing.T) {
+	lossless := []byte{0x2F, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	// 640 - 1 in the first 14 bits, 480 - 1 in the next 14 bits
+	binary.LittleEndian.PutUint32(lossless[1:], (640-1)|(480-1)<<14)
+	lossy := []byte{0, 0, 0, 0x9D, 0x01, 0x2A, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint16(lossy[6:], 320)
+	binary.LittleEndian.PutUint16(lossy[8:], 200)
+	extended := []byte{0, 0, 0, 0, 0xFF, 0x0F, 0, 0x37, 0x0B, 0}
+
+	testCases := []struct {
+		name   string
+		format Format
+		header []byte
+		width  int
+		height int
+	}{
+		{name: "JPEG", format: JPEG, header: encodeTestImage(t, JPEG, 120, 80), width: 120, height: 80},
+		{name: "PNG", format: PNG, header: encodeTestImage(t, PNG, 64, 32), width: 64, height: 32},
+		{name: "WebP_Lossless", format: WebP, header: webpHeader("VP8L", lossless), width: 640, height: 480},
+		{name: "WebP_Lossy", format: WebP, header: webpHeader("VP8 ", lossy), width: 320, height: 200},
+		{name: "WebP_Extended", format: WebP, header: webpHeader("VP8X", extended), width: 4096, height: 2872},
+		{name: "HEIC_Largest_Extents", format: HEIC, header: heicHeader("heic", [2]uint32{320, 240}, [2]uint32{4032, 3024}), width: 4032, height: 3024},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			width, height, err := Dimensions(testCase.format, testCase.header)
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.width, width)
+			assert.Equal(t, testCase.height, height)
+		})
+	}
+
+	t.Run("Truncated_Header_Error", func(t *testing.T) {
+		_, _, err := Dimensions(PNG, encodeTestImage(t, PNG, 2, 2)[:12])
+
+		assert.Error(t, err)
+	})
+
+	t.Run("HEIC_Without_Extents_Error", func(t *testing.T) {
+		_, _, err := Dimensions(HEIC, heicHeader("heic"))
+
+		assert.Equal(t, "Could not read the heic header: no image spatial extents", err.Error())
+	})
+}
@@ -0,0 +1,277 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	goErrors "errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	commonLogger "github.com/quadev-ltd/qd-common/pkg/log"
+	"github.com/rs/zerolog/log"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+)
+
+// Defaults used when the jobs configuration leaves them unset
+const (
+	DefaultWorkers        = 4
+	DefaultQueueSize      = 64
+	DefaultMaxQueuedBytes = 256 << 20
+	DefaultTimeout        = 2 * time.Minute
+	DefaultDrainTimeout   = 30 * time.Second
+)
+
+// ErrQueueFull is returned when a job is submitted while every worker is busy and the queue is full, or when
+// the jobs not done yet already hold the maximum of bytes
+var ErrQueueFull = goErrors.New("The job queue is full")
+
+// Runner runs a job, returning its result to encode as JSON or the envelope of its error
+type Runner func(ctx context.Context) (interface{}, *errors.Envelope)
+
+// Response renders a job to its user, in the job responses and the webhook deliveries
+type Response struct {
+	ID        string           `json:"id"`
+	Status    Status           `json:"status"`
+	Result    json.RawMessage  `json:"result,omitempty"`
+	Error     *errors.Envelope `json:"error,omitempty"`
+	CreatedAt time.Time        `json:"createdAt"`
+	UpdatedAt time.Time        `json:"updatedAt"`
+}
+
+// Response returns the rendering of the job to its user, without its user ID and callback URL
+func (job *Job) Response() *Response {
+	return &Response{
+		ID:        job.ID,
+		Status:    job.Status,
+		Result:    job.Result,
+		Error:     job.Error,
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+	}
+}
+
+// Manager runs the jobs on a worker pool, keeps their status in the store and delivers them to their
+// callback URLs once done, on a pool of its own so that slow receivers do not hold the job workers
+type Manager struct {
+	store      Storer
+	pool       *WorkerPool
+	deliveries *WorkerPool
+	notifier   *Notifier
+	timeout    time.Duration
+	// queuedBytes are the bytes held by the jobs not done yet, bounded by maxQueuedBytes
+	queuedBytes    atomic.Int64
+	maxQueuedBytes int64
+	drainTimeout   time.Duration
+	// ctx is cancelled when the manager closes, interrupting the jobs and deliveries in flight
+	ctx    context.Context
+	cancel context.CancelFunc
+	now    func() time.Time
+}
+
+// NewManager creates a job manager keeping the jobs in the store, which it closes with itself
+func NewManager(store Storer, configurations *config.Config) *Manager {
+	jobsConfig := configurations.Jobs
+	workers := jobsConfig.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	queueSize := jobsConfig.QueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	maxQueuedBytes := jobsConfig.MaxQueuedBytes
+	if maxQueuedBytes <= 0 {
+		maxQueuedBytes = DefaultMaxQueuedBytes
+	}
+	timeout := jobsConfig.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	drainTimeout := configurations.Server.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = DefaultDrainTimeout
+	}
+	deliveryWorkers := jobsConfig.Webhook.Workers
+	if deliveryWorkers <= 0 {
+		deliveryWorkers = DefaultWebhookWorkers
+	}
+	deliveryQueueSize := jobsConfig.Webhook.QueueSize
+	if deliveryQueueSize <= 0 {
+		deliveryQueueSize = DefaultWebhookQueueSize
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Manager{
+		store:          store,
+		pool:           NewWorkerPool(workers, queueSize),
+		deliveries:     NewWorkerPool(deliveryWorkers, deliveryQueueSize),
+		notifier:       NewNotifier(jobsConfig.Webhook),
+		timeout:        timeout,
+		maxQueuedBytes: maxQueuedBytes,
+		drainTimeout:   drainTimeout,
+		ctx:            ctx,
+		cancel:         cancel,
+		now:            time.Now,
+	}
+}
+
+func logError(ctx context.Context, err error, message string) {
+	if logger, loggerErr := commonLogger.GetLoggerFromContext(ctx); loggerErr == nil {
+		logger.Error(err, message)
+		return
+	}
+	log.Error().Err(err).Msg(message)
+}
+
+// ValidateCallbackURL checks that the jobs can be delivered to the callback URL
+func (manager *Manager) ValidateCallbackURL(callbackURL string) error {
+	return manager.notifier.ValidateCallbackURL(callbackURL)
+}
+
+// Submit creates a queued job of the user and queues its run, returning ErrQueueFull when the queue is full.
+// The size is the number of bytes the runner holds until it returns, such as its image, counted against
+// the maximum of queued bytes. The job runs without the deadline of the request but with its values, such
+// as its logger and trace.
+func (manager *Manager) Submit(ctx context.Context, userID, callbackURL string, size int64, run Runner) (*Job, error) {
+	if manager.queuedBytes.Add(size) > manager.maxQueuedBytes {
+		manager.queuedBytes.Add(-size)
+		return nil, ErrQueueFull
+	}
+	now := manager.now()
+	job := &Job{
+		ID:          uuid.NewString(),
+		UserID:      userID,
+		Status:      Queued,
+		CallbackURL: callbackURL,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	// The job is saved before it is queued, so that the worker updates always follow its creation
+	if err := manager.store.Save(ctx, job); err != nil {
+		manager.queuedBytes.Add(-size)
+		return nil, fmt.Errorf("Could not save the job: %v", err)
+	}
+	queued := *job
+	jobCtx := context.WithoutCancel(ctx)
+	if !manager.pool.Submit(func() {
+		defer manager.queuedBytes.Add(-size)
+		manager.run(jobCtx, &queued, run)
+	}) {
+		manager.queuedBytes.Add(-size)
+		job.Status = Failed
+		job.Error = &errors.Envelope{Code: errors.Unavailable, Message: "The job queue is full"}
+		job.UpdatedAt = manager.now()
+		if err := manager.store.Save(ctx, job); err != nil {
+			logError(ctx, err, "Could not save the rejected job")
+		}
+		return nil, ErrQueueFull
+	}
+	return job, nil
+}
+
+// withManagerCancel returns a context cancelled with the manager
+func (manager *Manager) withManagerCancel(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(manager.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// save updates the job status, logging the store errors since the job goes on without them
+func (manager *Manager) save(ctx context.Context, job *Job, status Status) {
+	job.Status = status
+	job.UpdatedAt = manager.now()
+	if err := manager.store.Save(ctx, job); err != nil {
+		logError(ctx, err, fmt.Sprintf("Could not save the %s job %s", status, job.ID))
+	}
+}
+
+// run runs a job on a worker, within the job timeout, and delivers it once done
+func (manager *Manager) run(ctx context.Context, job *Job, run Runner) {
+	manager.save(ctx, job, Running)
+
+	runCtx, cancel := manager.withManagerCancel(ctx)
+	runCtx, cancelTimeout := context.WithTimeout(runCtx, manager.timeout)
+	result, envelope := run(runCtx)
+	cancelTimeout()
+	cancel()
+
+	if envelope != nil && manager.ctx.Err() != nil {
+		envelope = &errors.Envelope{
+			Code:    errors.Unavailable,
+			Message: "The gateway shut down before the job completed, submit it again",
+		}
+	}
+	if envelope == nil {
+		encoded, err := json.Marshal(result)
+		if err != nil {
+			logError(ctx, err, fmt.Sprintf("Could not encode the result of the job %s", job.ID))
+			envelope = &errors.Envelope{Code: errors.Internal, Message: http.StatusText(http.StatusInternalServerError)}
+		}
+		job.Result = encoded
+	}
+	if envelope != nil {
+		job.Result = nil
+		job.Error = envelope
+		manager.save(ctx, job, Failed)
+	} else {
+		manager.save(ctx, job, Succeeded)
+	}
+
+	if job.CallbackURL != "" && !manager.deliveries.Submit(func() { manager.notify(ctx, job) }) {
+		logError(ctx, ErrQueueFull, fmt.Sprintf("Could not queue the delivery of the job %s to its callback", job.ID))
+	}
+}
+
+// notify delivers the done job to its callback URL, the deliveries in flight when the manager closes are dropped
+func (manager *Manager) notify(ctx context.Context, job *Job) {
+	body, err := json.Marshal(job.Response())
+	if err != nil {
+		logError(ctx, err, fmt.Sprintf("Could not encode the job %s for its callback", job.ID))
+		return
+	}
+	deliveryCtx, cancel := manager.withManagerCancel(ctx)
+	defer cancel()
+	if err := manager.notifier.Deliver(deliveryCtx, job.CallbackURL, job.ID, body); err != nil {
+		logError(ctx, err, fmt.Sprintf("Could not deliver the job %s to its callback", job.ID))
+	}
+}
+
+// Get returns the job of the user with the ID, nil when there is none or it belongs to another user
+func (manager *Manager) Get(ctx context.Context, userID, jobID string) (*Job, error) {
+	job, err := manager.store.Get(ctx, jobID)
+	if err != nil || job == nil || job.UserID != userID {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Close stops accepting jobs and lets the queued and running ones finish and be delivered within the drain
+// timeout. Past it, the jobs in flight are interrupted and fail as interrupted by the shutdown, and the
+// deliveries in flight are dropped. The store is closed last.
+func (manager *Manager) Close() error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		manager.pool.Close()
+		// The deliveries are closed once the last jobs queued theirs
+		manager.deliveries.Close()
+	}()
+	timer := time.NewTimer(manager.drainTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		log.Warn().Msg("The jobs did not finish within the drain timeout, interrupting them")
+	}
+	manager.cancel()
+	<-done
+	return manager.store.Close()
+}
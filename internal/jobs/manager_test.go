@@ -0,0 +1,223 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+)
+
+func newTestManager(jobsConfig config.JobsConfig) *Manager {
+	return NewManager(NewMemoryJobStore(time.Hour), &config.Config{Jobs: jobsConfig})
+}
+
+// waitForJob waits for the job of the user to be done and returns it
+func waitForJob(t *testing.T, manager *Manager, userID, jobID string) *Job {
+	var job *Job
+	assert.Eventually(t, func() bool {
+		stored, err := manager.Get(context.Background(), userID, jobID)
+		if err != nil || stored == nil || !stored.Status.Done() {
+			return false
+		}
+		job = stored
+		return true
+	}, 5*time.Second, time.Millisecond)
+	return job
+}
+
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Job_Succeeds_With_Result", func(t *testing.T) {
+		manager := newTestManager(config.JobsConfig{})
+		defer manager.Close()
+
+		job, err := manager.Submit(ctx, "user", "", 0, func(ctx context.Context) (interface{}, *errors.Envelope) {
+			return map[string]string{"responseToPrompt": "A cat"}, nil
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, Queued, job.Status)
+		done := waitForJob(t, manager, "user", job.ID)
+		assert.Equal(t, Succeeded, done.Status)
+		assert.JSONEq(t, `{"responseToPrompt":"A cat"}`, string(done.Result))
+		assert.Nil(t, done.Error)
+	})
+
+	t.Run("Job_Fails_With_Error", func(t *testing.T) {
+		manager := newTestManager(config.JobsConfig{})
+		defer manager.Close()
+		envelope := &errors.Envelope{Code: errors.BadRequest, Message: "Unsupported image"}
+
+		job, err := manager.Submit(ctx, "user", "", 0, func(ctx context.Context) (interface{}, *errors.Envelope) {
+			return nil, envelope
+		})
+
+		assert.NoError(t, err)
+		done := waitForJob(t, manager, "user", job.ID)
+		assert.Equal(t, Failed, done.Status)
+		assert.Equal(t, envelope, done.Error)
+		assert.Nil(t, done.Result)
+	})
+
+	t.Run("Job_Of_Another_User_Nil", func(t *testing.T) {
+		manager := newTestManager(config.JobsConfig{})
+		defer manager.Close()
+		job, err := manager.Submit(ctx, "user", "", 0, func(ctx context.Context) (interface{}, *errors.Envelope) {
+			return "done", nil
+		})
+		assert.NoError(t, err)
+		waitForJob(t, manager, "user", job.ID)
+
+		stored, err := manager.Get(ctx, "another-user", job.ID)
+
+		assert.NoError(t, err)
+		assert.Nil(t, stored)
+	})
+
+	t.Run("Job_Runs_Within_Timeout", func(t *testing.T) {
+		manager := newTestManager(config.JobsConfig{Timeout: time.Millisecond})
+		defer manager.Close()
+
+		job, err := manager.Submit(ctx, "user", "", 0, func(ctx context.Context) (interface{}, *errors.Envelope) {
+			<-ctx.Done()
+			return nil, &errors.Envelope{Code: errors.Timeout, Message: ctx.Err().Error()}
+		})
+
+		assert.NoError(t, err)
+		done := waitForJob(t, manager, "user", job.ID)
+		assert.Equal(t, errors.Timeout, done.Error.Code)
+	})
+
+	t.Run("Full_Queue_Rejects_Jobs", func(t *testing.T) {
+		manager := newTestManager(config.JobsConfig{Workers: 1, QueueSize: 1})
+		defer manager.Close()
+		started := make(chan struct{}, 2)
+		release := make(chan struct{})
+		blocking := func(ctx context.Context) (interface{}, *errors.Envelope) {
+			started <- struct{}{}
+			<-release
+			return "done", nil
+		}
+
+		running, err := manager.Submit(ctx, "user", "", 0, blocking)
+		assert.NoError(t, err)
+		<-started
+		queued, err := manager.Submit(ctx, "user", "", 0, blocking)
+		assert.NoError(t, err)
+		rejected, err := manager.Submit(ctx, "user", "", 0, blocking)
+		close(release)
+
+		assert.Equal(t, ErrQueueFull, err)
+		assert.Nil(t, rejected)
+		assert.Equal(t, Succeeded, waitForJob(t, manager, "user", running.ID).Status)
+		assert.Equal(t, Succeeded, waitForJob(t, manager, "user", queued.ID).Status)
+	})
+
+	t.Run("Queued_Bytes_Bound_Rejects_Jobs", func(t *testing.T) {
+		manager := newTestManager(config.JobsConfig{MaxQueuedBytes: 10})
+		defer manager.Close()
+		release := make(chan struct{})
+
+		held, err := manager.Submit(ctx, "user", "", 6, func(ctx context.Context) (interface{}, *errors.Envelope) {
+			<-release
+			return "done", nil
+		})
+		assert.NoError(t, err)
+		rejected, err := manager.Submit(ctx, "user", "", 6, func(ctx context.Context) (interface{}, *errors.Envelope) {
+			return "done", nil
+		})
+		close(release)
+
+		assert.Equal(t, ErrQueueFull, err)
+		assert.Nil(t, rejected)
+		waitForJob(t, manager, "user", held.ID)
+		assert.Eventually(t, func() bool { return manager.queuedBytes.Load() == 0 }, 5*time.Second, time.Millisecond)
+	})
+
+	t.Run("Close_Lets_Running_Jobs_Finish", func(t *testing.T) {
+		manager := newTestManager(config.JobsConfig{Workers: 1})
+		started := make(chan struct{})
+		job, err := manager.Submit(ctx, "user", "", 0, func(ctx context.Context) (interface{}, *errors.Envelope) {
+			close(started)
+			select {
+			case <-ctx.Done():
+				return nil, &errors.Envelope{Code: errors.RequestTimeout, Message: "context canceled"}
+			case <-time.After(10 * time.Millisecond):
+				return "done", nil
+			}
+		})
+		assert.NoError(t, err)
+		<-started
+
+		assert.NoError(t, manager.Close())
+
+		stored, err := manager.Get(ctx, "user", job.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, Succeeded, stored.Status)
+	})
+
+	t.Run("Close_Interrupts_Running_Jobs_After_Drain_Timeout", func(t *testing.T) {
+		manager := NewManager(NewMemoryJobStore(time.Hour), &config.Config{
+			Server: config.ServerConfig{DrainTimeout: time.Millisecond},
+			Jobs:   config.JobsConfig{Workers: 1},
+		})
+		started := make(chan struct{})
+		job, err := manager.Submit(ctx, "user", "", 0, func(ctx context.Context) (interface{}, *errors.Envelope) {
+			close(started)
+			<-ctx.Done()
+			return nil, &errors.Envelope{Code: errors.RequestTimeout, Message: "context canceled"}
+		})
+		assert.NoError(t, err)
+		<-started
+
+		assert.NoError(t, manager.Close())
+
+		stored, err := manager.Get(ctx, "user", job.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, Failed, stored.Status)
+		assert.Equal(t, errors.Unavailable, stored.Error.Code)
+		_, err = manager.Submit(ctx, "user", "", 0, func(ctx context.Context) (interface{}, *errors.Envelope) {
+			return "done", nil
+		})
+		assert.Equal(t, ErrQueueFull, err)
+	})
+
+	t.Run("Done_Job_Delivered_To_Callback", func(t *testing.T) {
+		deliveries := make(chan *http.Request, 1)
+		bodies := make(chan []byte, 1)
+		receiver := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			body, _ := io.ReadAll(request.Body)
+			deliveries <- request
+			bodies <- body
+		}))
+		defer receiver.Close()
+		manager := newTestManager(config.JobsConfig{Webhook: config.WebhookConfig{Secret: "secret", AllowInsecure: true}})
+		defer manager.Close()
+
+		job, err := manager.Submit(ctx, "user", receiver.URL+"/callback", 0, func(ctx context.Context) (interface{}, *errors.Envelope) {
+			return map[string]string{"responseToPrompt": "A cat"}, nil
+		})
+		assert.NoError(t, err)
+
+		delivery := <-deliveries
+		body := <-bodies
+		response := &Response{}
+		assert.NoError(t, json.Unmarshal(body, response))
+		assert.Equal(t, "/callback", delivery.URL.Path)
+		assert.Equal(t, job.ID, delivery.Header.Get(WebhookIDHeader))
+		assert.Equal(t, job.ID, response.ID)
+		assert.Equal(t, Succeeded, response.Status)
+		assert.NotContains(t, string(body), "user")
+		timestamp := delivery.Header.Get(WebhookTimestampHeader)
+		assert.Equal(t, Sign("secret", parseTimestamp(t, timestamp), body), delivery.Header.Get(WebhookSignatureHeader))
+	})
+}
@@ -0,0 +1,97 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryJob is a job kept in memory until it expires
+type memoryJob struct {
+	job    Job
+	expiry time.Time
+}
+
+// memorySweepInterval is the interval between the sweeps of the expired jobs of the memory store
+const memorySweepInterval = time.Minute
+
+// MemoryJobStore keeps the jobs in the memory of a single gateway replica, so only that replica returns them
+type MemoryJobStore struct {
+	jobs     map[string]memoryJob
+	ttl      time.Duration
+	now      func() time.Time
+	mtx      sync.RWMutex
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+var _ Storer = &MemoryJobStore{}
+
+// NewMemoryJobStore creates an in-memory job store keeping the jobs for the given TTL after their last update,
+// sweeping the expired ones in the background until it is closed
+func NewMemoryJobStore(ttl time.Duration) *MemoryJobStore {
+	store := &MemoryJobStore{
+		jobs: make(map[string]memoryJob),
+		ttl:  ttl,
+		now:  time.Now,
+		stop: make(chan struct{}),
+	}
+	go store.runJanitor()
+	return store
+}
+
+// removeExpired drops the jobs past their TTL
+func (store *MemoryJobStore) removeExpired() {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	now := store.now()
+	for jobID, stored := range store.jobs {
+		if !stored.expiry.After(now) {
+			delete(store.jobs, jobID)
+		}
+	}
+}
+
+func (store *MemoryJobStore) runJanitor() {
+	ticker := time.NewTicker(memorySweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			store.removeExpired()
+		case <-store.stop:
+			return
+		}
+	}
+}
+
+// Save creates or replaces the job, keeping a copy of it for the TTL of the store
+func (store *MemoryJobStore) Save(ctx context.Context, job *Job) error {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	store.jobs[job.ID] = memoryJob{job: *job, expiry: store.now().Add(store.ttl)}
+	return nil
+}
+
+// Get returns a copy of the job with the ID, nil when there is none or it expired
+func (store *MemoryJobStore) Get(ctx context.Context, jobID string) (*Job, error) {
+	store.mtx.RLock()
+	defer store.mtx.RUnlock()
+
+	stored, exists := store.jobs[jobID]
+	if !exists || !stored.expiry.After(store.now()) {
+		return nil, nil
+	}
+	job := stored.job
+	return &job, nil
+}
+
+// Close ends the background sweep of the expired jobs
+func (store *MemoryJobStore) Close() error {
+	store.stopOnce.Do(func() {
+		close(store.stop)
+	})
+	return nil
+}
@@ -0,0 +1,60 @@
+package jobs
+
+import (
+	"sync"
+)
+
+// WorkerPool runs the submitted tasks on a fixed number of workers, queueing a bounded number of them
+type WorkerPool struct {
+	tasks   chan func()
+	workers sync.WaitGroup
+	closed  bool
+	mtx     sync.RWMutex
+}
+
+// NewWorkerPool starts a pool of workers, at least one, with a queue of the given size
+func NewWorkerPool(workers, queueSize int) *WorkerPool {
+	pool := &WorkerPool{
+		tasks: make(chan func(), max(queueSize, 0)),
+	}
+	for worker := 0; worker < max(workers, 1); worker++ {
+		pool.workers.Add(1)
+		go pool.work()
+	}
+	return pool
+}
+
+func (pool *WorkerPool) work() {
+	defer pool.workers.Done()
+	for task := range pool.tasks {
+		task()
+	}
+}
+
+// Submit queues the task without waiting, returning false when the queue is full or the pool closed
+func (pool *WorkerPool) Submit(task func()) bool {
+	pool.mtx.RLock()
+	defer pool.mtx.RUnlock()
+
+	if pool.closed {
+		return false
+	}
+	select {
+	case pool.tasks <- task:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops accepting tasks and waits for the workers to run the queued ones
+func (pool *WorkerPool) Close() {
+	pool.mtx.Lock()
+	if !pool.closed {
+		pool.closed = true
+		close(pool.tasks)
+	}
+	pool.mtx.Unlock()
+
+	pool.workers.Wait()
+}
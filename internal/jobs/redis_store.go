@@ -0,0 +1,64 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisJobStore shares the jobs across gateway replicas through Redis, so that any replica returns the jobs
+// run by the others. Each job is kept as JSON in the string "<prefix>:<job ID>", expiring with the TTL.
+type RedisJobStore struct {
+	client    redis.UniversalClient
+	keyPrefix string
+	ttl       time.Duration
+}
+
+var _ Storer = &RedisJobStore{}
+
+// NewRedisJobStore creates a Redis job store keeping the jobs for the given TTL after their last update
+func NewRedisJobStore(client redis.UniversalClient, keyPrefix string, ttl time.Duration) *RedisJobStore {
+	return &RedisJobStore{
+		client:    client,
+		keyPrefix: keyPrefix,
+		ttl:       ttl,
+	}
+}
+
+func (store *RedisJobStore) jobKey(jobID string) string {
+	return fmt.Sprintf("%s:%s", store.keyPrefix, jobID)
+}
+
+// Save creates or replaces the job, keeping it for the TTL of the store
+func (store *RedisJobStore) Save(ctx context.Context, job *Job) error {
+	value, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("Could not encode the job %s: %v", job.ID, err)
+	}
+	return store.client.Set(ctx, store.jobKey(job.ID), value, store.ttl).Err()
+}
+
+// Get returns the job with the ID, nil when there is none or it expired
+func (store *RedisJobStore) Get(ctx context.Context, jobID string) (*Job, error) {
+	value, err := store.client.Get(ctx, store.jobKey(jobID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{}
+	if err := json.Unmarshal(value, job); err != nil {
+		return nil, fmt.Errorf("Could not decode the job %s: %v", jobID, err)
+	}
+	return job, nil
+}
+
+// Close closes the Redis connection
+func (store *RedisJobStore) Close() error {
+	return store.client.Close()
+}
@@ -0,0 +1,97 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+)
+
+// Job store names accepted in the configuration
+const (
+	MemoryStore = "memory"
+	RedisStore  = "redis"
+)
+
+// DefaultRedisKeyPrefix is used when no Redis key prefix is configured
+const DefaultRedisKeyPrefix = "jobs"
+
+// DefaultTTL is used when no job TTL is configured
+const DefaultTTL = 24 * time.Hour
+
+// Status is the state of a job
+type Status string
+
+// Job statuses, a job is queued until a worker runs it and then either succeeds or fails
+const (
+	Queued    Status = "queued"
+	Running   Status = "running"
+	Succeeded Status = "succeeded"
+	Failed    Status = "failed"
+)
+
+// Done reports whether the job reached a final status
+func (status Status) Done() bool {
+	return status == Succeeded || status == Failed
+}
+
+// Job is an asynchronous job of a user, stored as JSON
+type Job struct {
+	ID     string `json:"id"`
+	UserID string `json:"userId"`
+	Status Status `json:"status"`
+	// CallbackURL receives the job once done, if set
+	CallbackURL string `json:"callbackUrl,omitempty"`
+	// Result is the JSON result of the succeeded jobs
+	Result json.RawMessage `json:"result,omitempty"`
+	// Error is the error envelope of the failed jobs
+	Error     *errors.Envelope `json:"error,omitempty"`
+	CreatedAt time.Time        `json:"createdAt"`
+	UpdatedAt time.Time        `json:"updatedAt"`
+}
+
+// Storer defines the interface for the backends holding the jobs
+type Storer interface {
+	// Save creates or replaces the job, keeping it for the TTL of the store
+	Save(ctx context.Context, job *Job) error
+	// Get returns the job with the ID, nil when there is none or it expired
+	Get(ctx context.Context, jobID string) (*Job, error)
+	// Close releases the resources of the store
+	Close() error
+}
+
+// NewStore creates the job store selected in the configuration
+func NewStore(configurations *config.Config) (Storer, error) {
+	jobsConfig := configurations.Jobs
+	ttl := jobsConfig.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	switch storeName := strings.ToLower(jobsConfig.Store); storeName {
+	case "", MemoryStore:
+		return NewMemoryJobStore(ttl), nil
+	case RedisStore:
+		if jobsConfig.Redis.Address == "" {
+			return nil, fmt.Errorf("Redis address is required for the job store")
+		}
+		keyPrefix := jobsConfig.Redis.KeyPrefix
+		if keyPrefix == "" {
+			keyPrefix = DefaultRedisKeyPrefix
+		}
+		client := redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs:    strings.Split(jobsConfig.Redis.Address, ","),
+			Password: jobsConfig.Redis.Password,
+			DB:       jobsConfig.Redis.DB,
+		})
+		return NewRedisJobStore(client, keyPrefix, ttl), nil
+	default:
+		return nil, fmt.Errorf("Unknown job store %q", storeName)
+	}
+}
@@ -0,0 +1,132 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+)
+
+func TestStores(t *testing.T) {
+	ctx := context.Background()
+	redisServer := miniredis.RunT(t)
+	stores := map[string]func() Storer{
+		"Memory": func() Storer {
+			return NewMemoryJobStore(time.Hour)
+		},
+		"Redis": func() Storer {
+			redisServer.FlushAll()
+			client := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+			return NewRedisJobStore(client, DefaultRedisKeyPrefix, time.Hour)
+		},
+	}
+	createdAt := time.Date(2026, time.October, 18, 10, 0, 0, 0, time.UTC)
+
+	for name, newStore := range stores {
+		t.Run(name+"_Save_And_Get", func(t *testing.T) {
+			store := newStore()
+			defer store.Close()
+			job := &Job{ID: "job-id", UserID: "user", Status: Queued, CreatedAt: createdAt, UpdatedAt: createdAt}
+
+			assert.NoError(t, store.Save(ctx, job))
+			job.Status = Succeeded
+			job.Result = json.RawMessage(`{"responseToPrompt":"A cat"}`)
+			assert.NoError(t, store.Save(ctx, job))
+
+			stored, err := store.Get(ctx, "job-id")
+			assert.NoError(t, err)
+			assert.Equal(t, job, stored)
+		})
+
+		t.Run(name+"_Failed_Job_Error", func(t *testing.T) {
+			store := newStore()
+			defer store.Close()
+			job := &Job{
+				ID:        "job-id",
+				UserID:    "user",
+				Status:    Failed,
+				Error:     &errors.Envelope{Code: errors.Unavailable, Message: "The service is unavailable"},
+				CreatedAt: createdAt,
+				UpdatedAt: createdAt,
+			}
+
+			assert.NoError(t, store.Save(ctx, job))
+
+			stored, err := store.Get(ctx, "job-id")
+			assert.NoError(t, err)
+			assert.Equal(t, job.Error, stored.Error)
+		})
+
+		t.Run(name+"_Missing_Job_Nil", func(t *testing.T) {
+			store := newStore()
+			defer store.Close()
+
+			stored, err := store.Get(ctx, "missing")
+
+			assert.NoError(t, err)
+			assert.Nil(t, stored)
+		})
+	}
+
+	t.Run("Memory_Jobs_Expire", func(t *testing.T) {
+		store := NewMemoryJobStore(time.Hour)
+		defer store.Close()
+		now := createdAt
+		store.now = func() time.Time { return now }
+		assert.NoError(t, store.Save(ctx, &Job{ID: "expired", Status: Queued}))
+
+		now = now.Add(time.Hour)
+		expired, err := store.Get(ctx, "expired")
+		assert.NoError(t, err)
+		assert.NoError(t, store.Save(ctx, &Job{ID: "job-id", Status: Queued}))
+		store.removeExpired()
+
+		assert.Nil(t, expired)
+		assert.NotContains(t, store.jobs, "expired")
+		assert.Contains(t, store.jobs, "job-id")
+	})
+
+	t.Run("Redis_Jobs_Expire", func(t *testing.T) {
+		redisServer.FlushAll()
+		client := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+		store := NewRedisJobStore(client, DefaultRedisKeyPrefix, time.Hour)
+		defer store.Close()
+		assert.NoError(t, store.Save(ctx, &Job{ID: "job-id", Status: Queued}))
+
+		assert.Equal(t, time.Hour, redisServer.TTL("jobs:job-id"))
+		redisServer.FastForward(time.Hour)
+		stored, err := store.Get(ctx, "job-id")
+
+		assert.NoError(t, err)
+		assert.Nil(t, stored)
+	})
+}
+
+func TestNewStore(t *testing.T) {
+	t.Run("Memory_By_Default", func(t *testing.T) {
+		store, err := NewStore(&config.Config{})
+
+		assert.NoError(t, err)
+		assert.IsType(t, &MemoryJobStore{}, store)
+		assert.Equal(t, DefaultTTL, store.(*MemoryJobStore).ttl)
+	})
+
+	t.Run("Redis_Without_Address_Error", func(t *testing.T) {
+		_, err := NewStore(&config.Config{Jobs: config.JobsConfig{Store: RedisStore}})
+
+		assert.Equal(t, "Redis address is required for the job store", err.Error())
+	})
+
+	t.Run("Unknown_Store_Error", func(t *testing.T) {
+		_, err := NewStore(&config.Config{Jobs: config.JobsConfig{Store: "disk"}})
+
+		assert.Equal(t, `Unknown job store "disk"`, err.Error())
+	})
+}
@@ -0,0 +1,233 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+)
+
+// Headers of the webhook deliveries
+const (
+	WebhookIDHeader        = "X-Webhook-Id"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// signaturePrefix names the algorithm of the webhook signatures
+const signaturePrefix = "sha256="
+
+// Defaults used when the webhook configuration leaves them unset
+const (
+	DefaultWebhookTimeout     = 10 * time.Second
+	DefaultWebhookMaxAttempts = 3
+	DefaultWebhookWorkers     = 4
+	DefaultWebhookQueueSize   = 256
+)
+
+// defaultRetryBackoff is the wait before the second delivery attempt, doubled before each next one
+const defaultRetryBackoff = time.Second
+
+// Sign returns the signature of a webhook delivery: the hex HMAC-SHA256 of "<timestamp>.<body>" with the
+// secret, prefixed with sha256=. Signing the timestamp lets the receivers reject replayed deliveries.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// nonPublicNetworks are the special purpose ranges of the IANA registries, which the callbacks must not reach
+var nonPublicNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "this" network
+	netip.MustParsePrefix("10.0.0.0/8"),      // private
+	netip.MustParsePrefix("100.64.0.0/10"),   // carrier-grade NAT
+	netip.MustParsePrefix("127.0.0.0/8"),     // loopback
+	netip.MustParsePrefix("169.254.0.0/16"),  // link local, cloud metadata services
+	netip.MustParsePrefix("172.16.0.0/12"),   // private
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation
+	netip.MustParsePrefix("192.88.99.0/24"),  // 6to4 relay anycast
+	netip.MustParsePrefix("192.168.0.0/16"),  // private
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation
+	netip.MustParsePrefix("224.0.0.0/4"),     // multicast
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved and broadcast
+	netip.MustParsePrefix("::/128"),          // unspecified
+	netip.MustParsePrefix("::1/128"),         // loopback
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64, translating to any IPv4
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local NAT64
+	netip.MustParsePrefix("100::/64"),        // discard
+	netip.MustParsePrefix("2001::/23"),       // IETF protocol assignments, Teredo included
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("2002::/16"),       // 6to4, translating to any IPv4
+	netip.MustParsePrefix("fc00::/7"),        // unique local
+	netip.MustParsePrefix("fe80::/10"),       // link local
+	netip.MustParsePrefix("ff00::/8"),        // multicast
+}
+
+// isPublicIP reports whether the IP can be reached from the internet, so that the callbacks cannot probe
+// the networks of the gateway. The IPv4-mapped IPv6 addresses are checked as the IPv4 ones they map.
+func isPublicIP(ip net.IP) bool {
+	address, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	address = address.Unmap()
+	for _, network := range nonPublicNetworks {
+		if network.Contains(address) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublicOnly refuses the connections to non public IPs, checked once the host is resolved so that a
+// public host name resolving to a private IP is refused too
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("Callback address %s is not public", host)
+	}
+	return nil
+}
+
+// Notifier delivers the jobs to their callback URLs, signed with the configured secret
+type Notifier struct {
+	client        *http.Client
+	secret        string
+	maxAttempts   int
+	retryBackoff  time.Duration
+	allowInsecure bool
+	now           func() time.Time
+}
+
+// NewNotifier creates a webhook notifier from the configuration. Without a secret the callbacks are disabled.
+func NewNotifier(webhookConfig config.WebhookConfig) *Notifier {
+	timeout := webhookConfig.Timeout
+	if timeout <= 0 {
+		timeout = DefaultWebhookTimeout
+	}
+	maxAttempts := webhookConfig.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultWebhookMaxAttempts
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	if !webhookConfig.AllowInsecure {
+		dialer.Control = dialPublicOnly
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &Notifier{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+			// The redirects are not followed, the callback URL is the only one validated
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		secret:        webhookConfig.Secret,
+		maxAttempts:   maxAttempts,
+		retryBackoff:  defaultRetryBackoff,
+		allowInsecure: webhookConfig.AllowInsecure,
+		now:           time.Now,
+	}
+}
+
+// Enabled reports whether the callbacks can be delivered, which requires a secret to sign them
+func (notifier *Notifier) Enabled() bool {
+	return notifier.secret != ""
+}
+
+// ValidateCallbackURL checks that the callback URL is an absolute https URL without credentials, http being
+// accepted when insecure callbacks are allowed, and that its host is not a non public IP. The errors describe
+// the URL, to follow the name of the field holding it.
+func (notifier *Notifier) ValidateCallbackURL(rawURL string) error {
+	if !notifier.Enabled() {
+		return fmt.Errorf("is not supported, the callbacks are not enabled")
+	}
+	callbackURL, err := url.Parse(rawURL)
+	if err != nil || callbackURL.Host == "" {
+		return fmt.Errorf("must be an absolute URL")
+	}
+	scheme := strings.ToLower(callbackURL.Scheme)
+	if scheme != "https" && !(notifier.allowInsecure && scheme == "http") {
+		return fmt.Errorf("must be an https URL")
+	}
+	if callbackURL.User != nil {
+		return fmt.Errorf("must not contain credentials")
+	}
+	if notifier.allowInsecure {
+		return nil
+	}
+	host := callbackURL.Hostname()
+	if strings.EqualFold(host, "localhost") {
+		return fmt.Errorf("must not point to a private network")
+	}
+	if ip := net.ParseIP(host); ip != nil && !isPublicIP(ip) {
+		return fmt.Errorf("must not point to a private network")
+	}
+	return nil
+}
+
+// deliver posts the body once, returning whether a failure is worth retrying
+func (notifier *Notifier) deliver(ctx context.Context, callbackURL, jobID string, body []byte) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("Could not create the webhook request: %v", err)
+	}
+	timestamp := notifier.now().Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookIDHeader, jobID)
+	request.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(WebhookSignatureHeader, Sign(notifier.secret, timestamp, body))
+
+	response, err := notifier.client.Do(request)
+	if err != nil {
+		return true, fmt.Errorf("Could not deliver the webhook: %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode >= http.StatusOK && response.StatusCode < http.StatusMultipleChoices {
+		return false, nil
+	}
+	retry := response.StatusCode >= http.StatusInternalServerError || response.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("Webhook receiver answered %d", response.StatusCode)
+}
+
+// Deliver posts the JSON body to the callback URL, signed, retrying the network errors and the 5xx and 429
+// statuses with an exponential backoff. Every attempt carries the job ID, for the receivers to deduplicate them.
+func (notifier *Notifier) Deliver(ctx context.Context, callbackURL, jobID string, body []byte) error {
+	backoff := notifier.retryBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := notifier.deliver(ctx, callbackURL, jobID, body)
+		if err == nil || !retry || attempt >= notifier.maxAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%v, retries cancelled: %v", err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,57 @@
+package metering
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryMeteringStore keeps the usage counters in the memory of a single gateway replica
+type MemoryMeteringStore struct {
+	periods map[string]map[string]Usage
+	mtx     sync.RWMutex
+}
+
+var _ Storer = &MemoryMeteringStore{}
+
+// NewMemoryMeteringStore creates an in-memory metering store
+func NewMemoryMeteringStore() *MemoryMeteringStore {
+	return &MemoryMeteringStore{
+		periods: make(map[string]map[string]Usage),
+	}
+}
+
+// Usage returns the usage of the user in the billing period
+func (store *MemoryMeteringStore) Usage(ctx context.Context, userID, period string) (Usage, error) {
+	store.mtx.RLock()
+	defer store.mtx.RUnlock()
+
+	return store.periods[period][userID], nil
+}
+
+// Record adds the usage to the counters of the user in the billing period and returns the new totals.
+// The counters of the periods before it are dropped, they are no longer enforced nor reported.
+func (store *MemoryMeteringStore) Record(ctx context.Context, userID, period string, usage Usage) (Usage, error) {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	users, exists := store.periods[period]
+	if !exists {
+		for storedPeriod := range store.periods {
+			if storedPeriod < period {
+				delete(store.periods, storedPeriod)
+			}
+		}
+		users = make(map[string]Usage)
+		store.periods[period] = users
+	}
+	total := users[userID]
+	total.Requests += usage.Requests
+	total.Bytes += usage.Bytes
+	users[userID] = total
+	return total, nil
+}
+
+// Close does nothing, the memory store holds no external resources
+func (store *MemoryMeteringStore) Close() error {
+	return nil
+}
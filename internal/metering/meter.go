@@ -0,0 +1,251 @@
+package metering
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	commonJWT "github.com/quadev-ltd/qd-common/pkg/jwt"
+	commonLogger "github.com/quadev-ltd/qd-common/pkg/log"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/middleware"
+)
+
+// settlementKey is the key of the gin context holding the settlement of the metered request
+const settlementKey = "metering.settlement"
+
+// errByteQuotaExceeded fails the reads of the request bodies going over the byte quota
+var errByteQuotaExceeded = fmt.Errorf("The request body exceeds the byte quota of the plan")
+
+// countingReader counts the bytes read from the request body and fails the reads going over the limit,
+// which is negative when there is none
+type countingReader struct {
+	io.ReadCloser
+	count           int64
+	limit           int64
+	isLimitExceeded bool
+}
+
+func (reader *countingReader) Read(buffer []byte) (int, error) {
+	read, err := reader.ReadCloser.Read(buffer)
+	reader.count += int64(read)
+	if reader.limit >= 0 && reader.count > reader.limit {
+		reader.isLimitExceeded = true
+		return read, errByteQuotaExceeded
+	}
+	return read, err
+}
+
+// Meter counts the successful requests and uploaded bytes of each user and enforces the monthly quotas of their plan
+type Meter struct {
+	store  Storer
+	quotas map[string]config.QuotaConfig
+	now    func() time.Time
+}
+
+// NewMeter creates a meter keeping the counters in the store and enforcing the configured quotas
+func NewMeter(store Storer, configurations *config.Config) *Meter {
+	return &Meter{
+		store:  store,
+		quotas: configurations.Metering.Quotas,
+		now:    time.Now,
+	}
+}
+
+// quota returns the quota of the plan, the free plan quota when the plan has none
+func (meter *Meter) quota(plan string) config.QuotaConfig {
+	if quota, exists := meter.quotas[plan]; exists {
+		return quota
+	}
+	return meter.quotas[middleware.FreePlan]
+}
+
+// authenticatedUser returns the user ID and plan of the verified token
+func authenticatedUser(ctx *gin.Context) (string, string, bool) {
+	claims, ok := ctx.Value(string(commonJWT.ClaimsContextKey)).(*commonJWT.TokenClaims)
+	if !ok || claims.UserID == "" {
+		return "", "", false
+	}
+	return claims.UserID, middleware.EntitlementsFromClaims(claims, middleware.MapClaimsFromContext(ctx)).Plan, true
+}
+
+func logError(ctx context.Context, err error, message string) {
+	if logger, loggerErr := commonLogger.GetLoggerFromContext(ctx); loggerErr == nil {
+		logger.Error(err, message)
+	}
+}
+
+// abortQuotaExceeded responds 429 to the paid plan users, who have to wait for the next period,
+// and 402 to the users of the other plans, who can upgrade
+func (meter *Meter) abortQuotaExceeded(ctx *gin.Context, plan string, now time.Time) {
+	if plan == middleware.PaidPlan {
+		retryAfter := int64(PeriodEnd(now).Sub(now).Round(time.Second).Seconds())
+		ctx.Header(middleware.RetryAfterHeader, strconv.FormatInt(retryAfter, 10))
+		errors.Abort(ctx, errors.New(http.StatusTooManyRequests, errors.TooManyRequests, "The monthly quota of the plan is used up").
+			WithReason(errors.QuotaExceeded))
+		return
+	}
+	errors.Abort(ctx, errors.New(http.StatusPaymentRequired, errors.PaymentRequired, "The monthly quota of the plan is used up, upgrade the plan to continue").
+		WithReason(errors.QuotaExceeded))
+}
+
+// isExceeded tells whether the usage, including the request being checked, goes over the quota
+func isExceeded(quota config.QuotaConfig, usage Usage) bool {
+	return (quota.Requests > 0 && usage.Requests > quota.Requests) ||
+		(quota.Bytes > 0 && usage.Bytes > quota.Bytes)
+}
+
+// record adds the usage to the counters, even when the request context is cancelled, refunds included
+func (meter *Meter) record(ctx context.Context, userID, period string, usage Usage) {
+	if usage == (Usage{}) {
+		return
+	}
+	if _, err := meter.store.Record(context.WithoutCancel(ctx), userID, period, usage); err != nil {
+		logError(ctx, err, "Could not record the usage of the user")
+	}
+}
+
+// settlement settles the usage reserved for a request once its outcome is known
+type settlement struct {
+	meter      *Meter
+	userID     string
+	period     string
+	reserved   Usage
+	isReserved bool
+	body       *countingReader
+	// isDeferred is set by the handlers settling the request themselves once its outcome is known
+	isDeferred bool
+	once       sync.Once
+}
+
+// settle records the usage of a successful request and refunds the reservation of a failed one, once
+func (settlement *settlement) settle(ctx context.Context, isSuccessful bool) {
+	settlement.once.Do(func() {
+		meter, reserved := settlement.meter, settlement.reserved
+		switch {
+		case isSuccessful && settlement.isReserved:
+			// Settles the bytes reserved with the bytes read
+			meter.record(ctx, settlement.userID, settlement.period, Usage{Bytes: settlement.body.count - reserved.Bytes})
+		case isSuccessful:
+			meter.record(ctx, settlement.userID, settlement.period, Usage{Requests: 1, Bytes: settlement.body.count})
+		case settlement.isReserved:
+			meter.record(ctx, settlement.userID, settlement.period, Usage{Requests: -reserved.Requests, Bytes: -reserved.Bytes})
+		}
+	})
+}
+
+// DeferSettlement hands the metering of the request over to its handler, for the requests whose outcome is
+// only known once they are answered, such as the jobs and the event streams. The returned function records
+// the usage when called with a success and refunds it when called with a failure, the request body having
+// been read by then. A request answered with an error is settled as failed by the middleware. It does
+// nothing for the requests not metered.
+func DeferSettlement(ctx *gin.Context) func(ctx context.Context, isSuccessful bool) {
+	settlement, ok := ctx.Value(settlementKey).(*settlement)
+	if !ok {
+		return func(context.Context, bool) {}
+	}
+	settlement.isDeferred = true
+	return settlement.settle
+}
+
+// Middleware enforces the monthly quota of the authenticated user. It must run after the authentication
+// middleware. The request and its declared length are reserved up front, the store returning the totals
+// atomically so that concurrent requests cannot overrun the quota, and refunded when the handler fails or,
+// with DeferSettlement, when the outcome it reports later is a failure. The bodies without a declared
+// length fail to be read once they go over the bytes left in the quota.
+func (meter *Meter) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, plan, isAuthenticated := authenticatedUser(ctx)
+		if !isAuthenticated {
+			ctx.Next()
+			return
+		}
+		now := meter.now()
+		period := Period(now)
+		quota := meter.quota(plan)
+		reserved := Usage{Requests: 1, Bytes: max(ctx.Request.ContentLength, 0)}
+		body := &countingReader{ReadCloser: ctx.Request.Body, limit: -1}
+		total, err := meter.store.Record(ctx.Request.Context(), userID, period, reserved)
+		isReserved := err == nil
+		if err != nil {
+			// The quotas are not worth failing the requests the store cannot check
+			logError(ctx.Request.Context(), err, "Could not reserve the usage of the user")
+		} else {
+			if isExceeded(quota, total) {
+				meter.record(ctx.Request.Context(), userID, period, Usage{Requests: -reserved.Requests, Bytes: -reserved.Bytes})
+				meter.abortQuotaExceeded(ctx, plan, now)
+				return
+			}
+			if quota.Bytes > 0 {
+				body.limit = quota.Bytes - (total.Bytes - reserved.Bytes)
+			}
+		}
+		if ctx.Request.Body != nil {
+			ctx.Request.Body = body
+		}
+		settlement := &settlement{
+			meter:      meter,
+			userID:     userID,
+			period:     period,
+			reserved:   reserved,
+			isReserved: isReserved,
+			body:       body,
+		}
+		ctx.Set(settlementKey, settlement)
+
+		ctx.Next()
+
+		if body.isLimitExceeded && !ctx.Writer.Written() {
+			meter.abortQuotaExceeded(ctx, plan, now)
+		}
+		status := ctx.Writer.Status()
+		isSuccessful := !ctx.IsAborted() && !body.isLimitExceeded &&
+			status >= http.StatusOK && status < http.StatusMultipleChoices
+		if settlement.isDeferred && isSuccessful {
+			return
+		}
+		settlement.settle(ctx.Request.Context(), isSuccessful)
+	}
+}
+
+// usageResponse renders the usage and its limit, 0 is unlimited
+func usageResponse(used, limit int64) gin.H {
+	response := gin.H{"used": used}
+	if limit > 0 {
+		response["limit"] = limit
+		response["remaining"] = max(limit-used, 0)
+	}
+	return response
+}
+
+// GetUsage handles the HTTP request returning the usage of the authenticated user in the current period
+func (meter *Meter) GetUsage(ctx *gin.Context) {
+	userID, plan, isAuthenticated := authenticatedUser(ctx)
+	if !isAuthenticated {
+		errors.Abort(ctx, errors.New(http.StatusUnauthorized, errors.Unauthorized, "The request has no verified bearer token"))
+		return
+	}
+	now := meter.now()
+	period := Period(now)
+	usage, err := meter.store.Usage(ctx.Request.Context(), userID, period)
+	if err != nil {
+		logError(ctx.Request.Context(), err, "Could not get the usage of the user")
+		errors.Abort(ctx, errors.New(http.StatusServiceUnavailable, errors.Unavailable, "Could not get the usage of the user").WithCause(err))
+		return
+	}
+	quota := meter.quota(plan)
+	ctx.JSON(http.StatusOK, gin.H{
+		"plan":      plan,
+		"period":    period,
+		"resets_at": PeriodEnd(now),
+		"requests":  usageResponse(usage.Requests, quota.Requests),
+		"bytes":     usageResponse(usage.Bytes, quota.Bytes),
+	})
+}
@@ -0,0 +1,251 @@
+package metering
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	commonJWT "github.com/quadev-ltd/qd-common/pkg/jwt"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/middleware"
+)
+
+type failingStore struct {
+	*MemoryMeteringStore
+}
+
+func (store *failingStore) Usage(ctx context.Context, userID, period string) (Usage, error) {
+	return Usage{}, fmt.Errorf("unavailable")
+}
+
+func (store *failingStore) Record(ctx context.Context, userID, period string, usage Usage) (Usage, error) {
+	return Usage{}, fmt.Errorf("unavailable")
+}
+
+func newTestMeter(store Storer, now time.Time) *Meter {
+	meter := NewMeter(store, &config.Config{
+		Metering: config.MeteringConfig{Quotas: map[string]config.QuotaConfig{
+			middleware.FreePlan: {Requests: 1, Bytes: 10},
+			middleware.PaidPlan: {Requests: 2},
+		}},
+	})
+	meter.now = func() time.Time { return now }
+	return meter
+}
+
+func newTestRouter(meter *Meter, status int) *gin.Engine {
+	router := gin.New()
+	authenticate := func(ctx *gin.Context) {
+		ctx.Set(string(commonJWT.ClaimsContextKey), &commonJWT.TokenClaims{
+			UserID:          ctx.Query("user"),
+			HasPaidFeatures: ctx.Query("paid") == "true",
+		})
+		ctx.Set(string(commonJWT.JWTTokenKey), &jwt.Token{Claims: jwt.MapClaims{}})
+	}
+	router.POST("/image-analysis", authenticate, meter.Middleware(), func(ctx *gin.Context) {
+		io.ReadAll(ctx.Request.Body)
+		ctx.Status(status)
+	})
+	router.GET("/usage", authenticate, meter.GetUsage)
+	return router
+}
+
+func serve(router *gin.Engine, method, target, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return recorder
+}
+
+func TestMeter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	now := time.Date(2026, time.October, 31, 23, 0, 0, 0, time.UTC)
+
+	t.Run("Middleware_Records_Successful_Requests_And_Bytes", func(t *testing.T) {
+		store := NewMemoryMeteringStore()
+		router := newTestRouter(newTestMeter(store, now), http.StatusOK)
+
+		recorder := serve(router, http.MethodPost, "/image-analysis?user=user", "image")
+
+		usage, err := store.Usage(ctx, "user", "2026-10")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, Usage{Requests: 1, Bytes: 5}, usage)
+	})
+
+	t.Run("Middleware_Does_Not_Record_Failed_Requests", func(t *testing.T) {
+		store := NewMemoryMeteringStore()
+		router := newTestRouter(newTestMeter(store, now), http.StatusBadGateway)
+
+		serve(router, http.MethodPost, "/image-analysis?user=user", "image")
+
+		usage, err := store.Usage(ctx, "user", "2026-10")
+		assert.NoError(t, err)
+		assert.Equal(t, Usage{}, usage)
+	})
+
+	t.Run("Middleware_Free_Plan_Quota_Payment_Required", func(t *testing.T) {
+		router := newTestRouter(newTestMeter(NewMemoryMeteringStore(), now), http.StatusOK)
+
+		serve(router, http.MethodPost, "/image-analysis?user=user", "image")
+		recorder := serve(router, http.MethodPost, "/image-analysis?user=user", "image")
+		body := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+
+		assert.Equal(t, http.StatusPaymentRequired, recorder.Code)
+		assert.Equal(t, errors.QuotaExceeded, body["reason"])
+	})
+
+	t.Run("Middleware_Byte_Quota_Payment_Required", func(t *testing.T) {
+		router := newTestRouter(newTestMeter(NewMemoryMeteringStore(), now), http.StatusOK)
+
+		recorder := serve(router, http.MethodPost, "/image-analysis?user=user", "a larger image")
+
+		assert.Equal(t, http.StatusPaymentRequired, recorder.Code)
+	})
+
+	t.Run("Middleware_Chunked_Body_Over_Byte_Quota_Payment_Required", func(t *testing.T) {
+		store := NewMemoryMeteringStore()
+		router := newTestRouter(newTestMeter(store, now), http.StatusOK)
+		request := httptest.NewRequest(http.MethodPost, "/image-analysis?user=user", io.NopCloser(strings.NewReader("a larger image")))
+		request.ContentLength = -1
+		recorder := httptest.NewRecorder()
+
+		router.ServeHTTP(recorder, request)
+
+		usage, err := store.Usage(ctx, "user", "2026-10")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusPaymentRequired, recorder.Code)
+		assert.Equal(t, Usage{}, usage)
+	})
+
+	t.Run("Middleware_Concurrent_Requests_Reserve_Quota", func(t *testing.T) {
+		store := NewMemoryMeteringStore()
+		meter := newTestMeter(store, now)
+		started := make(chan struct{})
+		release := make(chan struct{})
+		router := gin.New()
+		router.POST("/image-analysis", func(ctx *gin.Context) {
+			ctx.Set(string(commonJWT.ClaimsContextKey), &commonJWT.TokenClaims{UserID: "user"})
+			ctx.Set(string(commonJWT.JWTTokenKey), &jwt.Token{Claims: jwt.MapClaims{}})
+		}, meter.Middleware(), func(ctx *gin.Context) {
+			close(started)
+			<-release
+			ctx.Status(http.StatusOK)
+		})
+		first := make(chan int)
+		go func() { first <- serve(router, http.MethodPost, "/image-analysis", "").Code }()
+		<-started
+
+		second := serve(router, http.MethodPost, "/image-analysis", "")
+		close(release)
+
+		assert.Equal(t, http.StatusPaymentRequired, second.Code)
+		assert.Equal(t, http.StatusOK, <-first)
+	})
+
+	t.Run("Middleware_Deferred_Settlement_Records_Outcome", func(t *testing.T) {
+		for _, isSuccessful := range []bool{true, false} {
+			store := NewMemoryMeteringStore()
+			settled := make(chan func(context.Context, bool), 1)
+			router := gin.New()
+			router.POST("/image-analysis", func(ctx *gin.Context) {
+				ctx.Set(string(commonJWT.ClaimsContextKey), &commonJWT.TokenClaims{UserID: "user"})
+				ctx.Set(string(commonJWT.JWTTokenKey), &jwt.Token{Claims: jwt.MapClaims{}})
+			}, newTestMeter(store, now).Middleware(), func(ctx *gin.Context) {
+				io.ReadAll(ctx.Request.Body)
+				settled <- DeferSettlement(ctx)
+				ctx.Status(http.StatusAccepted)
+			})
+
+			recorder := serve(router, http.MethodPost, "/image-analysis", "image")
+			pending, err := store.Usage(ctx, "user", "2026-10")
+			assert.NoError(t, err)
+			(<-settled)(ctx, isSuccessful)
+
+			usage, err := store.Usage(ctx, "user", "2026-10")
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusAccepted, recorder.Code)
+			assert.Equal(t, Usage{Requests: 1, Bytes: 5}, pending)
+			if isSuccessful {
+				assert.Equal(t, Usage{Requests: 1, Bytes: 5}, usage)
+			} else {
+				assert.Equal(t, Usage{}, usage)
+			}
+		}
+	})
+
+	t.Run("Middleware_Deferred_Settlement_Of_Failed_Request_Refunded", func(t *testing.T) {
+		store := NewMemoryMeteringStore()
+		router := gin.New()
+		router.POST("/image-analysis", func(ctx *gin.Context) {
+			ctx.Set(string(commonJWT.ClaimsContextKey), &commonJWT.TokenClaims{UserID: "user"})
+			ctx.Set(string(commonJWT.JWTTokenKey), &jwt.Token{Claims: jwt.MapClaims{}})
+		}, newTestMeter(store, now).Middleware(), func(ctx *gin.Context) {
+			DeferSettlement(ctx)
+			ctx.Status(http.StatusServiceUnavailable)
+		})
+
+		serve(router, http.MethodPost, "/image-analysis", "image")
+
+		usage, err := store.Usage(ctx, "user", "2026-10")
+		assert.NoError(t, err)
+		assert.Equal(t, Usage{}, usage)
+	})
+
+	t.Run("Middleware_Paid_Plan_Quota_Too_Many_Requests", func(t *testing.T) {
+		router := newTestRouter(newTestMeter(NewMemoryMeteringStore(), now), http.StatusOK)
+
+		serve(router, http.MethodPost, "/image-analysis?user=user&paid=true", "image")
+		serve(router, http.MethodPost, "/image-analysis?user=user&paid=true", "image")
+		recorder := serve(router, http.MethodPost, "/image-analysis?user=user&paid=true", "image")
+
+		assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		assert.Equal(t, "3600", recorder.Header().Get(middleware.RetryAfterHeader))
+	})
+
+	t.Run("Middleware_Store_Error_Lets_Request_Through", func(t *testing.T) {
+		router := newTestRouter(newTestMeter(&failingStore{NewMemoryMeteringStore()}, now), http.StatusOK)
+
+		recorder := serve(router, http.MethodPost, "/image-analysis?user=user", "image")
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("GetUsage_Current_Period", func(t *testing.T) {
+		store := NewMemoryMeteringStore()
+		_, err := store.Record(ctx, "user", "2026-10", Usage{Requests: 1, Bytes: 4})
+		assert.NoError(t, err)
+		router := newTestRouter(newTestMeter(store, now), http.StatusOK)
+
+		recorder := serve(router, http.MethodGet, "/usage?user=user", "")
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.JSONEq(t, `{
+			"plan": "free",
+			"period": "2026-10",
+			"resets_at": "2026-11-01T00:00:00Z",
+			"requests": {"used": 1, "limit": 1, "remaining": 0},
+			"bytes": {"used": 4, "limit": 10, "remaining": 6}
+		}`, recorder.Body.String())
+	})
+
+	t.Run("GetUsage_Store_Error", func(t *testing.T) {
+		router := newTestRouter(newTestMeter(&failingStore{NewMemoryMeteringStore()}, now), http.StatusOK)
+
+		recorder := serve(router, http.MethodGet, "/usage?user=user", "")
+
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	})
+}
@@ -0,0 +1,79 @@
+package metering
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Fields of the usage hash of a user
+const (
+	requestsField = "requests"
+	bytesField    = "bytes"
+)
+
+// redisUsageTTL keeps the counters of a period for a month after it ends, for billing reconciliation
+const redisUsageTTL = 62 * 24 * time.Hour
+
+// RedisMeteringStore shares the usage counters across gateway replicas through Redis.
+// The counters of a user are kept in the hash "<prefix>:<period>:<user ID>" with the fields
+// requests and bytes, so that the billing jobs can read them.
+type RedisMeteringStore struct {
+	client    redis.UniversalClient
+	keyPrefix string
+}
+
+var _ Storer = &RedisMeteringStore{}
+
+// NewRedisMeteringStore creates a Redis metering store
+func NewRedisMeteringStore(client redis.UniversalClient, keyPrefix string) *RedisMeteringStore {
+	return &RedisMeteringStore{
+		client:    client,
+		keyPrefix: keyPrefix,
+	}
+}
+
+func (store *RedisMeteringStore) usageKey(userID, period string) string {
+	return fmt.Sprintf("%s:%s:%s", store.keyPrefix, period, userID)
+}
+
+// Usage returns the usage of the user in the billing period
+func (store *RedisMeteringStore) Usage(ctx context.Context, userID, period string) (Usage, error) {
+	values, err := store.client.HGetAll(ctx, store.usageKey(userID, period)).Result()
+	if err != nil {
+		return Usage{}, err
+	}
+	usage := Usage{}
+	if value, exists := values[requestsField]; exists {
+		if usage.Requests, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return Usage{}, fmt.Errorf("Invalid request count for %s: %v", userID, err)
+		}
+	}
+	if value, exists := values[bytesField]; exists {
+		if usage.Bytes, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return Usage{}, fmt.Errorf("Invalid byte count for %s: %v", userID, err)
+		}
+	}
+	return usage, nil
+}
+
+// Record adds the usage to the counters of the user in the billing period and returns the new totals
+func (store *RedisMeteringStore) Record(ctx context.Context, userID, period string, usage Usage) (Usage, error) {
+	key := store.usageKey(userID, period)
+	pipeline := store.client.TxPipeline()
+	requests := pipeline.HIncrBy(ctx, key, requestsField, usage.Requests)
+	bytes := pipeline.HIncrBy(ctx, key, bytesField, usage.Bytes)
+	pipeline.Expire(ctx, key, redisUsageTTL)
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return Usage{}, err
+	}
+	return Usage{Requests: requests.Val(), Bytes: bytes.Val()}, nil
+}
+
+// Close closes the Redis connection
+func (store *RedisMeteringStore) Close() error {
+	return store.client.Close()
+}
@@ -0,0 +1,22 @@
+package metering
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/middleware"
+)
+
+// RegisterRoutes registers the usage routes with the provided router group,
+// applying the configured authorization and rate limit policies
+func RegisterRoutes(
+	meter *Meter,
+	api *gin.RouterGroup,
+	authMiddleware middleware.AutheticationMiddlewarer,
+	routePolicies []middleware.RoutePolicier,
+) error {
+	router := middleware.NewPolicyRouter(api, routePolicies...)
+
+	router.GET("/usage", authMiddleware.RequireAuthentication, meter.GetUsage)
+
+	return router.Err()
+}
@@ -0,0 +1,77 @@
+package metering
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+)
+
+// Metering store names accepted in the configuration
+const (
+	MemoryStore = "memory"
+	RedisStore  = "redis"
+)
+
+// DefaultRedisKeyPrefix is used when no Redis key prefix is configured
+const DefaultRedisKeyPrefix = "metering"
+
+// periodLayout formats the monthly billing periods, sorting as strings the way they sort in time
+const periodLayout = "2006-01"
+
+// Usage holds the successful requests and uploaded bytes of a user in a billing period
+type Usage struct {
+	Requests int64
+	Bytes    int64
+}
+
+// Storer defines the interface for the backends holding the usage counters
+type Storer interface {
+	// Usage returns the usage of the user in the billing period
+	Usage(ctx context.Context, userID, period string) (Usage, error)
+	// Record adds the usage to the counters of the user in the billing period and returns the new totals
+	Record(ctx context.Context, userID, period string, usage Usage) (Usage, error)
+	// Close releases the resources of the store
+	Close() error
+}
+
+// Period returns the monthly billing period of the time, in UTC
+func Period(now time.Time) string {
+	return now.UTC().Format(periodLayout)
+}
+
+// PeriodEnd returns the time the billing period of the time ends and the counters reset, in UTC
+func PeriodEnd(now time.Time) time.Time {
+	year, month, _ := now.UTC().Date()
+	return time.Date(year, month+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// NewStore creates the metering store selected in the configuration
+func NewStore(configurations *config.Config) (Storer, error) {
+	meteringConfig := configurations.Metering
+
+	switch storeName := strings.ToLower(meteringConfig.Store); storeName {
+	case "", MemoryStore:
+		return NewMemoryMeteringStore(), nil
+	case RedisStore:
+		if meteringConfig.Redis.Address == "" {
+			return nil, fmt.Errorf("Redis address is required for the metering store")
+		}
+		keyPrefix := meteringConfig.Redis.KeyPrefix
+		if keyPrefix == "" {
+			keyPrefix = DefaultRedisKeyPrefix
+		}
+		client := redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs:    strings.Split(meteringConfig.Redis.Address, ","),
+			Password: meteringConfig.Redis.Password,
+			DB:       meteringConfig.Redis.DB,
+		})
+		return NewRedisMeteringStore(client, keyPrefix), nil
+	default:
+		return nil, fmt.Errorf("Unknown metering store %q", storeName)
+	}
+}
@@ -0,0 +1,90 @@
+package metering
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+)
+
+func TestPeriod(t *testing.T) {
+	t.Run("Period_And_End_In_UTC", func(t *testing.T) {
+		now := time.Date(2026, time.December, 31, 23, 30, 0, 0, time.FixedZone("CET", -3600))
+
+		assert.Equal(t, "2027-01", Period(now))
+		assert.Equal(t, time.Date(2027, time.February, 1, 0, 0, 0, 0, time.UTC), PeriodEnd(now))
+	})
+}
+
+func TestStores(t *testing.T) {
+	ctx := context.Background()
+	redisServer := miniredis.RunT(t)
+	stores := map[string]func() Storer{
+		"Memory": func() Storer {
+			return NewMemoryMeteringStore()
+		},
+		"Redis": func() Storer {
+			redisServer.FlushAll()
+			client := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+			return NewRedisMeteringStore(client, DefaultRedisKeyPrefix)
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name+"_Record_Per_User_And_Period", func(t *testing.T) {
+			store := newStore()
+			defer store.Close()
+
+			_, err := store.Record(ctx, "user", "2026-10", Usage{Requests: 1, Bytes: 100})
+			assert.NoError(t, err)
+			total, err := store.Record(ctx, "user", "2026-10", Usage{Requests: 1, Bytes: 50})
+			assert.NoError(t, err)
+			_, err = store.Record(ctx, "another-user", "2026-10", Usage{Requests: 1, Bytes: 10})
+			assert.NoError(t, err)
+
+			usage, err := store.Usage(ctx, "user", "2026-10")
+			assert.NoError(t, err)
+			nextPeriodUsage, err := store.Usage(ctx, "user", "2026-11")
+			assert.NoError(t, err)
+			assert.Equal(t, Usage{Requests: 2, Bytes: 150}, total)
+			assert.Equal(t, total, usage)
+			assert.Equal(t, Usage{}, nextPeriodUsage)
+		})
+	}
+
+	t.Run("Memory_Drops_Previous_Periods", func(t *testing.T) {
+		store := NewMemoryMeteringStore()
+
+		_, err := store.Record(ctx, "user", "2026-10", Usage{Requests: 1})
+		assert.NoError(t, err)
+		_, err = store.Record(ctx, "user", "2026-11", Usage{Requests: 1})
+		assert.NoError(t, err)
+
+		usage, err := store.Usage(ctx, "user", "2026-10")
+		assert.NoError(t, err)
+		assert.Equal(t, Usage{}, usage)
+	})
+
+	t.Run("Redis_Keys_Readable_By_Billing", func(t *testing.T) {
+		redisServer.FlushAll()
+		store := NewRedisMeteringStore(redis.NewClient(&redis.Options{Addr: redisServer.Addr()}), DefaultRedisKeyPrefix)
+		defer store.Close()
+
+		_, err := store.Record(ctx, "user", "2026-10", Usage{Requests: 1, Bytes: 100})
+		assert.NoError(t, err)
+
+		assert.Equal(t, "100", redisServer.HGet("metering:2026-10:user", "bytes"))
+		assert.Equal(t, redisUsageTTL, redisServer.TTL("metering:2026-10:user"))
+	})
+
+	t.Run("NewStore_Unknown_Store_Error", func(t *testing.T) {
+		_, err := NewStore(&config.Config{Metering: config.MeteringConfig{Store: "memcached"}})
+
+		assert.Equal(t, "Unknown metering store \"memcached\"", err.Error())
+	})
+}
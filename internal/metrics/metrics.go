@@ -0,0 +1,161 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/connection"
+)
+
+// Path is the route where the metrics are exposed
+const Path = "/metrics"
+
+const (
+	namespace      = "gateway"
+	unmatchedRoute = "unmatched"
+)
+
+// Registry is the registry holding every gateway metric
+var Registry = prometheus.NewRegistry()
+
+// HTTPRequestDuration observes the latency of every HTTP request by method, route template and status
+var HTTPRequestDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of HTTP requests by method, route template and status code.",
+		Buckets:   prometheus.DefBuckets,
+	},
+	[]string{"method", "route", "status"},
+)
+
+// GRPCClientDuration observes the latency of every outgoing gRPC call by service, method and status code
+var GRPCClientDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "grpc_client",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of outgoing gRPC calls by service, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	},
+	[]string{"grpc_service", "grpc_method", "grpc_code"},
+)
+
+// RateLimitRejections counts the requests rejected by the rate limiter by route template
+var RateLimitRejections = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "rate_limit",
+		Name:      "rejections_total",
+		Help:      "Requests rejected by the rate limiter by route template.",
+	},
+	[]string{"route"},
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestDuration,
+		GRPCClientDuration,
+		RateLimitRejections,
+	)
+}
+
+// RouteTemplate returns the registered route template of the request rather than its raw path
+func RouteTemplate(ctx *gin.Context) string {
+	route := ctx.FullPath()
+	if route == "" {
+		return unmatchedRoute
+	}
+	return route
+}
+
+// GinMiddleware records the latency of every request handled by the router
+func GinMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+		HTTPRequestDuration.WithLabelValues(
+			ctx.Request.Method,
+			RouteTemplate(ctx),
+			strconv.Itoa(ctx.Writer.Status()),
+		).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler returns the handler exposing the registry in the Prometheus format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// NewServer creates the server exposing the metrics on its own address, apart from the public API
+func NewServer(address string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle(Path, Handler())
+	return &http.Server{
+		Addr:    address,
+		Handler: mux,
+	}
+}
+
+// UnaryClientInterceptor records the latency and status code of every outgoing unary gRPC call
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		service, methodName := connection.SplitMethodName(method)
+		GRPCClientDuration.WithLabelValues(
+			service,
+			methodName,
+			status.Code(err).String(),
+		).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// StreamClientInterceptor records the latency and status code of every outgoing gRPC stream, from its start
+// until it ends
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		start := time.Now()
+		service, methodName := connection.SplitMethodName(method)
+		observe := func(err error) {
+			GRPCClientDuration.WithLabelValues(
+				service,
+				methodName,
+				status.Code(err).String(),
+			).Observe(time.Since(start).Seconds())
+		}
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			observe(err)
+			return nil, err
+		}
+		return connection.WrapClientStream(ctx, stream, desc, observe), nil
+	}
+}
@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// clientStreamStub is an outgoing stream whose messages received fail with the error
+type clientStreamStub struct {
+	grpc.ClientStream
+	err error
+}
+
+func (stream *clientStreamStub) RecvMsg(message interface{}) error {
+	return stream.err
+}
+
+// sampleCount returns how many observations a histogram series holds
+func sampleCount(t *testing.T, name string, labels map[string]string) uint64 {
+	families, err := Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			matches := true
+			for _, label := range metric.GetLabel() {
+				if value, exists := labels[label.GetName()]; exists && value != label.GetValue() {
+					matches = false
+				}
+			}
+			if matches {
+				return metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
+
+func TestMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("GinMiddleware_Records_Route_Template", func(t *testing.T) {
+		router := gin.New()
+		router.Use(GinMiddleware())
+		router.POST("/user/:userID/email/:verificationToken", func(ctx *gin.Context) {
+			ctx.Status(http.StatusAccepted)
+		})
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/user/123/email/abc", nil))
+
+		assert.Equal(t, http.StatusAccepted, recorder.Code)
+		assert.Equal(t, uint64(1), sampleCount(t, "gateway_http_request_duration_seconds", map[string]string{
+			"method": http.MethodPost,
+			"route":  "/user/:userID/email/:verificationToken",
+			"status": "202",
+		}))
+	})
+
+	t.Run("GinMiddleware_Records_Unmatched_Route", func(t *testing.T) {
+		router := gin.New()
+		router.Use(GinMiddleware())
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/random/path", nil))
+
+		assert.Equal(t, uint64(1), sampleCount(t, "gateway_http_request_duration_seconds", map[string]string{
+			"route":  unmatchedRoute,
+			"status": "404",
+		}))
+	})
+
+	t.Run("UnaryClientInterceptor_Records_Status_Code", func(t *testing.T) {
+		interceptor := UnaryClientInterceptor()
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			return status.Error(codes.NotFound, "example error")
+		}
+
+		err := interceptor(context.Background(), "/pb_authentication.AuthenticationService/Register", nil, nil, nil, invoker)
+
+		assert.Error(t, err)
+		assert.Equal(t, uint64(1), sampleCount(t, "gateway_grpc_client_request_duration_seconds", map[string]string{
+			"grpc_service": "pb_authentication.AuthenticationService",
+			"grpc_method":  "Register",
+			"grpc_code":    codes.NotFound.String(),
+		}))
+	})
+
+	t.Run("StreamClientInterceptor_Records_Stream_End", func(t *testing.T) {
+		interceptor := StreamClientInterceptor()
+		streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return &clientStreamStub{err: status.Error(codes.Unimplemented, "example error")}, nil
+		}
+		labels := map[string]string{
+			"grpc_service": "src.pb.ImageAnalysisService",
+			"grpc_method":  "ProcessImageAndPromptStream",
+			"grpc_code":    codes.Unimplemented.String(),
+		}
+
+		stream, err := interceptor(context.Background(), &grpc.StreamDesc{ClientStreams: true}, nil, "/src.pb.ImageAnalysisService/ProcessImageAndPromptStream", streamer)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(0), sampleCount(t, "gateway_grpc_client_request_duration_seconds", labels))
+		assert.Error(t, stream.RecvMsg(nil))
+
+		assert.Equal(t, uint64(1), sampleCount(t, "gateway_grpc_client_request_duration_seconds", labels))
+	})
+
+	t.Run("Handler_Exposes_Metrics", func(t *testing.T) {
+		RateLimitRejections.WithLabelValues("/user/sessions").Inc()
+		server := NewServer(":0")
+
+		recorder := httptest.NewRecorder()
+		server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, Path, nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.True(t, strings.Contains(recorder.Body.String(), "gateway_rate_limit_rejections_total"))
+		assert.Equal(t, float64(1), testutil.ToFloat64(RateLimitRejections.WithLabelValues("/user/sessions")))
+	})
+}
@@ -16,6 +16,8 @@ import (
 	commonToken "github.com/quadev-ltd/qd-common/pkg/token"
 
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/revocation"
 )
 
 // ServiceClienter defines the interface for services that can provide public keys
@@ -29,8 +31,10 @@ type AutheticationMiddlewarer interface {
 	RequireAuthentication(ctx *gin.Context)
 	// RefreshAuthentication ensures the request has a valid refresh token
 	RefreshAuthentication(ctx *gin.Context)
-	// RequirePaidFeatures ensures the request has a valid authentication token with HasPaidFeatures set to true
-	RequirePaidFeatures(ctx *gin.Context)
+	// HasPublicKeys reports whether a verified public key is loaded
+	HasPublicKeys() bool
+	// Stop stops the background refresh of the public keys
+	Stop()
 }
 
 // AutheticationMiddleware implements the AutheticationMiddlewarer interface
@@ -38,26 +42,34 @@ type AutheticationMiddleware struct {
 	service           ServiceClienter
 	jwtVerifier       commonJWT.TokenVerifierer
 	jwtTokenInspector commonJWT.TokenInspectorer
+	revocations       revocation.Storer
 }
 
 var _ AutheticationMiddlewarer = &AutheticationMiddleware{}
 
-// InitAuthenticationMiddleware initializes a new authentication middleware with the provided service and configuration
-func InitAuthenticationMiddleware(authenticationService ServiceClienter, configurations *config.Config) (AutheticationMiddlewarer, error) {
+// InitAuthenticationMiddleware initializes a new authentication middleware with the provided service, configuration
+// and store of revoked tokens
+func InitAuthenticationMiddleware(
+	authenticationService ServiceClienter,
+	configurations *config.Config,
+	revocations revocation.Storer,
+) (AutheticationMiddlewarer, error) {
 	correlationID := uuid.New().String()
 	publicKey, err := RequestPublicKey(authenticationService, correlationID, configurations.Environment, backoffDelay)
 	if err != nil {
 		return nil, err
 	}
-	jwtVerifier, err := commonJWT.NewTokenVerifier(*publicKey)
-	if err != nil {
+	keySet := NewPublicKeySet(authenticationService, configurations)
+	if err := keySet.Load(*publicKey); err != nil {
 		return nil, err
 	}
+	keySet.Start()
 	jwtTokenInspector := &commonJWT.TokenInspector{}
 	return &AutheticationMiddleware{
 		authenticationService,
-		jwtVerifier,
+		keySet,
 		jwtTokenInspector,
+		revocations,
 	}, nil
 }
 
@@ -122,17 +134,14 @@ func (autheticationMiddleware *AutheticationMiddleware) RefreshAuthentication(ct
 func ParseAccessToken(ctx *gin.Context) *string {
 	logger, err := commonLogger.GetLoggerFromContext(ctx.Request.Context())
 	if err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, err)
+		errors.Abort(ctx, errors.New(http.StatusInternalServerError, errors.Internal, "Could not get the logger of the request").WithCause(err))
 		return nil
 	}
 	authorization := ctx.Request.Header.Get("Authorization")
 
 	if authorization == "" {
 		logger.Error(nil, "No authorization header was present in the request")
-		ctx.AbortWithError(
-			http.StatusForbidden,
-			fmt.Errorf("No authorization header was present in the request"),
-		)
+		errors.Abort(ctx, errors.New(http.StatusForbidden, errors.Unauthorized, "No authorization header was present in the request"))
 		return nil
 	}
 
@@ -140,10 +149,7 @@ func ParseAccessToken(ctx *gin.Context) *string {
 
 	if len(token) < 2 {
 		logger.Error(nil, "No bearer token was present in the authorization header")
-		ctx.AbortWithError(
-			http.StatusUnauthorized,
-			fmt.Errorf("No bearer token was present in the authorization header"),
-		)
+		errors.Abort(ctx, errors.New(http.StatusUnauthorized, errors.Unauthorized, "No bearer token was present in the authorization header"))
 		return nil
 	}
 	return &token[1]
@@ -155,7 +161,7 @@ func (autheticationMiddleware *AutheticationMiddleware) verifyToken(
 ) (*jwt.Token, bool) {
 	logger, err := commonLogger.GetLoggerFromContext(ctx.Request.Context())
 	if err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, err)
+		errors.Abort(ctx, errors.New(http.StatusInternalServerError, errors.Internal, "Could not get the logger of the request").WithCause(err))
 		return nil, false
 	}
 	parsedAuthorizationToken := ParseAccessToken(ctx)
@@ -164,24 +170,45 @@ func (autheticationMiddleware *AutheticationMiddleware) verifyToken(
 	}
 	parsedToken, err := autheticationMiddleware.jwtVerifier.Verify(*parsedAuthorizationToken)
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusUnauthorized, "The bearer token was invalid")
+		errors.Abort(ctx, errors.New(http.StatusUnauthorized, errors.Unauthorized, "The bearer token was invalid").WithCause(err))
 		return nil, false
 	}
 	claims, err := autheticationMiddleware.jwtTokenInspector.GetClaimsFromToken(parsedToken)
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusUnauthorized, "Could not obtain claims from bearer token")
+		errors.Abort(ctx, errors.New(http.StatusUnauthorized, errors.Unauthorized, "Could not obtain claims from bearer token").WithCause(err))
 		return nil, false
 	}
 	if commonToken.Type(claims.Type) != expectedTokenType {
-		ctx.AbortWithStatusJSON(http.StatusUnauthorized, fmt.Sprintf("The bearer token was not an %s but a %s", expectedTokenType, claims.Type))
+		errors.Abort(ctx, errors.New(
+			http.StatusUnauthorized,
+			errors.Unauthorized,
+			fmt.Sprintf("The bearer token was not an %s but a %s", expectedTokenType, claims.Type),
+		))
 		return nil, false
 	}
 
 	if claims.Expiry.Before(time.Now()) {
-		ctx.AbortWithStatusJSON(http.StatusUnauthorized, "The bearer token has expired")
+		errors.Abort(ctx, errors.New(http.StatusUnauthorized, errors.Unauthorized, "The bearer token has expired").WithReason(errors.TokenExpired))
 		return nil, false
 	}
 
+	if autheticationMiddleware.revocations != nil {
+		isRevoked, err := revocation.IsRevoked(ctx.Request.Context(), autheticationMiddleware.revocations, revocation.Token{
+			ID:       revocation.TokenID(parsedToken, *parsedAuthorizationToken),
+			UserID:   claims.UserID,
+			IssuedAt: revocation.IssuedAt(parsedToken),
+		})
+		if err != nil {
+			logger.Error(err, "Could not check whether the bearer token was revoked")
+			errors.Abort(ctx, errors.New(http.StatusServiceUnavailable, errors.Unavailable, "Could not check whether the bearer token was revoked").WithCause(err))
+			return nil, false
+		}
+		if isRevoked {
+			errors.Abort(ctx, errors.New(http.StatusUnauthorized, errors.Unauthorized, "The bearer token has been revoked").WithReason(errors.TokenRevoked))
+			return nil, false
+		}
+	}
+
 	newContext := commonJWT.AddAuthorizationMetadataToContext(ctx.Request.Context(), *parsedAuthorizationToken)
 	ctx.Request = ctx.Request.WithContext(newContext)
 	ctx.Set(string(commonJWT.ClaimsContextKey), claims)
@@ -191,30 +218,18 @@ func (autheticationMiddleware *AutheticationMiddleware) verifyToken(
 	return parsedToken, true
 }
 
-// RequirePaidFeatures middleware ensures the request has a valid authentication token with HasPaidFeatures set to true
-func (autheticationMiddleware *AutheticationMiddleware) RequirePaidFeatures(ctx *gin.Context) {
-	logger, err := commonLogger.GetLoggerFromContext(ctx.Request.Context())
-	if err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, err)
-		return
+// HasPublicKeys reports whether a verified public key is loaded
+func (autheticationMiddleware *AutheticationMiddleware) HasPublicKeys() bool {
+	keySet, ok := autheticationMiddleware.jwtVerifier.(PublicKeySetter)
+	if !ok {
+		return autheticationMiddleware.jwtVerifier != nil
 	}
+	return len(keySet.KeyIDs()) > 0
+}
 
-	parsedToken, isVerified := autheticationMiddleware.verifyToken(ctx, commonToken.AuthTokenType)
-	if !isVerified {
-		return
+// Stop stops the background refresh of the public keys
+func (autheticationMiddleware *AutheticationMiddleware) Stop() {
+	if keySet, ok := autheticationMiddleware.jwtVerifier.(PublicKeySetter); ok {
+		keySet.Stop()
 	}
-	claims, err := autheticationMiddleware.jwtTokenInspector.GetClaimsFromToken(parsedToken)
-	if err != nil {
-		logger.Error(err, "Could not obtain claims from bearer token")
-		ctx.AbortWithStatus(http.StatusUnauthorized)
-		return
-	}
-	hasPaidFeatures := bool(claims.HasPaidFeatures)
-	if !hasPaidFeatures {
-		ctx.AbortWithStatusJSON(http.StatusPaymentRequired, "User does not have access to paid features")
-		return
-	}
-
-	logger.Info("User has access to paid features")
-	ctx.Next()
 }
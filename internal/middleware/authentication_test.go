@@ -13,7 +13,6 @@ import (
 	"github.com/golang-jwt/jwt"
 	"github.com/golang/mock/gomock"
 	commmonJWT "github.com/quadev-ltd/qd-common/pkg/jwt"
-	commonJWT "github.com/quadev-ltd/qd-common/pkg/jwt"
 	commonJWTMock "github.com/quadev-ltd/qd-common/pkg/jwt/mock"
 	commonLogger "github.com/quadev-ltd/qd-common/pkg/log"
 	commonLoggerMock "github.com/quadev-ltd/qd-common/pkg/log/mock"
@@ -21,6 +20,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/authentication/mock"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/revocation"
 )
 
 func createTestContext(method, path string, body []byte, authHeader *string) (*gin.Context, *httptest.ResponseRecorder) {
@@ -104,6 +104,7 @@ func TestMiddleware(t *testing.T) {
 			serviceMock,
 			jwtVerifierMock,
 			jwtTokenInspectorMock,
+			nil,
 		}
 		ctx, w := createTestContext("GET", "/test", nil, nil)
 
@@ -122,6 +123,7 @@ func TestMiddleware(t *testing.T) {
 			serviceMock,
 			jwtVerifierMock,
 			jwtTokenInspectorMock,
+			nil,
 		}
 		loggerMock := commonLoggerMock.NewMockLoggerer(controller)
 
@@ -144,6 +146,7 @@ func TestMiddleware(t *testing.T) {
 			serviceMock,
 			jwtVerifierMock,
 			jwtTokenInspectorMock,
+			nil,
 		}
 		loggerMock := commonLoggerMock.NewMockLoggerer(controller)
 
@@ -167,6 +170,7 @@ func TestMiddleware(t *testing.T) {
 			serviceMock,
 			jwtVerifierMock,
 			jwtTokenInspectorMock,
+			nil,
 		}
 		loggerMock := commonLoggerMock.NewMockLoggerer(controller)
 
@@ -190,6 +194,7 @@ func TestMiddleware(t *testing.T) {
 			serviceMock,
 			jwtVerifierMock,
 			jwtTokenInspectorMock,
+			nil,
 		}
 		loggerMock := commonLoggerMock.NewMockLoggerer(controller)
 
@@ -214,6 +219,7 @@ func TestMiddleware(t *testing.T) {
 			serviceMock,
 			jwtVerifierMock,
 			jwtTokenInspectorMock,
+			nil,
 		}
 		loggerMock := commonLoggerMock.NewMockLoggerer(controller)
 
@@ -242,6 +248,7 @@ func TestMiddleware(t *testing.T) {
 			serviceMock,
 			jwtVerifierMock,
 			jwtTokenInspectorMock,
+			nil,
 		}
 		loggerMock := commonLoggerMock.NewMockLoggerer(controller)
 
@@ -271,6 +278,7 @@ func TestMiddleware(t *testing.T) {
 			serviceMock,
 			jwtVerifierMock,
 			jwtTokenInspectorMock,
+			nil,
 		}
 		loggerMock := commonLoggerMock.NewMockLoggerer(controller)
 
@@ -301,6 +309,7 @@ func TestMiddleware(t *testing.T) {
 			serviceMock,
 			jwtVerifierMock,
 			jwtTokenInspectorMock,
+			nil,
 		}
 		loggerMock := commonLoggerMock.NewMockLoggerer(controller)
 
@@ -322,102 +331,139 @@ func TestMiddleware(t *testing.T) {
 		assert.Equal(t, http.StatusOK, w.Code)
 	})
 
-	// Refresh Authentication
-	t.Run("RefreshAuthentication_Wrong_Type_Claim_Authorization_Header_Error", func(t *testing.T) {
+	t.Run("RequireAuthentication_Revoked_Token_Error", func(t *testing.T) {
 		controller := gomock.NewController(t)
 		defer controller.Finish()
 		serviceMock := mock.NewMockServiceClienter(controller)
 		jwtVerifierMock := commonJWTMock.NewMockTokenVerifierer(controller)
 		jwtTokenInspectorMock := commonJWTMock.NewMockTokenInspectorer(controller)
+		revocations := revocation.NewMemoryRevocationStore(time.Hour)
 		authenticationMiddleware := &AutheticationMiddleware{
 			serviceMock,
 			jwtVerifierMock,
 			jwtTokenInspectorMock,
+			revocations,
 		}
 		loggerMock := commonLoggerMock.NewMockLoggerer(controller)
 
 		authHeader := "Bearer test-header"
-		testToken := &jwt.Token{}
+		testToken := &jwt.Token{Claims: jwt.MapClaims{"iat": float64(time.Now().Add(-time.Minute).Unix())}}
 		tokenClaims := &commmonJWT.TokenClaims{
 			Type:   commonToken.AuthTokenType,
-			Expiry: time.Now().Add(-1 * time.Second),
+			Expiry: time.Now().Add(10 * time.Second),
+			UserID: "user-id",
 		}
+		revocations.RevokeUserTokens(context.Background(), "user-id", time.Now())
 
 		ctx, w := createTestContextWithLogger(loggerMock, &authHeader)
 
 		jwtVerifierMock.EXPECT().Verify("test-header").Return(testToken, nil)
 		jwtTokenInspectorMock.EXPECT().GetClaimsFromToken(testToken).Return(tokenClaims, nil)
 
-		authenticationMiddleware.RefreshAuthentication(ctx)
+		authenticationMiddleware.RequireAuthentication(ctx)
 
 		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Body.String(), "The bearer token has been revoked")
 	})
 
-	t.Run("RequirePaidFeatures_Success", func(t *testing.T) {
+	t.Run("RequireAuthentication_Token_Issued_After_Revocation_Success", func(t *testing.T) {
 		controller := gomock.NewController(t)
 		defer controller.Finish()
 		serviceMock := mock.NewMockServiceClienter(controller)
 		jwtVerifierMock := commonJWTMock.NewMockTokenVerifierer(controller)
 		jwtTokenInspectorMock := commonJWTMock.NewMockTokenInspectorer(controller)
+		revocations := revocation.NewMemoryRevocationStore(time.Hour)
 		authenticationMiddleware := &AutheticationMiddleware{
 			serviceMock,
 			jwtVerifierMock,
 			jwtTokenInspectorMock,
+			revocations,
 		}
 		loggerMock := commonLoggerMock.NewMockLoggerer(controller)
 
 		authHeader := "Bearer test-header"
-		testToken := &jwt.Token{}
+		testToken := &jwt.Token{Claims: jwt.MapClaims{"iat": float64(time.Now().Unix())}}
 		tokenClaims := &commmonJWT.TokenClaims{
-			Type:            commonToken.AuthTokenType,
-			Expiry:          time.Now().Add(10 * time.Second),
-			HasPaidFeatures: true,
+			Type:   commonToken.AuthTokenType,
+			Expiry: time.Now().Add(10 * time.Second),
+			UserID: "user-id",
 		}
+		revocations.RevokeUserTokens(context.Background(), "user-id", time.Now().Add(-time.Minute))
+		revocations.RevokeToken(context.Background(), revocation.TokenID(nil, "another-token"), time.Now().Add(time.Minute))
+
 		ctx, w := createTestContextWithLogger(loggerMock, &authHeader)
 
 		jwtVerifierMock.EXPECT().Verify("test-header").Return(testToken, nil)
 		jwtTokenInspectorMock.EXPECT().GetClaimsFromToken(testToken).Return(tokenClaims, nil)
-		jwtTokenInspectorMock.EXPECT().GetClaimsFromToken(testToken).Return(tokenClaims, nil)
 		loggerMock.EXPECT().Info("Successfully authenticated user")
-		ctx.Set(string(commonJWT.JWTTokenKey), testToken)
-		loggerMock.EXPECT().Info("User has access to paid features")
 
-		authenticationMiddleware.RequirePaidFeatures(ctx)
+		authenticationMiddleware.RequireAuthentication(ctx)
 
 		assert.Equal(t, http.StatusOK, w.Code)
 	})
 
-	t.Run("RequirePaidFeatures_No_Paid_Features_Error", func(t *testing.T) {
+	t.Run("RefreshAuthentication_Revoked_Token_Error", func(t *testing.T) {
 		controller := gomock.NewController(t)
 		defer controller.Finish()
 		serviceMock := mock.NewMockServiceClienter(controller)
 		jwtVerifierMock := commonJWTMock.NewMockTokenVerifierer(controller)
 		jwtTokenInspectorMock := commonJWTMock.NewMockTokenInspectorer(controller)
+		revocations := revocation.NewMemoryRevocationStore(time.Hour)
 		authenticationMiddleware := &AutheticationMiddleware{
 			serviceMock,
 			jwtVerifierMock,
 			jwtTokenInspectorMock,
+			revocations,
 		}
 		loggerMock := commonLoggerMock.NewMockLoggerer(controller)
 
 		authHeader := "Bearer test-header"
-		testToken := &jwt.Token{}
+		testToken := &jwt.Token{Claims: jwt.MapClaims{}}
 		tokenClaims := &commmonJWT.TokenClaims{
-			Type:            commonToken.AuthTokenType,
-			Expiry:          time.Now().Add(10 * time.Second),
-			HasPaidFeatures: false,
+			Type:   commonToken.RefreshTokenType,
+			Expiry: time.Now().Add(10 * time.Second),
 		}
+		revocations.RevokeToken(context.Background(), revocation.TokenID(nil, "test-header"), time.Now().Add(time.Minute))
 
 		ctx, w := createTestContextWithLogger(loggerMock, &authHeader)
 
 		jwtVerifierMock.EXPECT().Verify("test-header").Return(testToken, nil)
 		jwtTokenInspectorMock.EXPECT().GetClaimsFromToken(testToken).Return(tokenClaims, nil)
+
+		authenticationMiddleware.RefreshAuthentication(ctx)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	// Refresh Authentication
+	t.Run("RefreshAuthentication_Wrong_Type_Claim_Authorization_Header_Error", func(t *testing.T) {
+		controller := gomock.NewController(t)
+		defer controller.Finish()
+		serviceMock := mock.NewMockServiceClienter(controller)
+		jwtVerifierMock := commonJWTMock.NewMockTokenVerifierer(controller)
+		jwtTokenInspectorMock := commonJWTMock.NewMockTokenInspectorer(controller)
+		authenticationMiddleware := &AutheticationMiddleware{
+			serviceMock,
+			jwtVerifierMock,
+			jwtTokenInspectorMock,
+			nil,
+		}
+		loggerMock := commonLoggerMock.NewMockLoggerer(controller)
+
+		authHeader := "Bearer test-header"
+		testToken := &jwt.Token{}
+		tokenClaims := &commmonJWT.TokenClaims{
+			Type:   commonToken.AuthTokenType,
+			Expiry: time.Now().Add(-1 * time.Second),
+		}
+
+		ctx, w := createTestContextWithLogger(loggerMock, &authHeader)
+
+		jwtVerifierMock.EXPECT().Verify("test-header").Return(testToken, nil)
 		jwtTokenInspectorMock.EXPECT().GetClaimsFromToken(testToken).Return(tokenClaims, nil)
-		loggerMock.EXPECT().Info("Successfully authenticated user")
-		ctx.Set(string(commonJWT.JWTTokenKey), testToken)
 
-		authenticationMiddleware.RequirePaidFeatures(ctx)
+		authenticationMiddleware.RefreshAuthentication(ctx)
 
-		assert.Equal(t, http.StatusPaymentRequired, w.Code)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
 	})
 }
@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	commonJWT "github.com/quadev-ltd/qd-common/pkg/jwt"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+)
+
+// Claims carrying the authorization of a token
+const (
+	// ScopeClaim holds the scopes as a space separated string or a list
+	ScopeClaim = "scope"
+	// RolesClaim holds the roles as a list or a single string
+	RolesClaim = "roles"
+)
+
+// PaidRole is granted to the tokens with paid features
+const PaidRole = "paid"
+
+// Authorization holds the roles and scopes granted to a verified token
+type Authorization struct {
+	Roles  map[string]bool
+	Scopes map[string]bool
+}
+
+// claimValues reads a claim holding a space separated string or a list of strings
+func claimValues(claims jwt.MapClaims, claim string) map[string]bool {
+	values := make(map[string]bool)
+	switch claimValue := claims[claim].(type) {
+	case string:
+		for _, value := range strings.Fields(claimValue) {
+			values[value] = true
+		}
+	case []interface{}:
+		for _, value := range claimValue {
+			if stringValue, ok := value.(string); ok && stringValue != "" {
+				values[stringValue] = true
+			}
+		}
+	case []string:
+		for _, value := range claimValue {
+			if value != "" {
+				values[value] = true
+			}
+		}
+	}
+	return values
+}
+
+// MapClaimsFromContext returns every claim of the token verified by the authentication middleware, none if there is no token
+func MapClaimsFromContext(ctx *gin.Context) jwt.MapClaims {
+	if token, ok := ctx.Value(string(commonJWT.JWTTokenKey)).(*jwt.Token); ok {
+		if mapClaims, ok := token.Claims.(jwt.MapClaims); ok {
+			return mapClaims
+		}
+	}
+	return jwt.MapClaims{}
+}
+
+// GetAuthorization returns the roles and scopes of the token verified by the authentication middleware
+func GetAuthorization(ctx *gin.Context) (*Authorization, bool) {
+	claimsValue, exists := ctx.Get(string(commonJWT.ClaimsContextKey))
+	if !exists {
+		return nil, false
+	}
+	claims, ok := claimsValue.(*commonJWT.TokenClaims)
+	if !ok {
+		return nil, false
+	}
+	mapClaims := MapClaimsFromContext(ctx)
+	authorization := &Authorization{
+		Roles:  claimValues(mapClaims, RolesClaim),
+		Scopes: claimValues(mapClaims, ScopeClaim),
+	}
+	if claims.HasPaidFeatures {
+		authorization.Roles[PaidRole] = true
+	}
+	return authorization, true
+}
+
+// missing returns the required values not granted, sorted
+func missing(granted map[string]bool, required []string) []string {
+	missingValues := []string{}
+	for _, value := range required {
+		if !granted[value] {
+			missingValues = append(missingValues, value)
+		}
+	}
+	sort.Strings(missingValues)
+	return missingValues
+}
+
+// abortUnauthenticated responds to the requests reaching an authorization check without a verified token
+func abortUnauthenticated(ctx *gin.Context) {
+	errors.Abort(ctx, errors.New(http.StatusUnauthorized, errors.Unauthorized, "The request has no verified bearer token"))
+}
+
+// hasScopes aborts the request with 403 unless the verified token has every one of the scopes
+func hasScopes(ctx *gin.Context, scopes []string) bool {
+	authorization, isAuthenticated := GetAuthorization(ctx)
+	if !isAuthenticated {
+		abortUnauthenticated(ctx)
+		return false
+	}
+	missingScopes := missing(authorization.Scopes, scopes)
+	if len(missingScopes) == 0 {
+		return true
+	}
+	ctx.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="%s", scope="%s"`, errors.InsufficientScope, strings.Join(scopes, " ")))
+	errors.Abort(ctx, errors.New(http.StatusForbidden, errors.InsufficientScope, "The bearer token is missing required scopes").
+		WithDetail("missing_scopes", missingScopes))
+	return false
+}
+
+// hasRoles aborts the request with 403 unless the verified token has any of the roles
+func hasRoles(ctx *gin.Context, roles []string) bool {
+	authorization, isAuthenticated := GetAuthorization(ctx)
+	if !isAuthenticated {
+		abortUnauthenticated(ctx)
+		return false
+	}
+	for _, role := range roles {
+		if authorization.Roles[role] {
+			return true
+		}
+	}
+	requiredRoles := append([]string{}, roles...)
+	sort.Strings(requiredRoles)
+	errors.Abort(ctx, errors.New(http.StatusForbidden, errors.Forbidden, "The bearer token has none of the required roles").
+		WithDetail("required_roles", requiredRoles))
+	return false
+}
+
+// RequireScopes ensures the verified token has every one of the scopes.
+// It must run after the authentication middleware.
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if hasScopes(ctx, scopes) {
+			ctx.Next()
+		}
+	}
+}
+
+// RequireRoles ensures the verified token has any of the roles.
+// It must run after the authentication middleware.
+func RequireRoles(roles ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if hasRoles(ctx, roles) {
+			ctx.Next()
+		}
+	}
+}
+
+// AuthorizationPolicies resolves the authorization middleware of each route from the configured policies
+type AuthorizationPolicies struct {
+	policies []config.AuthorizationPolicyConfig
+}
+
+var _ RoutePolicier = &AuthorizationPolicies{}
+
+// NewAuthorizationPolicies validates the configured authorization policies
+func NewAuthorizationPolicies(configurations *config.Config) (*AuthorizationPolicies, error) {
+	policies := configurations.Authorization.Policies
+	for index, policy := range policies {
+		if policy.Route == "" {
+			return nil, fmt.Errorf("Authorization policy %d has no route", index)
+		}
+		if len(policy.Roles) == 0 && len(policy.Scopes) == 0 {
+			return nil, fmt.Errorf("Authorization policy of %s requires no role or scope", policy.Route)
+		}
+	}
+	return &AuthorizationPolicies{policies: policies}, nil
+}
+
+// Match returns the first policy matching the method and full route template, nil if there is none
+func (authorizationPolicies *AuthorizationPolicies) Match(method, route string) *config.AuthorizationPolicyConfig {
+	for index := range authorizationPolicies.policies {
+		policy := &authorizationPolicies.policies[index]
+		if matchesMethod(policy.Method, method) && matchesRoute(policy.Route, route) {
+			return policy
+		}
+	}
+	return nil
+}
+
+// Middleware returns the authorization middleware of the route, nil when no policy matches it
+func (authorizationPolicies *AuthorizationPolicies) Middleware(method, route string) (gin.HandlerFunc, error) {
+	policy := authorizationPolicies.Match(method, route)
+	if policy == nil {
+		return nil, nil
+	}
+	return func(ctx *gin.Context) {
+		if len(policy.Roles) > 0 && !hasRoles(ctx, policy.Roles) {
+			return
+		}
+		if hasScopes(ctx, policy.Scopes) {
+			ctx.Next()
+		}
+	}, nil
+}
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	commonJWT "github.com/quadev-ltd/qd-common/pkg/jwt"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+)
+
+// authenticateWith sets the claims the authentication middleware sets for a verified token
+func authenticateWith(claims *commonJWT.TokenClaims, mapClaims jwt.MapClaims) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(string(commonJWT.ClaimsContextKey), claims)
+		ctx.Set(string(commonJWT.JWTTokenKey), &jwt.Token{Claims: mapClaims})
+	}
+}
+
+func serveJSON(t *testing.T, router *gin.Engine, method, target string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+	body := map[string]interface{}{}
+	if recorder.Body.Len() > 0 {
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	}
+	return recorder, body
+}
+
+func TestAuthorization(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("RequireScopes_Success", func(t *testing.T) {
+		router := gin.New()
+		router.GET("/test", authenticateWith(&commonJWT.TokenClaims{}, jwt.MapClaims{
+			ScopeClaim: "images:read images:write",
+		}), RequireScopes("images:write", "images:read"), okHandler)
+
+		recorder, _ := serveJSON(t, router, http.MethodGet, "/test")
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("RequireScopes_Missing_Scope_Forbidden", func(t *testing.T) {
+		router := gin.New()
+		router.GET("/test", authenticateWith(&commonJWT.TokenClaims{}, jwt.MapClaims{
+			ScopeClaim: []interface{}{"images:read"},
+		}), RequireScopes("images:read", "images:write"), okHandler)
+
+		recorder, body := serveJSON(t, router, http.MethodGet, "/test")
+
+		assert.Equal(t, http.StatusForbidden, recorder.Code)
+		assert.Equal(t, errors.InsufficientScope, body["error"])
+		assert.Equal(t, []interface{}{"images:write"}, body["details"].(map[string]interface{})["missing_scopes"])
+		assert.Equal(t, `Bearer error="insufficient_scope", scope="images:read images:write"`, recorder.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("RequireRoles_Any_Role_Success", func(t *testing.T) {
+		router := gin.New()
+		router.GET("/test", authenticateWith(&commonJWT.TokenClaims{}, jwt.MapClaims{
+			RolesClaim: []interface{}{"support"},
+		}), RequireRoles("admin", "support"), okHandler)
+
+		recorder, _ := serveJSON(t, router, http.MethodGet, "/test")
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("RequireRoles_Paid_Features_Grant_Paid_Role", func(t *testing.T) {
+		router := gin.New()
+		router.GET("/test", authenticateWith(&commonJWT.TokenClaims{HasPaidFeatures: true}, jwt.MapClaims{}), RequireRoles(PaidRole), okHandler)
+
+		recorder, _ := serveJSON(t, router, http.MethodGet, "/test")
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("RequireRoles_Missing_Role_Forbidden", func(t *testing.T) {
+		router := gin.New()
+		router.GET("/test", authenticateWith(&commonJWT.TokenClaims{}, jwt.MapClaims{
+			RolesClaim: "user",
+		}), RequireRoles("admin"), okHandler)
+
+		recorder, body := serveJSON(t, router, http.MethodGet, "/test")
+
+		assert.Equal(t, http.StatusForbidden, recorder.Code)
+		assert.Equal(t, errors.Forbidden, body["error"])
+		assert.Equal(t, []interface{}{"admin"}, body["details"].(map[string]interface{})["required_roles"])
+	})
+
+	t.Run("RequireRoles_Without_Authentication_Unauthorized", func(t *testing.T) {
+		router := gin.New()
+		router.GET("/test", RequireRoles("admin"), okHandler)
+
+		recorder, body := serveJSON(t, router, http.MethodGet, "/test")
+
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.Equal(t, errors.Unauthorized, body["error"])
+	})
+
+	t.Run("NewAuthorizationPolicies_Empty_Policy_Error", func(t *testing.T) {
+		_, err := NewAuthorizationPolicies(&config.Config{
+			Authorization: config.AuthorizationConfig{Policies: []config.AuthorizationPolicyConfig{
+				{Route: "/admin/*"},
+			}},
+		})
+
+		assert.Equal(t, "Authorization policy of /admin/* requires no role or scope", err.Error())
+	})
+
+	t.Run("PolicyRouter_Applies_Authorization_Policies", func(t *testing.T) {
+		authorizationPolicies, err := NewAuthorizationPolicies(&config.Config{
+			Authorization: config.AuthorizationConfig{Policies: []config.AuthorizationPolicyConfig{
+				{Route: "/admin/*", Roles: []string{"admin"}},
+				{Route: "/images", Method: http.MethodPost, Scopes: []string{"images:write"}},
+			}},
+		})
+		assert.NoError(t, err)
+		authenticate := authenticateWith(&commonJWT.TokenClaims{}, jwt.MapClaims{
+			RolesClaim: []interface{}{"user"},
+			ScopeClaim: "images:read",
+		})
+		engine := gin.New()
+		router := NewPolicyRouter(&engine.RouterGroup, authorizationPolicies)
+		router.GET("/admin/users", authenticate, okHandler)
+		router.GET("/images", authenticate, okHandler)
+		router.POST("/images", authenticate, okHandler)
+
+		admin, _ := serveJSON(t, engine, http.MethodGet, "/admin/users")
+		readImages, _ := serveJSON(t, engine, http.MethodGet, "/images")
+		writeImages, _ := serveJSON(t, engine, http.MethodPost, "/images")
+
+		assert.NoError(t, router.Err())
+		assert.Equal(t, http.StatusForbidden, admin.Code)
+		assert.Equal(t, http.StatusOK, readImages.Code)
+		assert.Equal(t, http.StatusForbidden, writeImages.Code)
+	})
+}
@@ -0,0 +1,255 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	commonJWT "github.com/quadev-ltd/qd-common/pkg/jwt"
+	commonLogger "github.com/quadev-ltd/qd-common/pkg/log"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+)
+
+// TrialPlan is the plan of the users trialling the paid features until their trial expires
+const TrialPlan = "trial"
+
+// Claims carrying the entitlements of a token
+const (
+	// PlanClaim holds the plan of the user, the paid features flag decides it when missing
+	PlanClaim = "plan"
+	// TrialExpiryClaim holds the unix time the trial of the user expires at
+	TrialExpiryClaim = "trial_exp"
+	// EntitlementsClaim holds the features granted to the user on top of their plan
+	EntitlementsClaim = "entitlements"
+)
+
+// Reasons of the requests denied for lack of entitlement
+const (
+	TrialExpiredReason     = "trial_expired"
+	PlanInsufficientReason = "plan_insufficient"
+)
+
+// Entitlements holds the plan of a user and the features granted on top of it
+type Entitlements struct {
+	Plan           string
+	TrialExpiresAt time.Time
+	Features       map[string]bool
+}
+
+// EntitlementsFromClaims returns the entitlements carried by the token verified by the authentication middleware
+func EntitlementsFromClaims(claims *commonJWT.TokenClaims, mapClaims jwt.MapClaims) *Entitlements {
+	entitlements := &Entitlements{
+		Plan:     FreePlan,
+		Features: claimValues(mapClaims, EntitlementsClaim),
+	}
+	if claims.HasPaidFeatures {
+		entitlements.Plan = PaidPlan
+	}
+	if plan, ok := mapClaims[PlanClaim].(string); ok && plan != "" {
+		entitlements.Plan = plan
+	}
+	if trialExpiry, ok := mapClaims[TrialExpiryClaim].(float64); ok {
+		entitlements.TrialExpiresAt = time.Unix(int64(trialExpiry), 0)
+	}
+	return entitlements
+}
+
+// merge overrides the plan and trial with the looked up ones and adds the looked up features
+func (entitlements *Entitlements) merge(lookedUp *Entitlements) {
+	if lookedUp.Plan != "" {
+		entitlements.Plan = lookedUp.Plan
+		entitlements.TrialExpiresAt = lookedUp.TrialExpiresAt
+	}
+	for feature, isGranted := range lookedUp.Features {
+		if isGranted {
+			entitlements.Features[feature] = true
+		}
+	}
+}
+
+// EntitlementLookuper defines the interface for the sources of the entitlements of a user
+type EntitlementLookuper interface {
+	LookupEntitlements(ctx context.Context, userID string) (*Entitlements, error)
+}
+
+type cachedEntitlements struct {
+	entitlements *Entitlements
+	expiresAt    time.Time
+}
+
+// CachedEntitlementLookup caches the entitlements returned by a lookup for a time to live
+type CachedEntitlementLookup struct {
+	lookup  EntitlementLookuper
+	ttl     time.Duration
+	entries map[string]cachedEntitlements
+	mtx     sync.Mutex
+	now     func() time.Time
+}
+
+var _ EntitlementLookuper = &CachedEntitlementLookup{}
+
+// NewCachedEntitlementLookup creates a cache of the lookup keeping each user's entitlements for the time to live
+func NewCachedEntitlementLookup(lookup EntitlementLookuper, ttl time.Duration) *CachedEntitlementLookup {
+	return &CachedEntitlementLookup{
+		lookup:  lookup,
+		ttl:     ttl,
+		entries: make(map[string]cachedEntitlements),
+		now:     time.Now,
+	}
+}
+
+// LookupEntitlements returns the cached entitlements of the user, looking them up when missing or expired
+func (cachedLookup *CachedEntitlementLookup) LookupEntitlements(ctx context.Context, userID string) (*Entitlements, error) {
+	cachedLookup.mtx.Lock()
+	entry, exists := cachedLookup.entries[userID]
+	cachedLookup.mtx.Unlock()
+	now := cachedLookup.now()
+	if exists && now.Before(entry.expiresAt) {
+		return entry.entitlements, nil
+	}
+
+	entitlements, err := cachedLookup.lookup.LookupEntitlements(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	cachedLookup.mtx.Lock()
+	defer cachedLookup.mtx.Unlock()
+	for cachedUserID, cachedEntry := range cachedLookup.entries {
+		if !now.Before(cachedEntry.expiresAt) {
+			delete(cachedLookup.entries, cachedUserID)
+		}
+	}
+	cachedLookup.entries[userID] = cachedEntitlements{
+		entitlements: entitlements,
+		expiresAt:    now.Add(cachedLookup.ttl),
+	}
+	return entitlements, nil
+}
+
+// EntitlementMiddlewarer defines the interface for entitlement middleware
+type EntitlementMiddlewarer interface {
+	// RequireEntitlement ensures the authenticated user is entitled to the feature
+	RequireEntitlement(feature string) gin.HandlerFunc
+}
+
+// EntitlementMiddleware implements the EntitlementMiddlewarer interface
+type EntitlementMiddleware struct {
+	features map[string]config.FeatureConfig
+	cohorts  map[string]map[string]bool
+	lookup   EntitlementLookuper
+	now      func() time.Time
+}
+
+var _ EntitlementMiddlewarer = &EntitlementMiddleware{}
+
+// NewEntitlementMiddleware creates the entitlement middleware of the configured features.
+// The lookup is optional, when set its entitlements, cached for the configured time to live, take over the claims.
+func NewEntitlementMiddleware(configurations *config.Config, lookup EntitlementLookuper) (*EntitlementMiddleware, error) {
+	cohorts := make(map[string]map[string]bool)
+	for cohort, userIDs := range configurations.Entitlements.Cohorts {
+		cohorts[cohort] = make(map[string]bool)
+		for _, userID := range userIDs {
+			cohorts[cohort][userID] = true
+		}
+	}
+	for feature, featureConfig := range configurations.Entitlements.Features {
+		for _, cohort := range featureConfig.Cohorts {
+			if _, exists := cohorts[cohort]; !exists {
+				return nil, fmt.Errorf("Unknown cohort %s in the entitlements of %s", cohort, feature)
+			}
+		}
+	}
+	if lookup != nil && configurations.Entitlements.CacheTTL > 0 {
+		lookup = NewCachedEntitlementLookup(lookup, configurations.Entitlements.CacheTTL)
+	}
+	return &EntitlementMiddleware{
+		features: configurations.Entitlements.Features,
+		cohorts:  cohorts,
+		lookup:   lookup,
+		now:      time.Now,
+	}, nil
+}
+
+// isEnabledFor reports whether the feature is turned on for the user by ID or cohort
+func (entitlementMiddleware *EntitlementMiddleware) isEnabledFor(featureConfig config.FeatureConfig, userID string) bool {
+	if userID == "" {
+		return false
+	}
+	for _, featureUserID := range featureConfig.Users {
+		if featureUserID == userID {
+			return true
+		}
+	}
+	for _, cohort := range featureConfig.Cohorts {
+		if entitlementMiddleware.cohorts[cohort][userID] {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns an empty reason when the entitlements grant the feature, the reason it is denied otherwise.
+// A trial without expiry is expired, it would otherwise never end.
+func (entitlementMiddleware *EntitlementMiddleware) check(feature string, userID string, entitlements *Entitlements) string {
+	featureConfig := entitlementMiddleware.features[feature]
+	if entitlementMiddleware.isEnabledFor(featureConfig, userID) || entitlements.Features[feature] {
+		return ""
+	}
+	isTrialExpired := entitlements.Plan == TrialPlan &&
+		!entitlementMiddleware.now().Before(entitlements.TrialExpiresAt)
+	if isTrialExpired {
+		return TrialExpiredReason
+	}
+	for _, plan := range featureConfig.Plans {
+		if plan == entitlements.Plan {
+			return ""
+		}
+	}
+	return PlanInsufficientReason
+}
+
+// resolve returns the entitlements of the verified token, taking over the looked up ones when there is a lookup
+func (entitlementMiddleware *EntitlementMiddleware) resolve(ctx *gin.Context, claims *commonJWT.TokenClaims) *Entitlements {
+	entitlements := EntitlementsFromClaims(claims, MapClaimsFromContext(ctx))
+	if entitlementMiddleware.lookup == nil || claims.UserID == "" {
+		return entitlements
+	}
+	lookedUp, err := entitlementMiddleware.lookup.LookupEntitlements(ctx.Request.Context(), claims.UserID)
+	if err != nil {
+		// The claims are still signed by the authentication service, fall back on them
+		if logger, loggerErr := commonLogger.GetLoggerFromContext(ctx.Request.Context()); loggerErr == nil {
+			logger.Error(err, "Could not look up the entitlements of the user")
+		}
+		return entitlements
+	}
+	entitlements.merge(lookedUp)
+	return entitlements
+}
+
+// RequireEntitlement middleware ensures the authenticated user is entitled to the feature, responding
+// 402 with the reason otherwise. It must run after the authentication middleware.
+func (entitlementMiddleware *EntitlementMiddleware) RequireEntitlement(feature string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		claims, ok := ctx.Value(string(commonJWT.ClaimsContextKey)).(*commonJWT.TokenClaims)
+		if !ok {
+			abortUnauthenticated(ctx)
+			return
+		}
+		entitlements := entitlementMiddleware.resolve(ctx, claims)
+		reason := entitlementMiddleware.check(feature, claims.UserID, entitlements)
+		if reason != "" {
+			errors.Abort(ctx, errors.New(http.StatusPaymentRequired, errors.PaymentRequired, fmt.Sprintf("User is not entitled to %s", feature)).
+				WithReason(reason).
+				WithDetail("feature", feature))
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	commonJWT "github.com/quadev-ltd/qd-common/pkg/jwt"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+)
+
+const testFeature = "image-analysis"
+
+type entitlementLookupStub struct {
+	entitlements *Entitlements
+	err          error
+	calls        int
+}
+
+func (lookup *entitlementLookupStub) LookupEntitlements(ctx context.Context, userID string) (*Entitlements, error) {
+	lookup.calls++
+	return lookup.entitlements, lookup.err
+}
+
+func newTestEntitlementMiddleware(t *testing.T, lookup EntitlementLookuper) *EntitlementMiddleware {
+	entitlementMiddleware, err := NewEntitlementMiddleware(&config.Config{
+		Entitlements: config.EntitlementsConfig{
+			Cohorts: map[string][]string{"beta": {"beta-user"}},
+			Features: map[string]config.FeatureConfig{
+				testFeature: {
+					Plans:   []string{PaidPlan, TrialPlan},
+					Users:   []string{"early-user"},
+					Cohorts: []string{"beta"},
+				},
+			},
+		},
+	}, lookup)
+	assert.NoError(t, err)
+	return entitlementMiddleware
+}
+
+func serveEntitlement(
+	t *testing.T,
+	entitlementMiddleware *EntitlementMiddleware,
+	claims *commonJWT.TokenClaims,
+	mapClaims jwt.MapClaims,
+) (int, map[string]interface{}) {
+	router := gin.New()
+	router.GET("/test", authenticateWith(claims, mapClaims), entitlementMiddleware.RequireEntitlement(testFeature), okHandler)
+	recorder, body := serveJSON(t, router, http.MethodGet, "/test")
+	return recorder.Code, body
+}
+
+func TestEntitlementMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("RequireEntitlement_Paid_Features_Success", func(t *testing.T) {
+		entitlementMiddleware := newTestEntitlementMiddleware(t, nil)
+
+		code, _ := serveEntitlement(t, entitlementMiddleware, &commonJWT.TokenClaims{UserID: "user", HasPaidFeatures: true}, jwt.MapClaims{})
+
+		assert.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("RequireEntitlement_Plan_Insufficient", func(t *testing.T) {
+		entitlementMiddleware := newTestEntitlementMiddleware(t, nil)
+
+		code, body := serveEntitlement(t, entitlementMiddleware, &commonJWT.TokenClaims{UserID: "user"}, jwt.MapClaims{})
+
+		assert.Equal(t, http.StatusPaymentRequired, code)
+		assert.Equal(t, errors.PaymentRequired, body["error"])
+		assert.Equal(t, PlanInsufficientReason, body["reason"])
+		assert.Equal(t, testFeature, body["details"].(map[string]interface{})["feature"])
+	})
+
+	t.Run("RequireEntitlement_Trial_Active_Success", func(t *testing.T) {
+		entitlementMiddleware := newTestEntitlementMiddleware(t, nil)
+
+		code, _ := serveEntitlement(t, entitlementMiddleware, &commonJWT.TokenClaims{UserID: "user"}, jwt.MapClaims{
+			PlanClaim:        TrialPlan,
+			TrialExpiryClaim: float64(time.Now().Add(time.Hour).Unix()),
+		})
+
+		assert.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("RequireEntitlement_Trial_Expired", func(t *testing.T) {
+		entitlementMiddleware := newTestEntitlementMiddleware(t, nil)
+
+		code, body := serveEntitlement(t, entitlementMiddleware, &commonJWT.TokenClaims{UserID: "user"}, jwt.MapClaims{
+			PlanClaim:        TrialPlan,
+			TrialExpiryClaim: float64(time.Now().Add(-time.Hour).Unix()),
+		})
+
+		assert.Equal(t, http.StatusPaymentRequired, code)
+		assert.Equal(t, TrialExpiredReason, body["reason"])
+	})
+
+	t.Run("RequireEntitlement_Trial_Without_Expiry_Expired", func(t *testing.T) {
+		entitlementMiddleware := newTestEntitlementMiddleware(t, nil)
+
+		code, body := serveEntitlement(t, entitlementMiddleware, &commonJWT.TokenClaims{UserID: "user"}, jwt.MapClaims{
+			PlanClaim: TrialPlan,
+		})
+
+		assert.Equal(t, http.StatusPaymentRequired, code)
+		assert.Equal(t, TrialExpiredReason, body["reason"])
+	})
+
+	t.Run("RequireEntitlement_Entitlements_Claim_Success", func(t *testing.T) {
+		entitlementMiddleware := newTestEntitlementMiddleware(t, nil)
+
+		code, _ := serveEntitlement(t, entitlementMiddleware, &commonJWT.TokenClaims{UserID: "user"}, jwt.MapClaims{
+			EntitlementsClaim: []interface{}{testFeature},
+		})
+
+		assert.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("RequireEntitlement_Enabled_Per_User_And_Cohort", func(t *testing.T) {
+		entitlementMiddleware := newTestEntitlementMiddleware(t, nil)
+
+		userCode, _ := serveEntitlement(t, entitlementMiddleware, &commonJWT.TokenClaims{UserID: "early-user"}, jwt.MapClaims{})
+		cohortCode, _ := serveEntitlement(t, entitlementMiddleware, &commonJWT.TokenClaims{UserID: "beta-user"}, jwt.MapClaims{})
+
+		assert.Equal(t, http.StatusOK, userCode)
+		assert.Equal(t, http.StatusOK, cohortCode)
+	})
+
+	t.Run("RequireEntitlement_Without_Authentication_Unauthorized", func(t *testing.T) {
+		entitlementMiddleware := newTestEntitlementMiddleware(t, nil)
+		router := gin.New()
+		router.GET("/test", entitlementMiddleware.RequireEntitlement(testFeature), okHandler)
+
+		recorder, _ := serveJSON(t, router, http.MethodGet, "/test")
+
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	})
+
+	t.Run("RequireEntitlement_Lookup_Overrides_Claims", func(t *testing.T) {
+		lookup := &entitlementLookupStub{entitlements: &Entitlements{Plan: PaidPlan}}
+		entitlementMiddleware := newTestEntitlementMiddleware(t, lookup)
+
+		code, _ := serveEntitlement(t, entitlementMiddleware, &commonJWT.TokenClaims{UserID: "user"}, jwt.MapClaims{})
+
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 1, lookup.calls)
+	})
+
+	t.Run("RequireEntitlement_Lookup_Error_Falls_Back_On_Claims", func(t *testing.T) {
+		lookup := &entitlementLookupStub{err: fmt.Errorf("unavailable")}
+		entitlementMiddleware := newTestEntitlementMiddleware(t, lookup)
+
+		code, body := serveEntitlement(t, entitlementMiddleware, &commonJWT.TokenClaims{UserID: "user"}, jwt.MapClaims{})
+
+		assert.Equal(t, http.StatusPaymentRequired, code)
+		assert.Equal(t, PlanInsufficientReason, body["reason"])
+	})
+
+	t.Run("CachedEntitlementLookup_Caches_Until_Expiry", func(t *testing.T) {
+		lookup := &entitlementLookupStub{entitlements: &Entitlements{Plan: PaidPlan}}
+		cachedLookup := NewCachedEntitlementLookup(lookup, time.Minute)
+		now := time.Now()
+		cachedLookup.now = func() time.Time { return now }
+
+		_, err := cachedLookup.LookupEntitlements(context.Background(), "user")
+		assert.NoError(t, err)
+		_, err = cachedLookup.LookupEntitlements(context.Background(), "user")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, lookup.calls)
+
+		now = now.Add(time.Minute)
+		_, err = cachedLookup.LookupEntitlements(context.Background(), "user")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, lookup.calls)
+	})
+
+	t.Run("NewEntitlementMiddleware_Unknown_Cohort_Error", func(t *testing.T) {
+		_, err := NewEntitlementMiddleware(&config.Config{
+			Entitlements: config.EntitlementsConfig{
+				Features: map[string]config.FeatureConfig{testFeature: {Cohorts: []string{"alpha"}}},
+			},
+		}, nil)
+
+		assert.Equal(t, "Unknown cohort alpha in the entitlements of image-analysis", err.Error())
+	})
+}
@@ -0,0 +1,381 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	commonJWT "github.com/quadev-ltd/qd-common/pkg/jwt"
+	commonLogger "github.com/quadev-ltd/qd-common/pkg/log"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+)
+
+// Default key set timings used when the configuration does not provide them
+const (
+	DefaultKeyRefreshInterval = 10 * time.Minute
+	DefaultKeyGracePeriod     = time.Hour
+	DefaultKeyMissCooldown    = 30 * time.Second
+	keyRefreshTimeout         = 10 * time.Second
+	// KeyIDHeader is the JWT header holding the identifier of the signing key
+	KeyIDHeader = "kid"
+)
+
+// PublicKeySetter defines the interface for a rotating set of token verification keys
+type PublicKeySetter interface {
+	commonJWT.TokenVerifierer
+	// Load replaces the current keys with the ones in the PEM encoded string
+	Load(publicKeysPEM string) error
+	// Refresh fetches the current keys from the authentication service
+	Refresh(ctx context.Context) error
+	// KeyIDs returns the identifiers of the keys currently accepted
+	KeyIDs() []string
+	// Start begins refreshing the keys in the background
+	Start()
+	// Stop ends the background refresh
+	Stop()
+}
+
+type publicKeyEntry struct {
+	key       *rsa.PublicKey
+	loadedAt  time.Time
+	retiredAt *time.Time
+}
+
+// PublicKeySet holds the public keys of the authentication service indexed by key ID
+type PublicKeySet struct {
+	service           ServiceClienter
+	tokenInspector    commonJWT.TokenInspectorer
+	logger            commonLogger.Loggerer
+	refreshInterval   time.Duration
+	gracePeriod       time.Duration
+	missCooldown      time.Duration
+	keys              map[string]*publicKeyEntry
+	lastMissRefreshAt time.Time
+	mtx               sync.RWMutex
+	refreshMtx        sync.Mutex
+	stop              chan struct{}
+	stopOnce          sync.Once
+	now               func() time.Time
+}
+
+var _ PublicKeySetter = &PublicKeySet{}
+
+// NewPublicKeySet creates a new empty public key set refreshed from the given service
+func NewPublicKeySet(service ServiceClienter, configurations *config.Config) *PublicKeySet {
+	refreshInterval := configurations.Authentication.KeyRefreshInterval
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultKeyRefreshInterval
+	}
+	gracePeriod := configurations.Authentication.KeyGracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = DefaultKeyGracePeriod
+	}
+	missCooldown := configurations.Authentication.KeyMissCooldown
+	if missCooldown <= 0 {
+		missCooldown = DefaultKeyMissCooldown
+	}
+	logFactory := commonLogger.NewLogFactory(configurations.Environment)
+	return &PublicKeySet{
+		service:         service,
+		tokenInspector:  &commonJWT.TokenInspector{},
+		logger:          logFactory.NewLogger(),
+		refreshInterval: refreshInterval,
+		gracePeriod:     gracePeriod,
+		missCooldown:    missCooldown,
+		keys:            make(map[string]*publicKeyEntry),
+		stop:            make(chan struct{}),
+		now:             time.Now,
+	}
+}
+
+// KeyID returns the identifier of a public key, a base64url SHA-256 thumbprint of its DER encoding
+func KeyID(publicKey *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	thumbprint := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(thumbprint[:]), nil
+}
+
+// parsePublicKeys parses every PEM block of the string, using the kid block header as key ID when present
+func parsePublicKeys(publicKeysPEM string) (map[string]*rsa.PublicKey, error) {
+	keys := make(map[string]*rsa.PublicKey)
+	rest := []byte(publicKeysPEM)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		var publicKey *rsa.PublicKey
+		parsedKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err == nil {
+			rsaKey, ok := parsedKey.(*rsa.PublicKey)
+			if !ok {
+				return nil, fmt.Errorf("Public key is not an RSA key")
+			}
+			publicKey = rsaKey
+		} else {
+			publicKey, err = x509.ParsePKCS1PublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("Could not parse public key: %v", err)
+			}
+		}
+		keyID, ok := block.Headers[KeyIDHeader]
+		if !ok || keyID == "" {
+			keyID, err = KeyID(publicKey)
+			if err != nil {
+				return nil, err
+			}
+		}
+		keys[keyID] = publicKey
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("No public keys were found in the response")
+	}
+	return keys, nil
+}
+
+// Load replaces the current keys, keeping the ones no longer served for the grace period
+func (keySet *PublicKeySet) Load(publicKeysPEM string) error {
+	publicKeys, err := parsePublicKeys(publicKeysPEM)
+	if err != nil {
+		return err
+	}
+
+	keySet.mtx.Lock()
+	defer keySet.mtx.Unlock()
+
+	now := keySet.now()
+	for keyID, entry := range keySet.keys {
+		if _, exists := publicKeys[keyID]; exists {
+			continue
+		}
+		if entry.retiredAt == nil {
+			retiredAt := now
+			entry.retiredAt = &retiredAt
+			keySet.logger.Info(fmt.Sprintf("Public key %s was rotated out, accepting it for %s", keyID, keySet.gracePeriod))
+		}
+	}
+	for keyID, publicKey := range publicKeys {
+		if entry, exists := keySet.keys[keyID]; exists {
+			entry.retiredAt = nil
+			continue
+		}
+		keySet.keys[keyID] = &publicKeyEntry{
+			key:      publicKey,
+			loadedAt: now,
+		}
+		keySet.logger.Info(fmt.Sprintf("Loaded public key %s", keyID))
+	}
+	keySet.removeExpiredKeys(now)
+	return nil
+}
+
+func (keySet *PublicKeySet) removeExpiredKeys(now time.Time) {
+	for keyID, entry := range keySet.keys {
+		if entry.retiredAt != nil && now.Sub(*entry.retiredAt) > keySet.gracePeriod {
+			delete(keySet.keys, keyID)
+		}
+	}
+}
+
+// Refresh fetches the current keys from the authentication service
+func (keySet *PublicKeySet) Refresh(ctx context.Context) error {
+	keySet.refreshMtx.Lock()
+	defer keySet.refreshMtx.Unlock()
+
+	publicKey, err := keySet.service.GetPublicKey(ctx)
+	if err != nil {
+		return fmt.Errorf("Could not obtain public key: %v", err)
+	}
+	if publicKey == nil {
+		return fmt.Errorf("Could not obtain public key: empty response")
+	}
+	return keySet.Load(*publicKey)
+}
+
+func (keySet *PublicKeySet) refreshWithTimeout() error {
+	ctx, cancel := context.WithTimeout(context.Background(), keyRefreshTimeout)
+	defer cancel()
+	ctx = commonLogger.AddCorrelationIDToOutgoingContext(ctx, uuid.New().String())
+	return keySet.Refresh(ctx)
+}
+
+// refreshOnMiss refreshes the keys after an unknown key ID, or a token without key ID verified by none of
+// the keys, unless a refresh happened recently. It returns whether the keys were refreshed.
+func (keySet *PublicKeySet) refreshOnMiss(keyID string) bool {
+	keySet.mtx.Lock()
+	now := keySet.now()
+	if now.Sub(keySet.lastMissRefreshAt) < keySet.missCooldown {
+		keySet.mtx.Unlock()
+		return false
+	}
+	keySet.lastMissRefreshAt = now
+	keySet.mtx.Unlock()
+
+	if keyID != "" {
+		keySet.logger.Info(fmt.Sprintf("Unknown public key %s, refreshing keys", keyID))
+	} else {
+		keySet.logger.Info("No public key verifies the token without key ID, refreshing keys")
+	}
+	if err := keySet.refreshWithTimeout(); err != nil {
+		keySet.logger.Error(err, "Could not refresh public keys after unknown key ID")
+		return false
+	}
+	return true
+}
+
+// KeyIDs returns the identifiers of the keys currently accepted
+func (keySet *PublicKeySet) KeyIDs() []string {
+	keySet.mtx.RLock()
+	defer keySet.mtx.RUnlock()
+
+	now := keySet.now()
+	keyIDs := []string{}
+	for keyID, entry := range keySet.keys {
+		if entry.retiredAt != nil && now.Sub(*entry.retiredAt) > keySet.gracePeriod {
+			continue
+		}
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+	return keyIDs
+}
+
+// candidateKeys returns the keys that may have signed a token, newest first
+func (keySet *PublicKeySet) candidateKeys(keyID string) []*rsa.PublicKey {
+	keySet.mtx.RLock()
+	defer keySet.mtx.RUnlock()
+
+	now := keySet.now()
+	isValid := func(entry *publicKeyEntry) bool {
+		return entry.retiredAt == nil || now.Sub(*entry.retiredAt) <= keySet.gracePeriod
+	}
+	if keyID != "" {
+		entry, exists := keySet.keys[keyID]
+		if !exists || !isValid(entry) {
+			return nil
+		}
+		return []*rsa.PublicKey{entry.key}
+	}
+
+	entries := []*publicKeyEntry{}
+	for _, entry := range keySet.keys {
+		if isValid(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].loadedAt.After(entries[j].loadedAt)
+	})
+	keys := make([]*rsa.PublicKey, len(entries))
+	for index, entry := range entries {
+		keys[index] = entry.key
+	}
+	return keys
+}
+
+// parseWithCandidates parses the token with the first of the keys verifying it, returning the error of the last one
+func parseWithCandidates(tokenString string, candidates []*rsa.PublicKey) (*jwt.Token, error) {
+	var token *jwt.Token
+	err := fmt.Errorf("Token Verifier: no public key available")
+	for _, candidate := range candidates {
+		publicKey := candidate
+		token, err = jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+				return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+			}
+			return publicKey, nil
+		})
+		if err == nil {
+			return token, nil
+		}
+	}
+	return token, err
+}
+
+// isSignatureInvalid reports whether the token failed to verify because of its signature
+func isSignatureInvalid(err error) bool {
+	var validationError *jwt.ValidationError
+	return errors.As(err, &validationError) && validationError.Errors&jwt.ValidationErrorSignatureInvalid != 0
+}
+
+// Verify verifies a JWT token against the key matching its kid header, or every accepted key if it has none.
+// An unknown kid, or a token without kid verified by none of the keys, refreshes the keys once per cooldown.
+func (keySet *PublicKeySet) Verify(tokenString string) (*jwt.Token, error) {
+	unverifiedToken, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return nil, err
+	}
+	keyID, _ := unverifiedToken.Header[KeyIDHeader].(string)
+
+	candidates := keySet.candidateKeys(keyID)
+	if len(candidates) == 0 && keyID != "" {
+		keySet.refreshOnMiss(keyID)
+		candidates = keySet.candidateKeys(keyID)
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("Token Verifier: no public key available for key ID %q", keyID)
+	}
+
+	token, err := parseWithCandidates(tokenString, candidates)
+	// The tokens without key ID signed by a rotated key only show the rotation by failing every key
+	if isSignatureInvalid(err) && keyID == "" && keySet.refreshOnMiss(keyID) {
+		token, err = parseWithCandidates(tokenString, keySet.candidateKeys(keyID))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("Token Verifier: JWT Token is not valid")
+	}
+	expiry, err := keySet.tokenInspector.GetExpiryFromToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if expiry.Before(keySet.now()) {
+		return nil, fmt.Errorf("Token Verifier: JWT Token is expired")
+	}
+	return token, nil
+}
+
+// Start begins refreshing the keys in the background on the configured interval
+func (keySet *PublicKeySet) Start() {
+	go func() {
+		ticker := time.NewTicker(keySet.refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := keySet.refreshWithTimeout(); err != nil {
+					keySet.logger.Error(err, "Could not refresh public keys")
+				}
+				keySet.mtx.Lock()
+				keySet.removeExpiredKeys(keySet.now())
+				keySet.mtx.Unlock()
+			case <-keySet.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the background refresh
+func (keySet *PublicKeySet) Stop() {
+	keySet.stopOnce.Do(func() {
+		close(keySet.stop)
+	})
+}
@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/authentication/mock"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+)
+
+func generateTestKey(t *testing.T) (*rsa.PrivateKey, string, string) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PUBLIC KEY",
+		Bytes: publicKeyBytes,
+	})
+	keyID, err := KeyID(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privateKey, string(publicKeyPEM), keyID
+}
+
+func signTestToken(t *testing.T, privateKey *rsa.PrivateKey, keyID string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"exp": float64(time.Now().Add(time.Hour).Unix()),
+	})
+	if keyID != "" {
+		token.Header[KeyIDHeader] = keyID
+	}
+	tokenString, err := token.SignedString(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokenString
+}
+
+func TestPublicKeySet(t *testing.T) {
+	configurations := &config.Config{
+		Environment: "Test",
+		Authentication: config.AuthenticationConfig{
+			KeyRefreshInterval: time.Minute,
+			KeyGracePeriod:     time.Hour,
+			KeyMissCooldown:    time.Minute,
+		},
+	}
+	oldPrivateKey, oldPublicKey, oldKeyID := generateTestKey(t)
+	newPrivateKey, newPublicKey, newKeyID := generateTestKey(t)
+
+	t.Run("Verify_Known_Key_ID_Success", func(t *testing.T) {
+		controller := gomock.NewController(t)
+		defer controller.Finish()
+		serviceMock := mock.NewMockServiceClienter(controller)
+		keySet := NewPublicKeySet(serviceMock, configurations)
+		assert.NoError(t, keySet.Load(oldPublicKey))
+
+		token, err := keySet.Verify(signTestToken(t, oldPrivateKey, oldKeyID))
+
+		assert.NoError(t, err)
+		assert.True(t, token.Valid)
+		assert.Equal(t, []string{oldKeyID}, keySet.KeyIDs())
+	})
+
+	t.Run("Verify_Without_Key_ID_Success", func(t *testing.T) {
+		controller := gomock.NewController(t)
+		defer controller.Finish()
+		serviceMock := mock.NewMockServiceClienter(controller)
+		keySet := NewPublicKeySet(serviceMock, configurations)
+		assert.NoError(t, keySet.Load(oldPublicKey+newPublicKey))
+
+		_, err := keySet.Verify(signTestToken(t, newPrivateKey, ""))
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("Verify_Unknown_Key_ID_Refreshes_Keys", func(t *testing.T) {
+		controller := gomock.NewController(t)
+		defer controller.Finish()
+		serviceMock := mock.NewMockServiceClienter(controller)
+		keySet := NewPublicKeySet(serviceMock, configurations)
+		assert.NoError(t, keySet.Load(oldPublicKey))
+
+		serviceMock.EXPECT().GetPublicKey(gomock.Any()).Return(&newPublicKey, nil).Times(1)
+
+		_, err := keySet.Verify(signTestToken(t, newPrivateKey, newKeyID))
+		assert.NoError(t, err)
+
+		// A second miss within the cooldown must not hit the service again
+		_, err = keySet.Verify(signTestToken(t, newPrivateKey, "unknown-key-id"))
+		assert.Error(t, err)
+	})
+
+	t.Run("Verify_Without_Key_ID_After_Rotation_Refreshes_Keys", func(t *testing.T) {
+		controller := gomock.NewController(t)
+		defer controller.Finish()
+		serviceMock := mock.NewMockServiceClienter(controller)
+		keySet := NewPublicKeySet(serviceMock, configurations)
+		assert.NoError(t, keySet.Load(oldPublicKey))
+		unknownPrivateKey, _, _ := generateTestKey(t)
+
+		serviceMock.EXPECT().GetPublicKey(gomock.Any()).Return(&newPublicKey, nil).Times(1)
+
+		_, err := keySet.Verify(signTestToken(t, newPrivateKey, ""))
+		assert.NoError(t, err)
+
+		// A second miss within the cooldown must not hit the service again
+		_, err = keySet.Verify(signTestToken(t, unknownPrivateKey, ""))
+		assert.Error(t, err)
+	})
+
+	t.Run("Verify_Rotated_Key_Within_Grace_Period", func(t *testing.T) {
+		controller := gomock.NewController(t)
+		defer controller.Finish()
+		serviceMock := mock.NewMockServiceClienter(controller)
+		keySet := NewPublicKeySet(serviceMock, configurations)
+		now := time.Now()
+		keySet.now = func() time.Time { return now }
+		assert.NoError(t, keySet.Load(oldPublicKey))
+		assert.NoError(t, keySet.Load(newPublicKey))
+		oldToken := signTestToken(t, oldPrivateKey, oldKeyID)
+
+		_, err := keySet.Verify(oldToken)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{oldKeyID, newKeyID}, keySet.KeyIDs())
+
+		now = now.Add(2 * time.Hour)
+		serviceMock.EXPECT().GetPublicKey(gomock.Any()).Return(&newPublicKey, nil).Times(1)
+
+		_, err = keySet.Verify(oldToken)
+		assert.Error(t, err)
+		assert.Equal(t, []string{newKeyID}, keySet.KeyIDs())
+	})
+
+	t.Run("Load_Invalid_PEM_Error", func(t *testing.T) {
+		controller := gomock.NewController(t)
+		defer controller.Finish()
+		serviceMock := mock.NewMockServiceClienter(controller)
+		keySet := NewPublicKeySet(serviceMock, configurations)
+
+		err := keySet.Load("not-a-key")
+
+		assert.Error(t, err)
+		assert.Equal(t, "No public keys were found in the response", err.Error())
+	})
+}
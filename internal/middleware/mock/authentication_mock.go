@@ -73,6 +73,20 @@ func (m *MockAutheticationMiddlewarer) EXPECT() *MockAutheticationMiddlewarerMoc
 	return m.recorder
 }
 
+// HasPublicKeys mocks base method.
+func (m *MockAutheticationMiddlewarer) HasPublicKeys() bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HasPublicKeys")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// HasPublicKeys indicates an expected call of HasPublicKeys.
+func (mr *MockAutheticationMiddlewarerMockRecorder) HasPublicKeys() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasPublicKeys", reflect.TypeOf((*MockAutheticationMiddlewarer)(nil).HasPublicKeys))
+}
+
 // RefreshAuthentication mocks base method.
 func (m *MockAutheticationMiddlewarer) RefreshAuthentication(ctx *gin.Context) {
 	m.ctrl.T.Helper()
@@ -97,14 +111,14 @@ func (mr *MockAutheticationMiddlewarerMockRecorder) RequireAuthentication(ctx in
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequireAuthentication", reflect.TypeOf((*MockAutheticationMiddlewarer)(nil).RequireAuthentication), ctx)
 }
 
-// RequirePaidFeatures mocks base method.
-func (m *MockAutheticationMiddlewarer) RequirePaidFeatures(ctx *gin.Context) {
+// Stop mocks base method.
+func (m *MockAutheticationMiddlewarer) Stop() {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "RequirePaidFeatures", ctx)
+	m.ctrl.Call(m, "Stop")
 }
 
-// RequirePaidFeatures indicates an expected call of RequirePaidFeatures.
-func (mr *MockAutheticationMiddlewarerMockRecorder) RequirePaidFeatures(ctx interface{}) *gomock.Call {
+// Stop indicates an expected call of Stop.
+func (mr *MockAutheticationMiddlewarerMockRecorder) Stop() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequirePaidFeatures", reflect.TypeOf((*MockAutheticationMiddlewarer)(nil).RequirePaidFeatures), ctx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stop", reflect.TypeOf((*MockAutheticationMiddlewarer)(nil).Stop))
 }
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RoutePolicier defines the interface for the policies adding middleware to the routes they match
+type RoutePolicier interface {
+	// Middleware returns the middleware of the route, nil when no policy matches it
+	Middleware(method, route string) (gin.HandlerFunc, error)
+}
+
+// matchesRoute reports whether the pattern is the route or, ending in *, a prefix of it
+func matchesRoute(pattern, route string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(route, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == route
+}
+
+// matchesMethod reports whether the policy method is the request method, an empty policy method matches any
+func matchesMethod(policyMethod, method string) bool {
+	return policyMethod == "" || strings.EqualFold(policyMethod, method)
+}
+
+// PolicyRouter registers routes on a router group adding the middleware of the policies matching each
+// route right before its final handler, so that they run after the authentication middleware
+type PolicyRouter struct {
+	group    *gin.RouterGroup
+	policies []RoutePolicier
+	err      *error
+}
+
+// NewPolicyRouter wraps the router group to apply the policies, in order, on registration
+func NewPolicyRouter(group *gin.RouterGroup, policies ...RoutePolicier) *PolicyRouter {
+	var err error
+	return &PolicyRouter{
+		group:    group,
+		policies: policies,
+		err:      &err,
+	}
+}
+
+// joinPaths joins a relative path to the base path of a group the way gin does
+func joinPaths(absolutePath, relativePath string) string {
+	if relativePath == "" {
+		return absolutePath
+	}
+	finalPath := path.Join(absolutePath, relativePath)
+	if strings.HasSuffix(relativePath, "/") && !strings.HasSuffix(finalPath, "/") {
+		return finalPath + "/"
+	}
+	return finalPath
+}
+
+// Group creates a new policy router group
+func (router *PolicyRouter) Group(relativePath string, handlers ...gin.HandlerFunc) *PolicyRouter {
+	return &PolicyRouter{
+		group:    router.group.Group(relativePath, handlers...),
+		policies: router.policies,
+		err:      router.err,
+	}
+}
+
+// Use adds middleware to the group
+func (router *PolicyRouter) Use(handlers ...gin.HandlerFunc) {
+	router.group.Use(handlers...)
+}
+
+// Handle registers a route adding the middleware of the policies matching it
+func (router *PolicyRouter) Handle(method, relativePath string, handlers ...gin.HandlerFunc) {
+	route := joinPaths(router.group.BasePath(), relativePath)
+	policyHandlers := []gin.HandlerFunc{}
+	for _, policy := range router.policies {
+		policyHandler, err := policy.Middleware(method, route)
+		if err != nil {
+			if *router.err == nil {
+				*router.err = fmt.Errorf("Could not apply policy to %s %s: %v", method, route, err)
+			}
+			return
+		}
+		if policyHandler != nil {
+			policyHandlers = append(policyHandlers, policyHandler)
+		}
+	}
+	if len(policyHandlers) > 0 && len(handlers) > 0 {
+		routeHandlers := make([]gin.HandlerFunc, 0, len(handlers)+len(policyHandlers))
+		routeHandlers = append(routeHandlers, handlers[:len(handlers)-1]...)
+		routeHandlers = append(routeHandlers, policyHandlers...)
+		handlers = append(routeHandlers, handlers[len(handlers)-1])
+	}
+	router.group.Handle(method, relativePath, handlers...)
+}
+
+// GET registers a GET route
+func (router *PolicyRouter) GET(relativePath string, handlers ...gin.HandlerFunc) {
+	router.Handle(http.MethodGet, relativePath, handlers...)
+}
+
+// POST registers a POST route
+func (router *PolicyRouter) POST(relativePath string, handlers ...gin.HandlerFunc) {
+	router.Handle(http.MethodPost, relativePath, handlers...)
+}
+
+// PUT registers a PUT route
+func (router *PolicyRouter) PUT(relativePath string, handlers ...gin.HandlerFunc) {
+	router.Handle(http.MethodPut, relativePath, handlers...)
+}
+
+// PATCH registers a PATCH route
+func (router *PolicyRouter) PATCH(relativePath string, handlers ...gin.HandlerFunc) {
+	router.Handle(http.MethodPatch, relativePath, handlers...)
+}
+
+// DELETE registers a DELETE route
+func (router *PolicyRouter) DELETE(relativePath string, handlers ...gin.HandlerFunc) {
+	router.Handle(http.MethodDelete, relativePath, handlers...)
+}
+
+// Err returns the first error found applying the policies to the registered routes
+func (router *PolicyRouter) Err() error {
+	return *router.err
+}
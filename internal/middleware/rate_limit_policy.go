@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+)
+
+// Key strategies of the rate limit policies
+const (
+	IPRateLimitKey   = "ip"
+	UserRateLimitKey = "user"
+)
+
+const defaultRateLimitGroup = "default"
+
+// DefaultRateLimitPolicies apply when no policy is configured, so that the sessions, registration, password
+// reset and image analysis routes are never left without rate limiting
+var DefaultRateLimitPolicies = []config.RateLimitPolicyConfig{
+	{Route: "/api/v1/user/", Method: "POST", Group: "user", Rate: 0.08, Burst: 5},
+	{Route: "/api/v1/user/sessions", Method: "POST", Group: "user", Bucket: "sessions", Rate: 0.08, Burst: 5},
+	{Route: "/api/v1/user/firebase/sessions", Method: "POST", Group: "user", Bucket: "sessions", Rate: 0.08, Burst: 5},
+	{Route: "/api/v1/user/:userID/email/verification", Method: "POST", Group: "user", Rate: 0.02, Burst: 3},
+	{Route: "/api/v1/user/password/reset", Method: "POST", Group: "user", Rate: 0.02, Burst: 3},
+	{Route: "/api/v1/user/:userID/password/*", Group: "user", Bucket: "password_reset", Rate: 0.08, Burst: 5},
+	{
+		Route:  "/api/v1/image-analysis",
+		Method: "POST",
+		Group:  "image_analysis",
+		Key:    UserRateLimitKey,
+		Plans: map[string]config.RateLimitPlanConfig{
+			FreePlan:  {Rate: 0.05, Burst: 3}, // 3 requests per minute
+			TrialPlan: {Rate: 0.1, Burst: 5},  // 6 requests per minute
+			PaidPlan:  {Rate: 0.2, Burst: 10}, // 12 requests per minute
+		},
+	},
+}
+
+// prefixedRateLimitStore namespaces the keys of a bucket inside a store shared by several buckets
+type prefixedRateLimitStore struct {
+	store  RateLimitStore
+	prefix string
+}
+
+// Allow consumes one request for the prefixed key
+func (prefixedStore *prefixedRateLimitStore) Allow(ctx context.Context, key string) (*RateLimitResult, error) {
+	return prefixedStore.store.Allow(ctx, prefixedStore.prefix+key)
+}
+
+// RateLimitPolicies resolves the rate limit middleware of each route from the configured policies
+type RateLimitPolicies struct {
+	policies []config.RateLimitPolicyConfig
+	stores   RateLimitStoreFactorier
+	buckets  map[string]gin.HandlerFunc
+	mtx      sync.Mutex
+}
+
+var _ RoutePolicier = &RateLimitPolicies{}
+
+// NewRateLimitPolicies validates the configured policies and creates their stores on demand. Without
+// configured policies the DefaultRateLimitPolicies apply.
+func NewRateLimitPolicies(configurations *config.Config, stores RateLimitStoreFactorier) (*RateLimitPolicies, error) {
+	policies := configurations.RateLimit.Policies
+	if len(policies) == 0 {
+		policies = DefaultRateLimitPolicies
+	}
+	for index, policy := range policies {
+		if policy.Route == "" {
+			return nil, fmt.Errorf("Rate limit policy %d has no route", index)
+		}
+		switch strings.ToLower(policy.Key) {
+		case "", IPRateLimitKey, UserRateLimitKey:
+		default:
+			return nil, fmt.Errorf("Unknown key strategy %q in the rate limit policy of %s", policy.Key, policy.Route)
+		}
+		if policy.Burst <= 0 && len(policy.Plans) == 0 {
+			return nil, fmt.Errorf("Rate limit policy of %s needs a burst greater than 0", policy.Route)
+		}
+	}
+	return &RateLimitPolicies{
+		policies: policies,
+		stores:   stores,
+		buckets:  make(map[string]gin.HandlerFunc),
+	}, nil
+}
+
+// Match returns the first policy matching the method and full route template, nil if there is none
+func (rateLimitPolicies *RateLimitPolicies) Match(method, route string) *config.RateLimitPolicyConfig {
+	for index := range rateLimitPolicies.policies {
+		policy := &rateLimitPolicies.policies[index]
+		if matchesMethod(policy.Method, method) && matchesRoute(policy.Route, route) {
+			return policy
+		}
+	}
+	return nil
+}
+
+func (rateLimitPolicies *RateLimitPolicies) newStore(policy *config.RateLimitPolicyConfig, bucket string, limits config.RateLimitPlanConfig) (RateLimitStore, error) {
+	group := policy.Group
+	if group == "" {
+		group = defaultRateLimitGroup
+	}
+	store, err := rateLimitPolicies.stores.NewStore(group, rate.Limit(limits.Rate), limits.Burst)
+	if err != nil {
+		return nil, err
+	}
+	return &prefixedRateLimitStore{store: store, prefix: bucket + "|"}, nil
+}
+
+// Middleware returns the rate limit middleware of the route, nil when no policy matches it.
+// Routes whose policy shares a bucket share the same middleware and budget.
+func (rateLimitPolicies *RateLimitPolicies) Middleware(method, route string) (gin.HandlerFunc, error) {
+	policy := rateLimitPolicies.Match(method, route)
+	if policy == nil {
+		return nil, nil
+	}
+	bucket := policy.Bucket
+	if bucket == "" {
+		bucket = fmt.Sprintf("%s %s", method, route)
+	}
+
+	rateLimitPolicies.mtx.Lock()
+	defer rateLimitPolicies.mtx.Unlock()
+
+	if handler, exists := rateLimitPolicies.buckets[bucket]; exists {
+		return handler, nil
+	}
+
+	policyLimits := config.RateLimitPlanConfig{Rate: policy.Rate, Burst: policy.Burst}
+	var handler gin.HandlerFunc
+	if strings.EqualFold(policy.Key, UserRateLimitKey) {
+		planStores := make(map[string]RateLimitStore)
+		for _, plan := range []string{FreePlan, TrialPlan, PaidPlan} {
+			limits := policyLimits
+			if planLimits, exists := policy.Plans[plan]; exists {
+				limits = planLimits
+			}
+			if limits.Burst <= 0 && plan == TrialPlan {
+				// The trial users are limited on the free plan store
+				continue
+			}
+			if limits.Burst <= 0 {
+				return nil, fmt.Errorf("Rate limit policy of %s has no limits for the %s plan", policy.Route, plan)
+			}
+			store, err := rateLimitPolicies.newStore(policy, bucket, limits)
+			if err != nil {
+				return nil, err
+			}
+			planStores[plan] = store
+		}
+		handler = UserRateLimitMiddleware(planStores)
+	} else {
+		store, err := rateLimitPolicies.newStore(policy, bucket, policyLimits)
+		if err != nil {
+			return nil, err
+		}
+		handler = RateLimitMiddleware(store)
+	}
+	rateLimitPolicies.buckets[bucket] = handler
+	return handler, nil
+}
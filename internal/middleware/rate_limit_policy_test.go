@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	commonJWT "github.com/quadev-ltd/qd-common/pkg/jwt"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+)
+
+func newTestRateLimitPolicies(t *testing.T, policies ...config.RateLimitPolicyConfig) *RateLimitPolicies {
+	configurations := &config.Config{
+		RateLimit: config.RateLimitConfig{Policies: policies},
+	}
+	stores := NewRateLimitStoreFactory(configurations)
+	t.Cleanup(func() { stores.Close() })
+	rateLimitPolicies, err := NewRateLimitPolicies(configurations, stores)
+	assert.NoError(t, err)
+	return rateLimitPolicies
+}
+
+func okHandler(ctx *gin.Context) {
+	ctx.Status(http.StatusOK)
+}
+
+func serve(router *gin.Engine, method, target string) int {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+	return recorder.Code
+}
+
+func TestRateLimitPolicies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("NewRateLimitPolicies_Unknown_Key_Error", func(t *testing.T) {
+		_, err := NewRateLimitPolicies(&config.Config{
+			RateLimit: config.RateLimitConfig{Policies: []config.RateLimitPolicyConfig{
+				{Route: "/user", Key: "email", Burst: 1},
+			}},
+		}, nil)
+
+		assert.Equal(t, "Unknown key strategy \"email\" in the rate limit policy of /user", err.Error())
+	})
+
+	t.Run("NewRateLimitPolicies_Missing_Burst_Error", func(t *testing.T) {
+		_, err := NewRateLimitPolicies(&config.Config{
+			RateLimit: config.RateLimitConfig{Policies: []config.RateLimitPolicyConfig{
+				{Route: "/user"},
+			}},
+		}, nil)
+
+		assert.Equal(t, "Rate limit policy of /user needs a burst greater than 0", err.Error())
+	})
+
+	t.Run("NewRateLimitPolicies_Defaults_Without_Policies", func(t *testing.T) {
+		rateLimitPolicies := newTestRateLimitPolicies(t)
+
+		assert.Equal(t, "sessions", rateLimitPolicies.Match(http.MethodPost, "/api/v1/user/sessions").Bucket)
+		assert.Equal(t, UserRateLimitKey, rateLimitPolicies.Match(http.MethodPost, "/api/v1/image-analysis").Key)
+		assert.Nil(t, rateLimitPolicies.Match(http.MethodGet, "/api/v1/user/profile"))
+	})
+
+	t.Run("Match_First_Policy_By_Method_And_Route", func(t *testing.T) {
+		rateLimitPolicies := newTestRateLimitPolicies(t,
+			config.RateLimitPolicyConfig{Route: "/user/sessions", Method: http.MethodPost, Burst: 1},
+			config.RateLimitPolicyConfig{Route: "/user/:userID/password/*", Burst: 2},
+			config.RateLimitPolicyConfig{Route: "/user/*", Burst: 3},
+		)
+
+		assert.Equal(t, 1, rateLimitPolicies.Match(http.MethodPost, "/user/sessions").Burst)
+		assert.Equal(t, 3, rateLimitPolicies.Match(http.MethodGet, "/user/sessions").Burst)
+		assert.Equal(t, 2, rateLimitPolicies.Match(http.MethodGet, "/user/:userID/password/reset-verification/:verificationToken").Burst)
+		assert.Nil(t, rateLimitPolicies.Match(http.MethodGet, "/image-analysis"))
+	})
+
+	t.Run("PolicyRouter_Separate_Buckets_Per_Route", func(t *testing.T) {
+		rateLimitPolicies := newTestRateLimitPolicies(t,
+			config.RateLimitPolicyConfig{Route: "/api/user/*", Rate: 0.01, Burst: 1},
+		)
+		engine := gin.New()
+		router := NewPolicyRouter(engine.Group("/api"), rateLimitPolicies)
+		userRoutes := router.Group("/user")
+		userRoutes.POST("/password/reset", okHandler)
+		userRoutes.POST("/sessions", okHandler)
+
+		assert.NoError(t, router.Err())
+		assert.Equal(t, http.StatusOK, serve(engine, http.MethodPost, "/api/user/password/reset"))
+		assert.Equal(t, http.StatusTooManyRequests, serve(engine, http.MethodPost, "/api/user/password/reset"))
+		// A noisy route does not eat the budget of another one
+		assert.Equal(t, http.StatusOK, serve(engine, http.MethodPost, "/api/user/sessions"))
+	})
+
+	t.Run("PolicyRouter_Shared_Bucket", func(t *testing.T) {
+		rateLimitPolicies := newTestRateLimitPolicies(t,
+			config.RateLimitPolicyConfig{Route: "/user/sessions", Bucket: "sessions", Rate: 0.01, Burst: 1},
+			config.RateLimitPolicyConfig{Route: "/user/firebase/sessions", Bucket: "sessions", Rate: 0.01, Burst: 1},
+		)
+		engine := gin.New()
+		router := NewPolicyRouter(&engine.RouterGroup, rateLimitPolicies)
+		router.POST("/user/sessions", okHandler)
+		router.POST("/user/firebase/sessions", okHandler)
+		router.POST("/user/profile", okHandler)
+
+		assert.Equal(t, http.StatusOK, serve(engine, http.MethodPost, "/user/sessions"))
+		assert.Equal(t, http.StatusTooManyRequests, serve(engine, http.MethodPost, "/user/firebase/sessions"))
+		// Routes without a policy are not limited
+		assert.Equal(t, http.StatusOK, serve(engine, http.MethodPost, "/user/profile"))
+		assert.Equal(t, http.StatusOK, serve(engine, http.MethodPost, "/user/profile"))
+	})
+
+	t.Run("PolicyRouter_User_Key_Runs_After_Authentication", func(t *testing.T) {
+		rateLimitPolicies := newTestRateLimitPolicies(t,
+			config.RateLimitPolicyConfig{
+				Route: "/image-analysis",
+				Key:   UserRateLimitKey,
+				Plans: map[string]config.RateLimitPlanConfig{
+					FreePlan: {Rate: 0.01, Burst: 1},
+					PaidPlan: {Rate: 0.01, Burst: 2},
+				},
+			},
+		)
+		authenticate := func(ctx *gin.Context) {
+			ctx.Set(string(commonJWT.ClaimsContextKey), &commonJWT.TokenClaims{
+				UserID:          ctx.Query("user"),
+				HasPaidFeatures: true,
+			})
+		}
+		engine := gin.New()
+		router := NewPolicyRouter(&engine.RouterGroup, rateLimitPolicies)
+		router.POST("/image-analysis", authenticate, okHandler)
+
+		assert.Equal(t, http.StatusOK, serve(engine, http.MethodPost, "/image-analysis?user=first"))
+		assert.Equal(t, http.StatusOK, serve(engine, http.MethodPost, "/image-analysis?user=first"))
+		assert.Equal(t, http.StatusTooManyRequests, serve(engine, http.MethodPost, "/image-analysis?user=first"))
+		assert.Equal(t, http.StatusOK, serve(engine, http.MethodPost, "/image-analysis?user=second"))
+	})
+
+	t.Run("PolicyRouter_Store_Error", func(t *testing.T) {
+		configurations := &config.Config{
+			RateLimit: config.RateLimitConfig{
+				Store:    "memcached",
+				Policies: []config.RateLimitPolicyConfig{{Route: "/user", Burst: 1}},
+			},
+		}
+		rateLimitPolicies, err := NewRateLimitPolicies(configurations, NewRateLimitStoreFactory(configurations))
+		assert.NoError(t, err)
+		router := NewPolicyRouter(&gin.New().RouterGroup, rateLimitPolicies)
+
+		router.POST("/user", okHandler)
+
+		assert.Equal(t, "Could not apply policy to POST /user: Unknown rate limit store \"memcached\" for the default rate limit group", router.Err().Error())
+	})
+}
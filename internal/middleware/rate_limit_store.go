@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/time/rate"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+)
+
+// Rate limit store names accepted in the configuration
+const (
+	MemoryRateLimitStore = "memory"
+	RedisRateLimitStore  = "redis"
+)
+
+// RateLimitResult describes the outcome of a rate limit decision
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	ResetAfter time.Duration
+}
+
+// RateLimitStore defines the interface for the backends holding rate limit state
+type RateLimitStore interface {
+	// Allow consumes one request for the key and reports whether it is allowed
+	Allow(ctx context.Context, key string) (*RateLimitResult, error)
+}
+
+// RateLimitStoreFactorier defines the interface for creating the rate limit store of each route group
+type RateLimitStoreFactorier interface {
+	// NewStore creates the store configured for the route group with the given rate and burst
+	NewStore(group string, r rate.Limit, b int) (RateLimitStore, error)
+	// Close releases every store created and the shared connections
+	Close() error
+}
+
+// RateLimitStoreFactory implements the RateLimitStoreFactorier interface
+type RateLimitStoreFactory struct {
+	configurations *config.Config
+	redisClient    redis.UniversalClient
+	memoryStores   []*RateLimiter
+	mtx            sync.Mutex
+}
+
+var _ RateLimitStoreFactorier = &RateLimitStoreFactory{}
+
+// NewRateLimitStoreFactory creates a factory of rate limit stores from the configuration
+func NewRateLimitStoreFactory(configurations *config.Config) *RateLimitStoreFactory {
+	return &RateLimitStoreFactory{
+		configurations: configurations,
+	}
+}
+
+// storeName returns the store configured for the route group, falling back to the default store
+func (factory *RateLimitStoreFactory) storeName(group string) string {
+	rateLimitConfig := factory.configurations.RateLimit
+	if storeName, exists := rateLimitConfig.Groups[group]; exists && storeName != "" {
+		return strings.ToLower(storeName)
+	}
+	if rateLimitConfig.Store != "" {
+		return strings.ToLower(rateLimitConfig.Store)
+	}
+	return MemoryRateLimitStore
+}
+
+func (factory *RateLimitStoreFactory) getRedisClient() redis.UniversalClient {
+	if factory.redisClient == nil {
+		redisConfig := factory.configurations.RateLimit.Redis
+		factory.redisClient = redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs:    strings.Split(redisConfig.Address, ","),
+			Password: redisConfig.Password,
+			DB:       redisConfig.DB,
+		})
+	}
+	return factory.redisClient
+}
+
+// NewStore creates the store configured for the route group with the given rate and burst
+func (factory *RateLimitStoreFactory) NewStore(group string, r rate.Limit, b int) (RateLimitStore, error) {
+	factory.mtx.Lock()
+	defer factory.mtx.Unlock()
+
+	switch storeName := factory.storeName(group); storeName {
+	case MemoryRateLimitStore:
+		store := NewRateLimiter(r, b, factory.configurations.RateLimit)
+		factory.memoryStores = append(factory.memoryStores, store)
+		return store, nil
+	case RedisRateLimitStore:
+		if factory.configurations.RateLimit.Redis.Address == "" {
+			return nil, fmt.Errorf("Redis address is required for the %s rate limit group", group)
+		}
+		keyPrefix := factory.configurations.RateLimit.Redis.KeyPrefix
+		if keyPrefix == "" {
+			keyPrefix = DefaultRedisKeyPrefix
+		}
+		return NewRedisRateLimiter(factory.getRedisClient(), fmt.Sprintf("%s:%s:", keyPrefix, group), r, b), nil
+	default:
+		return nil, fmt.Errorf("Unknown rate limit store %q for the %s rate limit group", storeName, group)
+	}
+}
+
+// Close stops the memory stores and closes the Redis connection
+func (factory *RateLimitStoreFactory) Close() error {
+	factory.mtx.Lock()
+	defer factory.mtx.Unlock()
+
+	for _, store := range factory.memoryStores {
+		store.Stop()
+	}
+	factory.memoryStores = nil
+	if factory.redisClient != nil {
+		err := factory.redisClient.Close()
+		factory.redisClient = nil
+		return err
+	}
+	return nil
+}
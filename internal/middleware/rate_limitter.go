@@ -1,59 +1,277 @@
 package middleware
 
 import (
+	"container/list"
+	"context"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	commonJWT "github.com/quadev-ltd/qd-common/pkg/jwt"
+	commonLogger "github.com/quadev-ltd/qd-common/pkg/log"
 	"golang.org/x/time/rate"
 
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/metrics"
 )
 
+// Default rate limiter bounds used when the configuration does not provide them
+const (
+	DefaultRateLimitIdleTimeout     = 10 * time.Minute
+	DefaultRateLimitCleanupInterval = time.Minute
+	DefaultRateLimitMaxKeys         = 100000
+)
+
+type rateLimiterEntry struct {
+	key      string
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
 // RateLimiter settings type
 type RateLimiter struct {
-	rate  rate.Limit
-	burst int
-	ips   map[string]*rate.Limiter
-	mtx   sync.Mutex
+	rate            rate.Limit
+	burst           int
+	idleTimeout     time.Duration
+	cleanupInterval time.Duration
+	maxKeys         int
+	keys            map[string]*list.Element
+	recentlyUsed    *list.List
+	mtx             sync.Mutex
+	stop            chan struct{}
+	stopOnce        sync.Once
+	now             func() time.Time
 }
 
-// NewRateLimiter Return new RateLimiter
-func NewRateLimiter(r rate.Limit, b int) *RateLimiter {
-	return &RateLimiter{
-		rate:  r,
-		burst: b,
-		ips:   make(map[string]*rate.Limiter),
+var _ RateLimitStore = &RateLimiter{}
+
+// NewRateLimiter Return new RateLimiter evicting idle keys in the background and
+// keeping at most the configured number of keys, dropping the least recently used
+func NewRateLimiter(r rate.Limit, b int, limits config.RateLimitConfig) *RateLimiter {
+	idleTimeout := limits.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultRateLimitIdleTimeout
+	}
+	// An idle limiter must not be evicted before its bucket would have refilled,
+	// otherwise eviction would hand out extra tokens
+	if r > 0 && r != rate.Inf {
+		refillTime := time.Duration(float64(b) / float64(r) * float64(time.Second))
+		if idleTimeout < refillTime {
+			idleTimeout = refillTime
+		}
+	}
+	cleanupInterval := limits.CleanupInterval
+	if cleanupInterval <= 0 {
+		cleanupInterval = DefaultRateLimitCleanupInterval
+	}
+	maxKeys := limits.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = DefaultRateLimitMaxKeys
 	}
+
+	rateLimitter := &RateLimiter{
+		rate:            r,
+		burst:           b,
+		idleTimeout:     idleTimeout,
+		cleanupInterval: cleanupInterval,
+		maxKeys:         maxKeys,
+		keys:            make(map[string]*list.Element),
+		recentlyUsed:    list.New(),
+		stop:            make(chan struct{}),
+		now:             time.Now,
+	}
+	go rateLimitter.runJanitor()
+	return rateLimitter
 }
 
-// GetLimiter returns rate limiter instance for a given IP address locking instance
-func (rateLimitter *RateLimiter) GetLimiter(ip string) *rate.Limiter {
+// GetLimiter returns rate limiter instance for a given key locking instance
+func (rateLimitter *RateLimiter) GetLimiter(key string) *rate.Limiter {
 	rateLimitter.mtx.Lock()
 	defer rateLimitter.mtx.Unlock()
 
-	limiter, exists := rateLimitter.ips[ip]
-	if !exists {
-		limiter = rate.NewLimiter(rateLimitter.rate, rateLimitter.burst)
-		rateLimitter.ips[ip] = limiter
+	now := rateLimitter.now()
+	if element, exists := rateLimitter.keys[key]; exists {
+		entry := element.Value.(*rateLimiterEntry)
+		entry.lastSeen = now
+		rateLimitter.recentlyUsed.MoveToFront(element)
+		return entry.limiter
+	}
+
+	entry := &rateLimiterEntry{
+		key:      key,
+		limiter:  rate.NewLimiter(rateLimitter.rate, rateLimitter.burst),
+		lastSeen: now,
+	}
+	rateLimitter.keys[key] = rateLimitter.recentlyUsed.PushFront(entry)
+	for rateLimitter.recentlyUsed.Len() > rateLimitter.maxKeys {
+		rateLimitter.removeElement(rateLimitter.recentlyUsed.Back())
 	}
 
-	return limiter
+	return entry.limiter
 }
 
-// RateLimitMiddleware returns the rate limiter middleware
-func RateLimitMiddleware(rl *RateLimiter) gin.HandlerFunc {
+// Allow consumes one request for the key and reports whether it is allowed
+func (rateLimitter *RateLimiter) Allow(ctx context.Context, key string) (*RateLimitResult, error) {
+	limiter := rateLimitter.GetLimiter(key)
+	now := rateLimitter.now()
+
+	result := &RateLimitResult{
+		Limit: rateLimitter.burst,
+	}
+	reservation := limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		result.RetryAfter = rateLimitter.idleTimeout
+		result.ResetAfter = rateLimitter.idleTimeout
+		return result, nil
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		result.RetryAfter = delay
+	} else {
+		result.Allowed = true
+	}
+
+	tokens := limiter.TokensAt(now)
+	if tokens > 0 {
+		result.Remaining = int(math.Floor(tokens))
+	}
+	if rateLimitter.rate > 0 && rateLimitter.rate != rate.Inf {
+		missingTokens := float64(rateLimitter.burst) - tokens
+		result.ResetAfter = time.Duration(missingTokens / float64(rateLimitter.rate) * float64(time.Second))
+	}
+	return result, nil
+}
+
+// Len returns the number of keys currently tracked
+func (rateLimitter *RateLimiter) Len() int {
+	rateLimitter.mtx.Lock()
+	defer rateLimitter.mtx.Unlock()
+
+	return rateLimitter.recentlyUsed.Len()
+}
+
+func (rateLimitter *RateLimiter) removeElement(element *list.Element) {
+	entry := rateLimitter.recentlyUsed.Remove(element).(*rateLimiterEntry)
+	delete(rateLimitter.keys, entry.key)
+}
+
+// evictIdle removes every key not seen within the idle timeout
+func (rateLimitter *RateLimiter) evictIdle() {
+	rateLimitter.mtx.Lock()
+	defer rateLimitter.mtx.Unlock()
+
+	now := rateLimitter.now()
+	for element := rateLimitter.recentlyUsed.Back(); element != nil; element = rateLimitter.recentlyUsed.Back() {
+		entry := element.Value.(*rateLimiterEntry)
+		if now.Sub(entry.lastSeen) < rateLimitter.idleTimeout {
+			return
+		}
+		rateLimitter.removeElement(element)
+	}
+}
+
+func (rateLimitter *RateLimiter) runJanitor() {
+	ticker := time.NewTicker(rateLimitter.cleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rateLimitter.evictIdle()
+		case <-rateLimitter.stop:
+			return
+		}
+	}
+}
+
+// Stop ends the background eviction of idle keys
+func (rateLimitter *RateLimiter) Stop() {
+	rateLimitter.stopOnce.Do(func() {
+		close(rateLimitter.stop)
+	})
+}
+
+// Plan tiers used to choose the rate limits of authenticated users
+const (
+	FreePlan = "free"
+	PaidPlan = "paid"
+)
+
+// RateLimitMiddleware returns the rate limiter middleware keyed on the client IP
+func RateLimitMiddleware(store RateLimitStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ip := c.ClientIP()
-		limiter := rl.GetLimiter(ip)
+		applyRateLimit(c, store, c.ClientIP())
+	}
+}
 
-		if !limiter.Allow() {
-			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
-				"error": errors.TooManyRequests,
-			})
+// UserRateLimitMiddleware returns the rate limiter middleware keyed on the authenticated user ID,
+// using the store of the plan of their entitlements, the free plan store when their plan has none. It must run
+// after the authentication middleware has set the claims; requests without claims are limited by client IP
+// on the free plan store.
+func UserRateLimitMiddleware(planStores map[string]RateLimitStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := c.Value(string(commonJWT.ClaimsContextKey)).(*commonJWT.TokenClaims)
+		if !ok || claims.UserID == "" {
+			applyRateLimit(c, planStores[FreePlan], c.ClientIP())
 			return
 		}
+		plan := EntitlementsFromClaims(claims, MapClaimsFromContext(c)).Plan
+		store, exists := planStores[plan]
+		if !exists {
+			plan = FreePlan
+			store = planStores[FreePlan]
+		}
+		applyRateLimit(c, store, fmt.Sprintf("user:%s:%s", plan, claims.UserID))
+	}
+}
+
+// Rate limit response headers
+const (
+	RateLimitLimitHeader     = "RateLimit-Limit"
+	RateLimitRemainingHeader = "RateLimit-Remaining"
+	RateLimitResetHeader     = "RateLimit-Reset"
+	RetryAfterHeader         = "Retry-After"
+)
+
+// ceilSeconds rounds a duration up to whole seconds as used by the rate limit headers
+func ceilSeconds(duration time.Duration) int64 {
+	if duration <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(duration.Seconds()))
+}
+
+// setRateLimitHeaders writes the rate limit state, and when rejected when to retry
+func setRateLimitHeaders(c *gin.Context, result *RateLimitResult) {
+	c.Header(RateLimitLimitHeader, strconv.Itoa(result.Limit))
+	c.Header(RateLimitRemainingHeader, strconv.Itoa(result.Remaining))
+	c.Header(RateLimitResetHeader, strconv.FormatInt(ceilSeconds(result.ResetAfter), 10))
+	if !result.Allowed {
+		c.Header(RetryAfterHeader, strconv.FormatInt(ceilSeconds(result.RetryAfter), 10))
+	}
+}
 
+func applyRateLimit(c *gin.Context, store RateLimitStore, key string) {
+	result, err := store.Allow(c.Request.Context(), key)
+	if err != nil {
+		// Fail open so an unavailable shared store does not take the gateway down
+		if logger, loggerErr := commonLogger.GetLoggerFromContext(c.Request.Context()); loggerErr == nil {
+			logger.Error(err, "Could not evaluate rate limit")
+		}
 		c.Next()
+		return
+	}
+
+	setRateLimitHeaders(c, result)
+	if !result.Allowed {
+		metrics.RateLimitRejections.WithLabelValues(metrics.RouteTemplate(c)).Inc()
+		errors.Abort(c, errors.New(http.StatusTooManyRequests, errors.TooManyRequests, "Too many requests, retry later"))
+		return
 	}
+
+	c.Next()
 }
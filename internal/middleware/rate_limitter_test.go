@@ -0,0 +1,266 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	commonJWT "github.com/quadev-ltd/qd-common/pkg/jwt"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+)
+
+func heapInUse() uint64 {
+	runtime.GC()
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	return memStats.HeapInuse
+}
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("GetLimiter_Returns_Same_Limiter_For_Key", func(t *testing.T) {
+		rateLimiter := NewRateLimiter(rate.Limit(1), 1, config.RateLimitConfig{})
+		defer rateLimiter.Stop()
+
+		assert.Same(t, rateLimiter.GetLimiter("127.0.0.1"), rateLimiter.GetLimiter("127.0.0.1"))
+		assert.Equal(t, 1, rateLimiter.Len())
+	})
+
+	t.Run("GetLimiter_Flood_Of_Unique_IPs_Stays_Bounded", func(t *testing.T) {
+		maxKeys := 1000
+		rateLimiter := NewRateLimiter(rate.Limit(1), 1, config.RateLimitConfig{MaxKeys: maxKeys})
+		defer rateLimiter.Stop()
+		for index := 0; index < maxKeys; index++ {
+			rateLimiter.GetLimiter(fmt.Sprintf("10.0.%d.%d", index/256, index%256))
+		}
+		heapAtCapacity := heapInUse()
+
+		for index := 0; index < 200000; index++ {
+			rateLimiter.GetLimiter(fmt.Sprintf("%d.%d.%d.%d", index>>24&255, index>>16&255, index>>8&255, index&255))
+		}
+
+		assert.Equal(t, maxKeys, rateLimiter.Len())
+		assert.Equal(t, maxKeys, len(rateLimiter.keys))
+		// 200 times more keys than the capacity must not grow the heap beyond a small margin
+		assert.Less(t, heapInUse(), heapAtCapacity+4*1024*1024)
+	})
+
+	t.Run("GetLimiter_Evicts_Least_Recently_Used", func(t *testing.T) {
+		rateLimiter := NewRateLimiter(rate.Limit(1), 1, config.RateLimitConfig{MaxKeys: 2})
+		defer rateLimiter.Stop()
+		first := rateLimiter.GetLimiter("first")
+		rateLimiter.GetLimiter("second")
+		rateLimiter.GetLimiter("first")
+
+		rateLimiter.GetLimiter("third")
+
+		assert.Equal(t, 2, rateLimiter.Len())
+		assert.Contains(t, rateLimiter.keys, "first")
+		assert.Contains(t, rateLimiter.keys, "third")
+		assert.NotContains(t, rateLimiter.keys, "second")
+		assert.Same(t, first, rateLimiter.GetLimiter("first"))
+	})
+
+	t.Run("EvictIdle_Removes_Idle_Keys", func(t *testing.T) {
+		rateLimiter := NewRateLimiter(rate.Limit(1), 1, config.RateLimitConfig{IdleTimeout: time.Minute})
+		defer rateLimiter.Stop()
+		now := time.Now()
+		rateLimiter.now = func() time.Time { return now }
+		rateLimiter.GetLimiter("idle")
+		now = now.Add(30 * time.Second)
+		rateLimiter.GetLimiter("active")
+
+		now = now.Add(45 * time.Second)
+		rateLimiter.evictIdle()
+
+		assert.Equal(t, 1, rateLimiter.Len())
+		assert.Contains(t, rateLimiter.keys, "active")
+	})
+
+	t.Run("NewRateLimiter_Idle_Timeout_Covers_Refill_Time", func(t *testing.T) {
+		rateLimiter := NewRateLimiter(rate.Limit(0.05), 3, config.RateLimitConfig{IdleTimeout: time.Second})
+		defer rateLimiter.Stop()
+
+		assert.Equal(t, time.Minute, rateLimiter.idleTimeout)
+	})
+
+	t.Run("Janitor_Evicts_In_Background", func(t *testing.T) {
+		rateLimiter := NewRateLimiter(rate.Limit(1000), 1, config.RateLimitConfig{
+			IdleTimeout:     10 * time.Millisecond,
+			CleanupInterval: 10 * time.Millisecond,
+		})
+		defer rateLimiter.Stop()
+		rateLimiter.GetLimiter("127.0.0.1")
+
+		assert.Eventually(t, func() bool {
+			return rateLimiter.Len() == 0
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Allow_Reports_Remaining_And_Retry_After", func(t *testing.T) {
+		rateLimiter := NewRateLimiter(rate.Limit(1), 2, config.RateLimitConfig{})
+		defer rateLimiter.Stop()
+		now := time.Now()
+		rateLimiter.now = func() time.Time { return now }
+
+		first, err := rateLimiter.Allow(context.Background(), "127.0.0.1")
+		assert.NoError(t, err)
+		second, err := rateLimiter.Allow(context.Background(), "127.0.0.1")
+		assert.NoError(t, err)
+		third, err := rateLimiter.Allow(context.Background(), "127.0.0.1")
+		assert.NoError(t, err)
+
+		assert.True(t, first.Allowed)
+		assert.Equal(t, 1, first.Remaining)
+		assert.True(t, second.Allowed)
+		assert.Equal(t, 0, second.Remaining)
+		assert.False(t, third.Allowed)
+		assert.Equal(t, time.Second, third.RetryAfter)
+		assert.Equal(t, 2*time.Second, third.ResetAfter)
+	})
+
+	t.Run("RateLimitMiddleware_Too_Many_Requests", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+		rateLimiter := NewRateLimiter(rate.Limit(0.01), 2, config.RateLimitConfig{})
+		defer rateLimiter.Stop()
+		router := gin.New()
+		router.GET("/test", RateLimitMiddleware(rateLimiter), func(ctx *gin.Context) {
+			ctx.Status(http.StatusOK)
+		})
+
+		codes := []int{}
+		for index := 0; index < 3; index++ {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/test", nil))
+			codes = append(codes, recorder.Code)
+		}
+
+		assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+	})
+
+	t.Run("UserRateLimitMiddleware_Keys_On_User_And_Plan", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+		freeStore := NewRateLimiter(rate.Limit(0.01), 1, config.RateLimitConfig{})
+		defer freeStore.Stop()
+		paidStore := NewRateLimiter(rate.Limit(0.01), 2, config.RateLimitConfig{})
+		defer paidStore.Stop()
+		claimsByUser := map[string]*commonJWT.TokenClaims{
+			"free-user":         {UserID: "free-user"},
+			"another-free-user": {UserID: "another-free-user"},
+			"paid-user":         {UserID: "paid-user", HasPaidFeatures: true},
+		}
+		router := gin.New()
+		router.GET("/test", func(ctx *gin.Context) {
+			if claims, exists := claimsByUser[ctx.Query("user")]; exists {
+				ctx.Set(string(commonJWT.ClaimsContextKey), claims)
+			}
+		}, UserRateLimitMiddleware(map[string]RateLimitStore{
+			FreePlan: freeStore,
+			PaidPlan: paidStore,
+		}), func(ctx *gin.Context) {
+			ctx.Status(http.StatusOK)
+		})
+		request := func(user string) int {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/test?user="+user, nil))
+			return recorder.Code
+		}
+
+		// Every request comes from the same IP, each user has their own budget
+		assert.Equal(t, http.StatusOK, request("free-user"))
+		assert.Equal(t, http.StatusTooManyRequests, request("free-user"))
+		assert.Equal(t, http.StatusOK, request("another-free-user"))
+		assert.Equal(t, http.StatusOK, request("paid-user"))
+		assert.Equal(t, http.StatusOK, request("paid-user"))
+		assert.Equal(t, http.StatusTooManyRequests, request("paid-user"))
+		assert.Contains(t, paidStore.keys, "user:paid:paid-user")
+		// Without claims the client IP is limited on the free plan
+		assert.Equal(t, http.StatusOK, request(""))
+		assert.Equal(t, http.StatusTooManyRequests, request(""))
+	})
+
+	t.Run("UserRateLimitMiddleware_Plan_From_Entitlements", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+		freeStore := NewRateLimiter(rate.Limit(0.01), 1, config.RateLimitConfig{})
+		defer freeStore.Stop()
+		trialStore := NewRateLimiter(rate.Limit(0.01), 2, config.RateLimitConfig{})
+		defer trialStore.Stop()
+		router := gin.New()
+		router.GET("/test", func(ctx *gin.Context) {
+			ctx.Set(string(commonJWT.ClaimsContextKey), &commonJWT.TokenClaims{UserID: "trial-user"})
+			ctx.Set(string(commonJWT.JWTTokenKey), &jwt.Token{Claims: jwt.MapClaims{PlanClaim: TrialPlan}})
+		}, UserRateLimitMiddleware(map[string]RateLimitStore{
+			FreePlan:  freeStore,
+			TrialPlan: trialStore,
+		}), func(ctx *gin.Context) {
+			ctx.Status(http.StatusOK)
+		})
+		request := func() int {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/test", nil))
+			return recorder.Code
+		}
+
+		// The plan claim takes the trial user to the trial store, as it does for metering
+		assert.Equal(t, http.StatusOK, request())
+		assert.Equal(t, http.StatusOK, request())
+		assert.Equal(t, http.StatusTooManyRequests, request())
+		assert.Contains(t, trialStore.keys, "user:trial:trial-user")
+		assert.NotContains(t, freeStore.keys, "user:free:trial-user")
+	})
+
+	t.Run("RateLimitMiddleware_Sets_Rate_Limit_Headers", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+		rateLimiter := NewRateLimiter(rate.Limit(0.5), 2, config.RateLimitConfig{})
+		defer rateLimiter.Stop()
+		now := time.Now()
+		rateLimiter.now = func() time.Time { return now }
+		router := gin.New()
+		router.GET("/test", RateLimitMiddleware(rateLimiter), func(ctx *gin.Context) {
+			ctx.Status(http.StatusOK)
+		})
+		request := func() *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/test", nil))
+			return recorder
+		}
+
+		first := request()
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.Equal(t, "2", first.Header().Get(RateLimitLimitHeader))
+		assert.Equal(t, "1", first.Header().Get(RateLimitRemainingHeader))
+		assert.Equal(t, "2", first.Header().Get(RateLimitResetHeader))
+		assert.Empty(t, first.Header().Get(RetryAfterHeader))
+
+		second := request()
+		assert.Equal(t, http.StatusOK, second.Code)
+		assert.Equal(t, "0", second.Header().Get(RateLimitRemainingHeader))
+		assert.Equal(t, "4", second.Header().Get(RateLimitResetHeader))
+
+		rejected := request()
+		assert.Equal(t, http.StatusTooManyRequests, rejected.Code)
+		assert.Equal(t, "2", rejected.Header().Get(RateLimitLimitHeader))
+		assert.Equal(t, "0", rejected.Header().Get(RateLimitRemainingHeader))
+		assert.Equal(t, "4", rejected.Header().Get(RateLimitResetHeader))
+		assert.Equal(t, "2", rejected.Header().Get(RetryAfterHeader))
+
+		now = now.Add(1500 * time.Millisecond)
+		stillRejected := request()
+		assert.Equal(t, http.StatusTooManyRequests, stillRejected.Code)
+		assert.Equal(t, "1", stillRejected.Header().Get(RetryAfterHeader))
+
+		now = now.Add(500 * time.Millisecond)
+		allowedAgain := request()
+		assert.Equal(t, http.StatusOK, allowedAgain.Code)
+		assert.Equal(t, "0", allowedAgain.Header().Get(RateLimitRemainingHeader))
+		assert.Equal(t, "4", allowedAgain.Header().Get(RateLimitResetHeader))
+	})
+}
@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+)
+
+// Entitlement lookups accepted in the configuration
+const (
+	NoEntitlementLookup    = "none"
+	RedisEntitlementLookup = "redis"
+)
+
+// DefaultEntitlementsRedisKeyPrefix is used when no Redis key prefix is configured for the entitlements
+const DefaultEntitlementsRedisKeyPrefix = "entitlements"
+
+// Fields of the entitlement hashes
+const (
+	planField        = "plan"
+	trialExpiryField = "trial_exp"
+	featuresField    = "features"
+)
+
+// RedisEntitlementLookuper implements the EntitlementLookuper interface with the entitlements written to
+// Redis by the billing side of the authentication service, so that plan changes apply before the tokens
+// are refreshed. Each user has a hash "<prefix>:<user ID>" with the fields plan, trial_exp in Unix seconds
+// and features, a comma separated list.
+type RedisEntitlementLookuper struct {
+	client    redis.UniversalClient
+	keyPrefix string
+}
+
+var _ EntitlementLookuper = &RedisEntitlementLookuper{}
+
+// NewRedisEntitlementLookuper creates a lookup of the entitlements stored in Redis
+func NewRedisEntitlementLookuper(client redis.UniversalClient, keyPrefix string) *RedisEntitlementLookuper {
+	return &RedisEntitlementLookuper{
+		client:    client,
+		keyPrefix: keyPrefix,
+	}
+}
+
+// NewEntitlementLookuper creates the entitlement lookup selected in the configuration, nil when there is none
+func NewEntitlementLookuper(configurations *config.Config) (*RedisEntitlementLookuper, error) {
+	entitlementsConfig := configurations.Entitlements
+	switch lookup := strings.ToLower(entitlementsConfig.Lookup); lookup {
+	case "", NoEntitlementLookup:
+		return nil, nil
+	case RedisEntitlementLookup:
+		if entitlementsConfig.Redis.Address == "" {
+			return nil, fmt.Errorf("Redis address is required for the entitlement lookup")
+		}
+		keyPrefix := entitlementsConfig.Redis.KeyPrefix
+		if keyPrefix == "" {
+			keyPrefix = DefaultEntitlementsRedisKeyPrefix
+		}
+		client := redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs:    strings.Split(entitlementsConfig.Redis.Address, ","),
+			Password: entitlementsConfig.Redis.Password,
+			DB:       entitlementsConfig.Redis.DB,
+		})
+		return NewRedisEntitlementLookuper(client, keyPrefix), nil
+	default:
+		return nil, fmt.Errorf("Unknown entitlement lookup %q", lookup)
+	}
+}
+
+// LookupEntitlements returns the entitlements stored for the user, empty ones when there are none
+func (lookup *RedisEntitlementLookuper) LookupEntitlements(ctx context.Context, userID string) (*Entitlements, error) {
+	fields, err := lookup.client.HGetAll(ctx, fmt.Sprintf("%s:%s", lookup.keyPrefix, userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("Could not look up the entitlements in redis: %v", err)
+	}
+	entitlements := &Entitlements{
+		Plan:     fields[planField],
+		Features: make(map[string]bool),
+	}
+	if trialExpiry := fields[trialExpiryField]; trialExpiry != "" {
+		seconds, err := strconv.ParseInt(trialExpiry, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid trial expiry for %s: %v", userID, err)
+		}
+		entitlements.TrialExpiresAt = time.Unix(seconds, 0)
+	}
+	for _, feature := range strings.Split(fields[featuresField], ",") {
+		if feature = strings.TrimSpace(feature); feature != "" {
+			entitlements.Features[feature] = true
+		}
+	}
+	return entitlements, nil
+}
+
+// Close closes the Redis connection
+func (lookup *RedisEntitlementLookuper) Close() error {
+	return lookup.client.Close()
+}
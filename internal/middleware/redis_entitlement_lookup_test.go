@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+)
+
+func TestRedisEntitlementLookuper(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("LookupEntitlements_Stored_Success", func(t *testing.T) {
+		redisServer := miniredis.RunT(t)
+		lookup := NewRedisEntitlementLookuper(redis.NewClient(&redis.Options{Addr: redisServer.Addr()}), "entitlements")
+		defer lookup.Close()
+		trialExpiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+		redisServer.HSet("entitlements:user", planField, TrialPlan)
+		redisServer.HSet("entitlements:user", trialExpiryField, strconv.FormatInt(trialExpiresAt.Unix(), 10))
+		redisServer.HSet("entitlements:user", featuresField, "image-analysis, export")
+
+		entitlements, err := lookup.LookupEntitlements(ctx, "user")
+
+		assert.NoError(t, err)
+		assert.Equal(t, &Entitlements{
+			Plan:           TrialPlan,
+			TrialExpiresAt: trialExpiresAt,
+			Features:       map[string]bool{"image-analysis": true, "export": true},
+		}, entitlements)
+	})
+
+	t.Run("LookupEntitlements_Missing_Empty", func(t *testing.T) {
+		redisServer := miniredis.RunT(t)
+		lookup := NewRedisEntitlementLookuper(redis.NewClient(&redis.Options{Addr: redisServer.Addr()}), "entitlements")
+		defer lookup.Close()
+
+		entitlements, err := lookup.LookupEntitlements(ctx, "user")
+
+		assert.NoError(t, err)
+		assert.Equal(t, &Entitlements{Features: map[string]bool{}}, entitlements)
+	})
+
+	t.Run("LookupEntitlements_Invalid_Trial_Expiry_Error", func(t *testing.T) {
+		redisServer := miniredis.RunT(t)
+		lookup := NewRedisEntitlementLookuper(redis.NewClient(&redis.Options{Addr: redisServer.Addr()}), "entitlements")
+		defer lookup.Close()
+		redisServer.HSet("entitlements:user", trialExpiryField, "tomorrow")
+
+		_, err := lookup.LookupEntitlements(ctx, "user")
+
+		assert.Error(t, err)
+	})
+
+	t.Run("NewEntitlementLookuper_None_Nil", func(t *testing.T) {
+		lookup, err := NewEntitlementLookuper(&config.Config{
+			Entitlements: config.EntitlementsConfig{Lookup: NoEntitlementLookup},
+		})
+
+		assert.NoError(t, err)
+		assert.Nil(t, lookup)
+	})
+
+	t.Run("NewEntitlementLookuper_Unknown_Error", func(t *testing.T) {
+		_, err := NewEntitlementLookuper(&config.Config{
+			Entitlements: config.EntitlementsConfig{Lookup: "billing"},
+		})
+
+		assert.Equal(t, "Unknown entitlement lookup \"billing\"", err.Error())
+	})
+}
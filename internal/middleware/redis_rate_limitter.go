@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/time/rate"
+)
+
+// DefaultRedisKeyPrefix prefixes every rate limit key stored in Redis
+const DefaultRedisKeyPrefix = "rate_limit"
+
+// gcraScript implements the generic cell rate algorithm atomically. It stores the
+// theoretical arrival time (TAT) of the next request per key, all times in microseconds. The time is
+// the one of the Redis server, the clocks of the replicas sharing the limits may be skewed.
+// KEYS[1] key, ARGV[1] emission interval, ARGV[2] burst
+var gcraScript = redis.NewScript(`
+redis.replicate_commands()
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local emission_interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if tat == nil or tat < now then
+  tat = now
+end
+local new_tat = tat + emission_interval
+local allow_at = new_tat - emission_interval * burst
+local difference = now - allow_at
+if difference < 0 then
+  return {0, 0, -difference, tat - now}
+end
+local reset_after = new_tat - now
+redis.call('SET', KEYS[1], new_tat, 'PX', math.ceil(reset_after / 1000))
+return {1, math.floor(difference / emission_interval), 0, reset_after}
+`)
+
+// RedisRateLimiter implements the RateLimitStore interface with GCRA state shared in Redis
+type RedisRateLimiter struct {
+	client    redis.Scripter
+	keyPrefix string
+	burst     int
+	interval  time.Duration
+}
+
+var _ RateLimitStore = &RedisRateLimiter{}
+
+// NewRedisRateLimiter creates a rate limiter sharing its state across replicas through Redis
+func NewRedisRateLimiter(client redis.Scripter, keyPrefix string, r rate.Limit, b int) *RedisRateLimiter {
+	interval := time.Duration(math.MaxInt64)
+	if r > 0 {
+		interval = time.Duration(float64(time.Second) / float64(r))
+	}
+	return &RedisRateLimiter{
+		client:    client,
+		keyPrefix: keyPrefix,
+		burst:     b,
+		interval:  interval,
+	}
+}
+
+// Allow consumes one request for the key and reports whether it is allowed
+func (rateLimitter *RedisRateLimiter) Allow(ctx context.Context, key string) (*RateLimitResult, error) {
+	result, err := gcraScript.Run(
+		ctx,
+		rateLimitter.client,
+		[]string{rateLimitter.keyPrefix + key},
+		rateLimitter.interval.Microseconds(),
+		rateLimitter.burst,
+	).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("Could not evaluate rate limit in redis: %v", err)
+	}
+	if len(result) != 4 {
+		return nil, fmt.Errorf("Unexpected rate limit response from redis: %v", result)
+	}
+	return &RateLimitResult{
+		Allowed:    result[0] == 1,
+		Limit:      rateLimitter.burst,
+		Remaining:  int(result[1]),
+		RetryAfter: time.Duration(result[2]) * time.Microsecond,
+		ResetAfter: time.Duration(result[3]) * time.Microsecond,
+	}, nil
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+)
+
+func TestRedisRateLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Allow_Shares_Budget_Across_Replicas", func(t *testing.T) {
+		redisServer := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+		defer client.Close()
+		now := time.Now()
+		redisServer.SetTime(now)
+		firstReplica := NewRedisRateLimiter(client, "rate_limit:user:", rate.Limit(1), 2)
+		secondReplica := NewRedisRateLimiter(client, "rate_limit:user:", rate.Limit(1), 2)
+
+		result, err := firstReplica.Allow(ctx, "127.0.0.1")
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 2, result.Limit)
+		assert.Equal(t, 1, result.Remaining)
+
+		result, err = secondReplica.Allow(ctx, "127.0.0.1")
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 0, result.Remaining)
+
+		result, err = firstReplica.Allow(ctx, "127.0.0.1")
+		assert.NoError(t, err)
+		assert.False(t, result.Allowed)
+		assert.Equal(t, time.Second, result.RetryAfter)
+		assert.Equal(t, 2*time.Second, result.ResetAfter)
+
+		redisServer.SetTime(now.Add(time.Second))
+		result, err = secondReplica.Allow(ctx, "127.0.0.1")
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+	})
+
+	t.Run("Allow_Keys_Are_Independent", func(t *testing.T) {
+		redisServer := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+		defer client.Close()
+		rateLimiter := NewRedisRateLimiter(client, "rate_limit:user:", rate.Limit(0.01), 1)
+
+		first, err := rateLimiter.Allow(ctx, "first")
+		assert.NoError(t, err)
+		second, err := rateLimiter.Allow(ctx, "second")
+		assert.NoError(t, err)
+
+		assert.True(t, first.Allowed)
+		assert.True(t, second.Allowed)
+		assert.True(t, redisServer.Exists("rate_limit:user:first"))
+	})
+
+	t.Run("Allow_Redis_Unavailable_Error", func(t *testing.T) {
+		redisServer := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: redisServer.Addr(), MaxRetries: -1})
+		defer client.Close()
+		rateLimiter := NewRedisRateLimiter(client, "rate_limit:user:", rate.Limit(1), 1)
+		redisServer.Close()
+
+		result, err := rateLimiter.Allow(ctx, "127.0.0.1")
+
+		assert.Error(t, err)
+		assert.Nil(t, result)
+	})
+}
+
+func TestRateLimitStoreFactory(t *testing.T) {
+	t.Run("NewStore_Selects_Store_Per_Group", func(t *testing.T) {
+		redisServer := miniredis.RunT(t)
+		factory := NewRateLimitStoreFactory(&config.Config{
+			RateLimit: config.RateLimitConfig{
+				Store:  MemoryRateLimitStore,
+				Groups: map[string]string{"image_analysis": RedisRateLimitStore},
+				Redis:  config.RedisConfig{Address: redisServer.Addr()},
+			},
+		})
+		defer factory.Close()
+
+		userStore, err := factory.NewStore("user", rate.Limit(1), 1)
+		assert.NoError(t, err)
+		imageAnalysisStore, err := factory.NewStore("image_analysis", rate.Limit(1), 1)
+		assert.NoError(t, err)
+
+		assert.IsType(t, &RateLimiter{}, userStore)
+		assert.IsType(t, &RedisRateLimiter{}, imageAnalysisStore)
+		assert.Equal(t, "rate_limit:image_analysis:", imageAnalysisStore.(*RedisRateLimiter).keyPrefix)
+	})
+
+	t.Run("NewStore_Unknown_Store_Error", func(t *testing.T) {
+		factory := NewRateLimitStoreFactory(&config.Config{
+			RateLimit: config.RateLimitConfig{Store: "memcached"},
+		})
+		defer factory.Close()
+
+		store, err := factory.NewStore("user", rate.Limit(1), 1)
+
+		assert.Nil(t, store)
+		assert.Equal(t, "Unknown rate limit store \"memcached\" for the user rate limit group", err.Error())
+	})
+
+	t.Run("NewStore_Redis_Without_Address_Error", func(t *testing.T) {
+		factory := NewRateLimitStoreFactory(&config.Config{
+			RateLimit: config.RateLimitConfig{Store: RedisRateLimitStore},
+		})
+		defer factory.Close()
+
+		_, err := factory.NewStore("user", rate.Limit(1), 1)
+
+		assert.Equal(t, "Redis address is required for the user rate limit group", err.Error())
+	})
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pires/go-proxyproto"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+)
+
+// Headers the client IP can be resolved from
+const (
+	XForwardedForHeader = "X-Forwarded-For"
+	XRealIPHeader       = "X-Real-IP"
+	ForwardedHeader     = "Forwarded"
+)
+
+// forwardedForHeader carries the addresses of the Forwarded header in the X-Forwarded-For format gin understands
+const forwardedForHeader = "X-Gateway-Forwarded-For"
+
+// DefaultHeaders are used when no client IP header is configured
+var DefaultHeaders = []string{XForwardedForHeader, XRealIPHeader}
+
+// remoteIPHeaders maps the configured headers to the headers gin reads the client IP from
+func remoteIPHeaders(headers []string) ([]string, bool, error) {
+	if len(headers) == 0 {
+		headers = DefaultHeaders
+	}
+	remoteHeaders := make([]string, 0, len(headers))
+	parsesForwarded := false
+	for _, header := range headers {
+		switch http.CanonicalHeaderKey(header) {
+		case http.CanonicalHeaderKey(XForwardedForHeader):
+			remoteHeaders = append(remoteHeaders, XForwardedForHeader)
+		case http.CanonicalHeaderKey(XRealIPHeader):
+			remoteHeaders = append(remoteHeaders, XRealIPHeader)
+		case ForwardedHeader:
+			remoteHeaders = append(remoteHeaders, forwardedForHeader)
+			parsesForwarded = true
+		default:
+			return nil, false, fmt.Errorf("Unknown client IP header %q", header)
+		}
+	}
+	return remoteHeaders, parsesForwarded, nil
+}
+
+// ConfigureEngine makes the engine resolve the client IP only from the headers set by the trusted proxies.
+// Without trusted proxies the client IP is always the address of the connection.
+func ConfigureEngine(engine *gin.Engine, configurations *config.Config) error {
+	remoteHeaders, parsesForwarded, err := remoteIPHeaders(configurations.Proxy.Headers)
+	if err != nil {
+		return err
+	}
+
+	if err := engine.SetTrustedProxies(configurations.Proxy.TrustedProxies); err != nil {
+		return fmt.Errorf("Invalid trusted proxies: %v", err)
+	}
+	engine.ForwardedByClientIP = true
+	engine.RemoteIPHeaders = remoteHeaders
+
+	if parsesForwarded {
+		engine.Use(ForwardedMiddleware())
+	}
+	return nil
+}
+
+// forwardedFor returns the for parameter of every element of the Forwarded header values (RFC 7239)
+func forwardedFor(values []string) []string {
+	addresses := []string{}
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, address, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if !found || !strings.EqualFold(key, "for") {
+					continue
+				}
+				address = strings.Trim(address, "\"")
+				if host, _, err := net.SplitHostPort(address); err == nil {
+					address = host
+				}
+				addresses = append(addresses, strings.Trim(address, "[]"))
+			}
+		}
+	}
+	return addresses
+}
+
+// ForwardedMiddleware exposes the addresses of the Forwarded header to the client IP resolution,
+// dropping any value a client may have set directly in the internal header
+func ForwardedMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Request.Header.Del(forwardedForHeader)
+		addresses := forwardedFor(ctx.Request.Header.Values(ForwardedHeader))
+		if len(addresses) > 0 {
+			ctx.Request.Header.Set(forwardedForHeader, strings.Join(addresses, ", "))
+		}
+		ctx.Next()
+	}
+}
+
+// Listen listens on the TCP address, reading the PROXY protocol header of the connections from
+// the trusted proxies when enabled. Headers sent by any other peer are ignored.
+func Listen(address string, configurations *config.Config) (net.Listener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if !configurations.Proxy.ProxyProtocol {
+		return listener, nil
+	}
+	if len(configurations.Proxy.TrustedProxies) == 0 {
+		listener.Close()
+		return nil, fmt.Errorf("PROXY protocol requires trusted proxies")
+	}
+	policy, err := proxyproto.LaxWhiteListPolicy(configurations.Proxy.TrustedProxies)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("Invalid trusted proxies: %v", err)
+	}
+	return &proxyproto.Listener{
+		Listener: listener,
+		Policy:   policy,
+	}, nil
+}
@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+)
+
+func newClientIPRouter(t *testing.T, proxyConfig config.ProxyConfig) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	err := ConfigureEngine(router, &config.Config{Proxy: proxyConfig})
+	assert.NoError(t, err)
+	router.GET("/ip", func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, ctx.ClientIP())
+	})
+	return router
+}
+
+func clientIP(router *gin.Engine, remoteAddress string, headers map[string]string) string {
+	request := httptest.NewRequest(http.MethodGet, "/ip", nil)
+	request.RemoteAddr = remoteAddress
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder.Body.String()
+}
+
+func TestConfigureEngine(t *testing.T) {
+	t.Run("ClientIP_Without_Trusted_Proxies_Ignores_Spoofed_Headers", func(t *testing.T) {
+		router := newClientIPRouter(t, config.ProxyConfig{})
+
+		ip := clientIP(router, "203.0.113.7:5000", map[string]string{
+			XForwardedForHeader: "1.1.1.1",
+			XRealIPHeader:       "2.2.2.2",
+		})
+
+		assert.Equal(t, "203.0.113.7", ip)
+	})
+
+	t.Run("ClientIP_From_Trusted_Proxy", func(t *testing.T) {
+		router := newClientIPRouter(t, config.ProxyConfig{TrustedProxies: []string{"10.0.0.0/8"}})
+
+		ip := clientIP(router, "10.0.0.1:5000", map[string]string{
+			XForwardedForHeader: "198.51.100.4",
+		})
+
+		assert.Equal(t, "198.51.100.4", ip)
+	})
+
+	t.Run("ClientIP_Spoofed_Hops_Before_Trusted_Proxies_Are_Ignored", func(t *testing.T) {
+		router := newClientIPRouter(t, config.ProxyConfig{TrustedProxies: []string{"10.0.0.0/8"}})
+
+		// The client sends X-Forwarded-For: 1.1.1.1 and the proxies append the real hops
+		ip := clientIP(router, "10.0.0.1:5000", map[string]string{
+			XForwardedForHeader: "1.1.1.1, 198.51.100.4, 10.0.0.2",
+		})
+
+		assert.Equal(t, "198.51.100.4", ip)
+	})
+
+	t.Run("ClientIP_Untrusted_Peer_Headers_Ignored", func(t *testing.T) {
+		router := newClientIPRouter(t, config.ProxyConfig{TrustedProxies: []string{"10.0.0.0/8"}})
+
+		ip := clientIP(router, "203.0.113.7:5000", map[string]string{
+			XForwardedForHeader: "10.0.0.3",
+		})
+
+		assert.Equal(t, "203.0.113.7", ip)
+	})
+
+	t.Run("ClientIP_Only_Configured_Headers", func(t *testing.T) {
+		router := newClientIPRouter(t, config.ProxyConfig{
+			TrustedProxies: []string{"10.0.0.1"},
+			Headers:        []string{"x-real-ip"},
+		})
+
+		ip := clientIP(router, "10.0.0.1:5000", map[string]string{
+			XForwardedForHeader: "1.1.1.1",
+			XRealIPHeader:       "198.51.100.4",
+		})
+
+		assert.Equal(t, "198.51.100.4", ip)
+	})
+
+	t.Run("ClientIP_From_Forwarded_Header", func(t *testing.T) {
+		router := newClientIPRouter(t, config.ProxyConfig{
+			TrustedProxies: []string{"10.0.0.0/8"},
+			Headers:        []string{ForwardedHeader},
+		})
+
+		ip := clientIP(router, "10.0.0.1:5000", map[string]string{
+			ForwardedHeader:     `for=1.1.1.1, for="[2001:db8:cafe::17]:4711";proto=https, for=10.0.0.2`,
+			XForwardedForHeader: "2.2.2.2",
+		})
+
+		assert.Equal(t, "2001:db8:cafe::17", ip)
+	})
+
+	t.Run("ClientIP_Internal_Forwarded_Header_Cannot_Be_Spoofed", func(t *testing.T) {
+		router := newClientIPRouter(t, config.ProxyConfig{
+			TrustedProxies: []string{"10.0.0.0/8"},
+			Headers:        []string{ForwardedHeader},
+		})
+
+		ip := clientIP(router, "10.0.0.1:5000", map[string]string{
+			forwardedForHeader: "1.1.1.1",
+		})
+
+		assert.Equal(t, "10.0.0.1", ip)
+	})
+
+	t.Run("ConfigureEngine_Unknown_Header_Error", func(t *testing.T) {
+		err := ConfigureEngine(gin.New(), &config.Config{
+			Proxy: config.ProxyConfig{Headers: []string{"True-Client-IP"}},
+		})
+
+		assert.Equal(t, "Unknown client IP header \"True-Client-IP\"", err.Error())
+	})
+
+	t.Run("ConfigureEngine_Invalid_Trusted_Proxy_Error", func(t *testing.T) {
+		err := ConfigureEngine(gin.New(), &config.Config{
+			Proxy: config.ProxyConfig{TrustedProxies: []string{"10.0.0.0/33"}},
+		})
+
+		assert.Error(t, err)
+	})
+}
+
+// proxyProtocolClientIP sends a request preceded by a PROXY protocol v1 header from 192.0.2.1
+func proxyProtocolClientIP(t *testing.T, proxyConfig config.ProxyConfig) string {
+	listener, err := Listen("127.0.0.1:0", &config.Config{Proxy: proxyConfig})
+	assert.NoError(t, err)
+	server := &http.Server{Handler: newClientIPRouter(t, proxyConfig)}
+	go server.Serve(listener)
+	defer server.Close()
+
+	connection, err := net.Dial("tcp", listener.Addr().String())
+	assert.NoError(t, err)
+	defer connection.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	fmt.Fprintf(connection, "PROXY TCP4 192.0.2.1 127.0.0.1 5000 %s\r\n", port)
+	fmt.Fprint(connection, "GET /ip HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+	response, err := http.ReadResponse(bufio.NewReader(connection), nil)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	body := make([]byte, 64)
+	length, _ := response.Body.Read(body)
+	return string(body[:length])
+}
+
+func TestListen(t *testing.T) {
+	t.Run("Listen_PROXY_Protocol_From_Trusted_Proxy", func(t *testing.T) {
+		ip := proxyProtocolClientIP(t, config.ProxyConfig{
+			TrustedProxies: []string{"127.0.0.1"},
+			ProxyProtocol:  true,
+		})
+
+		assert.Equal(t, "192.0.2.1", ip)
+	})
+
+	t.Run("Listen_PROXY_Protocol_From_Untrusted_Peer_Ignored", func(t *testing.T) {
+		ip := proxyProtocolClientIP(t, config.ProxyConfig{
+			TrustedProxies: []string{"10.0.0.0/8"},
+			ProxyProtocol:  true,
+		})
+
+		assert.Equal(t, "127.0.0.1", ip)
+	})
+
+	t.Run("Listen_PROXY_Protocol_Without_Trusted_Proxies_Error", func(t *testing.T) {
+		listener, err := Listen("127.0.0.1:0", &config.Config{
+			Proxy: config.ProxyConfig{ProxyProtocol: true},
+		})
+
+		assert.Nil(t, listener)
+		assert.Equal(t, "PROXY protocol requires trusted proxies", err.Error())
+	})
+}
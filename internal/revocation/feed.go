@@ -0,0 +1,120 @@
+package revocation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+)
+
+// DefaultFeedChannel is used when no feed channel is configured
+const DefaultFeedChannel = "revocation:events"
+
+// Event is a revocation published by the authentication service on the feed channel, either of a single
+// token until it expires or of every token of a user issued before a time, all times in Unix seconds:
+// {"token_id":"<jti>","expires_at":1760781600} or {"user_id":"<user ID>","issued_before":1760781600}
+type Event struct {
+	TokenID      string `json:"token_id,omitempty"`
+	ExpiresAt    int64  `json:"expires_at,omitempty"`
+	UserID       string `json:"user_id,omitempty"`
+	IssuedBefore int64  `json:"issued_before,omitempty"`
+}
+
+// Apply records the revocation of the event in the store
+func (event *Event) Apply(ctx context.Context, store Storer) error {
+	switch {
+	case event.TokenID != "":
+		return store.RevokeToken(ctx, event.TokenID, time.Unix(event.ExpiresAt, 0))
+	case event.UserID != "":
+		return store.RevokeUserTokens(ctx, event.UserID, time.Unix(event.IssuedBefore, 0))
+	default:
+		return fmt.Errorf("Revocation event without token or user")
+	}
+}
+
+// Subscriber feeds the store with the revocations published by the authentication service on a Redis
+// channel. Every replica subscribes, so the memory stores get the revocations too. The events published
+// while a replica is disconnected are missed, the Redis store being the one to keep them.
+type Subscriber struct {
+	pubsub *redis.PubSub
+	store  Storer
+	done   chan struct{}
+	// client is closed with the subscriber when it created it, nil otherwise
+	client redis.UniversalClient
+}
+
+// NewSubscriber subscribes to the channel and applies its events to the store until it is closed
+func NewSubscriber(ctx context.Context, client redis.UniversalClient, channel string, store Storer) (*Subscriber, error) {
+	pubsub := client.Subscribe(ctx, channel)
+	// Waits for the subscription, failing on start rather than missing every event
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("Could not subscribe to the revocation feed: %v", err)
+	}
+	subscriber := &Subscriber{
+		pubsub: pubsub,
+		store:  store,
+		done:   make(chan struct{}),
+	}
+	go subscriber.run()
+	return subscriber, nil
+}
+
+// NewFeedSubscriber subscribes the store to the revocation feed configured, nil when it is not enabled
+func NewFeedSubscriber(configurations *config.Config, store Storer) (*Subscriber, error) {
+	revocationConfig := configurations.Revocation
+	if !revocationConfig.Feed.Enabled {
+		return nil, nil
+	}
+	if revocationConfig.Redis.Address == "" {
+		return nil, fmt.Errorf("Redis address is required for the revocation feed")
+	}
+	channel := revocationConfig.Feed.Channel
+	if channel == "" {
+		channel = DefaultFeedChannel
+	}
+	client := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs:    strings.Split(revocationConfig.Redis.Address, ","),
+		Password: revocationConfig.Redis.Password,
+		DB:       revocationConfig.Redis.DB,
+	})
+	subscriber, err := NewSubscriber(context.Background(), client, channel, store)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	subscriber.client = client
+	return subscriber, nil
+}
+
+// run applies the events until the subscription is closed, the client resubscribing after the disconnections
+func (subscriber *Subscriber) run() {
+	defer close(subscriber.done)
+	for message := range subscriber.pubsub.Channel() {
+		event := &Event{}
+		if err := json.Unmarshal([]byte(message.Payload), event); err != nil {
+			log.Error().Err(err).Msg("Invalid revocation event")
+			continue
+		}
+		if err := event.Apply(context.Background(), subscriber.store); err != nil {
+			log.Error().Err(err).Msg("Could not apply revocation event")
+		}
+	}
+}
+
+// Close unsubscribes from the feed and waits for the events received to be applied
+func (subscriber *Subscriber) Close() error {
+	err := subscriber.pubsub.Close()
+	<-subscriber.done
+	if subscriber.client != nil {
+		err = errors.Join(err, subscriber.client.Close())
+	}
+	return err
+}
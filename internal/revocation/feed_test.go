@@ -0,0 +1,62 @@
+package revocation
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+)
+
+func TestSubscriber(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Published_Events_Applied", func(t *testing.T) {
+		redisServer := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+		defer client.Close()
+		store := NewMemoryRevocationStore(time.Hour)
+		subscriber, err := NewSubscriber(ctx, client, DefaultFeedChannel, store)
+		assert.NoError(t, err)
+		defer subscriber.Close()
+		issuedBefore := time.Now().Truncate(time.Second)
+
+		redisServer.Publish(DefaultFeedChannel, `{"token_id":"token-id","expires_at":`+strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)+`}`)
+		redisServer.Publish(DefaultFeedChannel, `not an event`)
+		redisServer.Publish(DefaultFeedChannel, `{"user_id":"user-id","issued_before":`+strconv.FormatInt(issuedBefore.Unix(), 10)+`}`)
+
+		assert.Eventually(t, func() bool {
+			revokedBefore, _ := store.UserTokensRevokedBefore(ctx, "user-id")
+			return revokedBefore.Equal(issuedBefore)
+		}, 5*time.Second, time.Millisecond)
+		revoked, err := store.IsTokenRevoked(ctx, "token-id")
+		assert.NoError(t, err)
+		assert.True(t, revoked)
+	})
+
+	t.Run("Event_Without_Token_Or_User_Error", func(t *testing.T) {
+		err := (&Event{}).Apply(ctx, NewMemoryRevocationStore(time.Hour))
+
+		assert.Equal(t, "Revocation event without token or user", err.Error())
+	})
+
+	t.Run("NewFeedSubscriber_Disabled_Nil", func(t *testing.T) {
+		subscriber, err := NewFeedSubscriber(&config.Config{}, NewMemoryRevocationStore(time.Hour))
+
+		assert.NoError(t, err)
+		assert.Nil(t, subscriber)
+	})
+
+	t.Run("NewFeedSubscriber_Without_Address_Error", func(t *testing.T) {
+		_, err := NewFeedSubscriber(&config.Config{
+			Revocation: config.RevocationConfig{Feed: config.RevocationFeedConfig{Enabled: true}},
+		}, NewMemoryRevocationStore(time.Hour))
+
+		assert.Equal(t, "Redis address is required for the revocation feed", err.Error())
+	})
+}
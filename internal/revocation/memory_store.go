@@ -0,0 +1,93 @@
+package revocation
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryRevocationStore keeps the revocations in the memory of a single gateway replica
+type MemoryRevocationStore struct {
+	tokens            map[string]time.Time
+	users             map[string]time.Time
+	userRevocationTTL time.Duration
+	now               func() time.Time
+	mtx               sync.RWMutex
+}
+
+var _ Storer = &MemoryRevocationStore{}
+
+// NewMemoryRevocationStore creates an in-memory revocation store keeping the user revocations for the given TTL
+func NewMemoryRevocationStore(userRevocationTTL time.Duration) *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		tokens:            make(map[string]time.Time),
+		users:             make(map[string]time.Time),
+		userRevocationTTL: userRevocationTTL,
+		now:               time.Now,
+	}
+}
+
+// removeExpired drops the revocations that can no longer match a valid token, the lock must be held
+func (store *MemoryRevocationStore) removeExpired(now time.Time) {
+	for tokenID, expiry := range store.tokens {
+		if !expiry.After(now) {
+			delete(store.tokens, tokenID)
+		}
+	}
+	for userID, revokedBefore := range store.users {
+		if !revokedBefore.Add(store.userRevocationTTL).After(now) {
+			delete(store.users, userID)
+		}
+	}
+}
+
+// RevokeToken revokes a single token until it expires, for no longer than the user revocation TTL
+func (store *MemoryRevocationStore) RevokeToken(ctx context.Context, tokenID string, expiry time.Time) error {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	now := store.now()
+	store.removeExpired(now)
+	if maxExpiry := now.Add(store.userRevocationTTL); expiry.After(maxExpiry) {
+		expiry = maxExpiry
+	}
+	if expiry.After(now) {
+		store.tokens[tokenID] = expiry
+	}
+	return nil
+}
+
+// RevokeUserTokens revokes every token of the user issued before the given time, rounded down to the second
+func (store *MemoryRevocationStore) RevokeUserTokens(ctx context.Context, userID string, issuedBefore time.Time) error {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	store.removeExpired(store.now())
+	issuedBefore = issuedBefore.Truncate(time.Second)
+	if issuedBefore.After(store.users[userID]) {
+		store.users[userID] = issuedBefore
+	}
+	return nil
+}
+
+// IsTokenRevoked reports whether the token was revoked
+func (store *MemoryRevocationStore) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	store.mtx.RLock()
+	defer store.mtx.RUnlock()
+
+	expiry, exists := store.tokens[tokenID]
+	return exists && expiry.After(store.now()), nil
+}
+
+// UserTokensRevokedBefore returns the time before which the user tokens are revoked, zero if none
+func (store *MemoryRevocationStore) UserTokensRevokedBefore(ctx context.Context, userID string) (time.Time, error) {
+	store.mtx.RLock()
+	defer store.mtx.RUnlock()
+
+	return store.users[userID], nil
+}
+
+// Close does nothing, the memory store holds no external resources
+func (store *MemoryRevocationStore) Close() error {
+	return nil
+}
@@ -0,0 +1,52 @@
+package revocation
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	commonJWT "github.com/quadev-ltd/qd-common/pkg/jwt"
+	commonLogger "github.com/quadev-ltd/qd-common/pkg/log"
+)
+
+// UserIDFromClaims returns the user ID of the verified token claims, empty if the request is not authenticated
+func UserIDFromClaims(ctx *gin.Context) string {
+	value, exists := ctx.Get(string(commonJWT.ClaimsContextKey))
+	if !exists {
+		return ""
+	}
+	claims, ok := value.(*commonJWT.TokenClaims)
+	if !ok {
+		return ""
+	}
+	return claims.UserID
+}
+
+// UserIDFromParam returns the user ID of the userID route parameter
+func UserIDFromParam(ctx *gin.Context) string {
+	return ctx.Param("userID")
+}
+
+// RevokeUserTokensOnSuccess revokes every token issued to the user so far once the handler succeeds,
+// so that the tokens issued before a password reset or an account deletion stop working at the gateway
+func RevokeUserTokensOnSuccess(store Storer, userID func(ctx *gin.Context) string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		if ctx.IsAborted() || status < http.StatusOK || status >= http.StatusMultipleChoices {
+			return
+		}
+		revokedUserID := userID(ctx)
+		if revokedUserID == "" {
+			return
+		}
+		err := store.RevokeUserTokens(ctx.Request.Context(), revokedUserID, time.Now())
+		if err == nil {
+			return
+		}
+		if logger, loggerErr := commonLogger.GetLoggerFromContext(ctx.Request.Context()); loggerErr == nil {
+			logger.Error(err, "Could not revoke the user tokens")
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// revokeUserScript keeps the latest user revocation so that replicas racing each other never move it back
+var revokeUserScript = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+local revokedBefore = tonumber(ARGV[1])
+if revokedBefore > current then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+end
+return 1
+`)
+
+// RedisRevocationStore shares the revocations across gateway replicas through Redis.
+// The authentication service can push revocations by writing the same keys:
+// "<prefix>:token:<token ID>" with any value until the token expiry, and
+// "<prefix>:user:<user ID>" with the Unix time in seconds before which the user tokens are revoked.
+type RedisRevocationStore struct {
+	client            redis.UniversalClient
+	keyPrefix         string
+	userRevocationTTL time.Duration
+}
+
+var _ Storer = &RedisRevocationStore{}
+
+// NewRedisRevocationStore creates a Redis revocation store keeping the user revocations for the given TTL
+func NewRedisRevocationStore(client redis.UniversalClient, keyPrefix string, userRevocationTTL time.Duration) *RedisRevocationStore {
+	return &RedisRevocationStore{
+		client:            client,
+		keyPrefix:         keyPrefix,
+		userRevocationTTL: userRevocationTTL,
+	}
+}
+
+func (store *RedisRevocationStore) tokenKey(tokenID string) string {
+	return fmt.Sprintf("%s:token:%s", store.keyPrefix, tokenID)
+}
+
+func (store *RedisRevocationStore) userKey(userID string) string {
+	return fmt.Sprintf("%s:user:%s", store.keyPrefix, userID)
+}
+
+// RevokeToken revokes a single token until it expires, for no longer than the user revocation TTL
+func (store *RedisRevocationStore) RevokeToken(ctx context.Context, tokenID string, expiry time.Time) error {
+	ttl := time.Until(expiry)
+	if ttl > store.userRevocationTTL {
+		ttl = store.userRevocationTTL
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	return store.client.Set(ctx, store.tokenKey(tokenID), 1, ttl).Err()
+}
+
+// RevokeUserTokens revokes every token of the user issued before the given time, rounded down to the second
+func (store *RedisRevocationStore) RevokeUserTokens(ctx context.Context, userID string, issuedBefore time.Time) error {
+	return revokeUserScript.Run(
+		ctx,
+		store.client,
+		[]string{store.userKey(userID)},
+		issuedBefore.Unix(),
+		store.userRevocationTTL.Milliseconds(),
+	).Err()
+}
+
+// IsTokenRevoked reports whether the token was revoked
+func (store *RedisRevocationStore) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	count, err := store.client.Exists(ctx, store.tokenKey(tokenID)).Result()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// UserTokensRevokedBefore returns the time before which the user tokens are revoked, zero if none
+func (store *RedisRevocationStore) UserTokensRevokedBefore(ctx context.Context, userID string) (time.Time, error) {
+	value, err := store.client.Get(ctx, store.userKey(userID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid user revocation for %s: %v", userID, err)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// Close closes the Redis connection
+func (store *RedisRevocationStore) Close() error {
+	return store.client.Close()
+}
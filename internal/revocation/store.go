@@ -0,0 +1,138 @@
+package revocation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+)
+
+// Revocation store names accepted in the configuration
+const (
+	MemoryStore = "memory"
+	RedisStore  = "redis"
+)
+
+// DefaultUserRevocationTTL is used when no user revocation TTL is configured
+const DefaultUserRevocationTTL = 30 * 24 * time.Hour
+
+// DefaultRedisKeyPrefix is used when no Redis key prefix is configured
+const DefaultRedisKeyPrefix = "revocation"
+
+// TokenIDClaim is the claim holding the ID of a token
+const TokenIDClaim = "jti"
+
+// Storer defines the interface for the backends holding the revoked tokens
+type Storer interface {
+	// RevokeToken revokes a single token until it expires, expiries past the longest token lifetime are capped
+	RevokeToken(ctx context.Context, tokenID string, expiry time.Time) error
+	// RevokeUserTokens revokes every token of the user issued before the given time, rounded down to the second
+	RevokeUserTokens(ctx context.Context, userID string, issuedBefore time.Time) error
+	// IsTokenRevoked reports whether the token was revoked
+	IsTokenRevoked(ctx context.Context, tokenID string) (bool, error)
+	// UserTokensRevokedBefore returns the time before which the user tokens are revoked, zero if none
+	UserTokensRevokedBefore(ctx context.Context, userID string) (time.Time, error)
+	// Close releases the resources of the store
+	Close() error
+}
+
+// Token identifies a verified token for the revocation checks
+type Token struct {
+	ID       string
+	UserID   string
+	IssuedAt time.Time
+}
+
+// TokenID returns the jti claim of the token or, when it has none, the SHA-256 hash of the raw token
+func TokenID(parsedToken *jwt.Token, rawToken string) string {
+	if parsedToken != nil {
+		if claims, ok := parsedToken.Claims.(jwt.MapClaims); ok {
+			if tokenID, ok := claims[TokenIDClaim].(string); ok && tokenID != "" {
+				return tokenID
+			}
+		}
+	}
+	hash := sha256.Sum256([]byte(rawToken))
+	return hex.EncodeToString(hash[:])
+}
+
+// IssuedAt returns the iat claim of the token, zero when it has none
+func IssuedAt(parsedToken *jwt.Token) time.Time {
+	if parsedToken == nil {
+		return time.Time{}
+	}
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok {
+		return time.Time{}
+	}
+	switch issuedAt := claims["iat"].(type) {
+	case float64:
+		return time.Unix(int64(issuedAt), 0)
+	case int64:
+		return time.Unix(issuedAt, 0)
+	case json.Number:
+		seconds, err := issuedAt.Int64()
+		if err != nil {
+			return time.Time{}
+		}
+		return time.Unix(seconds, 0)
+	default:
+		return time.Time{}
+	}
+}
+
+// IsRevoked reports whether the token was revoked on its own or with every token of its user.
+// Tokens carry their issue time in whole seconds, so the tokens issued in the same second as a
+// user revocation are kept: they may be those of the sign in or refresh following it.
+func IsRevoked(ctx context.Context, store Storer, token Token) (bool, error) {
+	revoked, err := store.IsTokenRevoked(ctx, token.ID)
+	if err != nil || revoked {
+		return revoked, err
+	}
+	if token.UserID == "" {
+		return false, nil
+	}
+	revokedBefore, err := store.UserTokensRevokedBefore(ctx, token.UserID)
+	if err != nil || revokedBefore.IsZero() {
+		return false, err
+	}
+	return token.IssuedAt.Before(revokedBefore.Truncate(time.Second)), nil
+}
+
+// NewStore creates the revocation store selected in the configuration
+func NewStore(configurations *config.Config) (Storer, error) {
+	revocationConfig := configurations.Revocation
+	userRevocationTTL := revocationConfig.UserRevocationTTL
+	if userRevocationTTL <= 0 {
+		userRevocationTTL = DefaultUserRevocationTTL
+	}
+
+	switch storeName := strings.ToLower(revocationConfig.Store); storeName {
+	case "", MemoryStore:
+		return NewMemoryRevocationStore(userRevocationTTL), nil
+	case RedisStore:
+		if revocationConfig.Redis.Address == "" {
+			return nil, fmt.Errorf("Redis address is required for the revocation store")
+		}
+		keyPrefix := revocationConfig.Redis.KeyPrefix
+		if keyPrefix == "" {
+			keyPrefix = DefaultRedisKeyPrefix
+		}
+		client := redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs:    strings.Split(revocationConfig.Redis.Address, ","),
+			Password: revocationConfig.Redis.Password,
+			DB:       revocationConfig.Redis.DB,
+		})
+		return NewRedisRevocationStore(client, keyPrefix, userRevocationTTL), nil
+	default:
+		return nil, fmt.Errorf("Unknown revocation store %q", storeName)
+	}
+}
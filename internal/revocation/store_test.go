@@ -0,0 +1,171 @@
+package revocation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	commonJWT "github.com/quadev-ltd/qd-common/pkg/jwt"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+)
+
+func TestToken(t *testing.T) {
+	t.Run("TokenID_From_JTI_Claim", func(t *testing.T) {
+		parsedToken := &jwt.Token{Claims: jwt.MapClaims{TokenIDClaim: "token-id"}}
+
+		assert.Equal(t, "token-id", TokenID(parsedToken, "raw-token"))
+	})
+
+	t.Run("TokenID_Hash_Without_JTI_Claim", func(t *testing.T) {
+		parsedToken := &jwt.Token{Claims: jwt.MapClaims{}}
+
+		tokenID := TokenID(parsedToken, "raw-token")
+
+		assert.Len(t, tokenID, 64)
+		assert.Equal(t, tokenID, TokenID(nil, "raw-token"))
+		assert.NotEqual(t, tokenID, TokenID(nil, "another-raw-token"))
+	})
+
+	t.Run("IssuedAt_From_IAT_Claim", func(t *testing.T) {
+		parsedToken := &jwt.Token{Claims: jwt.MapClaims{"iat": float64(1700000000)}}
+
+		assert.Equal(t, time.Unix(1700000000, 0), IssuedAt(parsedToken))
+		assert.True(t, IssuedAt(&jwt.Token{}).IsZero())
+	})
+}
+
+func TestStores(t *testing.T) {
+	ctx := context.Background()
+	redisServer := miniredis.RunT(t)
+	stores := map[string]func() Storer{
+		"Memory": func() Storer {
+			return NewMemoryRevocationStore(time.Hour)
+		},
+		"Redis": func() Storer {
+			redisServer.FlushAll()
+			client := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+			return NewRedisRevocationStore(client, DefaultRedisKeyPrefix, time.Hour)
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name+"_RevokeToken_Until_Expiry", func(t *testing.T) {
+			store := newStore()
+			defer store.Close()
+
+			err := store.RevokeToken(ctx, "revoked", time.Now().Add(time.Minute))
+			assert.NoError(t, err)
+			err = store.RevokeToken(ctx, "expired", time.Now().Add(-time.Minute))
+			assert.NoError(t, err)
+
+			revoked, err := store.IsTokenRevoked(ctx, "revoked")
+			assert.NoError(t, err)
+			assert.True(t, revoked)
+			revoked, err = store.IsTokenRevoked(ctx, "expired")
+			assert.NoError(t, err)
+			assert.False(t, revoked)
+		})
+
+		t.Run(name+"_IsRevoked_Tokens_Issued_Before_User_Revocation_Second", func(t *testing.T) {
+			store := newStore()
+			defer store.Close()
+			revokedAt := time.Now().Truncate(time.Second).Add(500 * time.Millisecond)
+
+			err := store.RevokeUserTokens(ctx, "user-id", revokedAt)
+			assert.NoError(t, err)
+			// An older revocation never moves the revocation time back
+			err = store.RevokeUserTokens(ctx, "user-id", revokedAt.Add(-time.Hour))
+			assert.NoError(t, err)
+
+			before, err := IsRevoked(ctx, store, Token{ID: "before", UserID: "user-id", IssuedAt: revokedAt.Add(-time.Minute)})
+			assert.NoError(t, err)
+			sameSecond, err := IsRevoked(ctx, store, Token{ID: "same-second", UserID: "user-id", IssuedAt: revokedAt.Truncate(time.Second)})
+			assert.NoError(t, err)
+			after, err := IsRevoked(ctx, store, Token{ID: "after", UserID: "user-id", IssuedAt: revokedAt.Truncate(time.Second).Add(time.Second)})
+			assert.NoError(t, err)
+			anotherUser, err := IsRevoked(ctx, store, Token{ID: "another", UserID: "another-user-id", IssuedAt: revokedAt.Add(-time.Minute)})
+			assert.NoError(t, err)
+
+			assert.True(t, before)
+			assert.False(t, sameSecond)
+			assert.False(t, after)
+			assert.False(t, anotherUser)
+		})
+	}
+
+	t.Run("Memory_User_Revocation_Expires_After_TTL", func(t *testing.T) {
+		store := NewMemoryRevocationStore(time.Hour)
+		now := time.Now()
+		store.now = func() time.Time { return now }
+		err := store.RevokeUserTokens(ctx, "user-id", now)
+		assert.NoError(t, err)
+
+		now = now.Add(2 * time.Hour)
+		err = store.RevokeToken(ctx, "token-id", now.Add(time.Minute))
+		assert.NoError(t, err)
+
+		assert.NotContains(t, store.users, "user-id")
+	})
+
+	t.Run("Redis_Unavailable_Error", func(t *testing.T) {
+		unavailableServer := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: unavailableServer.Addr(), MaxRetries: -1})
+		store := NewRedisRevocationStore(client, DefaultRedisKeyPrefix, time.Hour)
+		defer store.Close()
+		unavailableServer.Close()
+
+		revoked, err := IsRevoked(ctx, store, Token{ID: "token-id", UserID: "user-id"})
+
+		assert.Error(t, err)
+		assert.False(t, revoked)
+	})
+
+	t.Run("NewStore_Unknown_Store_Error", func(t *testing.T) {
+		store, err := NewStore(&config.Config{Revocation: config.RevocationConfig{Store: "memcached"}})
+
+		assert.Nil(t, store)
+		assert.Equal(t, "Unknown revocation store \"memcached\"", err.Error())
+	})
+}
+
+func TestRevokeUserTokensOnSuccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	request := func(store Storer, status int) {
+		router := gin.New()
+		router.DELETE("/user", func(ctx *gin.Context) {
+			ctx.Set(string(commonJWT.ClaimsContextKey), &commonJWT.TokenClaims{UserID: "user-id"})
+		}, RevokeUserTokensOnSuccess(store, UserIDFromClaims), func(ctx *gin.Context) {
+			ctx.Status(status)
+		})
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/user", nil))
+	}
+
+	t.Run("Revokes_On_Success", func(t *testing.T) {
+		store := NewMemoryRevocationStore(time.Hour)
+
+		request(store, http.StatusOK)
+
+		revokedBefore, err := store.UserTokensRevokedBefore(context.Background(), "user-id")
+		assert.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), revokedBefore, time.Second)
+	})
+
+	t.Run("Does_Not_Revoke_On_Error", func(t *testing.T) {
+		store := NewMemoryRevocationStore(time.Hour)
+
+		request(store, http.StatusBadRequest)
+
+		revokedBefore, err := store.UserTokensRevokedBefore(context.Background(), "user-id")
+		assert.NoError(t, err)
+		assert.True(t, revokedBefore.IsZero())
+	})
+}
@@ -1,17 +1,34 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	commontConfig "github.com/quadev-ltd/qd-common/pkg/config"
+	"google.golang.org/grpc"
 
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/authentication"
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/health"
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/imageanalysis"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/jobs"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/metering"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/metrics"
 	"github.com/quadev-ltd/qd-qpi-gateway/internal/middleware"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/revocation"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/tracing"
 )
 
+// Shutdowner defines the interface of the servers drained on shutdown, implemented by http.Server
+type Shutdowner interface {
+	// Shutdown stops accepting connections and waits for the in-flight requests until the context is done
+	Shutdown(ctx context.Context) error
+}
+
 // ServiceInitialiser handles initialization of all services
 type ServiceInitialiser struct {
 	config               *config.Config
@@ -21,33 +38,89 @@ type ServiceInitialiser struct {
 	authMiddleware       middleware.AutheticationMiddlewarer
 	authService          authentication.ServiceClienter
 	imageAnalysisService imageanalysis.ServiceClienter
+	rateLimitStores      middleware.RateLimitStoreFactorier
+	routePolicies        []middleware.RoutePolicier
+	revocations          revocation.Storer
+	revocationFeed       *revocation.Subscriber
+	usage                metering.Storer
+	entitlementLookup    *middleware.RedisEntitlementLookuper
+	ready                atomic.Bool
 }
 
 // NewServiceInitialiser creates a new ServiceInitializer
 func NewServiceInitialiser(config *config.Config, centralConfig *commontConfig.Config, router *gin.Engine, apiGroup *gin.RouterGroup) *ServiceInitialiser {
 	return &ServiceInitialiser{
-		config:        config,
-		centralConfig: centralConfig,
-		router:        router,
-		apiGroup:      apiGroup,
+		config:          config,
+		centralConfig:   centralConfig,
+		router:          router,
+		apiGroup:        apiGroup,
+		rateLimitStores: middleware.NewRateLimitStoreFactory(config),
+	}
+}
+
+// dialOptions returns the options shared by every downstream gRPC connection
+func (serviceInitialiser *ServiceInitialiser) dialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(
+			tracing.UnaryClientInterceptor(),
+			metrics.UnaryClientInterceptor(),
+		),
+		grpc.WithChainStreamInterceptor(
+			tracing.StreamClientInterceptor(),
+			metrics.StreamClientInterceptor(),
+		),
+	}
+}
+
+// getRoutePolicies creates the rate limit and authorization policies once, shared by every service. The
+// rate limits apply first so that the requests rejected by the authorization still count against them.
+func (serviceInitialiser *ServiceInitialiser) getRoutePolicies() ([]middleware.RoutePolicier, error) {
+	if serviceInitialiser.routePolicies != nil {
+		return serviceInitialiser.routePolicies, nil
 	}
+	authorizationPolicies, err := middleware.NewAuthorizationPolicies(serviceInitialiser.config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorization policies: %w", err)
+	}
+	rateLimitPolicies, err := middleware.NewRateLimitPolicies(serviceInitialiser.config, serviceInitialiser.rateLimitStores)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rate limit policies: %w", err)
+	}
+	serviceInitialiser.routePolicies = []middleware.RoutePolicier{rateLimitPolicies, authorizationPolicies}
+	return serviceInitialiser.routePolicies, nil
 }
 
 // InitializeAuthService initializes the authentication service and middleware
 func (serviceInitialiser *ServiceInitialiser) InitializeAuthService() error {
-	authService, err := authentication.InitServiceClient(serviceInitialiser.centralConfig)
+	revocations, err := revocation.NewStore(serviceInitialiser.config)
+	if err != nil {
+		return fmt.Errorf("could not initialize the revocation store: %w", err)
+	}
+	serviceInitialiser.revocations = revocations
+	revocationFeed, err := revocation.NewFeedSubscriber(serviceInitialiser.config, revocations)
+	if err != nil {
+		return fmt.Errorf("could not subscribe to the revocation feed: %w", err)
+	}
+	serviceInitialiser.revocationFeed = revocationFeed
+
+	authService, err := authentication.InitServiceClient(serviceInitialiser.centralConfig, revocations, serviceInitialiser.dialOptions()...)
 	if err != nil {
 		return fmt.Errorf("could not initialize authentication service client: %w", err)
 	}
 	serviceInitialiser.authService = authService
 
-	authMiddleware, err := middleware.InitAuthenticationMiddleware(authService, serviceInitialiser.config)
+	authMiddleware, err := middleware.InitAuthenticationMiddleware(authService, serviceInitialiser.config, revocations)
 	if err != nil {
 		return fmt.Errorf("failed to initiate authenticator middleware: %w", err)
 	}
 	serviceInitialiser.authMiddleware = authMiddleware
 
-	err = authentication.RegisterRoutes(authService, serviceInitialiser.apiGroup, serviceInitialiser.centralConfig, serviceInitialiser.config, authMiddleware)
+	routePolicies, err := serviceInitialiser.getRoutePolicies()
+	if err != nil {
+		return err
+	}
+
+	err = authentication.RegisterRoutes(authService, serviceInitialiser.apiGroup, serviceInitialiser.centralConfig, serviceInitialiser.config, authMiddleware, routePolicies, revocations)
 	if err != nil {
 		return fmt.Errorf("failed to register authentication routes: %w", err)
 	}
@@ -61,17 +134,70 @@ func (serviceInitialiser *ServiceInitialiser) InitializeImageAnalysisService() e
 		return fmt.Errorf("authentication middleware not initialized")
 	}
 
-	imageAnalysisService, err := imageanalysis.InitServiceClient(serviceInitialiser.centralConfig)
+	jobStore, err := jobs.NewStore(serviceInitialiser.config)
+	if err != nil {
+		return fmt.Errorf("could not initialize the job store: %w", err)
+	}
+	jobManager := jobs.NewManager(jobStore, serviceInitialiser.config)
+
+	imageAnalysisService, err := imageanalysis.InitServiceClient(
+		serviceInitialiser.centralConfig,
+		serviceInitialiser.config.ImageAnalysis,
+		jobManager,
+		serviceInitialiser.dialOptions()...,
+	)
 	if err != nil {
+		jobManager.Close()
 		return fmt.Errorf("could not initialize image analysis service client: %w", err)
 	}
 	serviceInitialiser.imageAnalysisService = imageAnalysisService
 
-	err = imageanalysis.RegisterRoutes(imageAnalysisService, serviceInitialiser.apiGroup, serviceInitialiser.centralConfig, serviceInitialiser.authMiddleware)
+	entitlementLookup, err := middleware.NewEntitlementLookuper(serviceInitialiser.config)
+	if err != nil {
+		return fmt.Errorf("could not initialize the entitlement lookup: %w", err)
+	}
+	serviceInitialiser.entitlementLookup = entitlementLookup
+	// Without a lookup the entitlements are resolved from the token claims
+	var lookup middleware.EntitlementLookuper
+	if entitlementLookup != nil {
+		lookup = entitlementLookup
+	}
+	entitlementMiddleware, err := middleware.NewEntitlementMiddleware(serviceInitialiser.config, lookup)
+	if err != nil {
+		return fmt.Errorf("invalid entitlements: %w", err)
+	}
+
+	usage, err := metering.NewStore(serviceInitialiser.config)
+	if err != nil {
+		return fmt.Errorf("could not initialize the metering store: %w", err)
+	}
+	serviceInitialiser.usage = usage
+	meter := metering.NewMeter(usage, serviceInitialiser.config)
+
+	routePolicies, err := serviceInitialiser.getRoutePolicies()
+	if err != nil {
+		return err
+	}
+
+	err = imageanalysis.RegisterRoutes(
+		imageAnalysisService,
+		serviceInitialiser.apiGroup,
+		serviceInitialiser.centralConfig,
+		serviceInitialiser.config,
+		serviceInitialiser.authMiddleware,
+		entitlementMiddleware,
+		meter,
+		routePolicies,
+	)
 	if err != nil {
 		return fmt.Errorf("failed to register image analysis routes: %w", err)
 	}
 
+	err = metering.RegisterRoutes(meter, serviceInitialiser.apiGroup, serviceInitialiser.authMiddleware, routePolicies)
+	if err != nil {
+		return fmt.Errorf("failed to register usage routes: %w", err)
+	}
+
 	return nil
 }
 
@@ -85,5 +211,102 @@ func (serviceInitialiser *ServiceInitialiser) InitializeAllServices() error {
 		return err
 	}
 
+	serviceInitialiser.InitializeHealthRoutes()
+
 	return nil
 }
+
+// InitializeHealthRoutes registers the liveness and readiness routes at the root of the router
+func (serviceInitialiser *ServiceInitialiser) InitializeHealthRoutes() {
+	checks := []health.Check{
+		{
+			Name: "public_key",
+			Check: func(ctx context.Context) error {
+				if serviceInitialiser.authMiddleware == nil || !serviceInitialiser.authMiddleware.HasPublicKeys() {
+					return fmt.Errorf("no verified public key loaded")
+				}
+				return nil
+			},
+		},
+	}
+	if serviceInitialiser.authService != nil {
+		checks = append(checks, health.Check{
+			Name:  "authentication_service",
+			Check: serviceInitialiser.authService.CheckHealth,
+		})
+	}
+	if serviceInitialiser.imageAnalysisService != nil {
+		checks = append(checks, health.Check{
+			Name:  "image_analysis_service",
+			Check: serviceInitialiser.imageAnalysisService.CheckHealth,
+		})
+	}
+	handler := health.NewHandler(serviceInitialiser.IsReady, checks...)
+	health.RegisterRoutes(serviceInitialiser.router, handler)
+}
+
+// SetReady marks the gateway as ready or not ready to receive traffic
+func (serviceInitialiser *ServiceInitialiser) SetReady(ready bool) {
+	serviceInitialiser.ready.Store(ready)
+}
+
+// IsReady returns whether the gateway is ready to receive traffic
+func (serviceInitialiser *ServiceInitialiser) IsReady() bool {
+	return serviceInitialiser.ready.Load()
+}
+
+// Drain reports the gateway as not ready and waits the delay, for the load balancers to stop routing new
+// requests, before draining the in-flight requests of the server within the timeout
+func (serviceInitialiser *ServiceInitialiser) Drain(server Shutdowner, delay, timeout time.Duration) error {
+	serviceInitialiser.SetReady(false)
+	time.Sleep(delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
+
+// Close stops the authentication middleware and closes every service client connection
+func (serviceInitialiser *ServiceInitialiser) Close() error {
+	serviceInitialiser.SetReady(false)
+
+	var closeErrors []error
+	if serviceInitialiser.authMiddleware != nil {
+		serviceInitialiser.authMiddleware.Stop()
+	}
+	// The image analysis client drains the jobs first, which settle their usage in the stores closed after it
+	if serviceInitialiser.imageAnalysisService != nil {
+		if err := serviceInitialiser.imageAnalysisService.Close(); err != nil {
+			closeErrors = append(closeErrors, fmt.Errorf("could not close image analysis service client: %w", err))
+		}
+	}
+	if err := serviceInitialiser.rateLimitStores.Close(); err != nil {
+		closeErrors = append(closeErrors, fmt.Errorf("could not close rate limit stores: %w", err))
+	}
+	if serviceInitialiser.revocationFeed != nil {
+		if err := serviceInitialiser.revocationFeed.Close(); err != nil {
+			closeErrors = append(closeErrors, fmt.Errorf("could not close revocation feed: %w", err))
+		}
+	}
+	if serviceInitialiser.revocations != nil {
+		if err := serviceInitialiser.revocations.Close(); err != nil {
+			closeErrors = append(closeErrors, fmt.Errorf("could not close revocation store: %w", err))
+		}
+	}
+	if serviceInitialiser.usage != nil {
+		if err := serviceInitialiser.usage.Close(); err != nil {
+			closeErrors = append(closeErrors, fmt.Errorf("could not close metering store: %w", err))
+		}
+	}
+	if serviceInitialiser.entitlementLookup != nil {
+		if err := serviceInitialiser.entitlementLookup.Close(); err != nil {
+			closeErrors = append(closeErrors, fmt.Errorf("could not close entitlement lookup: %w", err))
+		}
+	}
+	if serviceInitialiser.authService != nil {
+		if err := serviceInitialiser.authService.Close(); err != nil {
+			closeErrors = append(closeErrors, fmt.Errorf("could not close authentication service client: %w", err))
+		}
+	}
+	return errors.Join(closeErrors...)
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/authentication/mock"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/imageanalysis"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/jobs"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/metering"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/middleware"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/revocation"
+)
+
+type failingRateLimitStores struct {
+	middleware.RateLimitStoreFactorier
+}
+
+func (failingRateLimitStores) Close() error { return fmt.Errorf("rate limit stores error") }
+
+type failingRevocations struct{ revocation.Storer }
+
+func (failingRevocations) Close() error { return fmt.Errorf("revocation error") }
+
+type failingUsage struct{ metering.Storer }
+
+func (failingUsage) Close() error { return fmt.Errorf("metering error") }
+
+type failingImageAnalysisService struct{ imageanalysis.ServiceClienter }
+
+// drainingImageAnalysisService drains its job manager when it is closed
+type drainingImageAnalysisService struct {
+	imageanalysis.ServiceClienter
+	jobManager *jobs.Manager
+}
+
+func (service drainingImageAnalysisService) Close() error { return service.jobManager.Close() }
+
+// closableUsage fails to record the usage once it is closed, as the Redis store does
+type closableUsage struct {
+	*metering.MemoryMeteringStore
+	closed atomic.Bool
+}
+
+func (usage *closableUsage) Record(ctx context.Context, userID, period string, recorded metering.Usage) (metering.Usage, error) {
+	if usage.closed.Load() {
+		return metering.Usage{}, fmt.Errorf("metering store closed")
+	}
+	return usage.MemoryMeteringStore.Record(ctx, userID, period, recorded)
+}
+
+func (usage *closableUsage) Close() error {
+	usage.closed.Store(true)
+	return nil
+}
+
+func (failingImageAnalysisService) Close() error { return fmt.Errorf("image analysis error") }
+
+// readinessRecorder records the readiness of the gateway when it is shut down
+type readinessRecorder struct {
+	serviceInitialiser *ServiceInitialiser
+	readyOnShutdown    bool
+	deadline           bool
+}
+
+func (recorder *readinessRecorder) Shutdown(ctx context.Context) error {
+	recorder.readyOnShutdown = recorder.serviceInitialiser.IsReady()
+	_, recorder.deadline = ctx.Deadline()
+	return nil
+}
+
+func TestServiceInitialiserClose(t *testing.T) {
+	t.Run("Errors_Of_Every_Client_And_Store_Joined", func(t *testing.T) {
+		controller := gomock.NewController(t)
+		defer controller.Finish()
+		authMiddleware := mock.NewMockAutheticationMiddlewarer(controller)
+		authService := mock.NewMockServiceClienter(controller)
+		authMiddleware.EXPECT().Stop()
+		authService.EXPECT().Close().Return(fmt.Errorf("authentication error"))
+		serviceInitialiser := &ServiceInitialiser{
+			authMiddleware:       authMiddleware,
+			authService:          authService,
+			imageAnalysisService: failingImageAnalysisService{},
+			rateLimitStores:      failingRateLimitStores{},
+			revocations:          failingRevocations{},
+			usage:                failingUsage{},
+		}
+		serviceInitialiser.SetReady(true)
+
+		err := serviceInitialiser.Close()
+
+		assert.False(t, serviceInitialiser.IsReady())
+		assert.Equal(t, "could not close image analysis service client: image analysis error\n"+
+			"could not close rate limit stores: rate limit stores error\n"+
+			"could not close revocation store: revocation error\n"+
+			"could not close metering store: metering error\n"+
+			"could not close authentication service client: authentication error", err.Error())
+	})
+
+	t.Run("Jobs_Drained_Before_Metering_Store_Closed", func(t *testing.T) {
+		usage := &closableUsage{MemoryMeteringStore: metering.NewMemoryMeteringStore()}
+		jobManager := jobs.NewManager(jobs.NewMemoryJobStore(time.Hour), &config.Config{})
+		started := make(chan struct{})
+		recorded := make(chan error, 1)
+		_, err := jobManager.Submit(context.Background(), "user", "", 0, func(ctx context.Context) (interface{}, *errors.Envelope) {
+			close(started)
+			time.Sleep(10 * time.Millisecond)
+			_, err := usage.Record(ctx, "user", "2026-10", metering.Usage{Requests: 1})
+			recorded <- err
+			return "done", nil
+		})
+		assert.NoError(t, err)
+		<-started
+		serviceInitialiser := &ServiceInitialiser{
+			imageAnalysisService: drainingImageAnalysisService{jobManager: jobManager},
+			rateLimitStores:      middleware.NewRateLimitStoreFactory(&config.Config{}),
+			usage:                usage,
+		}
+
+		assert.NoError(t, serviceInitialiser.Close())
+
+		assert.NoError(t, <-recorded)
+		total, err := usage.MemoryMeteringStore.Usage(context.Background(), "user", "2026-10")
+		assert.NoError(t, err)
+		assert.Equal(t, metering.Usage{Requests: 1}, total)
+	})
+
+	t.Run("Uninitialised_Services_Skipped", func(t *testing.T) {
+		serviceInitialiser := &ServiceInitialiser{rateLimitStores: middleware.NewRateLimitStoreFactory(&config.Config{})}
+
+		assert.NoError(t, serviceInitialiser.Close())
+	})
+}
+
+func TestServiceInitialiserDrain(t *testing.T) {
+	t.Run("Not_Ready_Before_Draining", func(t *testing.T) {
+		serviceInitialiser := &ServiceInitialiser{}
+		serviceInitialiser.SetReady(true)
+		server := &readinessRecorder{serviceInitialiser: serviceInitialiser, readyOnShutdown: true}
+
+		err := serviceInitialiser.Drain(server, time.Millisecond, time.Second)
+
+		assert.NoError(t, err)
+		assert.False(t, server.readyOnShutdown)
+		assert.True(t, server.deadline)
+	})
+}
+
+func TestServiceInitialiserRoutePolicies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("Rejected_Requests_Rate_Limited", func(t *testing.T) {
+		configurations := &config.Config{
+			Authorization: config.AuthorizationConfig{Policies: []config.AuthorizationPolicyConfig{
+				{Route: "/admin", Roles: []string{"admin"}},
+			}},
+			RateLimit: config.RateLimitConfig{Policies: []config.RateLimitPolicyConfig{
+				{Route: "/admin", Rate: 0.01, Burst: 1},
+			}},
+		}
+		serviceInitialiser := NewServiceInitialiser(configurations, nil, nil, nil)
+		defer serviceInitialiser.Close()
+		routePolicies, err := serviceInitialiser.getRoutePolicies()
+		assert.NoError(t, err)
+		engine := gin.New()
+		middleware.NewPolicyRouter(&engine.RouterGroup, routePolicies...).GET("/admin", func(ctx *gin.Context) {
+			ctx.Status(http.StatusOK)
+		})
+		codes := []int{}
+
+		for range []int{1, 2} {
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/admin", nil))
+			codes = append(codes, recorder.Code)
+		}
+
+		assert.Equal(t, []int{http.StatusUnauthorized, http.StatusTooManyRequests}, codes)
+	})
+}
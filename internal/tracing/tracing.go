@@ -0,0 +1,249 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	commonLogger "github.com/quadev-ltd/qd-common/pkg/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelCodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/connection"
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/metrics"
+)
+
+// Exporter names accepted in the tracing configuration
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+const (
+	tracerName         = "github.com/quadev-ltd/qd-qpi-gateway"
+	defaultServiceName = "qd-api-gateway"
+	// CorrelationIDAttribute is the span attribute holding the request correlation ID
+	CorrelationIDAttribute = "correlation_id"
+)
+
+// ShutdownFunc flushes and stops the tracer provider
+type ShutdownFunc func(ctx context.Context) error
+
+// InitTracerProvider sets the global tracer provider and W3C propagator from the configuration
+func InitTracerProvider(configurations *config.Config) (ShutdownFunc, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	tracingConfig := configurations.Tracing
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(tracingConfig.Exporter) {
+	case ExporterNone, "":
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		return func(ctx context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New()
+	case ExporterOTLP:
+		options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(tracingConfig.Endpoint)}
+		if tracingConfig.Insecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(context.Background(), options...)
+	default:
+		return nil, fmt.Errorf("Unknown tracing exporter %q", tracingConfig.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not create %s tracing exporter: %v", tracingConfig.Exporter, err)
+	}
+
+	serviceName := tracingConfig.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	tracerResource, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(serviceName),
+			semconv.DeploymentEnvironment(configurations.Environment),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create tracing resource: %v", err)
+	}
+
+	sampleRatio := tracingConfig.SampleRatio
+	if sampleRatio <= 0 || sampleRatio > 1 {
+		sampleRatio = 1
+	}
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(tracerResource),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	return tracerProvider.Shutdown, nil
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// GinMiddleware extracts the incoming trace context and starts a server span per route
+func GinMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		requestContext := otel.GetTextMapPropagator().Extract(
+			ctx.Request.Context(),
+			propagation.HeaderCarrier(ctx.Request.Header),
+		)
+
+		// The raw path of the unmatched requests would give a span name per path requested
+		route := metrics.RouteTemplate(ctx)
+		attributes := []attribute.KeyValue{
+			attribute.String("http.request.method", ctx.Request.Method),
+			attribute.String("http.route", route),
+		}
+		if correlationID, err := commonLogger.GetCorrelationIDFromContext(ctx.Request.Context()); err == nil {
+			attributes = append(attributes, attribute.String(CorrelationIDAttribute, *correlationID))
+		}
+
+		requestContext, span := tracer().Start(
+			requestContext,
+			fmt.Sprintf("%s %s", ctx.Request.Method, route),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attributes...),
+		)
+		defer span.End()
+		ctx.Request = ctx.Request.WithContext(requestContext)
+
+		ctx.Next()
+
+		statusCode := ctx.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+		if statusCode >= http.StatusInternalServerError {
+			span.SetStatus(otelCodes.Error, http.StatusText(statusCode))
+		}
+		if len(ctx.Errors) > 0 {
+			span.RecordError(ctx.Errors.Last())
+		}
+	}
+}
+
+// metadataCarrier adapts outgoing gRPC metadata to a propagation.TextMapCarrier
+type metadataCarrier metadata.MD
+
+var _ propagation.TextMapCarrier = metadataCarrier{}
+
+// Get returns the first value of the key
+func (carrier metadataCarrier) Get(key string) string {
+	values := metadata.MD(carrier).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Set replaces the values of the key
+func (carrier metadataCarrier) Set(key, value string) {
+	metadata.MD(carrier).Set(key, value)
+}
+
+// Keys returns every key of the metadata
+func (carrier metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(carrier))
+	for key := range carrier {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// startClientSpan starts the client span of an outgoing call and propagates it in the gRPC metadata
+func startClientSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	service, methodName := connection.SplitMethodName(method)
+	ctx, span := tracer().Start(
+		ctx,
+		strings.TrimPrefix(method, "/"),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "grpc"),
+			attribute.String("rpc.service", service),
+			attribute.String("rpc.method", methodName),
+		),
+	)
+
+	outgoingMetadata, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		outgoingMetadata = outgoingMetadata.Copy()
+	} else {
+		outgoingMetadata = metadata.MD{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, metadataCarrier(outgoingMetadata))
+	return metadata.NewOutgoingContext(ctx, outgoingMetadata), span
+}
+
+// endClientSpan ends the client span of an outgoing call with the status of the call
+func endClientSpan(span trace.Span, err error) {
+	statusCode := status.Code(err)
+	span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(statusCode)))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelCodes.Error, statusCode.String())
+	}
+	span.End()
+}
+
+// UnaryClientInterceptor starts a client span per outgoing call and propagates it in the gRPC metadata
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		ctx, span := startClientSpan(ctx, method)
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		endClientSpan(span, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor starts a client span per outgoing stream, ended with the stream, and propagates it
+// in the gRPC metadata
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		ctx, span := startClientSpan(ctx, method)
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			endClientSpan(span, err)
+			return nil, err
+		}
+		return connection.WrapClientStream(ctx, stream, desc, func(err error) { endClientSpan(span, err) }), nil
+	}
+}
@@ -0,0 +1,152 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	commonLogger "github.com/quadev-ltd/qd-common/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/config"
+)
+
+const (
+	incomingTraceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	incomingTraceParent = "00-" + incomingTraceID + "-00f067aa0ba902b7-01"
+)
+
+// clientStreamStub is an outgoing server stream that ends on the first message received
+type clientStreamStub struct {
+	grpc.ClientStream
+}
+
+func (stream *clientStreamStub) RecvMsg(message interface{}) error {
+	return io.EOF
+}
+
+func setUpSpanRecorder() *tracetest.SpanRecorder {
+	spanRecorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return spanRecorder
+}
+
+func findAttribute(attributes []attribute.KeyValue, key string) (attribute.Value, bool) {
+	for _, keyValue := range attributes {
+		if string(keyValue.Key) == key {
+			return keyValue.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracing(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("InitTracerProvider_Unknown_Exporter_Error", func(t *testing.T) {
+		_, err := InitTracerProvider(&config.Config{
+			Tracing: config.TracingConfig{Exporter: "zipkin"},
+		})
+
+		assert.Error(t, err)
+		assert.Equal(t, "Unknown tracing exporter \"zipkin\"", err.Error())
+	})
+
+	t.Run("InitTracerProvider_None_Success", func(t *testing.T) {
+		shutdown, err := InitTracerProvider(&config.Config{
+			Tracing: config.TracingConfig{Exporter: ExporterNone},
+		})
+
+		assert.NoError(t, err)
+		assert.NoError(t, shutdown(context.Background()))
+	})
+
+	t.Run("GinMiddleware_Continues_Incoming_Trace", func(t *testing.T) {
+		spanRecorder := setUpSpanRecorder()
+		router := gin.New()
+		router.Use(commonLogger.AddNewCorrelationIDToContext)
+		router.Use(GinMiddleware())
+		router.GET("/user/:userID", func(ctx *gin.Context) {
+			ctx.Status(http.StatusOK)
+		})
+
+		request := httptest.NewRequest(http.MethodGet, "/user/123", nil)
+		request.Header.Set("traceparent", incomingTraceParent)
+		router.ServeHTTP(httptest.NewRecorder(), request)
+
+		spans := spanRecorder.Ended()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "GET /user/:userID", spans[0].Name())
+		assert.Equal(t, incomingTraceID, spans[0].SpanContext().TraceID().String())
+		correlationID, exists := findAttribute(spans[0].Attributes(), CorrelationIDAttribute)
+		assert.True(t, exists)
+		assert.NotEmpty(t, correlationID.AsString())
+	})
+
+	t.Run("GinMiddleware_Unmatched_Route_Fixed_Name", func(t *testing.T) {
+		spanRecorder := setUpSpanRecorder()
+		router := gin.New()
+		router.Use(GinMiddleware())
+
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/unknown/123", nil))
+
+		spans := spanRecorder.Ended()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "GET unmatched", spans[0].Name())
+	})
+
+	t.Run("UnaryClientInterceptor_Propagates_Trace", func(t *testing.T) {
+		spanRecorder := setUpSpanRecorder()
+		parentContext := otel.GetTextMapPropagator().Extract(
+			context.Background(),
+			propagation.MapCarrier{"traceparent": incomingTraceParent},
+		)
+		parentContext = metadata.AppendToOutgoingContext(parentContext, "correlation_id", "example-correlation-id")
+
+		var outgoingMetadata metadata.MD
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			outgoingMetadata, _ = metadata.FromOutgoingContext(ctx)
+			return nil
+		}
+
+		err := UnaryClientInterceptor()(parentContext, "/pb_authentication.AuthenticationService/Register", nil, nil, nil, invoker)
+
+		assert.NoError(t, err)
+		spans := spanRecorder.Ended()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, incomingTraceID, spans[0].SpanContext().TraceID().String())
+		assert.Equal(t, []string{"example-correlation-id"}, outgoingMetadata.Get("correlation_id"))
+		assert.Contains(t, outgoingMetadata.Get("traceparent")[0], incomingTraceID)
+		assert.Contains(t, outgoingMetadata.Get("traceparent")[0], spans[0].SpanContext().SpanID().String())
+	})
+
+	t.Run("StreamClientInterceptor_Ends_Span_With_Stream", func(t *testing.T) {
+		spanRecorder := setUpSpanRecorder()
+		var outgoingMetadata metadata.MD
+		streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			outgoingMetadata, _ = metadata.FromOutgoingContext(ctx)
+			return &clientStreamStub{}, nil
+		}
+
+		stream, err := StreamClientInterceptor()(context.Background(), &grpc.StreamDesc{ServerStreams: true}, nil, "/src.pb.ImageAnalysisService/ProcessImageAndPromptEvents", streamer)
+		assert.NoError(t, err)
+		assert.Empty(t, spanRecorder.Ended())
+		assert.Equal(t, io.EOF, stream.RecvMsg(nil))
+
+		spans := spanRecorder.Ended()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "src.pb.ImageAnalysisService/ProcessImageAndPromptEvents", spans[0].Name())
+		assert.Contains(t, outgoingMetadata.Get("traceparent")[0], spans[0].SpanContext().SpanID().String())
+	})
+}
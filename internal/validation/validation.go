@@ -0,0 +1,208 @@
+package validation
+
+import (
+	"encoding/json"
+	goErrors "errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+)
+
+// PastRule is the binding rule of the dates that must be in the past, as times, timestamps or Unix seconds
+const PastRule = "past"
+
+var registerOnce sync.Once
+
+// register names the fields by their JSON names and adds the gateway rules to the gin validator
+func register() {
+	validate, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+	validate.RegisterCustomTypeFunc(func(value reflect.Value) interface{} {
+		if !value.CanAddr() {
+			return nil
+		}
+		timestamp, ok := value.Addr().Interface().(*timestamppb.Timestamp)
+		if !ok {
+			return nil
+		}
+		return timestamp.AsTime()
+	}, timestamppb.Timestamp{})
+	validate.RegisterValidation(PastRule, func(fieldLevel validator.FieldLevel) bool {
+		field := fieldLevel.Field()
+		switch value := field.Interface().(type) {
+		case time.Time:
+			return value.Before(time.Now())
+		case int64:
+			return time.Unix(value, 0).Before(time.Now())
+		default:
+			return false
+		}
+	})
+}
+
+// fieldName returns the JSON path of the field without the name of the body struct
+func fieldName(fieldError validator.FieldError) string {
+	namespace := fieldError.Namespace()
+	if index := strings.Index(namespace, "."); index >= 0 {
+		return namespace[index+1:]
+	}
+	return fieldError.Field()
+}
+
+// message describes why the field was rejected
+func message(field string, fieldError validator.FieldError) string {
+	isText := fieldError.Kind() == reflect.String
+	switch fieldError.Tag() {
+	case "required":
+		return fmt.Sprintf("%s is required", field)
+	case "email":
+		return fmt.Sprintf("%s must be a valid email address", field)
+	case "min":
+		if isText {
+			return fmt.Sprintf("%s must be at least %s characters long", field, fieldError.Param())
+		}
+		return fmt.Sprintf("%s must be at least %s", field, fieldError.Param())
+	case "max":
+		if isText {
+			return fmt.Sprintf("%s must be at most %s characters long", field, fieldError.Param())
+		}
+		return fmt.Sprintf("%s must be at most %s", field, fieldError.Param())
+	case PastRule:
+		return fmt.Sprintf("%s must be in the past", field)
+	default:
+		return fmt.Sprintf("%s is invalid", field)
+	}
+}
+
+// jsonType names the JSON type of a Go type for the clients
+func jsonType(goType reflect.Type) string {
+	switch goType.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	case reflect.Ptr:
+		return jsonType(goType.Elem())
+	default:
+		return "number"
+	}
+}
+
+// FieldErrors returns the field errors of an error binding a JSON body, none if the error is not about the fields
+func FieldErrors(err error) []errors.FieldError {
+	var validationErrors validator.ValidationErrors
+	if goErrors.As(err, &validationErrors) {
+		fieldErrors := make([]errors.FieldError, 0, len(validationErrors))
+		for _, validationError := range validationErrors {
+			field := fieldName(validationError)
+			fieldErrors = append(fieldErrors, errors.FieldError{Field: field, Error: message(field, validationError)})
+		}
+		return fieldErrors
+	}
+	var typeError *json.UnmarshalTypeError
+	if goErrors.As(err, &typeError) && typeError.Field != "" {
+		return []errors.FieldError{{
+			Field: typeError.Field,
+			Error: fmt.Sprintf("%s must be a %s", typeError.Field, jsonType(typeError.Type)),
+		}}
+	}
+	return nil
+}
+
+// Abort aborts the request with the field errors of an error binding or decoding a JSON body, as a bad request
+// if the error is not about the fields
+func Abort(ctx *gin.Context, err error) {
+	fieldErrors := FieldErrors(err)
+	if len(fieldErrors) == 0 {
+		errors.Abort(ctx, errors.InvalidBody(err))
+		return
+	}
+	errors.Abort(ctx, errors.New(http.StatusBadRequest, errors.ValidationFailed, "The request body is invalid").
+		WithFieldErrors(fieldErrors).
+		WithCause(err))
+}
+
+// BindJSON binds the JSON request body and validates it with the binding rules of the body struct.
+// It aborts the request with the field errors, in the format of the downstream services, and returns
+// false when the body is invalid.
+func BindJSON(ctx *gin.Context, body interface{}) bool {
+	registerOnce.Do(register)
+	if err := ctx.ShouldBindJSON(body); err != nil {
+		Abort(ctx, err)
+		return false
+	}
+	return true
+}
+
+// Validate validates a body decoded by the caller with the binding rules of its struct, aborting the
+// request with the field errors and returning false when the body is invalid
+func Validate(ctx *gin.Context, body interface{}) bool {
+	registerOnce.Do(register)
+	if err := binding.Validator.ValidateStruct(body); err != nil {
+		Abort(ctx, err)
+		return false
+	}
+	return true
+}
+
+// ValidateFields validates the fields of a body decoded by the caller, named by their JSON names, with the
+// binding rules of its struct, leaving the other fields unchecked. It aborts the request with the field errors
+// and returns false when one of the fields is invalid.
+func ValidateFields(ctx *gin.Context, body interface{}, fields ...string) bool {
+	registerOnce.Do(register)
+	validate, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return Validate(ctx, body)
+	}
+	if err := validate.StructPartial(body, structFields(body, fields)...); err != nil {
+		Abort(ctx, err)
+		return false
+	}
+	return true
+}
+
+// structFields returns the names of the struct fields of a body with the given JSON names
+func structFields(body interface{}, fields []string) []string {
+	bodyType := reflect.TypeOf(body)
+	for bodyType.Kind() == reflect.Ptr {
+		bodyType = bodyType.Elem()
+	}
+	isRequested := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		isRequested[field] = true
+	}
+	structFields := []string{}
+	for index := 0; index < bodyType.NumField(); index++ {
+		field := bodyType.Field(index)
+		if isRequested[strings.Split(field.Tag.Get("json"), ",")[0]] {
+			structFields = append(structFields, field.Name)
+		}
+	}
+	return structFields
+}
@@ -0,0 +1,97 @@
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/quadev-ltd/qd-qpi-gateway/internal/errors"
+)
+
+type testAddress struct {
+	City string `json:"city" binding:"required"`
+}
+
+type testBody struct {
+	Name      string      `json:"name" binding:"required,max=5"`
+	Count     int         `json:"count" binding:"min=1"`
+	StartedAt time.Time   `json:"startedAt" binding:"required,past"`
+	Address   testAddress `json:"address"`
+}
+
+func bind(t *testing.T, body string) (bool, *httptest.ResponseRecorder) {
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/test", bytes.NewBufferString(body))
+	ctx.Request.Header.Set("Content-Type", "application/json")
+	return BindJSON(ctx, &testBody{}), recorder
+}
+
+func TestBindJSON(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("Valid_Body", func(t *testing.T) {
+		isValid, _ := bind(t, `{"name":"test","count":1,"startedAt":"2000-01-01T00:00:00Z","address":{"city":"Bilbao"}}`)
+
+		assert.True(t, isValid)
+	})
+
+	t.Run("Field_Errors_With_JSON_Paths", func(t *testing.T) {
+		future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+		isValid, recorder := bind(t, `{"name":"too long","count":0,"startedAt":"`+future+`"}`)
+
+		assert.False(t, isValid)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		envelope := errors.Envelope{}
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &envelope))
+		assert.Equal(t, errors.ValidationFailed, envelope.Code)
+		assert.Equal(t, []errors.FieldError{
+			{Field: "name", Error: "name must be at most 5 characters long"},
+			{Field: "count", Error: "count must be at least 1"},
+			{Field: "startedAt", Error: "startedAt must be in the past"},
+			{Field: "address.city", Error: "address.city is required"},
+		}, envelope.FieldErrors)
+	})
+
+	t.Run("Malformed_Body_Bad_Request", func(t *testing.T) {
+		isValid, recorder := bind(t, `{"name":`)
+
+		assert.False(t, isValid)
+		envelope := errors.Envelope{}
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &envelope))
+		assert.Equal(t, errors.BadRequest, envelope.Code)
+		assert.Empty(t, envelope.FieldErrors)
+	})
+}
+
+func TestValidateFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("Only_Given_Fields_Validated", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(recorder)
+
+		isValid := ValidateFields(ctx, &testBody{Name: "too long"}, "count")
+
+		assert.False(t, isValid)
+		envelope := errors.Envelope{}
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &envelope))
+		assert.Equal(t, []errors.FieldError{{Field: "count", Error: "count must be at least 1"}}, envelope.FieldErrors)
+	})
+
+	t.Run("Valid_Fields_Ignore_Others", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(recorder)
+
+		isValid := ValidateFields(ctx, &testBody{Name: "test"}, "name")
+
+		assert.True(t, isValid)
+	})
+}